@@ -0,0 +1,128 @@
+// Package audit записывает события безопасности: неудачные входы, блокировки и т.п.
+//
+// Журнал выбирается переменной окружения AUDIT_LOG:
+//   - log (по умолчанию) — события пишутся в лог сервера;
+//   - file — события дописываются в файл AUDIT_LOG_FILE по одному JSON на строку.
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+// Типы событий
+const (
+	LoginFailed = "login_failed"
+	LoginLocked = "login_locked"
+)
+
+// Event — запись журнала аудита
+type Event struct {
+	Time   time.Time `json:"time"`
+	Type   string    `json:"type"`
+	UserID uint      `json:"user_id,omitempty"`
+	Login  string    `json:"login,omitempty"`
+	IP     string    `json:"ip,omitempty"`
+	Reason string    `json:"reason,omitempty"`
+}
+
+// Logger сохраняет события аудита
+type Logger interface {
+	Record(ctx context.Context, event Event) error
+}
+
+var (
+	current   Logger
+	currentMu sync.Mutex
+)
+
+// Current возвращает настроенный Logger. При первом вызове он создаётся из окружения.
+func Current() Logger {
+	currentMu.Lock()
+	defer currentMu.Unlock()
+
+	if current == nil {
+		current = FromEnv()
+	}
+	return current
+}
+
+// Use подменяет Logger, например в тестах. Функция restore возвращает прежний.
+func Use(logger Logger) (restore func()) {
+	currentMu.Lock()
+	defer currentMu.Unlock()
+
+	previous := current
+	current = logger
+	return func() {
+		currentMu.Lock()
+		defer currentMu.Unlock()
+		current = previous
+	}
+}
+
+// FromEnv создаёт Logger по переменным окружения
+func FromEnv() Logger {
+	if os.Getenv("AUDIT_LOG") == "file" {
+		return &FileLogger{Path: os.Getenv("AUDIT_LOG_FILE")}
+	}
+	return LogLogger{}
+}
+
+// Record записывает событие в текущий журнал. Время проставляется, если не задано.
+// Ошибка записи не должна прерывать обработку запроса, поэтому только логируется.
+func Record(ctx context.Context, event Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now().UTC()
+	}
+	if err := Current().Record(ctx, event); err != nil {
+		log.Println("audit: failed to record event:", err)
+	}
+}
+
+// LogLogger пишет события в лог сервера
+type LogLogger struct{}
+
+func (LogLogger) Record(ctx context.Context, event Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+	log.Printf("audit: %s", data)
+	return nil
+}
+
+// FileLogger дописывает события в файл в формате JSON Lines
+type FileLogger struct {
+	Path string
+	mu   sync.Mutex
+}
+
+func (l *FileLogger) Record(ctx context.Context, event Event) error {
+	if l.Path == "" {
+		return fmt.Errorf("audit: AUDIT_LOG_FILE is not set")
+	}
+
+	data, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	file, err := os.OpenFile(l.Path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(append(data, '\n')); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,41 @@
+package audit
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestFileLogger(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.log")
+	t.Cleanup(Use(&FileLogger{Path: path}))
+
+	Record(context.Background(), Event{Type: LoginFailed, Login: "ivan", IP: "10.0.0.1", Reason: "wrong_password"})
+	Record(context.Background(), Event{Type: LoginLocked, Login: "ivan", IP: "10.0.0.1"})
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	var events []Event
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var event Event
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Fatalf("line %q is not JSON: %v", scanner.Text(), err)
+		}
+		events = append(events, event)
+	}
+
+	if len(events) != 2 || events[0].Type != LoginFailed || events[0].Reason != "wrong_password" || events[1].Type != LoginLocked {
+		t.Fatalf("unexpected events: %+v", events)
+	}
+	if events[0].Time.IsZero() {
+		t.Fatal("event time is not set")
+	}
+}
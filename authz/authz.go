@@ -0,0 +1,120 @@
+// Package authz решает, может ли пользователь выполнить действие в чате.
+// Все обработчики, работающие с конкретным чатом, проверяют доступ только через него.
+package authz
+
+import (
+	"Bmessage_backend/repository"
+	"errors"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gocql/gocql"
+)
+
+// Action — действие пользователя в чате
+type Action int
+
+const (
+	// Read — чтение сообщений, отметки о прочтении, подписка на события чата
+	Read Action = iota
+	// Post — отправка сообщений в чат
+	Post
+	// Admin — изменение общих настроек чата
+	Admin
+)
+
+func (a Action) String() string {
+	switch a {
+	case Read:
+		return "read"
+	case Post:
+		return "post"
+	case Admin:
+		return "admin"
+	}
+	return "unknown"
+}
+
+// ErrForbidden возвращается, если пользователь не может выполнить действие в чате.
+// Отсутствие чата тоже даёт ErrForbidden, чтобы не раскрывать существование чужих чатов.
+var ErrForbidden = errors.New("forbidden")
+
+// ErrBlocked возвращается для Post, если собеседник добавил пользователя в чёрный список
+var ErrBlocked = errors.New("blocked by companion")
+
+// Access — результат успешной проверки: чат с точки зрения пользователя и собеседника
+type Access struct {
+	Chat          repository.Chat
+	CompanionChat repository.Chat
+}
+
+// Authorize проверяет, что userID может выполнить action в чате chatID.
+// Участник личного чата может читать, писать и администрировать его, пока
+// у собеседника существует парная запись чата. Если собеседник удалил аккаунт,
+// чат остаётся доступен только для чтения, а CompanionChat пуст. Писать в чат
+// нельзя, пока собеседник держит пользователя в чёрном списке.
+func Authorize(repos *repository.Repositories, userID uint, chatID gocql.UUID, action Action) (Access, error) {
+	chat, err := repos.Chats.GetChat(userID, chatID)
+	if err == repository.ErrNotFound {
+		return Access{}, ErrForbidden
+	}
+	if err != nil {
+		return Access{}, err
+	}
+
+	if chat.CompanionDeleted {
+		if action != Read {
+			return Access{}, ErrForbidden
+		}
+		return Access{Chat: chat}, nil
+	}
+
+	companionChat, err := repos.Chats.GetChat(chat.CompanionID, chatID)
+	if err == repository.ErrNotFound {
+		return Access{}, ErrForbidden
+	}
+	if err != nil {
+		return Access{}, err
+	}
+	if companionChat.CompanionID != userID {
+		return Access{}, ErrForbidden
+	}
+
+	if action == Post {
+		blocked, err := repos.Users.IsBlocked(chat.CompanionID, userID)
+		if err != nil {
+			return Access{}, err
+		}
+		if blocked {
+			return Access{}, ErrBlocked
+		}
+	}
+
+	switch action {
+	case Read, Post, Admin:
+		return Access{Chat: chat, CompanionChat: companionChat}, nil
+	}
+	return Access{}, ErrForbidden
+}
+
+// Require вызывает Authorize и при отказе сам отвечает клиенту: 403 для
+// ErrForbidden и ErrBlocked, 500 для ошибок хранилища. Второе значение false означает,
+// что обработчик должен завершиться.
+func Require(c *gin.Context, repos *repository.Repositories, userID uint, chatID gocql.UUID, action Action) (Access, bool) {
+	access, err := Authorize(repos, userID, chatID, action)
+	if err == ErrForbidden {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Нет доступа к чату"})
+		return Access{}, false
+	}
+	if err == ErrBlocked {
+		c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"error": "Собеседник ограничил вам отправку сообщений"})
+		return Access{}, false
+	}
+	if err != nil {
+		log.Printf("authz %s chat %s for user %d: %v", action, chatID, userID, err)
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Failed to get chat details"})
+		return Access{}, false
+	}
+	return access, true
+}
@@ -0,0 +1,70 @@
+package authz
+
+import (
+	"Bmessage_backend/repository"
+	"testing"
+
+	"github.com/gocql/gocql"
+)
+
+func TestAuthorize(t *testing.T) {
+	repos := repository.NewMemory()
+
+	chatID := gocql.TimeUUID()
+	oneSidedChatID := gocql.TimeUUID()
+	for _, chat := range []repository.Chat{
+		{UserID: 1, ChatID: chatID, CompanionID: 2},
+		{UserID: 2, ChatID: chatID, CompanionID: 1},
+		// Запись собеседника отсутствует: писать в такой чат некуда
+		{UserID: 1, ChatID: oneSidedChatID, CompanionID: 3},
+	} {
+		if err := repos.Chats.CreateChat(chat); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		userID uint
+		chatID gocql.UUID
+		want   error
+	}{
+		{"member", 1, chatID, nil},
+		{"companion", 2, chatID, nil},
+		{"stranger", 3, chatID, ErrForbidden},
+		{"unknown chat", 1, gocql.TimeUUID(), ErrForbidden},
+		{"companion record missing", 1, oneSidedChatID, ErrForbidden},
+	}
+
+	for _, tt := range tests {
+		for _, action := range []Action{Read, Post, Admin} {
+			t.Run(tt.name+"/"+action.String(), func(t *testing.T) {
+				access, err := Authorize(repos, tt.userID, tt.chatID, action)
+				if err != tt.want {
+					t.Fatalf("Authorize = %v, want %v", err, tt.want)
+				}
+				if err == nil && (access.Chat.UserID != tt.userID || access.CompanionChat.CompanionID != tt.userID) {
+					t.Fatalf("unexpected access: %+v", access)
+				}
+			})
+		}
+	}
+}
+
+func TestAuthorizeDeletedCompanion(t *testing.T) {
+	repos := repository.NewMemory()
+
+	chatID := gocql.TimeUUID()
+	if err := repos.Chats.CreateChat(repository.Chat{UserID: 1, ChatID: chatID, CompanionID: 2, CompanionDeleted: true}); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := Authorize(repos, 1, chatID, Read); err != nil {
+		t.Fatalf("Read = %v, want nil", err)
+	}
+	for _, action := range []Action{Post, Admin} {
+		if _, err := Authorize(repos, 1, chatID, action); err != ErrForbidden {
+			t.Fatalf("%s = %v, want ErrForbidden", action, err)
+		}
+	}
+}
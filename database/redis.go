@@ -0,0 +1,20 @@
+package database
+
+import (
+	"os"
+
+	"github.com/go-redis/redis/v8"
+)
+
+// GetRedis возвращает клиент Redis. Адрес берётся из REDIS_ADDR (по умолчанию localhost:6379).
+func GetRedis() *redis.Client {
+	addr := os.Getenv("REDIS_ADDR")
+	if addr == "" {
+		addr = "localhost:6379"
+	}
+
+	return redis.NewClient(&redis.Options{
+		Addr: addr,
+		DB:   0,
+	})
+}
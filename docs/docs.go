@@ -15,8 +15,14 @@ const docTemplate = `{
     "host": "{{.Host}}",
     "basePath": "{{.BasePath}}",
     "paths": {
-        "/chats/create-chat": {
+        "/chats/archive": {
             "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Архивный чат скрыт из основного списка и открепляется. Новое сообщение возвращает его из архива, если уведомления чата не отключены.",
                 "consumes": [
                     "application/json"
                 ],
@@ -26,15 +32,15 @@ const docTemplate = `{
                 "tags": [
                     "Chats"
                 ],
-                "summary": "Создание чата",
+                "summary": "Архивирование чата",
                 "parameters": [
                     {
-                        "description": "Данные для создания чата",
+                        "description": "Чат",
                         "name": "data",
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/chats.CreateChatStruct"
+                            "$ref": "#/definitions/chats.ArchiveChatStruct"
                         }
                     }
                 ],
@@ -52,13 +58,32 @@ const docTemplate = `{
                             "type": "object",
                             "additionalProperties": true
                         }
+                    },
+                    "401": {
+                        "description": "unauthorized",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "403": {
+                        "description": "forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
                     }
                 }
             }
         },
-        "/chats/find-chats": {
-            "get": {
-                "description": "Получает список пользователей",
+        "/chats/create-chat": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Если собеседник разрешил начинать чат только контактам, остальные получают 403.\nВ новый чат записывается служебное сообщение chat_created.",
                 "consumes": [
                     "application/json"
                 ],
@@ -68,21 +93,16 @@ const docTemplate = `{
                 "tags": [
                     "Chats"
                 ],
-                "summary": "Поиск пользователей",
+                "summary": "Создание чата",
                 "parameters": [
                     {
-                        "type": "string",
-                        "description": "search_term",
-                        "name": "search_term",
-                        "in": "query",
-                        "required": true
-                    },
-                    {
-                        "type": "string",
-                        "description": "UUID пользователя",
-                        "name": "uuid",
-                        "in": "query",
-                        "required": true
+                        "description": "Данные для создания чата",
+                        "name": "data",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/chats.CreateChatStruct"
+                        }
                     }
                 ],
                 "responses": {
@@ -99,13 +119,39 @@ const docTemplate = `{
                             "type": "object",
                             "additionalProperties": true
                         }
+                    },
+                    "401": {
+                        "description": "unauthorized",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "403": {
+                        "description": "blocked by companion or companion accepts chats only from contacts",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "404": {
+                        "description": "companion not found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
                     }
                 }
             }
         },
-        "/chats/get-chats": {
-            "get": {
-                "description": "Получает список чатов для указанного пользователя.",
+        "/chats/disappearing": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Таймер общий для обоих участников, и любой из них может его изменить. Он действует на сообщения,\nотправленные после изменения: каждое исчезает у обоих участников через ttl_seconds после отправки.\nДопустимо от 5 секунд до года. Изменение таймера записывается в чат служебным сообщением timer_changed.",
                 "consumes": [
                     "application/json"
                 ],
@@ -115,27 +161,16 @@ const docTemplate = `{
                 "tags": [
                     "Chats"
                 ],
-                "summary": "Получение чатов пользователя",
+                "summary": "Таймер исчезающих сообщений",
                 "parameters": [
                     {
-                        "type": "string",
-                        "description": "Токен пользователя",
-                        "name": "user_token",
-                        "in": "query",
-                        "required": true
-                    },
-                    {
-                        "type": "string",
-                        "description": "UUID пользователя",
-                        "name": "uuid",
-                        "in": "query",
-                        "required": true
-                    },
-                    {
-                        "type": "string",
-                        "description": "Стейт следющей страницы",
-                        "name": "page_state",
-                        "in": "query"
+                        "description": "Чат и срок жизни сообщений",
+                        "name": "data",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/chats.DisappearingMessagesStruct"
+                        }
                     }
                 ],
                 "responses": {
@@ -152,12 +187,32 @@ const docTemplate = `{
                             "type": "object",
                             "additionalProperties": true
                         }
+                    },
+                    "401": {
+                        "description": "unauthorized",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "403": {
+                        "description": "forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
                     }
                 }
             }
         },
-        "/messages/add-message": {
+        "/chats/draft": {
             "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Сохраняет неотправленный текст и сообщение, на которое готовится ответ. Черновик возвращается в get-chats\nи рассылается остальным устройствам пользователя через chatsWS событием draftUpdate. Пустой text\nбез reply_to_message_id удаляет черновик; отправка сообщения в чат тоже его удаляет.\nЧерновик можно сохранить и через chatsWS: {\"type\": \"draft\", \"chat_id\": ..., \"text\": ..., \"reply_to_message_id\": ...}.",
                 "consumes": [
                     "application/json"
                 ],
@@ -165,26 +220,25 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "Message"
+                    "Chats"
                 ],
-                "summary": "Запись сообщения",
+                "summary": "Сохранение черновика",
                 "parameters": [
                     {
-                        "description": "Данные для создания сообщения",
+                        "description": "Чат и черновик",
                         "name": "data",
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/messages.AddMessageStruct"
+                            "$ref": "#/definitions/chats.DraftStruct"
                         }
                     }
                 ],
                 "responses": {
                     "200": {
-                        "description": "successful response",
+                        "description": "сохранённый черновик",
                         "schema": {
-                            "type": "object",
-                            "additionalProperties": true
+                            "$ref": "#/definitions/chats.DraftUpdate"
                         }
                     },
                     "400": {
@@ -193,12 +247,32 @@ const docTemplate = `{
                             "type": "object",
                             "additionalProperties": true
                         }
+                    },
+                    "401": {
+                        "description": "unauthorized",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "403": {
+                        "description": "forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
                     }
                 }
             }
         },
-        "/messages/get-messages": {
+        "/chats/find-chats": {
             "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Ищет пользователей по подстроке ника, имени или фамилии. Первыми идут точное совпадение ника,\nзатем ники, начинающиеся с запроса, затем остальные по похожести. Сам пользователь, удалённые аккаунты\nи пользователи, заблокировавшие его, не возвращаются.\nnext_offset есть в ответе, если результатов больше.",
                 "consumes": [
                     "application/json"
                 ],
@@ -206,33 +280,36 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "Message"
+                    "Chats"
                 ],
-                "summary": "Получение сообщений",
+                "summary": "Поиск пользователей",
                 "parameters": [
                     {
                         "type": "string",
-                        "description": "Chat ID",
-                        "name": "chat_id",
+                        "description": "search_term",
+                        "name": "search_term",
                         "in": "query",
                         "required": true
                     },
                     {
-                        "type": "string",
-                        "description": "User Token",
-                        "name": "user_token",
-                        "in": "query",
-                        "required": true
+                        "type": "integer",
+                        "description": "Размер страницы (по умолчанию 20, не больше 50)",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Смещение",
+                        "name": "offset",
+                        "in": "query"
                     }
                 ],
                 "responses": {
                     "200": {
                         "description": "successful response",
                         "schema": {
-                            "type": "array",
-                            "items": {
-                                "$ref": "#/definitions/messages.Message"
-                            }
+                            "type": "object",
+                            "additionalProperties": true
                         }
                     },
                     "400": {
@@ -241,12 +318,25 @@ const docTemplate = `{
                             "type": "object",
                             "additionalProperties": true
                         }
+                    },
+                    "401": {
+                        "description": "unauthorized",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
                     }
                 }
             }
         },
-        "/messages/read-message": {
-            "post": {
+        "/chats/get-chats": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Получает список чатов для указанного пользователя. Первая страница основного списка начинается\nс закреплённых чатов в их порядке, остальные идут по времени обновления. Архивные чаты в основной\nсписок не попадают и запрашиваются отдельно с archived=true. Для контактов с заданным именем\ncompanion_name содержит это имя, а companion_so_name пуст. Если у пользователя есть черновик,\nон возвращается в draft.",
                 "consumes": [
                     "application/json"
                 ],
@@ -254,19 +344,28 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "Message",
-                    "Message"
+                    "Chats"
                 ],
-                "summary": "Сообщение было прочитано",
+                "summary": "Получение чатов пользователя",
                 "parameters": [
                     {
-                        "description": "Данные для прочтения сообщения",
-                        "name": "data",
-                        "in": "body",
-                        "required": true,
-                        "schema": {
-                            "$ref": "#/definitions/messages.ReadMessageStruct"
-                        }
+                        "type": "string",
+                        "description": "UUID пользователя",
+                        "name": "uuid",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Стейт следющей страницы",
+                        "name": "page_state",
+                        "in": "query"
+                    },
+                    {
+                        "type": "boolean",
+                        "description": "Вернуть архивные чаты вместо основного списка",
+                        "name": "archived",
+                        "in": "query"
                     }
                 ],
                 "responses": {
@@ -283,22 +382,9 @@ const docTemplate = `{
                             "type": "object",
                             "additionalProperties": true
                         }
-                    }
-                }
-            }
-        },
-        "/token/generateToken": {
-            "get": {
-                "produces": [
-                    "application/json"
-                ],
-                "tags": [
-                    "Tokens"
-                ],
-                "summary": "Получение токена и uuid",
-                "responses": {
-                    "200": {
-                        "description": "successful response",
+                    },
+                    "401": {
+                        "description": "unauthorized",
                         "schema": {
                             "type": "object",
                             "additionalProperties": true
@@ -307,9 +393,14 @@ const docTemplate = `{
                 }
             }
         },
-        "/user/check-uniqueness-registration-data": {
+        "/chats/mute": {
             "post": {
-                "description": "Проверяет не заняты ли login и Nik",
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "muted = true отключает уведомления до muted_until или навсегда, muted = false включает их.",
                 "consumes": [
                     "application/json"
                 ],
@@ -317,33 +408,60 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "Users"
+                    "Chats"
                 ],
-                "summary": "Проверка login и Nik",
+                "summary": "Отключение уведомлений чата",
                 "parameters": [
                     {
-                        "description": "Данные о пользователе",
+                        "description": "Чат и срок",
                         "name": "data",
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/users.UserCUD"
+                            "$ref": "#/definitions/chats.MuteChatStruct"
                         }
                     }
                 ],
                 "responses": {
                     "200": {
-                        "description": "User registered successfully",
+                        "description": "successful response",
                         "schema": {
-                            "$ref": "#/definitions/users.ResponseMessage"
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "400": {
+                        "description": "bad request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "401": {
+                        "description": "unauthorized",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "403": {
+                        "description": "forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
                         }
                     }
                 }
             }
         },
-        "/user/chek-token": {
+        "/chats/pin": {
             "post": {
-                "description": "Проверяет валидность пользователя =\u003e сессии",
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Закреплённый чат добавляется в конец списка закреплённых; их не больше 5. Закрепление возвращает чат из архива.",
                 "consumes": [
                     "application/json"
                 ],
@@ -351,44 +469,67 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "Users"
+                    "Chats"
                 ],
-                "summary": "Проверка токена",
+                "summary": "Закрепление чата",
                 "parameters": [
                     {
-                        "description": "Данные о токене",
+                        "description": "Чат",
                         "name": "data",
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/users.UserCT"
+                            "$ref": "#/definitions/chats.PinChatStruct"
                         }
                     }
                 ],
                 "responses": {
                     "200": {
-                        "description": "User registered successfully",
+                        "description": "successful response",
                         "schema": {
-                            "$ref": "#/definitions/users.ResponseMessage"
+                            "type": "object",
+                            "additionalProperties": true
                         }
                     },
                     "400": {
-                        "description": "Invalid request data",
+                        "description": "bad request",
                         "schema": {
-                            "$ref": "#/definitions/users.ErrorResponse"
+                            "type": "object",
+                            "additionalProperties": true
                         }
                     },
-                    "500": {
-                        "description": "Failed to connect to database",
+                    "401": {
+                        "description": "unauthorized",
                         "schema": {
-                            "$ref": "#/definitions/users.ErrorResponse"
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "403": {
+                        "description": "forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "409": {
+                        "description": "too many pinned chats",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
                         }
                     }
                 }
             }
         },
-        "/user/log-in-with-credentials": {
+        "/chats/reorder-pinned": {
             "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "chat_ids должен содержать ровно все закреплённые чаты пользователя в новом порядке.",
                 "consumes": [
                     "application/json"
                 ],
@@ -396,17 +537,17 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "Users"
+                    "Chats"
                 ],
-                "summary": "Аутентификация пользователя по логину и паролю",
+                "summary": "Порядок закреплённых чатов",
                 "parameters": [
                     {
-                        "description": "Данные пользователя",
+                        "description": "Закреплённые чаты",
                         "name": "data",
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/users.UserLogin"
+                            "$ref": "#/definitions/chats.ReorderPinnedStruct"
                         }
                     }
                 ],
@@ -424,13 +565,25 @@ const docTemplate = `{
                             "type": "object",
                             "additionalProperties": true
                         }
+                    },
+                    "401": {
+                        "description": "unauthorized",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
                     }
                 }
             }
         },
-        "/user/registration": {
+        "/messages/add-message": {
             "post": {
-                "description": "Регистрирует пользователя, сохраняя зашифрованные данные и ключ в базу данных.",
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "forwarded_from_chat_id и forwarded_from_message_id устарели: используйте messages/forward.\nЕсли они переданы, текст и автор берутся из исходного сообщения, а message_text игнорируется.\nreply_to_message_id должен указывать на сообщение этого же чата.\nЕсли в чате включён таймер, сообщение исчезнет у обоих участников через message_ttl секунд.\nСообщение с view_once удаляется у обоих участников после messages/read-message получателем.\nОтправка удаляет черновик отправителя в этом чате.",
                 "consumes": [
                     "application/json"
                 ],
@@ -438,141 +591,2933 @@ const docTemplate = `{
                     "application/json"
                 ],
                 "tags": [
-                    "Users"
+                    "Message"
                 ],
-                "summary": "Регистрация нового пользователя",
+                "summary": "Запись сообщения",
                 "parameters": [
                     {
-                        "description": "Данные пользователя",
+                        "description": "Данные для создания сообщения",
                         "name": "data",
                         "in": "body",
                         "required": true,
                         "schema": {
-                            "$ref": "#/definitions/users.UserRegistration"
+                            "$ref": "#/definitions/messages.AddMessageStruct"
                         }
                     }
                 ],
                 "responses": {
                     "200": {
-                        "description": "User registered successfully",
+                        "description": "successful response",
                         "schema": {
-                            "$ref": "#/definitions/users.ResponseMessage"
+                            "type": "object",
+                            "additionalProperties": true
                         }
                     },
                     "400": {
-                        "description": "Invalid request data",
+                        "description": "bad request",
                         "schema": {
-                            "$ref": "#/definitions/users.ErrorResponse"
+                            "type": "object",
+                            "additionalProperties": true
                         }
                     },
-                    "500": {
-                        "description": "Failed to connect to database",
+                    "401": {
+                        "description": "unauthorized",
                         "schema": {
-                            "$ref": "#/definitions/users.ErrorResponse"
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "403": {
+                        "description": "forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "404": {
+                        "description": "forwarded message not found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/messages/delete-message": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Удаляет копию пользователя или, с for_everyone, копии обоих участников вместе с записями поискового индекса и пересчитывает new_msg_count.\nСобытие с type = \"deleted\" уходит всем участникам чата при for_everyone и только самому пользователю иначе.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Message"
+                ],
+                "summary": "Удаление сообщения",
+                "parameters": [
+                    {
+                        "description": "Удаляемое сообщение",
+                        "name": "data",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/messages.DeleteMessageStruct"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "successful response",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "400": {
+                        "description": "bad request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "401": {
+                        "description": "unauthorized",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "403": {
+                        "description": "forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "404": {
+                        "description": "message not found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/messages/edit-message": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Заменяет текст в копиях обоих участников и в поисковом индексе. Участникам чата уходит событие с type = \"edited\".",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Message"
+                ],
+                "summary": "Редактирование сообщения",
+                "parameters": [
+                    {
+                        "description": "Сообщение и новый текст",
+                        "name": "data",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/messages.EditMessageStruct"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "successful response",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "400": {
+                        "description": "bad request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "401": {
+                        "description": "unauthorized",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "403": {
+                        "description": "forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "404": {
+                        "description": "message not found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/messages/forward": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Сервер сам расшифровывает исходное сообщение ключом исходного чата и шифрует копии для каждого целевого чата.\nВ копиях сохраняются автор и время исходного сообщения. Доступ ко всем чатам проверяется до записи.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Message"
+                ],
+                "summary": "Пересылка сообщения",
+                "parameters": [
+                    {
+                        "description": "Исходное сообщение и целевые чаты",
+                        "name": "data",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/messages.ForwardMessageStruct"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "successful response",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "400": {
+                        "description": "bad request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "401": {
+                        "description": "unauthorized",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "403": {
+                        "description": "forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "404": {
+                        "description": "message not found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
                         }
                     }
                 }
             }
-        }
-    },
-    "definitions": {
-        "chats.CreateChatStruct": {
-            "description": "Данные для создания чатов",
+        },
+        "/messages/get-messages": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Для ответов reply_preview содержит автора и начало текста цитируемого сообщения или признак его удаления.\nТекст одноразового сообщения в цитату не попадает, вместо него reply_preview.view_once = true.\nСлужебные сообщения отличаются полем kind, их данные — в payload, sender_id равен 0.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Message"
+                ],
+                "summary": "Получение сообщений",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Chat ID",
+                        "name": "chat_id",
+                        "in": "query",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "successful response",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/messages.Message"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "bad request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "401": {
+                        "description": "unauthorized",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "403": {
+                        "description": "forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/messages/pin": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Закрепление общее для обоих участников. В чат уходит событие с type = \"pinned\" или \"unpinned\",\nгде actor_id — закрепивший пользователь; закрепление также записывается в чат служебным сообщением message_pinned.\nЗакреплённое исчезающее сообщение открепляется, когда исчезает.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Message"
+                ],
+                "summary": "Закрепление сообщения в чате",
+                "parameters": [
+                    {
+                        "description": "Сообщение",
+                        "name": "data",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/messages.PinMessageStruct"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "successful response",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "400": {
+                        "description": "bad request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "401": {
+                        "description": "unauthorized",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "403": {
+                        "description": "forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "404": {
+                        "description": "message not found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "409": {
+                        "description": "too many pinned messages",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/messages/pinned": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Последние закреплённые первыми.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Message"
+                ],
+                "summary": "Закреплённые сообщения чата",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Chat ID",
+                        "name": "chat_id",
+                        "in": "query",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "successful response",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/messages.PinnedMessage"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "bad request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "401": {
+                        "description": "unauthorized",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "403": {
+                        "description": "forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/messages/read-message": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Входящее сообщение с view_once после прочтения удаляется у обоих участников; участникам уходит событие с type = \"deleted\".\nПрочтение своего сообщения ничего не меняет.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Message",
+                    "Message"
+                ],
+                "summary": "Сообщение было прочитано",
+                "parameters": [
+                    {
+                        "description": "Данные для прочтения сообщения",
+                        "name": "data",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/messages.ReadMessageStruct"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "successful response",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "400": {
+                        "description": "bad request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "401": {
+                        "description": "unauthorized",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "403": {
+                        "description": "forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/messages/read-messages-up-to": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Отмечает прочитанными все входящие сообщения, созданные не позже указанного, и пересчитывает new_msg_count. Время сообщения берётся из хранилища, а не от клиента.\nСообщения с view_once не отмечаются: каждое нужно прочитать через messages/read-message.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Message"
+                ],
+                "summary": "Прочтение всех сообщений чата до указанного включительно",
+                "parameters": [
+                    {
+                        "description": "Данные о последнем прочитанном сообщении",
+                        "name": "data",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/messages.ReadMessagesUpToStruct"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "successful response",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "400": {
+                        "description": "bad request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "401": {
+                        "description": "unauthorized",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "403": {
+                        "description": "forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/messages/schedule": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Сообщение хранится зашифрованным ключом чата отправителя и отправляется в send_at\nтак же, как через messages/add-message. Права на отправку проверяются ещё раз в момент отправки.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Message"
+                ],
+                "summary": "Отложенная отправка сообщения",
+                "parameters": [
+                    {
+                        "description": "Отложенное сообщение",
+                        "name": "data",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/messages.ScheduleMessageStruct"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "successful response",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "400": {
+                        "description": "bad request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "401": {
+                        "description": "unauthorized",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "403": {
+                        "description": "forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/messages/scheduled": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Ожидающие и неотправленные (status = failed) сообщения пользователя по возрастанию send_at.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Message"
+                ],
+                "summary": "Список отложенных сообщений",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Только сообщения этого чата",
+                        "name": "chat_id",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "successful response",
+                        "schema": {
+                            "type": "array",
+                            "items": {
+                                "$ref": "#/definitions/messages.ScheduledMessage"
+                            }
+                        }
+                    },
+                    "400": {
+                        "description": "bad request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "401": {
+                        "description": "unauthorized",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/messages/scheduled/cancel": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Удаляет ожидающее или неотправленное сообщение.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Message"
+                ],
+                "summary": "Отмена отложенного сообщения",
+                "parameters": [
+                    {
+                        "description": "Отложенное сообщение",
+                        "name": "data",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/messages.CancelScheduledMessageStruct"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "successful response",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "400": {
+                        "description": "bad request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "401": {
+                        "description": "unauthorized",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "404": {
+                        "description": "scheduled message not found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/messages/scheduled/edit": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Можно менять только сообщения, которые ещё не начали отправляться.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Message"
+                ],
+                "summary": "Изменение отложенного сообщения",
+                "parameters": [
+                    {
+                        "description": "Новые текст и время отправки",
+                        "name": "data",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/messages.EditScheduledMessageStruct"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "successful response",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "400": {
+                        "description": "bad request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "401": {
+                        "description": "unauthorized",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "403": {
+                        "description": "forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "404": {
+                        "description": "scheduled message not found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/messages/search": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Ищет сообщения пользователя, содержащие все слова запроса (поддерживаются \"фразы в кавычках\", OR и -исключение).\nБез chat_id — по всем чатам. next_offset есть в ответе, если результатов больше.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Message"
+                ],
+                "summary": "Поиск по сообщениям",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Запрос",
+                        "name": "q",
+                        "in": "query",
+                        "required": true
+                    },
+                    {
+                        "type": "string",
+                        "description": "Искать только в этом чате",
+                        "name": "chat_id",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Размер страницы (по умолчанию 20, не больше 50)",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Смещение",
+                        "name": "offset",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "successful response",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "400": {
+                        "description": "bad request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "401": {
+                        "description": "unauthorized",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "403": {
+                        "description": "forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/token/generateToken": {
+            "get": {
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Tokens"
+                ],
+                "summary": "Получение токена и uuid",
+                "responses": {
+                    "200": {
+                        "description": "successful response",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/token/key-exchange": {
+            "post": {
+                "description": "Альтернатива generateToken без RSA: стороны выводят общие ключи AES-256-GCM.\nПолученный uuid передаётся в запросах так же, как uuid из generateToken; ключи живут 10 минут.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Tokens"
+                ],
+                "summary": "Обмен ключами X25519",
+                "parameters": [
+                    {
+                        "description": "Публичный ключ клиента",
+                        "name": "data",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/tokens.KeyExchangeStruct"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/tokens.KeyExchangeResponse"
+                        }
+                    },
+                    "400": {
+                        "description": "Неверный публичный ключ",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/user/2fa/disable": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Требует пароль и код приложения или резервный код.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Users"
+                ],
+                "summary": "Отключение двухфакторной аутентификации",
+                "parameters": [
+                    {
+                        "description": "Пароль и код",
+                        "name": "data",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/users.DisableTwoFactorStruct"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "successful response",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "400": {
+                        "description": "invalid code",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "401": {
+                        "description": "wrong password",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/user/2fa/enroll": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Создаёт секрет и возвращает otpauth://-ссылку для приложения-аутентификатора. Двухфакторная аутентификация включится после user/2fa/verify.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Users"
+                ],
+                "summary": "Начало подключения двухфакторной аутентификации",
+                "responses": {
+                    "200": {
+                        "description": "successful response",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "409": {
+                        "description": "already enabled",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/user/2fa/recovery-codes": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Требует код приложения или резервный код. Прежние резервные коды перестают действовать.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Users"
+                ],
+                "summary": "Новые резервные коды",
+                "parameters": [
+                    {
+                        "description": "Код",
+                        "name": "data",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/users.TwoFactorCodeStruct"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "successful response",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "400": {
+                        "description": "invalid code",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/user/2fa/verify": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Проверяет код из приложения, включает двухфакторную аутентификацию и возвращает резервные коды. Коды показываются один раз.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Users"
+                ],
+                "summary": "Подтверждение подключения двухфакторной аутентификации",
+                "parameters": [
+                    {
+                        "description": "Код приложения",
+                        "name": "data",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/users.TwoFactorCodeStruct"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "successful response",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "400": {
+                        "description": "invalid code",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "409": {
+                        "description": "already enabled",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/user/block": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Заблокированный не может создать чат и писать пользователю, а пользователь пропадает из его поиска.\nСтатуса «в сети» в API нет, поэтому скрывать присутствие от заблокированного не требуется.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Users"
+                ],
+                "summary": "Блокировка пользователя",
+                "parameters": [
+                    {
+                        "description": "Пользователь",
+                        "name": "data",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/users.BlockUserStruct"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "successful response",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "400": {
+                        "description": "bad request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "401": {
+                        "description": "unauthorized",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "404": {
+                        "description": "not found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/user/blocked": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Заблокированные пользователи, недавно добавленные первыми.",
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Users"
+                ],
+                "summary": "Чёрный список",
+                "responses": {
+                    "200": {
+                        "description": "successful response",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "401": {
+                        "description": "unauthorized",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/user/cancel-account-deletion": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Users"
+                ],
+                "summary": "Отмена удаления аккаунта",
+                "responses": {
+                    "200": {
+                        "description": "successful response",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "401": {
+                        "description": "unauthorized",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/user/change-password": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Требует текущий пароль. Все остальные сессии пользователя завершаются, в ответе — новый токен текущей сессии.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Users"
+                ],
+                "summary": "Смена пароля",
+                "parameters": [
+                    {
+                        "description": "Текущий и новый пароль",
+                        "name": "data",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/users.ChangePasswordStruct"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "successful response",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "400": {
+                        "description": "bad request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "401": {
+                        "description": "wrong password",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/user/check-uniqueness-registration-data": {
+            "post": {
+                "description": "Проверяет не заняты ли login и Nik",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Users"
+                ],
+                "summary": "Проверка login и Nik",
+                "parameters": [
+                    {
+                        "description": "Данные о пользователе",
+                        "name": "data",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/users.UserCUD"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "User registered successfully",
+                        "schema": {
+                            "$ref": "#/definitions/users.ResponseMessage"
+                        }
+                    }
+                }
+            }
+        },
+        "/user/chek-token": {
+            "post": {
+                "description": "Проверяет валидность пользователя =\u003e сессии",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Users"
+                ],
+                "summary": "Проверка токена",
+                "parameters": [
+                    {
+                        "description": "Данные о токене",
+                        "name": "data",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/users.UserCT"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "User registered successfully",
+                        "schema": {
+                            "$ref": "#/definitions/users.ResponseMessage"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid request data",
+                        "schema": {
+                            "$ref": "#/definitions/users.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Failed to connect to database",
+                        "schema": {
+                            "$ref": "#/definitions/users.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/user/contacts": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Users"
+                ],
+                "summary": "Список контактов",
+                "responses": {
+                    "200": {
+                        "description": "successful response",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "401": {
+                        "description": "unauthorized",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/user/contacts/add": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Users"
+                ],
+                "summary": "Добавление контакта или изменение его имени",
+                "parameters": [
+                    {
+                        "description": "Контакт",
+                        "name": "data",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/users.AddContactStruct"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "successful response",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "400": {
+                        "description": "bad request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "401": {
+                        "description": "unauthorized",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "404": {
+                        "description": "not found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/user/contacts/import": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Найденные пользователи добавляются в контакты; имена уже существующих контактов не меняются.\nВ ответе — добавленные контакты и ники, которые не найдены.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Users"
+                ],
+                "summary": "Импорт контактов по никам",
+                "parameters": [
+                    {
+                        "description": "Ники",
+                        "name": "data",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/users.ImportContactsStruct"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "successful response",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "400": {
+                        "description": "bad request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "401": {
+                        "description": "unauthorized",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/user/contacts/remove": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Users"
+                ],
+                "summary": "Удаление контакта",
+                "parameters": [
+                    {
+                        "description": "Контакт",
+                        "name": "data",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/users.RemoveContactStruct"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "successful response",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "400": {
+                        "description": "bad request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "401": {
+                        "description": "unauthorized",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/user/delete-account": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Планирует удаление аккаунта по истечении срока отмены (ACCOUNT_DELETION_GRACE, по умолчанию 30 дней) и завершает все сессии. До окончания срока можно войти и отменить удаление.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Users"
+                ],
+                "summary": "Запрос на удаление аккаунта",
+                "parameters": [
+                    {
+                        "description": "Пароль",
+                        "name": "data",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/users.DeleteAccountStruct"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "successful response",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "400": {
+                        "description": "bad request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "401": {
+                        "description": "wrong password",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/user/export-data": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "ZIP-архив: profile.json, chats.json, contacts.json и messages/\u003cchat_id\u003e.json с расшифрованными сообщениями в хронологическом порядке; служебные сообщения выгружаются с kind и payload.",
+                "produces": [
+                    "application/zip"
+                ],
+                "tags": [
+                    "Users"
+                ],
+                "summary": "Выгрузка данных пользователя",
+                "responses": {
+                    "200": {
+                        "description": "ZIP-архив",
+                        "schema": {
+                            "type": "file"
+                        }
+                    },
+                    "401": {
+                        "description": "unauthorized",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/user/log-in-2fa": {
+            "post": {
+                "description": "Challenge действует 5 минут и допускает 5 попыток. Неверные коды учитываются в блокировке входа так же, как неверные пароли.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Users"
+                ],
+                "summary": "Второй шаг входа с двухфакторной аутентификацией",
+                "parameters": [
+                    {
+                        "description": "Challenge и код",
+                        "name": "data",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/users.LoginSecondFactorStruct"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "successful response",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "400": {
+                        "description": "bad request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "401": {
+                        "description": "invalid code or expired challenge",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "429": {
+                        "description": "too many attempts",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/user/log-in-with-credentials": {
+            "post": {
+                "description": "Неизвестный логин и неверный пароль дают одинаковый ответ 401. После 5 неудач подряд для логина или 20 для IP вход временно блокируется (429, заголовок Retry-After), срок блокировки растёт вдвое с каждой следующей неудачей.\nЕсли включена двухфакторная аутентификация, вместо токена возвращается two_factor_required и challenge для user/log-in-2fa.\nЕсли пользователь запросил удаление аккаунта, в ответе есть deletion_scheduled_at.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Users"
+                ],
+                "summary": "Аутентификация пользователя по логину и паролю",
+                "parameters": [
+                    {
+                        "description": "Данные пользователя",
+                        "name": "data",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/users.UserLogin"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "successful response",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "400": {
+                        "description": "bad request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "401": {
+                        "description": "invalid credentials",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "429": {
+                        "description": "too many attempts",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/user/privacy": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Users"
+                ],
+                "summary": "Настройки приватности",
+                "parameters": [
+                    {
+                        "description": "Настройки",
+                        "name": "data",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/users.PrivacyStruct"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "successful response",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "400": {
+                        "description": "bad request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "401": {
+                        "description": "unauthorized",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/user/profile": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Users"
+                ],
+                "summary": "Профиль текущего пользователя",
+                "responses": {
+                    "200": {
+                        "description": "successful response",
+                        "schema": {
+                            "$ref": "#/definitions/users.Profile"
+                        }
+                    },
+                    "401": {
+                        "description": "unauthorized",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/user/profile/{user_id}": {
+            "get": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Users"
+                ],
+                "summary": "Профиль пользователя по id",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "ID пользователя",
+                        "name": "user_id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "successful response",
+                        "schema": {
+                            "$ref": "#/definitions/users.Profile"
+                        }
+                    },
+                    "401": {
+                        "description": "unauthorized",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "404": {
+                        "description": "not found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/user/registration": {
+            "post": {
+                "description": "Регистрирует пользователя, сохраняя зашифрованные данные и ключ в базу данных.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Users"
+                ],
+                "summary": "Регистрация нового пользователя",
+                "parameters": [
+                    {
+                        "description": "Данные пользователя",
+                        "name": "data",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/users.UserRegistration"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "User registered successfully",
+                        "schema": {
+                            "$ref": "#/definitions/users.ResponseMessage"
+                        }
+                    },
+                    "400": {
+                        "description": "Invalid request data",
+                        "schema": {
+                            "$ref": "#/definitions/users.ErrorResponse"
+                        }
+                    },
+                    "500": {
+                        "description": "Failed to connect to database",
+                        "schema": {
+                            "$ref": "#/definitions/users.ErrorResponse"
+                        }
+                    }
+                }
+            }
+        },
+        "/user/report": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Для жалобы на сообщение модераторам сохраняется его текст и время отправки, автором считается отправитель.\nПожаловаться можно только на сообщение из своего чата; на служебные сообщения жаловаться нельзя.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Users"
+                ],
+                "summary": "Жалоба на пользователя или сообщение",
+                "parameters": [
+                    {
+                        "description": "Жалоба",
+                        "name": "data",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/users.ReportStruct"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "successful response",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "400": {
+                        "description": "bad request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "401": {
+                        "description": "unauthorized",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "403": {
+                        "description": "forbidden",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "404": {
+                        "description": "not found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/user/request-password-reset": {
+            "post": {
+                "description": "Отправляет одноразовый код на почту пользователя. Ответ не зависит от того, существует ли логин.\nКод для одного логина можно запросить не чаще раза в минуту, с одного IP — не больше 10 раз в час.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Users"
+                ],
+                "summary": "Запрос кода сброса пароля",
+                "parameters": [
+                    {
+                        "description": "Логин",
+                        "name": "data",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/users.RequestPasswordResetStruct"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "successful response",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "400": {
+                        "description": "bad request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "429": {
+                        "description": "too many requests",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/user/reset-password": {
+            "post": {
+                "description": "Код одноразовый и действует 15 минут. После 5 неверных кодов за час текущий код аннулируется,\nа новые коды не принимаются до конца часа. Все сессии пользователя завершаются.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Users"
+                ],
+                "summary": "Сброс пароля по коду",
+                "parameters": [
+                    {
+                        "description": "Логин, код и новый пароль",
+                        "name": "data",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/users.ResetPasswordStruct"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "successful response",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "400": {
+                        "description": "invalid or expired code",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/user/unblock": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Users"
+                ],
+                "summary": "Разблокировка пользователя",
+                "parameters": [
+                    {
+                        "description": "Пользователь",
+                        "name": "data",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/users.BlockUserStruct"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "successful response",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "400": {
+                        "description": "bad request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "401": {
+                        "description": "unauthorized",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "404": {
+                        "description": "not found",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/user/update-profile": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Ник повторно проверяется на уникальность. Собеседники получают chatUpdate с новыми данными.",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Users"
+                ],
+                "summary": "Изменение имени, фамилии, ника и почты",
+                "parameters": [
+                    {
+                        "description": "Данные профиля",
+                        "name": "data",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/users.UpdateProfileStruct"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "successful response",
+                        "schema": {
+                            "$ref": "#/definitions/users.Profile"
+                        }
+                    },
+                    "400": {
+                        "description": "bad request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "401": {
+                        "description": "unauthorized",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "409": {
+                        "description": "nik is taken",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        },
+        "/user/upload-avatar": {
+            "post": {
+                "security": [
+                    {
+                        "BearerAuth": []
+                    }
+                ],
+                "description": "Принимает JPEG, PNG, GIF или WebP до 5 МБ. Собеседники получают chatUpdate с новым аватаром.",
+                "consumes": [
+                    "multipart/form-data"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "tags": [
+                    "Users"
+                ],
+                "summary": "Загрузка аватара",
+                "parameters": [
+                    {
+                        "type": "file",
+                        "description": "Изображение",
+                        "name": "avatar",
+                        "in": "formData",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "successful response",
+                        "schema": {
+                            "$ref": "#/definitions/users.Profile"
+                        }
+                    },
+                    "400": {
+                        "description": "bad request",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "401": {
+                        "description": "unauthorized",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    },
+                    "413": {
+                        "description": "file too large",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": true
+                        }
+                    }
+                }
+            }
+        }
+    },
+    "definitions": {
+        "chats.ArchiveChatStruct": {
+            "description": "Перенос чата в архив или из архива",
+            "type": "object",
+            "properties": {
+                "archived": {
+                    "type": "boolean"
+                },
+                "chat_id": {
+                    "type": "string"
+                }
+            }
+        },
+        "chats.CreateChatStruct": {
+            "description": "Данные для создания чатов",
+            "type": "object",
+            "properties": {
+                "companion_id": {
+                    "type": "integer"
+                },
+                "user_token": {
+                    "description": "Устарело: токен передаётся в заголовке Authorization",
+                    "type": "string"
+                },
+                "uuid": {
+                    "type": "string"
+                }
+            }
+        },
+        "chats.DisappearingMessagesStruct": {
+            "description": "Таймер исчезающих сообщений. ttl_seconds = 0 отключает таймер.",
+            "type": "object",
+            "properties": {
+                "chat_id": {
+                    "type": "string"
+                },
+                "ttl_seconds": {
+                    "type": "integer"
+                }
+            }
+        },
+        "chats.Draft": {
+            "type": "object",
+            "properties": {
+                "reply_to_message_id": {
+                    "type": "string"
+                },
+                "text": {
+                    "type": "string"
+                },
+                "updated_at": {
+                    "type": "string"
+                }
+            }
+        },
+        "chats.DraftStruct": {
+            "description": "Черновик чата. Пустой text без reply_to_message_id удаляет черновик.",
+            "type": "object",
+            "properties": {
+                "chat_id": {
+                    "type": "string"
+                },
+                "reply_to_message_id": {
+                    "type": "string"
+                },
+                "text": {
+                    "type": "string"
+                }
+            }
+        },
+        "chats.DraftUpdate": {
+            "type": "object",
+            "properties": {
+                "chat_id": {
+                    "type": "string"
+                },
+                "draft": {
+                    "$ref": "#/definitions/chats.Draft"
+                }
+            }
+        },
+        "chats.MuteChatStruct": {
+            "description": "Отключение уведомлений чата. Без muted_until — навсегда.",
+            "type": "object",
+            "properties": {
+                "chat_id": {
+                    "type": "string"
+                },
+                "muted": {
+                    "type": "boolean"
+                },
+                "muted_until": {
+                    "type": "string"
+                }
+            }
+        },
+        "chats.PinChatStruct": {
+            "description": "Закрепление или открепление чата",
+            "type": "object",
+            "properties": {
+                "chat_id": {
+                    "type": "string"
+                },
+                "pinned": {
+                    "type": "boolean"
+                }
+            }
+        },
+        "chats.ReorderPinnedStruct": {
+            "description": "Новый порядок закреплённых чатов",
+            "type": "object",
+            "properties": {
+                "chat_ids": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
+        "chats.SystemPayload": {
+            "type": "object",
+            "properties": {
+                "actor_id": {
+                    "description": "ActorID — пользователь, действие которого записано в сообщении",
+                    "type": "integer"
+                },
+                "message_id": {
+                    "description": "MessageID — закреплённое сообщение для message_pinned",
+                    "type": "string"
+                },
+                "ttl_seconds": {
+                    "description": "TTLSeconds — новый таймер исчезающих сообщений для timer_changed; 0 — таймер выключен",
+                    "type": "integer"
+                }
+            }
+        },
+        "messages.AddMessageStruct": {
+            "description": "Данные для создания сообщения",
+            "type": "object",
+            "properties": {
+                "chat_id": {
+                    "type": "string"
+                },
+                "forwarded_from_chat_id": {
+                    "type": "string"
+                },
+                "forwarded_from_message_id": {
+                    "type": "string"
+                },
+                "message_text": {
+                    "type": "string"
+                },
+                "reply_to_message_id": {
+                    "type": "string"
+                },
+                "temporary_message_id": {
+                    "type": "string"
+                },
+                "user_token": {
+                    "description": "Устарело: токен передаётся в заголовке Authorization",
+                    "type": "string"
+                },
+                "view_once": {
+                    "description": "ViewOnce — сообщение удалится у обоих участников, когда получатель его прочитает",
+                    "type": "boolean"
+                }
+            }
+        },
+        "messages.CancelScheduledMessageStruct": {
+            "description": "Отмена отложенного сообщения",
+            "type": "object",
+            "properties": {
+                "id": {
+                    "type": "integer"
+                }
+            }
+        },
+        "messages.DeleteMessageStruct": {
+            "description": "Удаление сообщения. for_everyone удаляет и копию собеседника; доступно только отправителю.",
+            "type": "object",
+            "properties": {
+                "chat_id": {
+                    "type": "string"
+                },
+                "for_everyone": {
+                    "type": "boolean"
+                },
+                "message_id": {
+                    "type": "string"
+                }
+            }
+        },
+        "messages.EditMessageStruct": {
+            "description": "Новый текст сообщения. Редактировать можно только свои сообщения.",
+            "type": "object",
+            "properties": {
+                "chat_id": {
+                    "type": "string"
+                },
+                "message_id": {
+                    "type": "string"
+                },
+                "message_text": {
+                    "type": "string"
+                }
+            }
+        },
+        "messages.EditScheduledMessageStruct": {
+            "description": "Изменение отложенного сообщения. Непереданные поля не меняются.",
+            "type": "object",
+            "properties": {
+                "id": {
+                    "type": "integer"
+                },
+                "message_text": {
+                    "type": "string"
+                },
+                "send_at": {
+                    "type": "string"
+                }
+            }
+        },
+        "messages.ForwardMessageStruct": {
+            "description": "Пересылка сообщения из chat_id в один или несколько чатов",
+            "type": "object",
+            "properties": {
+                "chat_id": {
+                    "type": "string"
+                },
+                "message_id": {
+                    "type": "string"
+                },
+                "target_chat_ids": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
+        "messages.Message": {
+            "type": "object",
+            "properties": {
+                "actor_id": {
+                    "description": "ActorID — пользователь, закрепивший или открепивший сообщение, в событиях pinned и unpinned",
+                    "type": "integer"
+                },
+                "chat_id": {
+                    "type": "string"
+                },
+                "created_at": {
+                    "type": "string"
+                },
+                "edited_at": {
+                    "type": "string"
+                },
+                "expires_at": {
+                    "description": "ExpiresAt — когда сообщение исчезнет по таймеру чата",
+                    "type": "string"
+                },
+                "forwarded_at": {
+                    "type": "string"
+                },
+                "forwarded_from_chat_id": {
+                    "type": "string"
+                },
+                "forwarded_from_message_id": {
+                    "type": "string"
+                },
+                "forwarded_from_sender_id": {
+                    "description": "Автор и время исходного сообщения, если сообщение переслано",
+                    "type": "integer"
+                },
+                "is_my_message": {
+                    "type": "boolean"
+                },
+                "kind": {
+                    "description": "Kind — вид служебного сообщения (chat_created, message_pinned, timer_changed); у служебных\nсообщений нет текста и отправителя, данные события — в payload",
+                    "type": "string"
+                },
+                "message_id": {
+                    "type": "string"
+                },
+                "message_text": {
+                    "type": "string"
+                },
+                "payload": {
+                    "$ref": "#/definitions/chats.SystemPayload"
+                },
+                "read": {
+                    "type": "boolean"
+                },
+                "reply_preview": {
+                    "description": "ReplyPreview — цитата сообщения reply_to_message_id",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/messages.ReplyPreview"
+                        }
+                    ]
+                },
+                "reply_to_message_id": {
+                    "type": "string"
+                },
+                "sender_id": {
+                    "type": "integer"
+                },
+                "temporary_message_id": {
+                    "type": "string"
+                },
+                "type": {
+                    "type": "string"
+                },
+                "view_once": {
+                    "type": "boolean"
+                }
+            }
+        },
+        "messages.PinMessageStruct": {
+            "description": "Закрепление или открепление сообщения",
+            "type": "object",
+            "properties": {
+                "chat_id": {
+                    "type": "string"
+                },
+                "message_id": {
+                    "type": "string"
+                },
+                "pinned": {
+                    "type": "boolean"
+                }
+            }
+        },
+        "messages.PinnedMessage": {
+            "type": "object",
+            "properties": {
+                "actor_id": {
+                    "description": "ActorID — пользователь, закрепивший или открепивший сообщение, в событиях pinned и unpinned",
+                    "type": "integer"
+                },
+                "chat_id": {
+                    "type": "string"
+                },
+                "created_at": {
+                    "type": "string"
+                },
+                "edited_at": {
+                    "type": "string"
+                },
+                "expires_at": {
+                    "description": "ExpiresAt — когда сообщение исчезнет по таймеру чата",
+                    "type": "string"
+                },
+                "forwarded_at": {
+                    "type": "string"
+                },
+                "forwarded_from_chat_id": {
+                    "type": "string"
+                },
+                "forwarded_from_message_id": {
+                    "type": "string"
+                },
+                "forwarded_from_sender_id": {
+                    "description": "Автор и время исходного сообщения, если сообщение переслано",
+                    "type": "integer"
+                },
+                "is_my_message": {
+                    "type": "boolean"
+                },
+                "kind": {
+                    "description": "Kind — вид служебного сообщения (chat_created, message_pinned, timer_changed); у служебных\nсообщений нет текста и отправителя, данные события — в payload",
+                    "type": "string"
+                },
+                "message_id": {
+                    "type": "string"
+                },
+                "message_text": {
+                    "type": "string"
+                },
+                "payload": {
+                    "$ref": "#/definitions/chats.SystemPayload"
+                },
+                "pinned_at": {
+                    "type": "string"
+                },
+                "pinned_by": {
+                    "type": "integer"
+                },
+                "read": {
+                    "type": "boolean"
+                },
+                "reply_preview": {
+                    "description": "ReplyPreview — цитата сообщения reply_to_message_id",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/messages.ReplyPreview"
+                        }
+                    ]
+                },
+                "reply_to_message_id": {
+                    "type": "string"
+                },
+                "sender_id": {
+                    "type": "integer"
+                },
+                "temporary_message_id": {
+                    "type": "string"
+                },
+                "type": {
+                    "type": "string"
+                },
+                "view_once": {
+                    "type": "boolean"
+                }
+            }
+        },
+        "messages.ReadMessageStruct": {
+            "description": "Данные для прочтения сообщения",
             "type": "object",
             "properties": {
-                "companion_id": {
-                    "type": "integer"
+                "chat_id": {
+                    "type": "string"
                 },
-                "user_token": {
+                "message_id": {
                     "type": "string"
                 },
-                "uuid": {
+                "user_token": {
+                    "description": "Устарело: токен передаётся в заголовке Authorization",
                     "type": "string"
                 }
             }
         },
-        "messages.AddMessageStruct": {
-            "description": "Данные для создания сообщения",
+        "messages.ReadMessagesUpToStruct": {
+            "description": "Данные для прочтения всех сообщений до указанного включительно",
             "type": "object",
             "properties": {
                 "chat_id": {
                     "type": "string"
                 },
-                "forwarded_from_chat_id": {
+                "message_id": {
                     "type": "string"
                 },
-                "forwarded_from_message_id": {
+                "user_token": {
+                    "description": "Устарело: токен передаётся в заголовке Authorization",
+                    "type": "string"
+                }
+            }
+        },
+        "messages.ReplyPreview": {
+            "type": "object",
+            "properties": {
+                "deleted": {
+                    "description": "Deleted — сообщение удалено из копии пользователя",
+                    "type": "boolean"
+                },
+                "message_id": {
                     "type": "string"
                 },
-                "message_text": {
+                "sender_id": {
+                    "type": "integer"
+                },
+                "text": {
+                    "description": "Text обрезан до 100 символов",
                     "type": "string"
                 },
-                "reply_to_message_id": {
+                "unavailable": {
+                    "description": "Unavailable — сообщение не удалось прочитать",
+                    "type": "boolean"
+                },
+                "view_once": {
+                    "description": "ViewOnce — цитируется одноразовое сообщение; его текст в превью не попадает",
+                    "type": "boolean"
+                }
+            }
+        },
+        "messages.ScheduleMessageStruct": {
+            "description": "Отложенное сообщение. send_at — время отправки в формате RFC 3339, не позже чем через год.",
+            "type": "object",
+            "properties": {
+                "chat_id": {
                     "type": "string"
                 },
-                "temporary_message_id": {
+                "message_text": {
                     "type": "string"
                 },
-                "user_token": {
+                "reply_to_message_id": {
+                    "type": "string"
+                },
+                "send_at": {
                     "type": "string"
                 }
             }
         },
-        "messages.Message": {
+        "messages.ScheduledMessage": {
             "type": "object",
             "properties": {
                 "chat_id": {
                     "type": "string"
                 },
-                "created_at": {
+                "error": {
                     "type": "string"
                 },
-                "forwarded_from_chat_id": {
+                "id": {
+                    "type": "integer"
+                },
+                "message_text": {
                     "type": "string"
                 },
-                "forwarded_from_message_id": {
+                "reply_to_message_id": {
                     "type": "string"
                 },
-                "is_my_message": {
-                    "type": "boolean"
+                "send_at": {
+                    "type": "string"
                 },
-                "message_id": {
+                "status": {
+                    "description": "Status — pending, пока сообщение ждёт отправки, или failed, если отправить не удалось",
                     "type": "string"
+                }
+            }
+        },
+        "tokens.KeyExchangeResponse": {
+            "description": "uuid обмена и публичный ключ X25519 сервера. Поля запросов шифруются AES-256-GCM ключом клиент→сервер, выведенным HKDF-SHA256 из общего секрета (соль — uuid, info — \"bmessage handshake v1\"); формат поля — base64(nonce || ciphertext), связанные данные — uuid.",
+            "type": "object",
+            "properties": {
+                "expires_in": {
+                    "type": "integer"
                 },
-                "message_text": {
+                "scheme": {
                     "type": "string"
                 },
-                "read": {
-                    "type": "boolean"
+                "server_public_key": {
+                    "type": "string"
                 },
-                "reply_to_message_id": {
+                "uuid": {
+                    "type": "string"
+                }
+            }
+        },
+        "tokens.KeyExchangeStruct": {
+            "description": "Публичный ключ X25519 клиента, 32 байта в base64",
+            "type": "object",
+            "required": [
+                "client_public_key"
+            ],
+            "properties": {
+                "client_public_key": {
+                    "type": "string"
+                }
+            }
+        },
+        "users.AddContactStruct": {
+            "description": "Добавление контакта. custom_name заменяет имя контакта в списке чатов; пустое — убирает замену.",
+            "type": "object",
+            "properties": {
+                "custom_name": {
                     "type": "string"
                 },
-                "sender_id": {
+                "user_id": {
+                    "type": "integer"
+                }
+            }
+        },
+        "users.BlockUserStruct": {
+            "description": "Пользователь, которого нужно добавить в чёрный список или убрать из него",
+            "type": "object",
+            "properties": {
+                "user_id": {
                     "type": "integer"
+                }
+            }
+        },
+        "users.ChangePasswordStruct": {
+            "description": "Смена пароля. Пароли зашифрованы ключом из token/generateToken.",
+            "type": "object",
+            "properties": {
+                "current_password": {
+                    "type": "string"
                 },
-                "temporary_message_id": {
+                "new_password": {
                     "type": "string"
                 },
-                "type": {
+                "pKey": {
+                    "type": "string"
+                },
+                "uuid": {
                     "type": "string"
                 }
             }
         },
-        "messages.ReadMessageStruct": {
-            "description": "Данные для прочтения сообщения",
+        "users.DeleteAccountStruct": {
+            "description": "Запрос на удаление аккаунта. Пароль зашифрован ключом из token/generateToken.",
             "type": "object",
             "properties": {
-                "chat_id": {
+                "password": {
                     "type": "string"
                 },
-                "created_at": {
+                "uuid": {
+                    "type": "string"
+                }
+            }
+        },
+        "users.DisableTwoFactorStruct": {
+            "description": "Отключение двухфакторной аутентификации. Пароль зашифрован ключом из token/generateToken.",
+            "type": "object",
+            "properties": {
+                "code": {
                     "type": "string"
                 },
-                "message_id": {
+                "password": {
                     "type": "string"
                 },
-                "user_token": {
+                "recovery_code": {
+                    "type": "string"
+                },
+                "uuid": {
                     "type": "string"
                 }
             }
@@ -588,6 +3533,139 @@ const docTemplate = `{
                 }
             }
         },
+        "users.ImportContactsStruct": {
+            "description": "Импорт контактов по списку ников, не больше 500",
+            "type": "object",
+            "properties": {
+                "niks": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
+        "users.LoginSecondFactorStruct": {
+            "description": "Второй шаг входа: challenge из user/log-in-with-credentials и код приложения или резервный код",
+            "type": "object",
+            "properties": {
+                "challenge": {
+                    "type": "string"
+                },
+                "code": {
+                    "type": "string"
+                },
+                "pKey": {
+                    "type": "string"
+                },
+                "recovery_code": {
+                    "type": "string"
+                },
+                "uuid": {
+                    "description": "Uuid — обмен ключами, которым защищён первый шаг; для token/key-exchange токен шифруется его ключом",
+                    "type": "string"
+                }
+            }
+        },
+        "users.PrivacyStruct": {
+            "description": "Настройки приватности",
+            "type": "object",
+            "properties": {
+                "contacts_only": {
+                    "description": "Начинать чат могут только контакты. Уже существующие чаты продолжают работать.",
+                    "type": "boolean"
+                }
+            }
+        },
+        "users.Profile": {
+            "type": "object",
+            "properties": {
+                "avatar": {
+                    "type": "string"
+                },
+                "contacts_only": {
+                    "type": "boolean"
+                },
+                "email": {
+                    "type": "string"
+                },
+                "login": {
+                    "type": "string"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "nik": {
+                    "type": "string"
+                },
+                "soName": {
+                    "type": "string"
+                },
+                "two_factor_enabled": {
+                    "type": "boolean"
+                },
+                "user_id": {
+                    "type": "integer"
+                }
+            }
+        },
+        "users.RemoveContactStruct": {
+            "description": "Удаление контакта",
+            "type": "object",
+            "properties": {
+                "user_id": {
+                    "type": "integer"
+                }
+            }
+        },
+        "users.ReportStruct": {
+            "description": "Жалоба на пользователя (user_id) или на сообщение (chat_id и message_id).",
+            "type": "object",
+            "properties": {
+                "chat_id": {
+                    "type": "string"
+                },
+                "message_id": {
+                    "type": "string"
+                },
+                "reason": {
+                    "type": "string"
+                },
+                "user_id": {
+                    "type": "integer"
+                }
+            }
+        },
+        "users.RequestPasswordResetStruct": {
+            "description": "Запрос кода сброса пароля. Логин зашифрован ключом из token/generateToken.",
+            "type": "object",
+            "properties": {
+                "login": {
+                    "type": "string"
+                },
+                "uuid": {
+                    "type": "string"
+                }
+            }
+        },
+        "users.ResetPasswordStruct": {
+            "description": "Сброс пароля по коду. Все поля зашифрованы ключом из token/generateToken.",
+            "type": "object",
+            "properties": {
+                "code": {
+                    "type": "string"
+                },
+                "login": {
+                    "type": "string"
+                },
+                "new_password": {
+                    "type": "string"
+                },
+                "uuid": {
+                    "type": "string"
+                }
+            }
+        },
         "users.ResponseMessage": {
             "type": "object",
             "properties": {
@@ -603,6 +3681,37 @@ const docTemplate = `{
                 }
             }
         },
+        "users.TwoFactorCodeStruct": {
+            "description": "Код приложения-аутентификатора или резервный код",
+            "type": "object",
+            "properties": {
+                "code": {
+                    "type": "string"
+                },
+                "recovery_code": {
+                    "type": "string"
+                }
+            }
+        },
+        "users.UpdateProfileStruct": {
+            "description": "Новые данные профиля. Незаданные поля не меняются.",
+            "type": "object",
+            "properties": {
+                "email": {
+                    "description": "Почта для кодов сброса пароля. Пустая строка удаляет почту.",
+                    "type": "string"
+                },
+                "name": {
+                    "type": "string"
+                },
+                "nik": {
+                    "type": "string"
+                },
+                "soName": {
+                    "type": "string"
+                }
+            }
+        },
         "users.UserCT": {
             "description": "User chekToken data structure.",
             "type": "object",
@@ -685,6 +3794,14 @@ const docTemplate = `{
                 }
             }
         }
+    },
+    "securityDefinitions": {
+        "BearerAuth": {
+            "description": "Токен пользователя в формате \"Bearer \u003ctoken\u003e\"",
+            "type": "apiKey",
+            "name": "Authorization",
+            "in": "header"
+        }
     }
 }`
 
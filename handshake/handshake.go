@@ -0,0 +1,266 @@
+// Package handshake защищает учётные данные в запросах без Bearer-токена
+// (регистрация, вход, смена пароля и т.п.).
+//
+// Поддерживаются три схемы:
+//   - x25519: клиент выполняет token/key-exchange, стороны получают общий секрет ECDH
+//     и выводят из него через HKDF-SHA256 по ключу AES-256-GCM на каждое направление;
+//   - rsa: прежний обмен через token/generateToken — поля зашифрованы RSA PKCS#1 v1.5,
+//     ответ шифруется публичным ключом клиента pKey;
+//   - tls: uuid не передан, соединение защищено TLS — поля передаются открыто.
+package handshake
+
+import (
+	"Bmessage_backend/database"
+	"Bmessage_backend/helpers"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	SchemeX25519 = "x25519"
+	SchemeRSA    = "rsa"
+	SchemeTLS    = "tls"
+	// SchemeNone — uuid не передан и соединение не защищено: расшифровывать нечего,
+	// ответ можно зашифровать только публичным ключом клиента
+	SchemeNone = "none"
+)
+
+// KeyTTL — время жизни ключей, выведенных при обмене X25519
+const KeyTTL = 10 * time.Minute
+
+const (
+	keySize  = 32
+	hkdfInfo = "bmessage handshake v1"
+)
+
+var (
+	// ErrUnknownSession — ключи для uuid не найдены или истекли
+	ErrUnknownSession = errors.New("handshake: неизвестный или истёкший uuid")
+	// ErrInsecure — данные нельзя принять или отдать без шифрования по незащищённому соединению
+	ErrInsecure = errors.New("handshake: требуется TLS или обмен ключами")
+	// ErrInvalidKey — публичный ключ клиента не является ключом X25519
+	ErrInvalidKey = errors.New("handshake: неверный публичный ключ")
+	// ErrMalformed — зашифрованное поле повреждено или зашифровано другим ключом
+	ErrMalformed = errors.New("handshake: неверные зашифрованные данные")
+)
+
+func x25519Key(id string) string { return id + "_x25519_key" }
+func rsaKey(id string) string    { return id + "_private_key" }
+
+// Secure сообщает, пришёл ли запрос по TLS. Заголовок X-Forwarded-Proto учитывается
+// только при TLS_TRUST_FORWARDED_PROTO=true, когда TLS завершается на прокси.
+func Secure(r *http.Request) bool {
+	if r.TLS != nil {
+		return true
+	}
+	return os.Getenv("TLS_TRUST_FORWARDED_PROTO") == "true" && r.Header.Get("X-Forwarded-Proto") == "https"
+}
+
+// Exchange — результат обмена ключами X25519
+type Exchange struct {
+	Uuid            string
+	ServerPublicKey string
+	ExpiresIn       time.Duration
+}
+
+// KeyExchange выполняет серверную часть обмена: clientPublicKey — 32 байта ключа X25519
+// в base64. Выведенные ключи сохраняются в Redis на KeyTTL.
+func KeyExchange(ctx context.Context, clientPublicKey string) (Exchange, error) {
+	raw, err := base64.StdEncoding.DecodeString(clientPublicKey)
+	if err != nil {
+		return Exchange{}, ErrInvalidKey
+	}
+	clientKey, err := ecdh.X25519().NewPublicKey(raw)
+	if err != nil {
+		return Exchange{}, ErrInvalidKey
+	}
+
+	serverKey, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return Exchange{}, err
+	}
+	shared, err := serverKey.ECDH(clientKey)
+	if err != nil {
+		// Ключ малого порядка даёт нулевой общий секрет
+		return Exchange{}, ErrInvalidKey
+	}
+
+	id := uuid.New().String()
+	toServer, toClient, err := DeriveKeys(shared, id)
+	if err != nil {
+		return Exchange{}, err
+	}
+
+	client := database.GetRedis()
+	defer client.Close()
+
+	keys := base64.StdEncoding.EncodeToString(append(toServer, toClient...))
+	if err := client.Set(ctx, x25519Key(id), keys, KeyTTL).Err(); err != nil {
+		return Exchange{}, err
+	}
+
+	return Exchange{
+		Uuid:            id,
+		ServerPublicKey: base64.StdEncoding.EncodeToString(serverKey.PublicKey().Bytes()),
+		ExpiresIn:       KeyTTL,
+	}, nil
+}
+
+// DeriveKeys выводит из общего секрета ECDH ключи для направлений клиент→сервер
+// и сервер→клиент. uuid служит солью HKDF.
+func DeriveKeys(shared []byte, id string) (toServer, toClient []byte, err error) {
+	kdf := hkdf.New(sha256.New, shared, []byte(id), []byte(hkdfInfo))
+	keys := make([]byte, 2*keySize)
+	if _, err := io.ReadFull(kdf, keys); err != nil {
+		return nil, nil, err
+	}
+	return keys[:keySize], keys[keySize:], nil
+}
+
+// Seal шифрует значение AES-256-GCM. Результат — base64(nonce || ciphertext),
+// uuid передаётся как связанные данные.
+func Seal(key []byte, id, plaintext string) (string, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := aead.Seal(nonce, nonce, []byte(plaintext), []byte(id))
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Open расшифровывает значение, зашифрованное Seal
+func Open(key []byte, id, sealed string) (string, error) {
+	aead, err := newAEAD(key)
+	if err != nil {
+		return "", err
+	}
+	raw, err := base64.StdEncoding.DecodeString(sealed)
+	if err != nil || len(raw) < aead.NonceSize() {
+		return "", ErrMalformed
+	}
+	plaintext, err := aead.Open(nil, raw[:aead.NonceSize()], raw[aead.NonceSize():], []byte(id))
+	if err != nil {
+		return "", ErrMalformed
+	}
+	return string(plaintext), nil
+}
+
+func newAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Session — схема защиты полей конкретного запроса
+type Session struct {
+	Scheme   string
+	uuid     string
+	rsaKey   string
+	toServer []byte
+	toClient []byte
+}
+
+// Start определяет схему запроса по uuid: ключи X25519, затем RSA-ключ
+// token/generateToken. Без uuid запрос по TLS принимается открытым.
+func Start(ctx context.Context, r *http.Request, id string) (*Session, error) {
+	if id == "" {
+		if Secure(r) {
+			return &Session{Scheme: SchemeTLS}, nil
+		}
+		return &Session{Scheme: SchemeNone}, nil
+	}
+
+	client := database.GetRedis()
+	defer client.Close()
+
+	keys, err := client.Get(ctx, x25519Key(id)).Result()
+	if err == nil {
+		raw, err := base64.StdEncoding.DecodeString(keys)
+		if err != nil || len(raw) != 2*keySize {
+			return nil, ErrUnknownSession
+		}
+		return &Session{Scheme: SchemeX25519, uuid: id, toServer: raw[:keySize], toClient: raw[keySize:]}, nil
+	}
+	if err != redis.Nil {
+		return nil, err
+	}
+
+	privateKeyPEM, err := client.Get(ctx, rsaKey(id)).Result()
+	if err == redis.Nil {
+		return nil, ErrUnknownSession
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &Session{Scheme: SchemeRSA, uuid: id, rsaKey: privateKeyPEM}, nil
+}
+
+// Decrypt расшифровывает поля запроса на месте
+func (s *Session) Decrypt(fields ...*string) error {
+	for _, field := range fields {
+		var (
+			decrypted string
+			err       error
+		)
+		switch s.Scheme {
+		case SchemeX25519:
+			decrypted, err = Open(s.toServer, s.uuid, *field)
+		case SchemeRSA:
+			decrypted, err = helpers.DecryptWithPrivateKey(*field, s.rsaKey)
+		case SchemeTLS:
+			decrypted = *field
+		default:
+			err = ErrInsecure
+		}
+		if err != nil {
+			return err
+		}
+		*field = decrypted
+	}
+	return nil
+}
+
+// Seal шифрует значение ответа. Для x25519 используется ключ сервер→клиент;
+// иначе, если клиент передал pKey, значение шифруется им, как прежде; по TLS без pKey
+// значение отдаётся открыто.
+func (s *Session) Seal(value, pKey string) (string, error) {
+	switch {
+	case s.Scheme == SchemeX25519:
+		return Seal(s.toClient, s.uuid, value)
+	case pKey != "":
+		return helpers.EncryptWithPublicKey(value, pKey)
+	case s.Scheme == SchemeTLS:
+		return value, nil
+	default:
+		return "", ErrInsecure
+	}
+}
+
+// DecryptFields — Start и Decrypt одним вызовом
+func DecryptFields(ctx context.Context, r *http.Request, id string, fields ...*string) (*Session, error) {
+	session, err := Start(ctx, r, id)
+	if err != nil {
+		return nil, err
+	}
+	return session, session.Decrypt(fields...)
+}
@@ -0,0 +1,142 @@
+package handshake
+
+import (
+	"context"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func exchange(t *testing.T) (Exchange, []byte, []byte) {
+	t.Helper()
+	t.Setenv("REDIS_ADDR", miniredis.RunT(t).Addr())
+
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := KeyExchange(context.Background(), base64.StdEncoding.EncodeToString(key.PublicKey().Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(result.ServerPublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverKey, err := ecdh.X25519().NewPublicKey(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	shared, err := key.ECDH(serverKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	toServer, toClient, err := DeriveKeys(shared, result.Uuid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return result, toServer, toClient
+}
+
+func TestX25519RoundTrip(t *testing.T) {
+	result, toServer, toClient := exchange(t)
+	if result.ExpiresIn != KeyTTL {
+		t.Fatalf("ExpiresIn = %s, want %s", result.ExpiresIn, KeyTTL)
+	}
+
+	req := httptest.NewRequest("POST", "/", nil)
+	session, err := Start(context.Background(), req, result.Uuid)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if session.Scheme != SchemeX25519 {
+		t.Fatalf("scheme = %q, want %q", session.Scheme, SchemeX25519)
+	}
+
+	login, err := Seal(toServer, result.Uuid, "ivan_login")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := session.Decrypt(&login); err != nil || login != "ivan_login" {
+		t.Fatalf("Decrypt = %q, %v", login, err)
+	}
+
+	// Ключ сервер→клиент не подходит для запросов, а uuid входит в связанные данные
+	reflected, _ := Seal(toClient, result.Uuid, "ivan_login")
+	if err := session.Decrypt(&reflected); err != ErrMalformed {
+		t.Fatalf("Decrypt with wrong direction key = %v, want ErrMalformed", err)
+	}
+	if _, err := Open(toServer, "other-uuid", login); err != ErrMalformed {
+		t.Fatalf("Open with other uuid = %v, want ErrMalformed", err)
+	}
+
+	sealed, err := session.Seal("token", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if opened, err := Open(toClient, result.Uuid, sealed); err != nil || opened != "token" {
+		t.Fatalf("Open = %q, %v", opened, err)
+	}
+}
+
+func TestKeyExchangeRejectsInvalidKeys(t *testing.T) {
+	t.Setenv("REDIS_ADDR", miniredis.RunT(t).Addr())
+
+	for _, key := range []string{"not base64", base64.StdEncoding.EncodeToString([]byte("short")), base64.StdEncoding.EncodeToString(make([]byte, 32))} {
+		if _, err := KeyExchange(context.Background(), key); err != ErrInvalidKey {
+			t.Errorf("KeyExchange(%q) = %v, want ErrInvalidKey", key, err)
+		}
+	}
+}
+
+func TestStartWithoutUuid(t *testing.T) {
+	t.Setenv("REDIS_ADDR", miniredis.RunT(t).Addr())
+	ctx := context.Background()
+
+	if _, err := Start(ctx, httptest.NewRequest("POST", "/", nil), "unknown"); err != ErrUnknownSession {
+		t.Fatalf("Start with unknown uuid = %v, want ErrUnknownSession", err)
+	}
+
+	plain, err := Start(ctx, httptest.NewRequest("POST", "/", nil), "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	field := "secret"
+	if err := plain.Decrypt(&field); err != ErrInsecure {
+		t.Fatalf("Decrypt over plain HTTP = %v, want ErrInsecure", err)
+	}
+	if _, err := plain.Seal("token", ""); err != ErrInsecure {
+		t.Fatalf("Seal over plain HTTP = %v, want ErrInsecure", err)
+	}
+
+	req := httptest.NewRequest("POST", "/", nil)
+	req.TLS = &tls.ConnectionState{}
+	secure, err := Start(ctx, req, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := secure.Decrypt(&field); err != nil || field != "secret" {
+		t.Fatalf("Decrypt over TLS = %q, %v", field, err)
+	}
+	if token, err := secure.Seal("token", ""); err != nil || token != "token" {
+		t.Fatalf("Seal over TLS = %q, %v", token, err)
+	}
+}
+
+func TestSecureForwardedProto(t *testing.T) {
+	req := httptest.NewRequest("POST", "/", nil)
+	req.Header.Set("X-Forwarded-Proto", "https")
+	if Secure(req) {
+		t.Fatal("X-Forwarded-Proto trusted without TLS_TRUST_FORWARDED_PROTO")
+	}
+	t.Setenv("TLS_TRUST_FORWARDED_PROTO", "true")
+	if !Secure(req) {
+		t.Fatal("X-Forwarded-Proto ignored with TLS_TRUST_FORWARDED_PROTO=true")
+	}
+}
@@ -38,6 +38,8 @@ func removePadding(data []byte) ([]byte, error) {
 
 type UserData struct {
 	User_id uint
+	// Версия сессий пользователя на момент выдачи токена, см. пакет sessions
+	Session_version int64 `json:",omitempty"`
 }
 
 // EncryptAES шифрует данные с использованием AES
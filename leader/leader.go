@@ -0,0 +1,63 @@
+// Package leader выбирает среди инстансов одного исполнителя фоновой задачи.
+// Лидер держит ключ Redis с ограниченным временем жизни и продлевает его на каждом шаге;
+// если инстанс остановился, ключ истекает и лидерство переходит к другому.
+package leader
+
+import (
+	"Bmessage_backend/database"
+	"context"
+	"time"
+
+	"github.com/go-redis/redis/v8"
+	"github.com/google/uuid"
+)
+
+// renewScript продлевает ключ, только если он принадлежит этому инстансу
+var renewScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("PEXPIRE", KEYS[1], ARGV[2])
+end
+return 0`)
+
+// releaseScript удаляет ключ, только если он принадлежит этому инстансу
+var releaseScript = redis.NewScript(`
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0`)
+
+// Lease — аренда лидерства по ключу Key
+type Lease struct {
+	Key string
+	ID  string
+	TTL time.Duration
+}
+
+// New возвращает аренду со случайным идентификатором инстанса
+func New(key string, ttl time.Duration) *Lease {
+	return &Lease{Key: key, ID: uuid.New().String(), TTL: ttl}
+}
+
+// Acquire захватывает свободную аренду или продлевает свою. true — инстанс остаётся
+// лидером как минимум TTL; шаг задачи должен укладываться в это время.
+func (l *Lease) Acquire(ctx context.Context) (bool, error) {
+	client := database.GetRedis()
+	defer client.Close()
+
+	acquired, err := client.SetNX(ctx, l.Key, l.ID, l.TTL).Result()
+	if err != nil || acquired {
+		return acquired, err
+	}
+	renewed, err := renewScript.Run(ctx, client, []string{l.Key}, l.ID, l.TTL.Milliseconds()).Int()
+	if err != nil {
+		return false, err
+	}
+	return renewed == 1, nil
+}
+
+// Release отдаёт аренду, если она принадлежит этому инстансу
+func (l *Lease) Release(ctx context.Context) error {
+	client := database.GetRedis()
+	defer client.Close()
+	return releaseScript.Run(ctx, client, []string{l.Key}, l.ID).Err()
+}
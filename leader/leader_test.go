@@ -0,0 +1,53 @@
+package leader
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+)
+
+func TestLease(t *testing.T) {
+	redisServer := miniredis.RunT(t)
+	t.Setenv("REDIS_ADDR", redisServer.Addr())
+	ctx := context.Background()
+
+	first := New("job", time.Minute)
+	second := New("job", time.Minute)
+
+	acquire := func(lease *Lease, want bool) {
+		t.Helper()
+		got, err := lease.Acquire(ctx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Fatalf("Acquire(%s) = %v, want %v", lease.ID, got, want)
+		}
+	}
+
+	acquire(first, true)
+	acquire(second, false)
+
+	// Продление переносит истечение аренды
+	redisServer.FastForward(50 * time.Second)
+	acquire(first, true)
+	redisServer.FastForward(50 * time.Second)
+	acquire(second, false)
+
+	// Чужая аренда не освобождается
+	if err := second.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+	acquire(second, false)
+
+	if err := first.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+	acquire(second, true)
+
+	// Остановившийся лидер теряет аренду по истечении TTL
+	redisServer.FastForward(2 * time.Minute)
+	acquire(first, true)
+}
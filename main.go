@@ -1,15 +1,18 @@
 package main
 
 import (
-	"Bmessage_backend/database"
-	Models "Bmessage_backend/models"
+	"Bmessage_backend/middleware"
+	"Bmessage_backend/migrations"
 	"Bmessage_backend/routs/chats"
 	"Bmessage_backend/routs/messages"
 	"Bmessage_backend/routs/tokens"
 	"Bmessage_backend/routs/users"
+	"Bmessage_backend/server"
+	"context"
 	"log"
 	"net/http"
 	"os"
+	"time"
 
 	"github.com/joho/godotenv"
 
@@ -18,6 +21,10 @@ import (
 	ginSwagger "github.com/swaggo/gin-swagger"
 )
 
+// @securityDefinitions.apikey BearerAuth
+// @in header
+// @name Authorization
+// @description Токен пользователя в формате "Bearer <token>"
 func main() {
 	// Init .env
 	err := godotenv.Load()
@@ -26,11 +33,24 @@ func main() {
 	}
 
 	// Migrations
-	Models.MigrationUsertabel()
-	database.InitScylla()
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := migrations.RunCommand(os.Args[2:]); err != nil {
+			log.Fatalf("Migration failed: %v", err)
+		}
+		return
+	}
+
+	if err := migrations.Up(); err != nil {
+		log.Fatalf("Migration failed: %v", err)
+	}
+
+	// Background jobs
+	go users.RunAccountPurge(context.Background(), time.Hour)
+	go messages.RunScheduledDispatcher(context.Background(), 5*time.Second)
 
 	// Routs
-	router := gin.Default()
+	router := gin.New()
+	router.Use(middleware.Logger(), gin.Recovery())
 	users.UsersRouter(router)
 	tokens.TokensRouter(router)
 	chats.ChatRouter(router)
@@ -48,7 +68,7 @@ func main() {
 	})
 
 	// Start server
-	serverPort := os.Getenv("SERVER_PORT")
-	log.Println("Server will start on port:", serverPort)
-	router.Run(":" + serverPort)
+	if err := server.Run(router); err != nil {
+		log.Fatalf("Server stopped: %v", err)
+	}
 }
@@ -0,0 +1,105 @@
+package middleware
+
+import (
+	"Bmessage_backend/helpers"
+	"Bmessage_backend/sessions"
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+const userIDKey = "auth.user_id"
+
+// Поля, в которых клиенты передавали токен до появления заголовка Authorization
+var (
+	legacyTokenQueries = []string{"user_token", "userToken"}
+	legacyTokenFields  = []string{"user_token", "User_token", "UserToken"}
+)
+
+// RequireUser проверяет токен пользователя и кладёт его id в контекст запроса.
+// Токен берётся из заголовка Authorization: Bearer, а если его нет — из старых
+// полей user_token/userToken в query или JSON-теле. Без валидного токена или с
+// токеном отозванной сессии запрос завершается с 401.
+func RequireUser() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		token := requestToken(c)
+		if token == "" {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Требуется авторизация"})
+			return
+		}
+
+		userData, err := helpers.DecryptAES(token)
+		if err != nil || userData.User_id == 0 {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Недействительный токен"})
+			return
+		}
+
+		valid, err := sessions.Valid(c.Request.Context(), userData)
+		if err != nil {
+			log.Println("Failed to check session version:", err)
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": "Внутренняя ошибка сервера"})
+			return
+		}
+		if !valid {
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"error": "Сессия была завершена"})
+			return
+		}
+
+		c.Set(userIDKey, userData.User_id)
+		c.Next()
+	}
+}
+
+// UserID возвращает id пользователя, проверенного RequireUser
+func UserID(c *gin.Context) uint {
+	return c.MustGet(userIDKey).(uint)
+}
+
+func requestToken(c *gin.Context) string {
+	if header := c.GetHeader("Authorization"); header != "" {
+		scheme, token, found := strings.Cut(header, " ")
+		if found && strings.EqualFold(scheme, "Bearer") {
+			return strings.TrimSpace(token)
+		}
+		return ""
+	}
+
+	for _, name := range legacyTokenQueries {
+		if token := c.Query(name); token != "" {
+			return token
+		}
+	}
+
+	return bodyToken(c)
+}
+
+// bodyToken ищет токен в JSON-теле и возвращает тело на место для обработчика
+func bodyToken(c *gin.Context) string {
+	if c.Request.Body == nil || c.ContentType() != gin.MIMEJSON {
+		return ""
+	}
+
+	body, err := io.ReadAll(c.Request.Body)
+	c.Request.Body = io.NopCloser(bytes.NewReader(body))
+	if err != nil {
+		return ""
+	}
+
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(body, &fields); err != nil {
+		return ""
+	}
+
+	for _, name := range legacyTokenFields {
+		var token string
+		if raw, ok := fields[name]; ok && json.Unmarshal(raw, &token) == nil && token != "" {
+			return token
+		}
+	}
+	return ""
+}
@@ -0,0 +1,122 @@
+package middleware
+
+import (
+	"Bmessage_backend/helpers"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+)
+
+func newAuthRouter(t *testing.T) *gin.Engine {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	t.Setenv("TOKEN_KEY", "test-token-key")
+	t.Setenv("REDIS_ADDR", miniredis.RunT(t).Addr())
+
+	router := gin.New()
+	handler := func(c *gin.Context) {
+		body, _ := io.ReadAll(c.Request.Body)
+		c.JSON(http.StatusOK, gin.H{"user_id": UserID(c), "body": string(body)})
+	}
+	router.GET("/me", RequireUser(), handler)
+	router.POST("/me", RequireUser(), handler)
+	return router
+}
+
+func userToken(t *testing.T, userID uint) string {
+	t.Helper()
+	token, err := helpers.EncryptAES(helpers.UserData{User_id: userID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestRequireUser(t *testing.T) {
+	router := newAuthRouter(t)
+	token := userToken(t, 7)
+	body := `{"user_token":"` + token + `","chat_id":"abc"}`
+
+	tests := []struct {
+		name   string
+		req    func() *http.Request
+		status int
+	}{
+		{"bearer header", func() *http.Request {
+			req := httptest.NewRequest(http.MethodGet, "/me", nil)
+			req.Header.Set("Authorization", "Bearer "+token)
+			return req
+		}, http.StatusOK},
+		{"legacy query", func() *http.Request {
+			return httptest.NewRequest(http.MethodGet, "/me?user_token="+url.QueryEscape(token), nil)
+		}, http.StatusOK},
+		{"legacy websocket query", func() *http.Request {
+			return httptest.NewRequest(http.MethodGet, "/me?userToken="+url.QueryEscape(token), nil)
+		}, http.StatusOK},
+		{"legacy json field", func() *http.Request {
+			req := httptest.NewRequest(http.MethodPost, "/me", strings.NewReader(body))
+			req.Header.Set("Content-Type", "application/json")
+			return req
+		}, http.StatusOK},
+		{"missing token", func() *http.Request {
+			return httptest.NewRequest(http.MethodGet, "/me", nil)
+		}, http.StatusUnauthorized},
+		{"invalid token", func() *http.Request {
+			req := httptest.NewRequest(http.MethodGet, "/me", nil)
+			req.Header.Set("Authorization", "Bearer bm90LWEtdG9rZW4=")
+			return req
+		}, http.StatusUnauthorized},
+		{"wrong scheme", func() *http.Request {
+			req := httptest.NewRequest(http.MethodGet, "/me", nil)
+			req.Header.Set("Authorization", "Basic "+token)
+			return req
+		}, http.StatusUnauthorized},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, tt.req())
+			if rec.Code != tt.status {
+				t.Fatalf("status = %d, want %d, body: %s", rec.Code, tt.status, rec.Body.String())
+			}
+			if tt.status == http.StatusOK && !strings.Contains(rec.Body.String(), `"user_id":7`) {
+				t.Fatalf("user id not in context: %s", rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestRequireUserKeepsBody(t *testing.T) {
+	router := newAuthRouter(t)
+	body := `{"user_token":"` + userToken(t, 7) + `","chat_id":"abc"}`
+
+	req := httptest.NewRequest(http.MethodPost, "/me", strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+
+	if !strings.Contains(rec.Body.String(), `chat_id`) {
+		t.Fatalf("handler did not receive request body: %s", rec.Body.String())
+	}
+}
+
+func TestRedactPath(t *testing.T) {
+	tests := map[string]string{
+		"/chats/get-chats":                       "/chats/get-chats",
+		"/chats/get-chats?page_state=abc":        "/chats/get-chats?page_state=abc",
+		"/chats/get-chats?user_token=secret&a=1": "/chats/get-chats?a=1&user_token=%2A%2A%2A",
+		"/ws?chatId=1&userToken=secret":          "/ws?chatId=1&userToken=%2A%2A%2A",
+	}
+	for path, want := range tests {
+		if got := RedactPath(path); got != want {
+			t.Errorf("RedactPath(%q) = %q, want %q", path, got, want)
+		}
+	}
+}
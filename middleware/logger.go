@@ -0,0 +1,52 @@
+package middleware
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Query-параметры, значения которых нельзя писать в лог
+var secretQueries = []string{"user_token", "userToken", "token"}
+
+// Logger — gin.Logger, который скрывает токены в строке запроса
+func Logger() gin.HandlerFunc {
+	return gin.LoggerWithFormatter(func(param gin.LogFormatterParams) string {
+		return fmt.Sprintf("[GIN] %v | %3d | %13v | %15s | %-7s %#v\n%s",
+			param.TimeStamp.Format("2006/01/02 - 15:04:05"),
+			param.StatusCode,
+			param.Latency,
+			param.ClientIP,
+			param.Method,
+			RedactPath(param.Path),
+			param.ErrorMessage,
+		)
+	})
+}
+
+// RedactPath заменяет значения секретных query-параметров на "***"
+func RedactPath(path string) string {
+	base, rawQuery, found := strings.Cut(path, "?")
+	if !found {
+		return path
+	}
+
+	query, err := url.ParseQuery(rawQuery)
+	if err != nil {
+		return base + "?***"
+	}
+
+	redacted := false
+	for _, name := range secretQueries {
+		if _, ok := query[name]; ok {
+			query.Set(name, "***")
+			redacted = true
+		}
+	}
+	if !redacted {
+		return path
+	}
+	return base + "?" + query.Encode()
+}
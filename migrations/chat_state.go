@@ -0,0 +1,148 @@
+package migrations
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+// legacyChatState — состояние чата, собранное из строк старой таблицы chats
+type legacyChatState struct {
+	ChatID      gocql.UUID
+	CompanionID uint
+	ChatType    string
+	Secured     bool
+	Muted       bool
+	LastMsgTime *time.Time
+	LastUpdated *time.Time
+	PrivateKey  string
+}
+
+func createChatStateTables(session *gocql.Session, keyspace string) error {
+	createStateQuery := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s.chat_state (
+		chat_id uuid PRIMARY KEY,
+		companion_id bigint,
+		chat_type text,
+		secured boolean,
+		muted boolean,
+		last_msg_time timestamp,
+		last_updated timestamp,
+		private_key text
+	);
+	`, keyspace)
+
+	if err := session.Query(createStateQuery).Exec(); err != nil {
+		return err
+	}
+
+	createUnreadQuery := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s.chat_unread (
+		chat_id uuid PRIMARY KEY,
+		new_msg_count counter
+	);
+	`, keyspace)
+
+	return session.Query(createUnreadQuery).Exec()
+}
+
+// migrateChatState переносит состояние чатов из старой таблицы chats в chat_state и
+// chat_unread для keyspace одного пользователя. Повторный запуск безопасен: уже
+// перенесённые чаты не перезаписываются, а лишние строки индекса удаляются.
+func migrateChatState(session *gocql.Session, keyspace string) error {
+	if err := createChatStateTables(session, keyspace); err != nil {
+		return err
+	}
+
+	query := fmt.Sprintf(`SELECT user_id, last_updated, companion_id, chat_id, chat_type, secured, muted, last_msg_time, new_msg_count, private_key FROM %s.chats`, keyspace)
+	iter := session.Query(query).Iter()
+
+	type indexRow struct {
+		userID      uint
+		lastUpdated time.Time
+		companionID uint
+	}
+
+	latest := make(map[gocql.UUID]legacyChatState)
+	counts := make(map[gocql.UUID]int)
+	rows := make(map[gocql.UUID][]indexRow)
+
+	var userID uint
+	var state legacyChatState
+	var newMsgCount *int
+	var chatType *string
+	var secured, muted *bool
+	var privateKey *string
+	for iter.Scan(&userID, &state.LastUpdated, &state.CompanionID, &state.ChatID, &chatType, &secured, &muted, &state.LastMsgTime, &newMsgCount, &privateKey) {
+		rows[state.ChatID] = append(rows[state.ChatID], indexRow{userID: userID, lastUpdated: *state.LastUpdated, companionID: state.CompanionID})
+
+		// Строки, записанные уже новым кодом, содержат только ключ индекса
+		if privateKey == nil {
+			continue
+		}
+
+		current, ok := latest[state.ChatID]
+		if ok && !state.LastUpdated.After(*current.LastUpdated) {
+			continue
+		}
+
+		lastUpdated := *state.LastUpdated
+		row := legacyChatState{
+			ChatID:      state.ChatID,
+			CompanionID: state.CompanionID,
+			LastMsgTime: state.LastMsgTime,
+			LastUpdated: &lastUpdated,
+			PrivateKey:  *privateKey,
+		}
+		if chatType != nil {
+			row.ChatType = *chatType
+		}
+		if secured != nil {
+			row.Secured = *secured
+		}
+		if muted != nil {
+			row.Muted = *muted
+		}
+		latest[state.ChatID] = row
+		if newMsgCount != nil {
+			counts[state.ChatID] = *newMsgCount
+		}
+	}
+	if err := iter.Close(); err != nil {
+		return err
+	}
+
+	for chatID, row := range latest {
+		insertStateQuery := fmt.Sprintf(`INSERT INTO %s.chat_state (chat_id, companion_id, chat_type, secured, muted, last_msg_time, last_updated, private_key) VALUES (?, ?, ?, ?, ?, ?, ?, ?) IF NOT EXISTS`, keyspace)
+		applied, err := session.Query(insertStateQuery, chatID, row.CompanionID, row.ChatType, row.Secured, row.Muted, row.LastMsgTime, row.LastUpdated, row.PrivateKey).MapScanCAS(map[string]interface{}{})
+		if err != nil {
+			return err
+		}
+		if applied {
+			updateUnreadQuery := fmt.Sprintf(`UPDATE %s.chat_unread SET new_msg_count = new_msg_count + ? WHERE chat_id = ?`, keyspace)
+			if err := session.Query(updateUnreadQuery, int64(counts[chatID]), chatID).Exec(); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Оставляем в индексе только строку, совпадающую с last_updated из chat_state
+	for chatID, indexRows := range rows {
+		var lastUpdated *time.Time
+		stateQuery := fmt.Sprintf(`SELECT last_updated FROM %s.chat_state WHERE chat_id = ?`, keyspace)
+		if err := session.Query(stateQuery, chatID).Scan(&lastUpdated); err != nil {
+			return err
+		}
+
+		for _, row := range indexRows {
+			if lastUpdated != nil && row.lastUpdated.Equal(*lastUpdated) {
+				continue
+			}
+			deleteIndexQuery := fmt.Sprintf(`DELETE FROM %s.chats WHERE user_id = ? AND last_updated = ? AND companion_id = ? AND chat_id = ?`, keyspace)
+			if err := session.Query(deleteIndexQuery, row.userID, row.lastUpdated, row.companionID, chatID).Exec(); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,281 @@
+package migrations
+
+import (
+	"Bmessage_backend/database"
+	"Bmessage_backend/repository"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+// Перенос данных из keyspace user_N в общую схему keyspace chat.
+//
+// Копирование можно запускать на работающем сервере и повторять сколько угодно
+// раз: строки пишутся с USING TIMESTAMP, равным времени записи в старом keyspace,
+// поэтому всё, что сервер уже записал в общую схему, не перезаписывается.
+// Счётчики непрочитанных переносятся один раз на чат (отметка в legacy_copy).
+
+// CopyReport — результат сверки числа строк одного keyspace пользователя
+type CopyReport struct {
+	Keyspace       string
+	LegacyChats    int
+	SharedChats    int
+	LegacyMessages int
+	SharedMessages int
+}
+
+// OK сообщает, что в общей схеме не меньше строк, чем в старом keyspace.
+// Строк может быть больше, если после переключения появились новые сообщения.
+func (r CopyReport) OK() bool {
+	return r.SharedChats >= r.LegacyChats && r.SharedMessages >= r.LegacyMessages
+}
+
+func legacyUserID(keyspace string) (uint, error) {
+	id, err := strconv.ParseUint(strings.TrimPrefix(keyspace, "user_"), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("unexpected keyspace name %s", keyspace)
+	}
+	return uint(id), nil
+}
+
+func maxWriteTime(writeTimes ...*int64) int64 {
+	var result int64
+	for _, writeTime := range writeTimes {
+		if writeTime != nil && *writeTime > result {
+			result = *writeTime
+		}
+	}
+	if result == 0 {
+		result = time.Now().UnixMicro()
+	}
+	return result
+}
+
+// CopyLegacyKeyspaces копирует чаты и сообщения всех keyspace user_N в общую схему
+func CopyLegacyKeyspaces(session *gocql.Session) error {
+	keyspaces, err := database.ListUserKeyspaces(session)
+	if err != nil {
+		return err
+	}
+
+	for _, keyspace := range keyspaces {
+		if err := ApplyUserKeyspace(session, keyspace); err != nil {
+			return err
+		}
+		if err := copyLegacyKeyspace(session, keyspace); err != nil {
+			return fmt.Errorf("failed to copy %s: %w", keyspace, err)
+		}
+		log.Printf("Copied %s\n", keyspace)
+	}
+	return nil
+}
+
+func copyLegacyKeyspace(session *gocql.Session, keyspace string) error {
+	userID, err := legacyUserID(keyspace)
+	if err != nil {
+		return err
+	}
+
+	query := fmt.Sprintf(`SELECT chat_id, companion_id, chat_type, secured, muted, last_msg_time, last_updated, private_key,
+		WRITETIME(companion_id), WRITETIME(last_updated), WRITETIME(last_msg_time), WRITETIME(muted)
+	FROM %s.chat_state`, keyspace)
+	iter := session.Query(query).Iter()
+
+	var chats []repository.Chat
+	var writeTimes []int64
+	var chat repository.Chat
+	var wtCompanion, wtLastUpdated, wtLastMsg, wtMuted *int64
+	for iter.Scan(&chat.ChatID, &chat.CompanionID, &chat.ChatType, &chat.Secured, &chat.Muted, &chat.LastMsgTime, &chat.LastUpdated, &chat.PrivateKey,
+		&wtCompanion, &wtLastUpdated, &wtLastMsg, &wtMuted) {
+		chat.UserID = userID
+		chats = append(chats, chat)
+		writeTimes = append(writeTimes, maxWriteTime(wtCompanion, wtLastUpdated, wtLastMsg, wtMuted))
+	}
+	if err := iter.Close(); err != nil {
+		return err
+	}
+
+	for i, chat := range chats {
+		if err := copyLegacyChat(session, keyspace, chat, writeTimes[i]); err != nil {
+			return err
+		}
+		if err := copyLegacyMessages(session, keyspace, userID, chat.ChatID); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func copyLegacyChat(session *gocql.Session, keyspace string, chat repository.Chat, writeTime int64) error {
+	insertChatQuery := `INSERT INTO ` + database.SharedKeyspace + `.user_chats (user_id, chat_id, companion_id, chat_type, secured, muted, last_msg_time, last_updated, private_key) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?) USING TIMESTAMP ?`
+	if err := session.Query(insertChatQuery, chat.UserID, chat.ChatID, chat.CompanionID, chat.ChatType, chat.Secured, chat.Muted, chat.LastMsgTime, chat.LastUpdated, chat.PrivateKey, writeTime).Exec(); err != nil {
+		return err
+	}
+
+	insertCompanionQuery := `INSERT INTO ` + database.SharedKeyspace + `.user_chats_by_companion (user_id, companion_id, chat_id) VALUES (?, ?, ?) USING TIMESTAMP ?`
+	if err := session.Query(insertCompanionQuery, chat.UserID, chat.CompanionID, chat.ChatID, writeTime).Exec(); err != nil {
+		return err
+	}
+
+	// Строку индекса пишем для актуального last_updated: сервер мог уже поднять чат
+	lastUpdatedQuery := `SELECT last_updated FROM ` + database.SharedKeyspace + `.user_chats WHERE user_id = ? AND chat_id = ?`
+	var lastUpdated *time.Time
+	if err := session.Query(lastUpdatedQuery, chat.UserID, chat.ChatID).Scan(&lastUpdated); err != nil {
+		return err
+	}
+	if lastUpdated != nil {
+		insertIndexQuery := `INSERT INTO ` + database.SharedKeyspace + `.user_chats_by_time (user_id, last_updated, chat_id) VALUES (?, ?, ?)`
+		if err := session.Query(insertIndexQuery, chat.UserID, *lastUpdated, chat.ChatID).Exec(); err != nil {
+			return err
+		}
+
+		var current *time.Time
+		if err := session.Query(lastUpdatedQuery, chat.UserID, chat.ChatID).Scan(&current); err != nil {
+			return err
+		}
+		if current != nil && !current.Equal(*lastUpdated) {
+			deleteIndexQuery := `DELETE FROM ` + database.SharedKeyspace + `.user_chats_by_time WHERE user_id = ? AND last_updated = ? AND chat_id = ?`
+			if err := session.Query(deleteIndexQuery, chat.UserID, *lastUpdated, chat.ChatID).Exec(); err != nil {
+				return err
+			}
+		}
+	}
+
+	// Счётчик переносим только при первом копировании чата. Если процесс упадёт
+	// между отметкой и обновлением счётчика, счётчик останется нулевым, но не удвоится.
+	markQuery := `INSERT INTO ` + database.SharedKeyspace + `.legacy_copy (keyspace_name, chat_id, copied_at) VALUES (?, ?, ?) IF NOT EXISTS`
+	applied, err := session.Query(markQuery, keyspace, chat.ChatID, time.Now()).MapScanCAS(map[string]interface{}{})
+	if err != nil {
+		return err
+	}
+	if !applied {
+		return nil
+	}
+
+	unreadQuery := fmt.Sprintf(`SELECT new_msg_count FROM %s.chat_unread WHERE chat_id = ?`, keyspace)
+	var unread int64
+	if err := session.Query(unreadQuery, chat.ChatID).Scan(&unread); err != nil && err != gocql.ErrNotFound {
+		return err
+	}
+	if unread > 0 {
+		updateUnreadQuery := `UPDATE ` + database.SharedKeyspace + `.chat_unread SET new_msg_count = new_msg_count + ? WHERE user_id = ? AND chat_id = ?`
+		return session.Query(updateUnreadQuery, unread, chat.UserID, chat.ChatID).Exec()
+	}
+	return nil
+}
+
+func copyLegacyMessages(session *gocql.Session, keyspace string, userID uint, chatID gocql.UUID) error {
+	query := fmt.Sprintf(`SELECT message_id, sender_id, message_text, created_at, attachments, reactions, reply_to_message_id, forwarded_from_chat_id, forwarded_from_message_id, read,
+		WRITETIME(message_text), WRITETIME(read)
+	FROM %s.messages WHERE chat_id = ?`, keyspace)
+	iter := session.Query(query, chatID).Iter()
+
+	insertMessageQuery := `INSERT INTO ` + database.SharedKeyspace + `.messages (chat_id, bucket, owner_id, created_at, message_id, sender_id, message_text, attachments, reactions, reply_to_message_id, forwarded_from_chat_id, forwarded_from_message_id, read) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?) USING TIMESTAMP ?`
+	insertBucketQuery := `INSERT INTO ` + database.SharedKeyspace + `.message_buckets (chat_id, bucket) VALUES (?, ?)`
+	insertLookupQuery := `INSERT INTO ` + database.SharedKeyspace + `.message_ids (owner_id, chat_id, message_id, created_at) VALUES (?, ?, ?, ?)`
+
+	buckets := make(map[int]bool)
+	var messageID gocql.UUID
+	var senderID uint
+	var messageText string
+	var createdAt time.Time
+	var attachments []string
+	var reactions map[string]int
+	var replyTo, forwardedChat, forwardedMessage *gocql.UUID
+	var read *bool
+	var wtText, wtRead *int64
+	for iter.Scan(&messageID, &senderID, &messageText, &createdAt, &attachments, &reactions, &replyTo, &forwardedChat, &forwardedMessage, &read, &wtText, &wtRead) {
+		bucket := repository.BucketFor(createdAt)
+		if err := session.Query(insertMessageQuery, chatID, bucket, userID, createdAt, messageID, senderID, messageText, attachments, reactions, replyTo, forwardedChat, forwardedMessage, read, maxWriteTime(wtText, wtRead)).Exec(); err != nil {
+			iter.Close()
+			return err
+		}
+		if err := session.Query(insertLookupQuery, userID, chatID, messageID, createdAt).Exec(); err != nil {
+			iter.Close()
+			return err
+		}
+		if !buckets[bucket] {
+			if err := session.Query(insertBucketQuery, chatID, bucket).Exec(); err != nil {
+				iter.Close()
+				return err
+			}
+			buckets[bucket] = true
+		}
+	}
+	return iter.Close()
+}
+
+// VerifyLegacyKeyspaces сверяет число чатов и сообщений в keyspace user_N и в общей схеме
+func VerifyLegacyKeyspaces(session *gocql.Session) ([]CopyReport, error) {
+	keyspaces, err := database.ListUserKeyspaces(session)
+	if err != nil {
+		return nil, err
+	}
+
+	var reports []CopyReport
+	for _, keyspace := range keyspaces {
+		report, err := verifyLegacyKeyspace(session, keyspace)
+		if err != nil {
+			return nil, fmt.Errorf("failed to verify %s: %w", keyspace, err)
+		}
+		reports = append(reports, report)
+	}
+	return reports, nil
+}
+
+func verifyLegacyKeyspace(session *gocql.Session, keyspace string) (CopyReport, error) {
+	report := CopyReport{Keyspace: keyspace}
+	userID, err := legacyUserID(keyspace)
+	if err != nil {
+		return report, err
+	}
+
+	iter := session.Query(fmt.Sprintf(`SELECT chat_id FROM %s.chat_state`, keyspace)).Iter()
+	var chatIDs []gocql.UUID
+	var chatID gocql.UUID
+	for iter.Scan(&chatID) {
+		chatIDs = append(chatIDs, chatID)
+	}
+	if err := iter.Close(); err != nil {
+		return report, err
+	}
+	report.LegacyChats = len(chatIDs)
+
+	var sharedChats int
+	if err := session.Query(`SELECT COUNT(*) FROM `+database.SharedKeyspace+`.user_chats WHERE user_id = ?`, userID).Scan(&sharedChats); err != nil {
+		return report, err
+	}
+	report.SharedChats = sharedChats
+
+	for _, chatID := range chatIDs {
+		var legacyMessages int
+		if err := session.Query(fmt.Sprintf(`SELECT COUNT(*) FROM %s.messages WHERE chat_id = ?`, keyspace), chatID).Scan(&legacyMessages); err != nil {
+			return report, err
+		}
+		report.LegacyMessages += legacyMessages
+
+		bucketIter := session.Query(`SELECT bucket FROM `+database.SharedKeyspace+`.message_buckets WHERE chat_id = ?`, chatID).Iter()
+		var buckets []int
+		var bucket int
+		for bucketIter.Scan(&bucket) {
+			buckets = append(buckets, bucket)
+		}
+		if err := bucketIter.Close(); err != nil {
+			return report, err
+		}
+
+		for _, bucket := range buckets {
+			var sharedMessages int
+			countQuery := `SELECT COUNT(*) FROM ` + database.SharedKeyspace + `.messages WHERE chat_id = ? AND bucket = ? AND owner_id = ?`
+			if err := session.Query(countQuery, chatID, bucket, userID).Scan(&sharedMessages); err != nil {
+				return report, err
+			}
+			report.SharedMessages += sharedMessages
+		}
+	}
+	return report, nil
+}
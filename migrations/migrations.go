@@ -0,0 +1,159 @@
+package migrations
+
+import (
+	"Bmessage_backend/database"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Версионированные миграции схемы. Для Scylla есть два вида миграций:
+// общие (применяются к keyspace chat) и пользовательские (применяются к
+// каждому keyspace user_N). Для Postgres — SQL-скрипты для основной базы.
+// Применённые версии хранятся в таблице schema_migrations каждого хранилища.
+
+// Status описывает состояние одной миграции в одном хранилище
+type Status struct {
+	Target    string
+	Version   int
+	Name      string
+	Applied   bool
+	AppliedAt *time.Time
+}
+
+// Up применяет все неприменённые миграции Postgres и Scylla
+func Up() error {
+	db, err := database.GetDb()
+	if err != nil {
+		return fmt.Errorf("failed to connect to PostgreSQL: %w", err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err
+	}
+	defer sqlDB.Close()
+
+	if err := RunPostgres(db); err != nil {
+		return err
+	}
+
+	session, err := database.GetSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	return RunScylla(session)
+}
+
+// RunCommand выполняет подкоманду migrate:
+//
+//	migrate up               — применить миграции
+//	migrate status           — показать состояние миграций
+//	migrate copy-keyspaces   — скопировать данные из user_N в общую схему
+//	migrate verify-keyspaces — сверить число строк после копирования
+func RunCommand(args []string) error {
+	command := "up"
+	if len(args) > 0 {
+		command = args[0]
+	}
+
+	switch command {
+	case "up":
+		if err := Up(); err != nil {
+			return err
+		}
+		log.Println("Migrations applied successfully")
+		return nil
+	case "status":
+		return printStatus()
+	case "copy-keyspaces":
+		session, err := database.GetSession()
+		if err != nil {
+			return err
+		}
+		defer session.Close()
+
+		if err := RunScylla(session); err != nil {
+			return err
+		}
+		return CopyLegacyKeyspaces(session)
+	case "verify-keyspaces":
+		return printCopyReports()
+	default:
+		return fmt.Errorf("unknown migrate command %q, expected up, status, copy-keyspaces or verify-keyspaces", command)
+	}
+}
+
+func printStatus() error {
+	db, err := database.GetDb()
+	if err != nil {
+		return fmt.Errorf("failed to connect to PostgreSQL: %w", err)
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		return err
+	}
+	defer sqlDB.Close()
+
+	statuses, err := PostgresStatus(db)
+	if err != nil {
+		return err
+	}
+
+	session, err := database.GetSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	scyllaStatuses, err := ScyllaStatus(session)
+	if err != nil {
+		return err
+	}
+	statuses = append(statuses, scyllaStatuses...)
+
+	pending := 0
+	for _, status := range statuses {
+		state := "pending"
+		if status.Applied {
+			state = "applied"
+			if status.AppliedAt != nil {
+				state += " " + status.AppliedAt.Format(time.RFC3339)
+			}
+		} else {
+			pending++
+		}
+		fmt.Printf("%-20s %4d  %-40s %s\n", status.Target, status.Version, status.Name, state)
+	}
+	fmt.Printf("%d pending migration(s)\n", pending)
+	return nil
+}
+
+func printCopyReports() error {
+	session, err := database.GetSession()
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	reports, err := VerifyLegacyKeyspaces(session)
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	for _, report := range reports {
+		state := "ok"
+		if !report.OK() {
+			state = "MISMATCH"
+			failed++
+		}
+		fmt.Printf("%-20s chats %d/%d  messages %d/%d  %s\n", report.Keyspace,
+			report.SharedChats, report.LegacyChats, report.SharedMessages, report.LegacyMessages, state)
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d keyspace(s) are not fully copied", failed)
+	}
+	return nil
+}
@@ -0,0 +1,241 @@
+package migrations
+
+import (
+	"fmt"
+	"log"
+	"time"
+
+	"gorm.io/gorm"
+)
+
+// PostgresMigration — SQL-скрипт, применяемый к основной базе Postgres
+type PostgresMigration struct {
+	Version int
+	Name    string
+	Up      string
+}
+
+// Ключ advisory-блокировки, чтобы несколько инстансов не применяли миграции одновременно
+const postgresLockKey = 7_180_028
+
+var postgresMigrations = []PostgresMigration{
+	{
+		Version: 1,
+		Name:    "create_users",
+		Up: `
+			CREATE TABLE IF NOT EXISTS users (
+				id bigserial PRIMARY KEY,
+				created_at timestamptz,
+				updated_at timestamptz,
+				deleted_at timestamptz,
+				name text,
+				so_name text,
+				nik text CONSTRAINT uni_users_nik UNIQUE,
+				login text CONSTRAINT uni_users_login UNIQUE,
+				password text,
+				private_key text
+			);
+			CREATE INDEX IF NOT EXISTS idx_users_deleted_at ON users (deleted_at);
+		`,
+	},
+	{
+		Version: 2,
+		Name:    "add_user_avatar",
+		Up:      `ALTER TABLE users ADD COLUMN IF NOT EXISTS avatar text NOT NULL DEFAULT '';`,
+	},
+	{
+		Version: 3,
+		Name:    "add_user_email",
+		Up:      `ALTER TABLE users ADD COLUMN IF NOT EXISTS email text NOT NULL DEFAULT '';`,
+	},
+	{
+		Version: 4,
+		Name:    "add_user_deletion_requested_at",
+		Up: `
+			ALTER TABLE users ADD COLUMN IF NOT EXISTS deletion_requested_at timestamptz;
+			CREATE INDEX IF NOT EXISTS idx_users_deletion_requested_at ON users (deletion_requested_at) WHERE deletion_requested_at IS NOT NULL;
+		`,
+	},
+	{
+		Version: 5,
+		Name:    "add_two_factor_auth",
+		Up: `
+			ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_secret text NOT NULL DEFAULT '';
+			ALTER TABLE users ADD COLUMN IF NOT EXISTS totp_enabled boolean NOT NULL DEFAULT false;
+			CREATE TABLE IF NOT EXISTS recovery_codes (
+				id bigserial PRIMARY KEY,
+				user_id bigint NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+				code_hash text NOT NULL,
+				used_at timestamptz,
+				created_at timestamptz NOT NULL DEFAULT now()
+			);
+			CREATE INDEX IF NOT EXISTS idx_recovery_codes_user_id ON recovery_codes (user_id);
+		`,
+	},
+	{
+		Version: 6,
+		Name:    "add_user_search_trgm_indexes",
+		Up: `
+			CREATE EXTENSION IF NOT EXISTS pg_trgm;
+			CREATE INDEX IF NOT EXISTS idx_users_nik_trgm ON users USING gin (nik gin_trgm_ops) WHERE deleted_at IS NULL;
+			CREATE INDEX IF NOT EXISTS idx_users_name_trgm ON users USING gin (name gin_trgm_ops) WHERE deleted_at IS NULL;
+			CREATE INDEX IF NOT EXISTS idx_users_so_name_trgm ON users USING gin (so_name gin_trgm_ops) WHERE deleted_at IS NULL;
+		`,
+	},
+	{
+		Version: 7,
+		Name:    "create_message_search",
+		Up: `
+			CREATE TABLE IF NOT EXISTS message_search (
+				owner_id bigint NOT NULL,
+				message_id uuid NOT NULL,
+				chat_id uuid NOT NULL,
+				sender_id bigint NOT NULL,
+				created_at timestamptz NOT NULL,
+				body tsvector NOT NULL,
+				PRIMARY KEY (owner_id, message_id)
+			);
+			CREATE INDEX IF NOT EXISTS idx_message_search_body ON message_search USING gin (body);
+			CREATE INDEX IF NOT EXISTS idx_message_search_owner_chat ON message_search (owner_id, chat_id);
+		`,
+	},
+	{
+		Version: 8,
+		Name:    "create_user_blocks_and_reports",
+		Up: `
+			CREATE TABLE IF NOT EXISTS user_blocks (
+				blocker_id bigint NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+				blocked_id bigint NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+				created_at timestamptz NOT NULL DEFAULT now(),
+				PRIMARY KEY (blocker_id, blocked_id)
+			);
+			CREATE INDEX IF NOT EXISTS idx_user_blocks_blocked_id ON user_blocks (blocked_id);
+			CREATE TABLE IF NOT EXISTS reports (
+				id bigserial PRIMARY KEY,
+				reporter_id bigint NOT NULL,
+				reported_user_id bigint NOT NULL,
+				reason text NOT NULL,
+				chat_id uuid,
+				message_id uuid,
+				message_text text NOT NULL DEFAULT '',
+				message_sent_at timestamptz,
+				created_at timestamptz NOT NULL DEFAULT now()
+			);
+			CREATE INDEX IF NOT EXISTS idx_reports_reported_user_id ON reports (reported_user_id);
+		`,
+	},
+	{
+		Version: 9,
+		Name:    "create_contacts",
+		Up: `
+			ALTER TABLE users ADD COLUMN IF NOT EXISTS contacts_only boolean NOT NULL DEFAULT false;
+			CREATE TABLE IF NOT EXISTS contacts (
+				owner_id bigint NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+				contact_id bigint NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+				custom_name text NOT NULL DEFAULT '',
+				created_at timestamptz NOT NULL DEFAULT now(),
+				PRIMARY KEY (owner_id, contact_id)
+			);
+			CREATE INDEX IF NOT EXISTS idx_contacts_contact_id ON contacts (contact_id);
+		`,
+	},
+	{
+		Version: 10,
+		Name:    "create_scheduled_messages",
+		Up: `
+			CREATE TABLE IF NOT EXISTS scheduled_messages (
+				id bigserial PRIMARY KEY,
+				sender_id bigint NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+				chat_id uuid NOT NULL,
+				message_text text NOT NULL,
+				reply_to_message_id uuid,
+				send_at timestamptz NOT NULL,
+				status text NOT NULL DEFAULT 'pending',
+				message_id uuid,
+				error text NOT NULL DEFAULT '',
+				created_at timestamptz NOT NULL DEFAULT now(),
+				updated_at timestamptz NOT NULL DEFAULT now()
+			);
+			CREATE INDEX IF NOT EXISTS idx_scheduled_messages_sender_id ON scheduled_messages (sender_id, send_at);
+			CREATE INDEX IF NOT EXISTS idx_scheduled_messages_due ON scheduled_messages (send_at) WHERE status = 'pending';
+		`,
+	},
+}
+
+func ensurePostgresMigrationsTable(db *gorm.DB) error {
+	return db.Exec(`CREATE TABLE IF NOT EXISTS schema_migrations (
+		version integer PRIMARY KEY,
+		name text NOT NULL,
+		applied_at timestamptz NOT NULL DEFAULT now()
+	)`).Error
+}
+
+// RunPostgres применяет все неприменённые миграции Postgres по порядку версий
+func RunPostgres(db *gorm.DB) error {
+	if err := ensurePostgresMigrationsTable(db); err != nil {
+		return fmt.Errorf("failed to create schema_migrations: %w", err)
+	}
+
+	for _, migration := range postgresMigrations {
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Exec("SELECT pg_advisory_xact_lock(?)", postgresLockKey).Error; err != nil {
+				return err
+			}
+
+			var count int64
+			if err := tx.Table("schema_migrations").Where("version = ?", migration.Version).Count(&count).Error; err != nil {
+				return err
+			}
+			if count > 0 {
+				return nil
+			}
+
+			if err := tx.Exec(migration.Up).Error; err != nil {
+				return err
+			}
+			if err := tx.Exec("INSERT INTO schema_migrations (version, name) VALUES (?, ?)", migration.Version, migration.Name).Error; err != nil {
+				return err
+			}
+
+			log.Printf("Applied PostgreSQL migration %d_%s\n", migration.Version, migration.Name)
+			return nil
+		})
+		if err != nil {
+			return fmt.Errorf("PostgreSQL migration %d_%s failed: %w", migration.Version, migration.Name, err)
+		}
+	}
+
+	return nil
+}
+
+// PostgresStatus возвращает состояние всех миграций Postgres
+func PostgresStatus(db *gorm.DB) ([]Status, error) {
+	if err := ensurePostgresMigrationsTable(db); err != nil {
+		return nil, err
+	}
+
+	type appliedRow struct {
+		Version   int
+		AppliedAt time.Time
+	}
+	var rows []appliedRow
+	if err := db.Table("schema_migrations").Select("version", "applied_at").Scan(&rows).Error; err != nil {
+		return nil, err
+	}
+
+	applied := make(map[int]time.Time)
+	for _, row := range rows {
+		applied[row.Version] = row.AppliedAt
+	}
+
+	var statuses []Status
+	for _, migration := range postgresMigrations {
+		status := Status{Target: "postgres", Version: migration.Version, Name: migration.Name}
+		if appliedAt, ok := applied[migration.Version]; ok {
+			status.Applied = true
+			status.AppliedAt = &appliedAt
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
@@ -0,0 +1,361 @@
+package migrations
+
+import (
+	"Bmessage_backend/database"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+// Scope определяет, к каким keyspace применяется миграция Scylla
+type Scope int
+
+const (
+	// ScopeShared — общий keyspace database.SharedKeyspace
+	ScopeShared Scope = iota
+	// ScopeUser — каждый keyspace пользователя user_N
+	ScopeUser
+)
+
+// ScyllaMigration — шаг миграции схемы Scylla. Up получает имя keyspace,
+// к которому применяется миграция, и должен быть идемпотентным: при
+// конкурентном запуске шаг может выполниться дважды.
+type ScyllaMigration struct {
+	Version int
+	Name    string
+	Scope   Scope
+	Up      func(session *gocql.Session, keyspace string) error
+}
+
+var scyllaMigrations = []ScyllaMigration{
+	{Version: 1, Name: "create_chats_and_messages", Scope: ScopeUser, Up: cql(
+		`CREATE TABLE IF NOT EXISTS %[1]s.chats (
+			user_id bigint,
+			chat_id uuid,
+			companion_id bigint,
+			chat_type text,
+			secured boolean,
+			muted boolean,
+			new_msg_count int,
+			last_msg_time timestamp,
+			last_updated timestamp,
+			private_key text,
+			PRIMARY KEY (user_id,last_updated, companion_id, chat_id)
+		) WITH CLUSTERING ORDER BY (last_updated DESC);`,
+		`CREATE TABLE IF NOT EXISTS %[1]s.messages (
+			chat_id uuid,
+			message_id uuid,
+			sender_id bigint,
+			message_text TEXT,
+			created_at TIMESTAMP,
+			attachments LIST<TEXT>,
+			reactions MAP<TEXT, INT>,
+			reply_to_message_id UUID,
+			forwarded_from_chat_id UUID,
+			forwarded_from_message_id UUID,
+			read boolean,
+			PRIMARY KEY (chat_id, created_at, message_id)
+		) WITH CLUSTERING ORDER BY (created_at DESC);`,
+	)},
+	{Version: 2, Name: "chat_state_and_unread_counters", Scope: ScopeUser, Up: migrateChatState},
+	{Version: 3, Name: "shared_chat_schema", Scope: ScopeShared, Up: cql(
+		`CREATE TABLE IF NOT EXISTS %[1]s.user_chats (
+			user_id bigint,
+			chat_id uuid,
+			companion_id bigint,
+			chat_type text,
+			secured boolean,
+			muted boolean,
+			last_msg_time timestamp,
+			last_updated timestamp,
+			private_key text,
+			PRIMARY KEY (user_id, chat_id)
+		);`,
+		`CREATE TABLE IF NOT EXISTS %[1]s.user_chats_by_time (
+			user_id bigint,
+			last_updated timestamp,
+			chat_id uuid,
+			PRIMARY KEY (user_id, last_updated, chat_id)
+		) WITH CLUSTERING ORDER BY (last_updated DESC, chat_id ASC);`,
+		`CREATE TABLE IF NOT EXISTS %[1]s.user_chats_by_companion (
+			user_id bigint,
+			companion_id bigint,
+			chat_id uuid,
+			PRIMARY KEY (user_id, companion_id)
+		);`,
+		`CREATE TABLE IF NOT EXISTS %[1]s.chat_unread (
+			user_id bigint,
+			chat_id uuid,
+			new_msg_count counter,
+			PRIMARY KEY (user_id, chat_id)
+		);`,
+		`CREATE TABLE IF NOT EXISTS %[1]s.messages (
+			chat_id uuid,
+			bucket int,
+			owner_id bigint,
+			created_at timestamp,
+			message_id uuid,
+			sender_id bigint,
+			message_text text,
+			attachments list<text>,
+			reactions map<text, int>,
+			reply_to_message_id uuid,
+			forwarded_from_chat_id uuid,
+			forwarded_from_message_id uuid,
+			read boolean,
+			PRIMARY KEY ((chat_id, bucket), owner_id, created_at, message_id)
+		) WITH CLUSTERING ORDER BY (owner_id ASC, created_at DESC, message_id DESC);`,
+		`CREATE TABLE IF NOT EXISTS %[1]s.message_buckets (
+			chat_id uuid,
+			bucket int,
+			PRIMARY KEY (chat_id, bucket)
+		) WITH CLUSTERING ORDER BY (bucket DESC);`,
+		`CREATE TABLE IF NOT EXISTS %[1]s.message_ids (
+			owner_id bigint,
+			chat_id uuid,
+			message_id uuid,
+			created_at timestamp,
+			PRIMARY KEY ((owner_id, chat_id), message_id)
+		);`,
+		`CREATE TABLE IF NOT EXISTS %[1]s.legacy_copy (
+			keyspace_name text,
+			chat_id uuid,
+			copied_at timestamp,
+			PRIMARY KEY (keyspace_name, chat_id)
+		);`,
+	)},
+	{Version: 4, Name: "user_chats_companion_deleted", Scope: ScopeShared, Up: addColumns("user_chats",
+		"companion_deleted boolean",
+	)},
+	{Version: 5, Name: "messages_edited_at", Scope: ScopeShared, Up: addColumns("messages",
+		"edited_at timestamp",
+	)},
+	{Version: 6, Name: "user_chats_settings", Scope: ScopeShared, Up: addColumns("user_chats",
+		"muted_until timestamp",
+		"pin_order int",
+		"archived boolean",
+	)},
+	{Version: 7, Name: "messages_forward_attribution", Scope: ScopeShared, Up: addColumns("messages",
+		"forwarded_from_sender_id bigint",
+		"forwarded_at timestamp",
+	)},
+	{Version: 8, Name: "user_chats_message_ttl", Scope: ScopeShared, Up: addColumns("user_chats",
+		"message_ttl int",
+	)},
+	{Version: 9, Name: "messages_expiration", Scope: ScopeShared, Up: addColumns("messages",
+		"expires_at timestamp",
+		"view_once boolean",
+	)},
+	{Version: 10, Name: "pinned_messages", Scope: ScopeShared, Up: cql(
+		`CREATE TABLE IF NOT EXISTS %[1]s.pinned_messages (
+			owner_id bigint,
+			chat_id uuid,
+			message_id uuid,
+			pinned_by bigint,
+			pinned_at timestamp,
+			expires_at timestamp,
+			PRIMARY KEY ((owner_id, chat_id), message_id)
+		);`,
+	)},
+	{Version: 11, Name: "messages_system_kind", Scope: ScopeShared, Up: addColumns("messages",
+		"kind text",
+		"payload text",
+	)},
+	{Version: 12, Name: "user_chats_draft", Scope: ScopeShared, Up: addColumns("user_chats",
+		"draft_text text",
+		"draft_reply_to uuid",
+		"draft_updated_at timestamp",
+	)},
+	{Version: 13, Name: "user_chats_unread_expiring", Scope: ScopeShared, Up: addColumns("user_chats",
+		"unread_expiring map<uuid, timestamp>",
+	)},
+}
+
+// cql возвращает шаг миграции, выполняющий CQL-запросы по порядку.
+// В запросах %[1]s заменяется на имя keyspace.
+func cql(statements ...string) func(session *gocql.Session, keyspace string) error {
+	return func(session *gocql.Session, keyspace string) error {
+		for _, statement := range statements {
+			if err := session.Query(fmt.Sprintf(statement, keyspace)).Exec(); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// addColumns возвращает шаг миграции, добавляющий колонки в таблицу. ALTER TABLE ADD
+// в Scylla не поддерживает IF NOT EXISTS, поэтому уже существующие колонки пропускаются.
+// Колонка задаётся как "имя тип".
+func addColumns(table string, columns ...string) func(session *gocql.Session, keyspace string) error {
+	return func(session *gocql.Session, keyspace string) error {
+		for _, column := range columns {
+			name := strings.Fields(column)[0]
+			exists, err := scyllaColumnExists(session, keyspace, table, name)
+			if err != nil {
+				return err
+			}
+			if exists {
+				continue
+			}
+			if err := session.Query(fmt.Sprintf(`ALTER TABLE %s.%s ADD %s`, keyspace, table, column)).Exec(); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func ensureScyllaMigrationsTable(session *gocql.Session, keyspace string) error {
+	query := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s.schema_migrations (
+		version int PRIMARY KEY,
+		name text,
+		applied_at timestamp
+	);`, keyspace)
+	return session.Query(query).Exec()
+}
+
+func appliedScyllaVersions(session *gocql.Session, keyspace string) (map[int]time.Time, error) {
+	iter := session.Query(fmt.Sprintf(`SELECT version, applied_at FROM %s.schema_migrations`, keyspace)).Iter()
+	applied := make(map[int]time.Time)
+	var version int
+	var appliedAt time.Time
+	for iter.Scan(&version, &appliedAt) {
+		applied[version] = appliedAt
+	}
+	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+	return applied, nil
+}
+
+func scyllaTableExists(session *gocql.Session, keyspace, table string) (bool, error) {
+	query := "SELECT table_name FROM system_schema.tables WHERE keyspace_name = ? AND table_name = ?"
+	var name string
+	if err := session.Query(query, keyspace, table).Scan(&name); err != nil {
+		if err == gocql.ErrNotFound {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func scyllaColumnExists(session *gocql.Session, keyspace, table, column string) (bool, error) {
+	query := "SELECT column_name FROM system_schema.columns WHERE keyspace_name = ? AND table_name = ? AND column_name = ?"
+	var name string
+	if err := session.Query(query, keyspace, table, column).Scan(&name); err != nil {
+		if err == gocql.ErrNotFound {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func applyScyllaScope(session *gocql.Session, keyspace string, scope Scope) error {
+	if err := ensureScyllaMigrationsTable(session, keyspace); err != nil {
+		return fmt.Errorf("failed to create schema_migrations in %s: %w", keyspace, err)
+	}
+
+	applied, err := appliedScyllaVersions(session, keyspace)
+	if err != nil {
+		return fmt.Errorf("failed to read schema_migrations in %s: %w", keyspace, err)
+	}
+
+	for _, migration := range scyllaMigrations {
+		if migration.Scope != scope {
+			continue
+		}
+		if _, ok := applied[migration.Version]; ok {
+			continue
+		}
+
+		if err := migration.Up(session, keyspace); err != nil {
+			return fmt.Errorf("scylla migration %d_%s failed in %s: %w", migration.Version, migration.Name, keyspace, err)
+		}
+
+		insertQuery := fmt.Sprintf(`INSERT INTO %s.schema_migrations (version, name, applied_at) VALUES (?, ?, ?)`, keyspace)
+		if err := session.Query(insertQuery, migration.Version, migration.Name, time.Now()).Exec(); err != nil {
+			return err
+		}
+		log.Printf("Applied Scylla migration %d_%s to %s\n", migration.Version, migration.Name, keyspace)
+	}
+	return nil
+}
+
+// ApplyUserKeyspace применяет пользовательские миграции к keyspace user_N
+func ApplyUserKeyspace(session *gocql.Session, keyspace string) error {
+	return applyScyllaScope(session, keyspace, ScopeUser)
+}
+
+// RunScylla создаёт общий keyspace при необходимости и применяет все неприменённые
+// миграции к нему и ко всем keyspace пользователей
+func RunScylla(session *gocql.Session) error {
+	if err := database.CreateKeyspaceScylla(session, database.SharedKeyspace); err != nil {
+		return fmt.Errorf("failed to create keyspace %s: %w", database.SharedKeyspace, err)
+	}
+
+	if err := applyScyllaScope(session, database.SharedKeyspace, ScopeShared); err != nil {
+		return err
+	}
+
+	keyspaces, err := database.ListUserKeyspaces(session)
+	if err != nil {
+		return err
+	}
+	for _, keyspace := range keyspaces {
+		if err := ApplyUserKeyspace(session, keyspace); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type scyllaTarget struct {
+	keyspace string
+	scope    Scope
+}
+
+// ScyllaStatus возвращает состояние миграций для общего keyspace и всех keyspace пользователей
+func ScyllaStatus(session *gocql.Session) ([]Status, error) {
+	targets := []scyllaTarget{{keyspace: database.SharedKeyspace, scope: ScopeShared}}
+
+	keyspaces, err := database.ListUserKeyspaces(session)
+	if err != nil {
+		return nil, err
+	}
+	for _, keyspace := range keyspaces {
+		targets = append(targets, scyllaTarget{keyspace: keyspace, scope: ScopeUser})
+	}
+
+	var statuses []Status
+	for _, target := range targets {
+		applied := map[int]time.Time{}
+		exists, err := scyllaTableExists(session, target.keyspace, "schema_migrations")
+		if err != nil {
+			return nil, err
+		}
+		if exists {
+			if applied, err = appliedScyllaVersions(session, target.keyspace); err != nil {
+				return nil, err
+			}
+		}
+
+		for _, migration := range scyllaMigrations {
+			if migration.Scope != target.scope {
+				continue
+			}
+			status := Status{Target: "scylla:" + target.keyspace, Version: migration.Version, Name: migration.Name}
+			if appliedAt, ok := applied[migration.Version]; ok {
+				status.Applied = true
+				status.AppliedAt = &appliedAt
+			}
+			statuses = append(statuses, status)
+		}
+	}
+	return statuses, nil
+}
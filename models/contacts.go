@@ -0,0 +1,12 @@
+package models
+
+import "time"
+
+// Contact — пользователь ContactID в контактах OwnerID. CustomName, если задано,
+// показывается владельцу вместо имени контакта.
+type Contact struct {
+	OwnerID    uint   `gorm:"column:owner_id;primaryKey"`
+	ContactID  uint   `gorm:"column:contact_id;primaryKey"`
+	CustomName string `gorm:"column:custom_name"`
+	CreatedAt  time.Time
+}
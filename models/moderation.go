@@ -0,0 +1,25 @@
+package models
+
+import "time"
+
+// UserBlock — запись чёрного списка: BlockedID не может писать BlockerID и находить его в поиске
+type UserBlock struct {
+	BlockerID uint `gorm:"column:blocker_id;primaryKey"`
+	BlockedID uint `gorm:"column:blocked_id;primaryKey"`
+	CreatedAt time.Time
+}
+
+// Report — жалоба на пользователя или сообщение для модераторов. Для жалобы на сообщение
+// сохраняется расшифрованный снимок текста: копии сообщения могут быть изменены или удалены.
+type Report struct {
+	ID             uint    `gorm:"primaryKey"`
+	ReporterID     uint    `gorm:"column:reporter_id"`
+	ReportedUserID uint    `gorm:"column:reported_user_id"`
+	Reason         string  `gorm:"column:reason"`
+	ChatID         *string `gorm:"column:chat_id"`
+	MessageID      *string `gorm:"column:message_id"`
+	MessageText    string  `gorm:"column:message_text"`
+	// MessageSentAt — время отправки сообщения, на которое пожаловались
+	MessageSentAt *time.Time `gorm:"column:message_sent_at"`
+	CreatedAt     time.Time
+}
@@ -0,0 +1,28 @@
+package models
+
+import "time"
+
+// Статусы отложенного сообщения
+const (
+	ScheduledPending = "pending"
+	ScheduledSending = "sending"
+	ScheduledSent    = "sent"
+	ScheduledFailed  = "failed"
+)
+
+// ScheduledMessage — сообщение, которое диспетчер отправит в SendAt.
+// MessageText зашифрован ключом чата отправителя.
+type ScheduledMessage struct {
+	ID               uint      `gorm:"primaryKey"`
+	SenderID         uint      `gorm:"column:sender_id"`
+	ChatID           string    `gorm:"column:chat_id"`
+	MessageText      string    `gorm:"column:message_text"`
+	ReplyToMessageID *string   `gorm:"column:reply_to_message_id"`
+	SendAt           time.Time `gorm:"column:send_at"`
+	Status           string    `gorm:"column:status"`
+	// MessageID — отправленное сообщение; Error — причина неудачной отправки
+	MessageID *string `gorm:"column:message_id"`
+	Error     string  `gorm:"column:error"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
@@ -1,9 +1,7 @@
 package models
 
 import (
-	Database "Bmessage_backend/database"
-	"fmt"
-	"log"
+	"time"
 
 	"gorm.io/gorm"
 )
@@ -17,20 +15,23 @@ type User struct {
 	Login      string `gorm:"column:login;unique"`
 	Password   string `gorm:"column:password"`
 	PrivateKey string `gorm:"column:private_key"`
+	Avatar     string `gorm:"column:avatar"`
+	Email      string `gorm:"column:email"`
+	// DeletionRequestedAt — момент запроса на удаление аккаунта; nil, если удаление не запрошено
+	DeletionRequestedAt *time.Time `gorm:"column:deletion_requested_at"`
+	// TOTPSecret — секрет двухфакторной аутентификации в base32. Пока TOTPEnabled = false,
+	// это секрет, ожидающий подтверждения кодом.
+	TOTPSecret  string `gorm:"column:totp_secret"`
+	TOTPEnabled bool   `gorm:"column:totp_enabled"`
+	// ContactsOnly — начать чат с пользователем могут только те, кто есть в его контактах
+	ContactsOnly bool `gorm:"column:contacts_only"`
 }
 
-func MigrationUsertabel() {
-
-	db, err := Database.GetDb()
-	if err != nil {
-		log.Fatalf("failed to connect database: %v", err)
-	}
-
-	if err := db.AutoMigrate(&User{}); err != nil {
-		log.Fatalf("failed to migrate: %v", err)
-	}
-
-	fmt.Println("Migration executed successfully")
-	sqlDB, err := db.DB()
-	sqlDB.Close()
+// RecoveryCode — резервный код входа при двухфакторной аутентификации. Хранится только sha256 кода.
+type RecoveryCode struct {
+	ID        uint       `gorm:"primaryKey"`
+	UserID    uint       `gorm:"column:user_id"`
+	CodeHash  string     `gorm:"column:code_hash"`
+	UsedAt    *time.Time `gorm:"column:used_at"`
+	CreatedAt time.Time
 }
@@ -0,0 +1,107 @@
+// Package notify доставляет пользователям служебные сообщения: коды сброса пароля и т.п.
+//
+// Способ доставки выбирается переменной окружения NOTIFIER:
+//   - log (по умолчанию) — сообщение пишется в лог сервера;
+//   - file — сообщение дописывается в файл NOTIFIER_FILE;
+//   - smtp — письмо отправляется через SMTP_ADDR от имени SMTP_FROM
+//     (SMTP_USERNAME и SMTP_PASSWORD — если сервер требует авторизацию).
+package notify
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+)
+
+// Message — служебное сообщение пользователю
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Notifier доставляет сообщение получателю
+type Notifier interface {
+	Send(ctx context.Context, message Message) error
+}
+
+var (
+	current   Notifier
+	currentMu sync.Mutex
+)
+
+// Current возвращает настроенный Notifier. При первом вызове он создаётся из окружения.
+func Current() Notifier {
+	currentMu.Lock()
+	defer currentMu.Unlock()
+
+	if current == nil {
+		current = FromEnv()
+	}
+	return current
+}
+
+// Use подменяет Notifier, например в тестах. Функция restore возвращает прежний.
+func Use(notifier Notifier) (restore func()) {
+	currentMu.Lock()
+	defer currentMu.Unlock()
+
+	previous := current
+	current = notifier
+	return func() {
+		currentMu.Lock()
+		defer currentMu.Unlock()
+		current = previous
+	}
+}
+
+// FromEnv создаёт Notifier по переменным окружения
+func FromEnv() Notifier {
+	switch os.Getenv("NOTIFIER") {
+	case "file":
+		return &FileNotifier{Path: os.Getenv("NOTIFIER_FILE")}
+	case "smtp":
+		return &SMTPNotifier{
+			Addr:     os.Getenv("SMTP_ADDR"),
+			From:     os.Getenv("SMTP_FROM"),
+			Username: os.Getenv("SMTP_USERNAME"),
+			Password: os.Getenv("SMTP_PASSWORD"),
+		}
+	}
+	return LogNotifier{}
+}
+
+// LogNotifier пишет сообщения в лог. Подходит для локального запуска.
+type LogNotifier struct{}
+
+func (LogNotifier) Send(ctx context.Context, message Message) error {
+	log.Printf("notify: to=%s subject=%q\n%s", message.To, message.Subject, message.Body)
+	return nil
+}
+
+// FileNotifier дописывает сообщения в файл
+type FileNotifier struct {
+	Path string
+	mu   sync.Mutex
+}
+
+func (n *FileNotifier) Send(ctx context.Context, message Message) error {
+	if n.Path == "" {
+		return fmt.Errorf("notify: NOTIFIER_FILE is not set")
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	file, err := os.OpenFile(n.Path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(file, "To: %s\nSubject: %s\n\n%s\n---\n", message.To, message.Subject, message.Body); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,112 @@
+package notify
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFileNotifier(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "mail.log")
+	notifier := &FileNotifier{Path: path}
+
+	for _, body := range []string{"код 123456", "код 654321"} {
+		if err := notifier.Send(context.Background(), Message{To: "ivan@example.com", Subject: "Код", Body: body}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "To: ivan@example.com") || !strings.Contains(string(data), "код 123456") || !strings.Contains(string(data), "код 654321") {
+		t.Fatalf("unexpected file contents:\n%s", data)
+	}
+}
+
+// fakeSMTPServer принимает одно письмо и возвращает его через канал
+func fakeSMTPServer(t *testing.T) (addr string, received <-chan string) {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	messages := make(chan string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		reader := bufio.NewReader(conn)
+		write := func(line string) { conn.Write([]byte(line + "\r\n")) }
+
+		write("220 localhost ESMTP")
+		var data strings.Builder
+		inData := false
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			if inData {
+				if line == ".\r\n" {
+					inData = false
+					messages <- data.String()
+					write("250 OK")
+					continue
+				}
+				data.WriteString(line)
+				continue
+			}
+
+			switch command := strings.ToUpper(strings.TrimSpace(line)); {
+			case strings.HasPrefix(command, "EHLO"), strings.HasPrefix(command, "HELO"):
+				write("250 localhost")
+			case strings.HasPrefix(command, "DATA"):
+				inData = true
+				write("354 End data with <CR><LF>.<CR><LF>")
+			case strings.HasPrefix(command, "QUIT"):
+				write("221 Bye")
+				return
+			default:
+				write("250 OK")
+			}
+		}
+	}()
+
+	return listener.Addr().String(), messages
+}
+
+func TestSMTPNotifier(t *testing.T) {
+	addr, received := fakeSMTPServer(t)
+	notifier := &SMTPNotifier{Addr: addr, From: "noreply@example.com"}
+
+	err := notifier.Send(context.Background(), Message{To: "ivan@example.com", Subject: "Код сброса пароля", Body: "Ваш код: 123456"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	message := <-received
+	for _, want := range []string{"From: noreply@example.com", "To: ivan@example.com", "Subject: =?utf-8?q?", "Ваш код: 123456"} {
+		if !strings.Contains(message, want) {
+			t.Fatalf("message does not contain %q:\n%s", want, message)
+		}
+	}
+}
+
+func TestSMTPNotifierRejectsHeaderInjection(t *testing.T) {
+	notifier := &SMTPNotifier{Addr: "127.0.0.1:1", From: "noreply@example.com"}
+	if err := notifier.Send(context.Background(), Message{To: "ivan@example.com\r\nBcc: all@example.com"}); err == nil {
+		t.Fatal("expected error for recipient with line break")
+	}
+}
@@ -0,0 +1,52 @@
+package notify
+
+import (
+	"context"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strings"
+)
+
+// SMTPNotifier отправляет письма через SMTP-сервер. Для локальной проверки
+// достаточно указать адрес тестового сервера (например, MailHog) без авторизации.
+type SMTPNotifier struct {
+	Addr     string
+	From     string
+	Username string
+	Password string
+}
+
+func (n *SMTPNotifier) Send(ctx context.Context, message Message) error {
+	if n.Addr == "" || n.From == "" {
+		return fmt.Errorf("notify: SMTP_ADDR and SMTP_FROM must be set")
+	}
+	if strings.ContainsAny(message.To, "\r\n") {
+		return fmt.Errorf("notify: invalid recipient %q", message.To)
+	}
+
+	var auth smtp.Auth
+	if n.Username != "" {
+		host, _, err := net.SplitHostPort(n.Addr)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", n.Username, n.Password, host)
+	}
+
+	return smtp.SendMail(n.Addr, auth, n.From, []string{message.To}, n.format(message))
+}
+
+func (n *SMTPNotifier) format(message Message) []byte {
+	var b strings.Builder
+	fmt.Fprintf(&b, "From: %s\r\n", n.From)
+	fmt.Fprintf(&b, "To: %s\r\n", message.To)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", message.Subject))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("\r\n")
+	b.WriteString(strings.ReplaceAll(message.Body, "\n", "\r\n"))
+	b.WriteString("\r\n")
+	return []byte(b.String())
+}
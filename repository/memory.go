@@ -0,0 +1,1147 @@
+package repository
+
+import (
+	"Bmessage_backend/models"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+
+	"github.com/gocql/gocql"
+)
+
+// Реализация репозиториев в памяти процесса. Используется в тестах обработчиков,
+// которым не нужны запущенные Postgres и Scylla.
+
+type chatKey struct {
+	userID uint
+	chatID gocql.UUID
+}
+
+type memoryStore struct {
+	mu       sync.Mutex
+	users    []models.User
+	nextID   uint
+	chats    map[chatKey]Chat
+	unread   map[chatKey]int
+	messages map[chatKey][]Message
+	pins     map[chatKey][]PinnedMessage
+	recovery map[uint][]models.RecoveryCode
+	search   map[uint][]SearchEntry
+	blocks   []models.UserBlock
+	reports  []models.Report
+	contacts []models.Contact
+	schedule []models.ScheduledMessage
+	// nextScheduleID — последний выданный ID отложенного сообщения
+	nextScheduleID uint
+}
+
+// NewMemory возвращает пустой набор репозиториев в памяти
+func NewMemory() *Repositories {
+	store := &memoryStore{
+		nextID:   1,
+		chats:    make(map[chatKey]Chat),
+		unread:   make(map[chatKey]int),
+		messages: make(map[chatKey][]Message),
+		pins:     make(map[chatKey][]PinnedMessage),
+		recovery: make(map[uint][]models.RecoveryCode),
+		search:   make(map[uint][]SearchEntry),
+	}
+	return &Repositories{
+		Users:    &memoryUserRepository{store},
+		Chats:    &memoryChatRepository{store},
+		Messages: &memoryMessageRepository{store},
+		Search:   &memorySearchRepository{store},
+		Reports:  &memoryReportRepository{store},
+		Contacts: &memoryContactRepository{store},
+		Schedule: &memoryScheduleRepository{store},
+	}
+}
+
+// UseMemory подменяет Open набором репозиториев в памяти и возвращает его.
+// Функция restore возвращает прежний Open.
+func UseMemory() (repos *Repositories, restore func()) {
+	previous := Open
+	repos = NewMemory()
+	Open = func() (*Repositories, func(), error) {
+		return repos, func() {}, nil
+	}
+	return repos, func() { Open = previous }
+}
+
+type memoryUserRepository struct {
+	*memoryStore
+}
+
+func (r *memoryUserRepository) findUser(match func(models.User) bool) (models.User, error) {
+	for _, user := range r.users {
+		if !user.DeletedAt.Valid && match(user) {
+			return user, nil
+		}
+	}
+	return models.User{}, ErrNotFound
+}
+
+func (r *memoryUserRepository) GetUser(id uint) (models.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.findUser(func(user models.User) bool { return user.ID == id })
+}
+
+func (r *memoryUserRepository) GetUsers(ids []uint) ([]models.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var users []models.User
+	for _, id := range ids {
+		if user, err := r.findUser(func(user models.User) bool { return user.ID == id }); err == nil {
+			users = append(users, user)
+		}
+	}
+	return users, nil
+}
+
+func (r *memoryUserRepository) GetUserByLogin(login string) (models.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.findUser(func(user models.User) bool { return user.Login == login })
+}
+
+func (r *memoryUserRepository) CreateUser(user *models.User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, err := r.findUser(func(other models.User) bool { return other.Nik == user.Nik || other.Login == user.Login }); err == nil {
+		return ErrDuplicate
+	}
+
+	user.ID = r.nextID
+	r.nextID++
+	user.CreatedAt = time.Now()
+	user.UpdatedAt = user.CreatedAt
+	r.users = append(r.users, *user)
+	return nil
+}
+
+func (r *memoryUserRepository) UpdateProfile(user *models.User) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, err := r.findUser(func(other models.User) bool { return other.Nik == user.Nik && other.ID != user.ID }); err == nil {
+		return ErrDuplicate
+	}
+	for i := range r.users {
+		if r.users[i].ID == user.ID {
+			r.users[i].Name = user.Name
+			r.users[i].SoName = user.SoName
+			r.users[i].Nik = user.Nik
+			r.users[i].Avatar = user.Avatar
+			r.users[i].Email = user.Email
+			r.users[i].UpdatedAt = time.Now()
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+func (r *memoryUserRepository) UpdatePassword(userID uint, passwordHash string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := range r.users {
+		if r.users[i].ID == userID && !r.users[i].DeletedAt.Valid {
+			r.users[i].Password = passwordHash
+			r.users[i].UpdatedAt = time.Now()
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+func (r *memoryUserRepository) NikExists(nik string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, err := r.findUser(func(user models.User) bool { return user.Nik == nik })
+	return err == nil, nil
+}
+
+func (r *memoryUserRepository) LoginExists(login string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, err := r.findUser(func(user models.User) bool { return user.Login == login })
+	return err == nil, nil
+}
+
+func (r *memoryUserRepository) SearchUsers(opts UserSearch) ([]models.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	excluded := make(map[uint]bool, len(opts.ExcludeIDs))
+	for _, id := range opts.ExcludeIDs {
+		excluded[id] = true
+	}
+
+	term := strings.ToLower(opts.Term)
+	var users []models.User
+	for _, user := range r.users {
+		if user.DeletedAt.Valid || excluded[user.ID] {
+			continue
+		}
+		if strings.Contains(strings.ToLower(user.Name), term) ||
+			strings.Contains(strings.ToLower(user.SoName), term) ||
+			strings.Contains(strings.ToLower(user.Nik), term) {
+			users = append(users, user)
+		}
+	}
+
+	// Похожесть триграмм не вычисляется: после ника остальные идут по id
+	rank := func(user models.User) int {
+		nik := strings.ToLower(user.Nik)
+		switch {
+		case nik == term:
+			return 0
+		case strings.HasPrefix(nik, term):
+			return 1
+		}
+		return 2
+	}
+	sort.Slice(users, func(i, j int) bool {
+		if ri, rj := rank(users[i]), rank(users[j]); ri != rj {
+			return ri < rj
+		}
+		return users[i].ID < users[j].ID
+	})
+
+	if opts.Offset >= len(users) {
+		return nil, nil
+	}
+	users = users[opts.Offset:]
+	if opts.Limit > 0 && len(users) > opts.Limit {
+		users = users[:opts.Limit]
+	}
+	return users, nil
+}
+
+func (r *memoryUserRepository) SetDeletionRequestedAt(userID uint, at *time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := range r.users {
+		if r.users[i].ID == userID && !r.users[i].DeletedAt.Valid {
+			r.users[i].DeletionRequestedAt = at
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+func (r *memoryUserRepository) ListDeletionDue(before time.Time) ([]models.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var users []models.User
+	for _, user := range r.users {
+		if !user.DeletedAt.Valid && user.DeletionRequestedAt != nil && !user.DeletionRequestedAt.After(before) {
+			users = append(users, user)
+		}
+	}
+	return users, nil
+}
+
+func (r *memoryUserRepository) PurgeUser(userID uint) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := range r.users {
+		if r.users[i].ID == userID && !r.users[i].DeletedAt.Valid {
+			placeholder := fmt.Sprintf("deleted_%d", userID)
+			r.users[i] = models.User{Model: r.users[i].Model, Nik: placeholder, Login: placeholder}
+			r.users[i].DeletedAt.Time = time.Now()
+			r.users[i].DeletedAt.Valid = true
+			delete(r.recovery, userID)
+			r.removeBlocks(func(block models.UserBlock) bool {
+				return block.BlockerID == userID || block.BlockedID == userID
+			})
+			r.removeContacts(func(contact models.Contact) bool {
+				return contact.OwnerID == userID || contact.ContactID == userID
+			})
+			r.removeScheduled(func(message models.ScheduledMessage) bool {
+				return message.SenderID == userID
+			})
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+func (r *memoryUserRepository) SetTOTP(userID uint, secret string, enabled bool) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := range r.users {
+		if r.users[i].ID == userID && !r.users[i].DeletedAt.Valid {
+			r.users[i].TOTPSecret = secret
+			r.users[i].TOTPEnabled = enabled
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+func (r *memoryUserRepository) ReplaceRecoveryCodes(userID uint, codeHashes []string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	codes := make([]models.RecoveryCode, 0, len(codeHashes))
+	for _, hash := range codeHashes {
+		codes = append(codes, models.RecoveryCode{UserID: userID, CodeHash: hash, CreatedAt: time.Now()})
+	}
+	r.recovery[userID] = codes
+	return nil
+}
+
+func (r *memoryUserRepository) UseRecoveryCode(userID uint, codeHash string) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	codes := r.recovery[userID]
+	for i := range codes {
+		if codes[i].CodeHash == codeHash && codes[i].UsedAt == nil {
+			now := time.Now()
+			codes[i].UsedAt = &now
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (r *memoryUserRepository) BlockUser(blockerID, blockedID uint) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, block := range r.blocks {
+		if block.BlockerID == blockerID && block.BlockedID == blockedID {
+			return nil
+		}
+	}
+	r.blocks = append(r.blocks, models.UserBlock{BlockerID: blockerID, BlockedID: blockedID, CreatedAt: time.Now()})
+	return nil
+}
+
+func (r *memoryUserRepository) UnblockUser(blockerID, blockedID uint) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.removeBlocks(func(block models.UserBlock) bool {
+		return block.BlockerID == blockerID && block.BlockedID == blockedID
+	})
+	return nil
+}
+
+// removeBlocks удаляет подходящие записи чёрного списка; вызывается под r.mu
+func (r *memoryUserRepository) removeBlocks(match func(models.UserBlock) bool) {
+	kept := r.blocks[:0]
+	for _, block := range r.blocks {
+		if !match(block) {
+			kept = append(kept, block)
+		}
+	}
+	r.blocks = kept
+}
+
+func (r *memoryUserRepository) IsBlocked(blockerID, blockedID uint) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, block := range r.blocks {
+		if block.BlockerID == blockerID && block.BlockedID == blockedID {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (r *memoryUserRepository) ListBlocked(blockerID uint) ([]uint, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var ids []uint
+	for i := len(r.blocks) - 1; i >= 0; i-- {
+		if r.blocks[i].BlockerID == blockerID {
+			ids = append(ids, r.blocks[i].BlockedID)
+		}
+	}
+	return ids, nil
+}
+
+func (r *memoryUserRepository) ListBlockers(blockedID uint) ([]uint, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var ids []uint
+	for _, block := range r.blocks {
+		if block.BlockedID == blockedID {
+			ids = append(ids, block.BlockerID)
+		}
+	}
+	return ids, nil
+}
+
+func (r *memoryUserRepository) GetUsersByNiks(niks []string) ([]models.User, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var users []models.User
+	for _, nik := range niks {
+		if user, err := r.findUser(func(user models.User) bool { return user.Nik == nik }); err == nil {
+			users = append(users, user)
+		}
+	}
+	return users, nil
+}
+
+func (r *memoryUserRepository) SetContactsOnly(userID uint, contactsOnly bool) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := range r.users {
+		if r.users[i].ID == userID && !r.users[i].DeletedAt.Valid {
+			r.users[i].ContactsOnly = contactsOnly
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+type memoryContactRepository struct {
+	*memoryStore
+}
+
+func (r *memoryContactRepository) AddContact(contact models.Contact) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for i := range r.contacts {
+		if r.contacts[i].OwnerID == contact.OwnerID && r.contacts[i].ContactID == contact.ContactID {
+			r.contacts[i].CustomName = contact.CustomName
+			return nil
+		}
+	}
+	contact.CreatedAt = time.Now()
+	r.contacts = append(r.contacts, contact)
+	return nil
+}
+
+func (r *memoryContactRepository) RemoveContact(ownerID, contactID uint) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.removeContacts(func(contact models.Contact) bool {
+		return contact.OwnerID == ownerID && contact.ContactID == contactID
+	})
+	return nil
+}
+
+// removeContacts удаляет подходящие контакты; вызывается под mu
+func (s *memoryStore) removeContacts(match func(models.Contact) bool) {
+	kept := s.contacts[:0]
+	for _, contact := range s.contacts {
+		if !match(contact) {
+			kept = append(kept, contact)
+		}
+	}
+	s.contacts = kept
+}
+
+func (r *memoryContactRepository) GetContact(ownerID, contactID uint) (models.Contact, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, contact := range r.contacts {
+		if contact.OwnerID == ownerID && contact.ContactID == contactID {
+			return contact, nil
+		}
+	}
+	return models.Contact{}, ErrNotFound
+}
+
+func (r *memoryContactRepository) ListContacts(ownerID uint) ([]models.Contact, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var contacts []models.Contact
+	for _, contact := range r.contacts {
+		if contact.OwnerID == ownerID {
+			contacts = append(contacts, contact)
+		}
+	}
+	return contacts, nil
+}
+
+type memoryScheduleRepository struct {
+	*memoryStore
+}
+
+// removeScheduled удаляет подходящие отложенные сообщения; вызывается под mu
+func (s *memoryStore) removeScheduled(match func(models.ScheduledMessage) bool) {
+	kept := s.schedule[:0]
+	for _, message := range s.schedule {
+		if !match(message) {
+			kept = append(kept, message)
+		}
+	}
+	s.schedule = kept
+}
+
+// findScheduled возвращает индекс сообщения или -1; вызывается под mu
+func (s *memoryStore) findScheduled(match func(models.ScheduledMessage) bool) int {
+	for i, message := range s.schedule {
+		if match(message) {
+			return i
+		}
+	}
+	return -1
+}
+
+func (r *memoryScheduleRepository) CreateScheduled(message *models.ScheduledMessage) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.nextScheduleID++
+	message.ID = r.nextScheduleID
+	message.Status = models.ScheduledPending
+	message.CreatedAt = time.Now()
+	message.UpdatedAt = message.CreatedAt
+	r.schedule = append(r.schedule, *message)
+	return nil
+}
+
+func (r *memoryScheduleRepository) GetScheduled(senderID, id uint) (models.ScheduledMessage, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	i := r.findScheduled(func(message models.ScheduledMessage) bool {
+		return message.ID == id && message.SenderID == senderID && scheduledListed(message)
+	})
+	if i < 0 {
+		return models.ScheduledMessage{}, ErrNotFound
+	}
+	return r.schedule[i], nil
+}
+
+// scheduledListed сообщает, видит ли отправитель сообщение в списке отложенных
+func scheduledListed(message models.ScheduledMessage) bool {
+	return message.Status == models.ScheduledPending || message.Status == models.ScheduledFailed
+}
+
+func sortScheduled(messages []models.ScheduledMessage) {
+	sort.SliceStable(messages, func(i, j int) bool {
+		if !messages[i].SendAt.Equal(messages[j].SendAt) {
+			return messages[i].SendAt.Before(messages[j].SendAt)
+		}
+		return messages[i].ID < messages[j].ID
+	})
+}
+
+func (r *memoryScheduleRepository) ListScheduled(senderID uint, chatID *gocql.UUID) ([]models.ScheduledMessage, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var messages []models.ScheduledMessage
+	for _, message := range r.schedule {
+		if message.SenderID == senderID && scheduledListed(message) &&
+			(chatID == nil || message.ChatID == chatID.String()) {
+			messages = append(messages, message)
+		}
+	}
+	sortScheduled(messages)
+	return messages, nil
+}
+
+func (r *memoryScheduleRepository) UpdateScheduled(senderID, id uint, text string, sendAt time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	i := r.findScheduled(func(message models.ScheduledMessage) bool {
+		return message.ID == id && message.SenderID == senderID && message.Status == models.ScheduledPending
+	})
+	if i < 0 {
+		return ErrNotFound
+	}
+	r.schedule[i].MessageText = text
+	r.schedule[i].SendAt = sendAt
+	r.schedule[i].UpdatedAt = time.Now()
+	return nil
+}
+
+func (r *memoryScheduleRepository) CancelScheduled(senderID, id uint) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	match := func(message models.ScheduledMessage) bool {
+		return message.ID == id && message.SenderID == senderID && scheduledListed(message)
+	}
+	if r.findScheduled(match) < 0 {
+		return ErrNotFound
+	}
+	r.removeScheduled(match)
+	return nil
+}
+
+// scheduledDue сообщает, может ли диспетчер забрать сообщение
+func scheduledDue(message models.ScheduledMessage, before, staleBefore time.Time) bool {
+	switch message.Status {
+	case models.ScheduledPending:
+		return !message.SendAt.After(before)
+	case models.ScheduledSending:
+		return message.UpdatedAt.Before(staleBefore)
+	}
+	return false
+}
+
+func (r *memoryScheduleRepository) ListDueScheduled(before, staleBefore time.Time, limit int) ([]models.ScheduledMessage, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var messages []models.ScheduledMessage
+	for _, message := range r.schedule {
+		if scheduledDue(message, before, staleBefore) {
+			messages = append(messages, message)
+		}
+	}
+	sortScheduled(messages)
+	if limit > 0 && len(messages) > limit {
+		messages = messages[:limit]
+	}
+	return messages, nil
+}
+
+func (r *memoryScheduleRepository) ClaimScheduled(id uint, messageID gocql.UUID, staleBefore time.Time) (models.ScheduledMessage, bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	i := r.findScheduled(func(message models.ScheduledMessage) bool {
+		return message.ID == id && (message.Status == models.ScheduledPending ||
+			message.Status == models.ScheduledSending && message.UpdatedAt.Before(staleBefore))
+	})
+	if i < 0 {
+		return models.ScheduledMessage{}, false, nil
+	}
+	r.schedule[i].Status = models.ScheduledSending
+	if r.schedule[i].MessageID == nil {
+		value := messageID.String()
+		r.schedule[i].MessageID = &value
+	}
+	r.schedule[i].UpdatedAt = time.Now()
+	return r.schedule[i], true, nil
+}
+
+func (r *memoryScheduleRepository) FinishScheduled(id uint, messageID *gocql.UUID, failure string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	i := r.findScheduled(func(message models.ScheduledMessage) bool { return message.ID == id })
+	if i < 0 {
+		return ErrNotFound
+	}
+	r.schedule[i].Status = models.ScheduledSent
+	if failure != "" {
+		r.schedule[i].Status = models.ScheduledFailed
+	}
+	if messageID != nil {
+		value := messageID.String()
+		r.schedule[i].MessageID = &value
+	}
+	r.schedule[i].Error = failure
+	r.schedule[i].UpdatedAt = time.Now()
+	return nil
+}
+
+type memoryReportRepository struct {
+	*memoryStore
+}
+
+func (r *memoryReportRepository) CreateReport(report *models.Report) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	report.ID = uint(len(r.reports) + 1)
+	report.CreatedAt = time.Now()
+	r.reports = append(r.reports, *report)
+	return nil
+}
+
+type memoryChatRepository struct {
+	*memoryStore
+}
+
+func (r *memoryChatRepository) GetChat(userID uint, chatID gocql.UUID) (Chat, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	chat, ok := r.chats[chatKey{userID, chatID}]
+	if !ok {
+		return Chat{}, ErrNotFound
+	}
+	return chat, nil
+}
+
+func (r *memoryChatRepository) FindChatByCompanion(userID, companionID uint) (Chat, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for key, chat := range r.chats {
+		if key.userID == userID && chat.CompanionID == companionID {
+			return chat, nil
+		}
+	}
+	return Chat{}, ErrNotFound
+}
+
+func (r *memoryChatRepository) CreateChat(chat Chat) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.chats[chatKey{chat.UserID, chat.ChatID}] = chat
+	return nil
+}
+
+func (r *memoryChatRepository) TouchChat(userID uint, chatID gocql.UUID, lastUpdated time.Time, lastMsgTime *time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := chatKey{userID, chatID}
+	chat, ok := r.chats[key]
+	if !ok {
+		return ErrNotFound
+	}
+	if chat.LastUpdated != nil && !lastUpdated.After(*chat.LastUpdated) {
+		return nil
+	}
+
+	chat.LastUpdated = &lastUpdated
+	if lastMsgTime != nil {
+		msgTime := *lastMsgTime
+		chat.LastMsgTime = &msgTime
+	}
+	r.chats[key] = chat
+	return nil
+}
+
+// ListChats в памяти использует смещение в качестве состояния страницы
+func (r *memoryChatRepository) ListChats(userID uint, pageState []byte, pageSize int, match func(Chat) bool) ([]Chat, []byte, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var chats []Chat
+	for key, chat := range r.chats {
+		if key.userID == userID {
+			chats = append(chats, chat)
+		}
+	}
+	sort.Slice(chats, func(i, j int) bool {
+		if chats[i].LastUpdated == nil || chats[j].LastUpdated == nil {
+			return chats[j].LastUpdated == nil && chats[i].LastUpdated != nil
+		}
+		return chats[i].LastUpdated.After(*chats[j].LastUpdated)
+	})
+
+	offset := 0
+	if len(pageState) > 0 {
+		var err error
+		if offset, err = strconv.Atoi(string(pageState)); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	var page []Chat
+	for i := offset; i < len(chats); i++ {
+		if match != nil && !match(chats[i]) {
+			continue
+		}
+		page = append(page, chats[i])
+		if len(page) == pageSize && i+1 < len(chats) {
+			return page, []byte(strconv.Itoa(i + 1)), nil
+		}
+	}
+	return page, nil, nil
+}
+
+func (r *memoryChatRepository) GetUnreadCount(userID uint, chatID gocql.UUID) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return max(r.unread[chatKey{userID, chatID}], 0), nil
+}
+
+func (r *memoryChatRepository) AddUnreadCount(userID uint, chatID gocql.UUID, delta int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.unread[chatKey{userID, chatID}] += delta
+	return nil
+}
+
+func (r *memoryChatRepository) SetUnreadCount(userID uint, chatID gocql.UUID, count int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.unread[chatKey{userID, chatID}] = count
+	return nil
+}
+
+func (r *memoryChatRepository) AddUnreadExpiring(userID uint, chatID gocql.UUID, messageID gocql.UUID, expiresAt time.Time) error {
+	return r.updateChat(userID, chatID, func(chat *Chat) {
+		unread := make(map[gocql.UUID]time.Time, len(chat.UnreadExpiring)+1)
+		for id, at := range chat.UnreadExpiring {
+			unread[id] = at
+		}
+		unread[messageID] = expiresAt
+		chat.UnreadExpiring = unread
+	})
+}
+
+func (r *memoryChatRepository) RemoveUnreadExpiring(userID uint, chatID gocql.UUID, messageIDs []gocql.UUID) error {
+	if len(messageIDs) == 0 {
+		return nil
+	}
+	return r.updateChat(userID, chatID, func(chat *Chat) {
+		unread := make(map[gocql.UUID]time.Time, len(chat.UnreadExpiring))
+		for id, at := range chat.UnreadExpiring {
+			unread[id] = at
+		}
+		for _, id := range messageIDs {
+			delete(unread, id)
+		}
+		chat.UnreadExpiring = unread
+	})
+}
+
+func (r *memoryChatRepository) updateChat(userID uint, chatID gocql.UUID, update func(chat *Chat)) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := chatKey{userID, chatID}
+	chat, ok := r.chats[key]
+	if !ok {
+		return ErrNotFound
+	}
+	update(&chat)
+	r.chats[key] = chat
+	return nil
+}
+
+func (r *memoryChatRepository) MarkCompanionDeleted(userID uint, chatID gocql.UUID) error {
+	return r.updateChat(userID, chatID, func(chat *Chat) { chat.CompanionDeleted = true })
+}
+
+func (r *memoryChatRepository) SetMuted(userID uint, chatID gocql.UUID, muted bool, until *time.Time) error {
+	return r.updateChat(userID, chatID, func(chat *Chat) {
+		chat.Muted = muted
+		chat.MutedUntil = until
+	})
+}
+
+func (r *memoryChatRepository) SetPinOrder(userID uint, chatID gocql.UUID, order int) error {
+	return r.updateChat(userID, chatID, func(chat *Chat) { chat.PinOrder = order })
+}
+
+func (r *memoryChatRepository) SetArchived(userID uint, chatID gocql.UUID, archived bool) error {
+	return r.updateChat(userID, chatID, func(chat *Chat) { chat.Archived = archived })
+}
+
+func (r *memoryChatRepository) SetMessageTTL(userID uint, chatID gocql.UUID, ttl int) error {
+	return r.updateChat(userID, chatID, func(chat *Chat) { chat.MessageTTL = ttl })
+}
+
+func (r *memoryChatRepository) SetDraft(userID uint, chatID gocql.UUID, text string, replyTo *gocql.UUID, updatedAt *time.Time) error {
+	return r.updateChat(userID, chatID, func(chat *Chat) {
+		chat.DraftText = text
+		chat.DraftReplyToMessageID = replyTo
+		chat.DraftUpdatedAt = updatedAt
+	})
+}
+
+func (r *memoryChatRepository) ListPinnedChats(userID uint) ([]Chat, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var pinned []Chat
+	for key, chat := range r.chats {
+		if key.userID == userID && chat.PinOrder > 0 {
+			pinned = append(pinned, chat)
+		}
+	}
+	sort.Slice(pinned, func(i, j int) bool { return pinned[i].PinOrder < pinned[j].PinOrder })
+	return pinned, nil
+}
+
+func (r *memoryChatRepository) DeleteChat(userID uint, chatID gocql.UUID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := chatKey{userID, chatID}
+	if _, ok := r.chats[key]; !ok {
+		return ErrNotFound
+	}
+	delete(r.chats, key)
+	delete(r.unread, key)
+	return nil
+}
+
+// DropLegacyData в памяти ничего не делает: keyspace user_N есть только в Scylla
+func (r *memoryChatRepository) DropLegacyData(userID uint) error {
+	return nil
+}
+
+type memoryMessageRepository struct {
+	*memoryStore
+}
+
+func (r *memoryMessageRepository) AddMessage(message Message) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	// Как и INSERT в Scylla, повторная запись копии с тем же MessageID заменяет её
+	key := chatKey{message.OwnerID, message.ChatID}
+	messages := r.messages[key]
+	for i := range messages {
+		if messages[i].MessageID == message.MessageID {
+			messages = append(messages[:i], messages[i+1:]...)
+			break
+		}
+	}
+	messages = append(messages, message)
+	sort.SliceStable(messages, func(i, j int) bool {
+		return messages[i].CreatedAt.After(messages[j].CreatedAt)
+	})
+	r.messages[key] = messages
+	return nil
+}
+
+// expired повторяет TTL Scylla: истёкшие копии не видны, как будто их удалили
+func (m Message) expired() bool {
+	return m.ExpiresAt != nil && !time.Now().Before(*m.ExpiresAt)
+}
+
+func (r *memoryMessageRepository) GetMessage(ownerID uint, chatID, messageID gocql.UUID) (Message, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, message := range r.messages[chatKey{ownerID, chatID}] {
+		if message.MessageID == messageID && !message.expired() {
+			return message, nil
+		}
+	}
+	return Message{}, ErrNotFound
+}
+
+func (r *memoryMessageRepository) ListMessages(ownerID uint, chatID gocql.UUID, opts ListOptions) ([]Message, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var messages []Message
+	for _, message := range r.messages[chatKey{ownerID, chatID}] {
+		if message.expired() {
+			continue
+		}
+		if opts.Before != nil && message.CreatedAt.After(*opts.Before) {
+			continue
+		}
+		if opts.After != nil && !message.CreatedAt.After(*opts.After) {
+			continue
+		}
+		messages = append(messages, message)
+		if opts.Limit > 0 && len(messages) >= opts.Limit {
+			break
+		}
+	}
+	return messages, nil
+}
+
+func (r *memoryMessageRepository) MarkRead(ownerID uint, chatID gocql.UUID, keys []MessageKey) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	messages := r.messages[chatKey{ownerID, chatID}]
+	for _, key := range keys {
+		for i := range messages {
+			if messages[i].MessageID == key.MessageID {
+				messages[i].Read = true
+			}
+		}
+	}
+	return nil
+}
+
+func (r *memoryMessageRepository) DeleteMessages(ownerID uint, chatID gocql.UUID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	delete(r.messages, chatKey{ownerID, chatID})
+	delete(r.pins, chatKey{ownerID, chatID})
+	return nil
+}
+
+func (r *memoryMessageRepository) UpdateMessageText(ownerID uint, chatID, messageID gocql.UUID, text string, editedAt time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	messages := r.messages[chatKey{ownerID, chatID}]
+	for i := range messages {
+		if messages[i].MessageID == messageID && !messages[i].expired() {
+			messages[i].MessageText = text
+			messages[i].EditedAt = &editedAt
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+func (r *memoryMessageRepository) DeleteMessage(ownerID uint, chatID, messageID gocql.UUID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := chatKey{ownerID, chatID}
+	messages := r.messages[key]
+	for i := range messages {
+		if messages[i].MessageID == messageID {
+			r.messages[key] = append(messages[:i:i], messages[i+1:]...)
+			r.removePin(key, messageID)
+			return nil
+		}
+	}
+	return ErrNotFound
+}
+
+// removePin удаляет закрепление сообщения; вызывается под mu
+func (s *memoryStore) removePin(key chatKey, messageID gocql.UUID) {
+	pins := s.pins[key]
+	for i := range pins {
+		if pins[i].MessageID == messageID {
+			s.pins[key] = append(pins[:i:i], pins[i+1:]...)
+			return
+		}
+	}
+}
+
+func (r *memoryMessageRepository) PinMessage(pin PinnedMessage) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	key := chatKey{pin.OwnerID, pin.ChatID}
+	r.removePin(key, pin.MessageID)
+	r.pins[key] = append(r.pins[key], pin)
+	return nil
+}
+
+func (r *memoryMessageRepository) UnpinMessage(ownerID uint, chatID, messageID gocql.UUID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.removePin(chatKey{ownerID, chatID}, messageID)
+	return nil
+}
+
+func (r *memoryMessageRepository) ListPinnedMessages(ownerID uint, chatID gocql.UUID) ([]PinnedMessage, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var pins []PinnedMessage
+	now := time.Now()
+	for _, pin := range r.pins[chatKey{ownerID, chatID}] {
+		if pin.ExpiresAt == nil || now.Before(*pin.ExpiresAt) {
+			pins = append(pins, pin)
+		}
+	}
+	sortPins(pins)
+	return pins, nil
+}
+
+type memorySearchRepository struct {
+	*memoryStore
+}
+
+// searchWords разбивает текст на слова в нижнем регистре, как конфигурация simple в Postgres
+func searchWords(text string) []string {
+	return strings.FieldsFunc(strings.ToLower(text), func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsDigit(r)
+	})
+}
+
+func (r *memorySearchRepository) IndexMessage(entry SearchEntry) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	entries := r.search[entry.OwnerID]
+	for i := range entries {
+		if entries[i].MessageID == entry.MessageID {
+			entries[i].Text = entry.Text
+			return nil
+		}
+	}
+	r.search[entry.OwnerID] = append(entries, entry)
+	return nil
+}
+
+func (r *memorySearchRepository) removeWhere(ownerID uint, match func(SearchEntry) bool) {
+	var kept []SearchEntry
+	for _, entry := range r.search[ownerID] {
+		if !match(entry) {
+			kept = append(kept, entry)
+		}
+	}
+	r.search[ownerID] = kept
+}
+
+func (r *memorySearchRepository) RemoveMessage(ownerID uint, messageID gocql.UUID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.removeWhere(ownerID, func(entry SearchEntry) bool { return entry.MessageID == messageID })
+	return nil
+}
+
+func (r *memorySearchRepository) RemoveChat(ownerID uint, chatID gocql.UUID) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.removeWhere(ownerID, func(entry SearchEntry) bool { return entry.ChatID == chatID })
+	return nil
+}
+
+// SearchMessages в памяти не ранжирует: совпадения идут от новых к старым
+func (r *memorySearchRepository) SearchMessages(opts MessageSearch) ([]SearchHit, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	queryWords := searchWords(opts.Query)
+	if len(queryWords) == 0 {
+		return nil, nil
+	}
+
+	var hits []SearchHit
+	for _, entry := range r.search[opts.OwnerID] {
+		if opts.ChatID != nil && entry.ChatID != *opts.ChatID {
+			continue
+		}
+		words := make(map[string]bool)
+		for _, word := range searchWords(entry.Text) {
+			words[word] = true
+		}
+		matched := true
+		for _, word := range queryWords {
+			matched = matched && words[word]
+		}
+		if matched {
+			hits = append(hits, SearchHit{ChatID: entry.ChatID, MessageID: entry.MessageID, SenderID: entry.SenderID, CreatedAt: entry.CreatedAt})
+		}
+	}
+	sort.Slice(hits, func(i, j int) bool { return hits[i].CreatedAt.After(hits[j].CreatedAt) })
+
+	if opts.Offset >= len(hits) {
+		return nil, nil
+	}
+	hits = hits[opts.Offset:]
+	if opts.Limit > 0 && len(hits) > opts.Limit {
+		hits = hits[:opts.Limit]
+	}
+	return hits, nil
+}
@@ -0,0 +1,467 @@
+package repository
+
+import (
+	"Bmessage_backend/models"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/jackc/pgx/v5/pgconn"
+	"gorm.io/gorm"
+	"gorm.io/gorm/clause"
+)
+
+type postgresUserRepository struct {
+	db *gorm.DB
+}
+
+func NewPostgresUserRepository(db *gorm.DB) UserRepository {
+	return &postgresUserRepository{db: db}
+}
+
+func gormNotFound(err error) error {
+	if errors.Is(err, gorm.ErrRecordNotFound) {
+		return ErrNotFound
+	}
+	return err
+}
+
+// uniqueViolation переводит нарушение уникального индекса Postgres в ErrDuplicate
+func uniqueViolation(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" {
+		return ErrDuplicate
+	}
+	return err
+}
+
+func (r *postgresUserRepository) GetUser(id uint) (models.User, error) {
+	var user models.User
+	err := r.db.First(&user, id).Error
+	return user, gormNotFound(err)
+}
+
+func (r *postgresUserRepository) GetUsers(ids []uint) ([]models.User, error) {
+	var users []models.User
+	if len(ids) == 0 {
+		return users, nil
+	}
+	err := r.db.Where("id IN ?", ids).Find(&users).Error
+	return users, err
+}
+
+func (r *postgresUserRepository) GetUserByLogin(login string) (models.User, error) {
+	var user models.User
+	err := r.db.Where("login = ?", login).First(&user).Error
+	return user, gormNotFound(err)
+}
+
+func (r *postgresUserRepository) CreateUser(user *models.User) error {
+	return uniqueViolation(r.db.Create(user).Error)
+}
+
+func (r *postgresUserRepository) UpdateProfile(user *models.User) error {
+	err := r.db.Model(user).Select("name", "so_name", "nik", "avatar", "email").Updates(user).Error
+	return uniqueViolation(err)
+}
+
+func (r *postgresUserRepository) UpdatePassword(userID uint, passwordHash string) error {
+	result := r.db.Model(&models.User{}).Where("id = ?", userID).Update("password", passwordHash)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *postgresUserRepository) NikExists(nik string) (bool, error) {
+	var count int64
+	err := r.db.Model(&models.User{}).Where("nik = ?", nik).Count(&count).Error
+	return count > 0, err
+}
+
+func (r *postgresUserRepository) LoginExists(login string) (bool, error) {
+	var count int64
+	err := r.db.Model(&models.User{}).Where("login = ?", login).Count(&count).Error
+	return count > 0, err
+}
+
+// likeEscaper экранирует спецсимволы шаблона LIKE во введённой строке
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+func (r *postgresUserRepository) SearchUsers(opts UserSearch) ([]models.User, error) {
+	escaped := likeEscaper.Replace(opts.Term)
+	contains := "%" + escaped + "%"
+
+	// ILIKE по отдельным колонкам использует триграммные индексы idx_users_*_trgm
+	query := r.db.Model(&models.User{}).Select("id", "name", "so_name", "nik", "avatar").Where(
+		"nik ILIKE ? OR name ILIKE ? OR so_name ILIKE ?",
+		contains, contains, contains,
+	)
+	if len(opts.ExcludeIDs) > 0 {
+		query = query.Where("id NOT IN ?", opts.ExcludeIDs)
+	}
+	query = query.Clauses(clause.OrderBy{Expression: clause.Expr{
+		SQL:                "lower(nik) = lower(?) DESC, nik ILIKE ? DESC, greatest(similarity(nik, ?), similarity(name || ' ' || so_name, ?)) DESC, id",
+		Vars:               []interface{}{opts.Term, escaped + "%", opts.Term, opts.Term},
+		WithoutParentheses: true,
+	}})
+	if opts.Limit > 0 {
+		query = query.Limit(opts.Limit)
+	}
+	if opts.Offset > 0 {
+		query = query.Offset(opts.Offset)
+	}
+
+	var users []models.User
+	err := query.Find(&users).Error
+	return users, err
+}
+
+func (r *postgresUserRepository) SetDeletionRequestedAt(userID uint, at *time.Time) error {
+	result := r.db.Model(&models.User{}).Where("id = ?", userID).Update("deletion_requested_at", at)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *postgresUserRepository) ListDeletionDue(before time.Time) ([]models.User, error) {
+	var users []models.User
+	err := r.db.Where("deletion_requested_at IS NOT NULL AND deletion_requested_at <= ?", before).Find(&users).Error
+	return users, err
+}
+
+func (r *postgresUserRepository) PurgeUser(userID uint) error {
+	placeholder := fmt.Sprintf("deleted_%d", userID)
+	result := r.db.Model(&models.User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+		"name":                  "",
+		"so_name":               "",
+		"nik":                   placeholder,
+		"login":                 placeholder,
+		"password":              "",
+		"private_key":           "",
+		"avatar":                "",
+		"email":                 "",
+		"deletion_requested_at": nil,
+		"totp_secret":           "",
+		"totp_enabled":          false,
+		"deleted_at":            time.Now(),
+	})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	if err := r.db.Where("blocker_id = ? OR blocked_id = ?", userID, userID).Delete(&models.UserBlock{}).Error; err != nil {
+		return err
+	}
+	if err := r.db.Where("owner_id = ? OR contact_id = ?", userID, userID).Delete(&models.Contact{}).Error; err != nil {
+		return err
+	}
+	if err := r.db.Where("sender_id = ?", userID).Delete(&models.ScheduledMessage{}).Error; err != nil {
+		return err
+	}
+	return r.db.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error
+}
+
+func (r *postgresUserRepository) SetTOTP(userID uint, secret string, enabled bool) error {
+	result := r.db.Model(&models.User{}).Where("id = ?", userID).Updates(map[string]interface{}{
+		"totp_secret":  secret,
+		"totp_enabled": enabled,
+	})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *postgresUserRepository) ReplaceRecoveryCodes(userID uint, codeHashes []string) error {
+	return r.db.Transaction(func(tx *gorm.DB) error {
+		if err := tx.Where("user_id = ?", userID).Delete(&models.RecoveryCode{}).Error; err != nil {
+			return err
+		}
+		if len(codeHashes) == 0 {
+			return nil
+		}
+		codes := make([]models.RecoveryCode, 0, len(codeHashes))
+		for _, hash := range codeHashes {
+			codes = append(codes, models.RecoveryCode{UserID: userID, CodeHash: hash})
+		}
+		return tx.Create(&codes).Error
+	})
+}
+
+func (r *postgresUserRepository) UseRecoveryCode(userID uint, codeHash string) (bool, error) {
+	result := r.db.Model(&models.RecoveryCode{}).
+		Where("user_id = ? AND code_hash = ? AND used_at IS NULL", userID, codeHash).
+		Update("used_at", time.Now())
+	return result.RowsAffected == 1, result.Error
+}
+
+func (r *postgresUserRepository) BlockUser(blockerID, blockedID uint) error {
+	return r.db.Clauses(clause.OnConflict{DoNothing: true}).Create(&models.UserBlock{BlockerID: blockerID, BlockedID: blockedID}).Error
+}
+
+func (r *postgresUserRepository) UnblockUser(blockerID, blockedID uint) error {
+	return r.db.Where("blocker_id = ? AND blocked_id = ?", blockerID, blockedID).Delete(&models.UserBlock{}).Error
+}
+
+func (r *postgresUserRepository) IsBlocked(blockerID, blockedID uint) (bool, error) {
+	var count int64
+	err := r.db.Model(&models.UserBlock{}).Where("blocker_id = ? AND blocked_id = ?", blockerID, blockedID).Count(&count).Error
+	return count > 0, err
+}
+
+func (r *postgresUserRepository) ListBlocked(blockerID uint) ([]uint, error) {
+	var ids []uint
+	err := r.db.Model(&models.UserBlock{}).Where("blocker_id = ?", blockerID).Order("created_at DESC").Pluck("blocked_id", &ids).Error
+	return ids, err
+}
+
+func (r *postgresUserRepository) ListBlockers(blockedID uint) ([]uint, error) {
+	var ids []uint
+	err := r.db.Model(&models.UserBlock{}).Where("blocked_id = ?", blockedID).Pluck("blocker_id", &ids).Error
+	return ids, err
+}
+
+func (r *postgresUserRepository) GetUsersByNiks(niks []string) ([]models.User, error) {
+	var users []models.User
+	if len(niks) == 0 {
+		return users, nil
+	}
+	err := r.db.Where("nik IN ?", niks).Find(&users).Error
+	return users, err
+}
+
+func (r *postgresUserRepository) SetContactsOnly(userID uint, contactsOnly bool) error {
+	result := r.db.Model(&models.User{}).Where("id = ?", userID).Update("contacts_only", contactsOnly)
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+type postgresContactRepository struct {
+	db *gorm.DB
+}
+
+func NewPostgresContactRepository(db *gorm.DB) ContactRepository {
+	return &postgresContactRepository{db: db}
+}
+
+func (r *postgresContactRepository) AddContact(contact models.Contact) error {
+	return r.db.Clauses(clause.OnConflict{
+		Columns:   []clause.Column{{Name: "owner_id"}, {Name: "contact_id"}},
+		DoUpdates: clause.AssignmentColumns([]string{"custom_name"}),
+	}).Create(&contact).Error
+}
+
+func (r *postgresContactRepository) RemoveContact(ownerID, contactID uint) error {
+	return r.db.Where("owner_id = ? AND contact_id = ?", ownerID, contactID).Delete(&models.Contact{}).Error
+}
+
+func (r *postgresContactRepository) GetContact(ownerID, contactID uint) (models.Contact, error) {
+	var contact models.Contact
+	err := r.db.Where("owner_id = ? AND contact_id = ?", ownerID, contactID).First(&contact).Error
+	return contact, gormNotFound(err)
+}
+
+func (r *postgresContactRepository) ListContacts(ownerID uint) ([]models.Contact, error) {
+	var contacts []models.Contact
+	err := r.db.Where("owner_id = ?", ownerID).Order("created_at, contact_id").Find(&contacts).Error
+	return contacts, err
+}
+
+type postgresReportRepository struct {
+	db *gorm.DB
+}
+
+func NewPostgresReportRepository(db *gorm.DB) ReportRepository {
+	return &postgresReportRepository{db: db}
+}
+
+func (r *postgresReportRepository) CreateReport(report *models.Report) error {
+	return r.db.Create(report).Error
+}
+
+// listedScheduledStatuses — статусы сообщений, которые видит и может отменить отправитель
+var listedScheduledStatuses = []string{models.ScheduledPending, models.ScheduledFailed}
+
+type postgresScheduleRepository struct {
+	db *gorm.DB
+}
+
+func NewPostgresScheduleRepository(db *gorm.DB) ScheduleRepository {
+	return &postgresScheduleRepository{db: db}
+}
+
+func (r *postgresScheduleRepository) CreateScheduled(message *models.ScheduledMessage) error {
+	message.Status = models.ScheduledPending
+	return r.db.Create(message).Error
+}
+
+func (r *postgresScheduleRepository) GetScheduled(senderID, id uint) (models.ScheduledMessage, error) {
+	var message models.ScheduledMessage
+	err := r.db.Where("id = ? AND sender_id = ? AND status IN ?", id, senderID, listedScheduledStatuses).First(&message).Error
+	return message, gormNotFound(err)
+}
+
+func (r *postgresScheduleRepository) ListScheduled(senderID uint, chatID *gocql.UUID) ([]models.ScheduledMessage, error) {
+	query := r.db.Where("sender_id = ? AND status IN ?", senderID, listedScheduledStatuses)
+	if chatID != nil {
+		query = query.Where("chat_id = ?", chatID.String())
+	}
+	var messages []models.ScheduledMessage
+	err := query.Order("send_at, id").Find(&messages).Error
+	return messages, err
+}
+
+func (r *postgresScheduleRepository) UpdateScheduled(senderID, id uint, text string, sendAt time.Time) error {
+	result := r.db.Model(&models.ScheduledMessage{}).
+		Where("id = ? AND sender_id = ? AND status = ?", id, senderID, models.ScheduledPending).
+		Updates(map[string]interface{}{"message_text": text, "send_at": sendAt, "updated_at": time.Now()})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *postgresScheduleRepository) CancelScheduled(senderID, id uint) error {
+	result := r.db.Where("id = ? AND sender_id = ? AND status IN ?", id, senderID, listedScheduledStatuses).
+		Delete(&models.ScheduledMessage{})
+	if result.Error != nil {
+		return result.Error
+	}
+	if result.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *postgresScheduleRepository) ListDueScheduled(before, staleBefore time.Time, limit int) ([]models.ScheduledMessage, error) {
+	var messages []models.ScheduledMessage
+	err := r.db.Where("(status = ? AND send_at <= ?) OR (status = ? AND updated_at < ?)",
+		models.ScheduledPending, before, models.ScheduledSending, staleBefore).
+		Order("send_at, id").Limit(limit).Find(&messages).Error
+	return messages, err
+}
+
+func (r *postgresScheduleRepository) ClaimScheduled(id uint, messageID gocql.UUID, staleBefore time.Time) (models.ScheduledMessage, bool, error) {
+	result := r.db.Model(&models.ScheduledMessage{}).
+		Where("id = ? AND (status = ? OR (status = ? AND updated_at < ?))", id, models.ScheduledPending, models.ScheduledSending, staleBefore).
+		Updates(map[string]interface{}{
+			"status":     models.ScheduledSending,
+			"message_id": gorm.Expr("COALESCE(message_id, ?)", messageID.String()),
+			"updated_at": time.Now(),
+		})
+	if result.Error != nil || result.RowsAffected == 0 {
+		return models.ScheduledMessage{}, false, result.Error
+	}
+
+	var message models.ScheduledMessage
+	if err := r.db.First(&message, id).Error; err != nil {
+		return models.ScheduledMessage{}, false, err
+	}
+	return message, true, nil
+}
+
+func (r *postgresScheduleRepository) FinishScheduled(id uint, messageID *gocql.UUID, failure string) error {
+	values := map[string]interface{}{"status": models.ScheduledSent, "error": failure, "updated_at": time.Now()}
+	if failure != "" {
+		values["status"] = models.ScheduledFailed
+	}
+	if messageID != nil {
+		values["message_id"] = messageID.String()
+	}
+	return r.db.Model(&models.ScheduledMessage{}).Where("id = ?", id).Updates(values).Error
+}
+
+// searchConfig — конфигурация полнотекстового поиска: без стемминга, одинаково для всех языков
+const searchConfig = "simple"
+
+type postgresSearchRepository struct {
+	db *gorm.DB
+}
+
+func NewPostgresSearchRepository(db *gorm.DB) SearchRepository {
+	return &postgresSearchRepository{db: db}
+}
+
+func (r *postgresSearchRepository) IndexMessage(entry SearchEntry) error {
+	return r.db.Exec(`INSERT INTO message_search (owner_id, message_id, chat_id, sender_id, created_at, body)
+		VALUES (?, ?, ?, ?, ?, to_tsvector('`+searchConfig+`', ?))
+		ON CONFLICT (owner_id, message_id) DO UPDATE SET body = EXCLUDED.body`,
+		entry.OwnerID, entry.MessageID.String(), entry.ChatID.String(), entry.SenderID, entry.CreatedAt, entry.Text,
+	).Error
+}
+
+func (r *postgresSearchRepository) RemoveMessage(ownerID uint, messageID gocql.UUID) error {
+	return r.db.Exec(`DELETE FROM message_search WHERE owner_id = ? AND message_id = ?`, ownerID, messageID.String()).Error
+}
+
+func (r *postgresSearchRepository) RemoveChat(ownerID uint, chatID gocql.UUID) error {
+	return r.db.Exec(`DELETE FROM message_search WHERE owner_id = ? AND chat_id = ?`, ownerID, chatID.String()).Error
+}
+
+func (r *postgresSearchRepository) SearchMessages(opts MessageSearch) ([]SearchHit, error) {
+	query := `SELECT chat_id::text, message_id::text, sender_id, created_at
+		FROM message_search, websearch_to_tsquery('` + searchConfig + `', ?) AS q
+		WHERE owner_id = ? AND body @@ q`
+	args := []interface{}{opts.Query, opts.OwnerID}
+	if opts.ChatID != nil {
+		query += ` AND chat_id = ?`
+		args = append(args, opts.ChatID.String())
+	}
+	query += ` ORDER BY ts_rank(body, q) DESC, created_at DESC`
+	if opts.Limit > 0 {
+		query += ` LIMIT ?`
+		args = append(args, opts.Limit)
+	}
+	if opts.Offset > 0 {
+		query += ` OFFSET ?`
+		args = append(args, opts.Offset)
+	}
+
+	rows, err := r.db.Raw(query, args...).Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var hits []SearchHit
+	for rows.Next() {
+		var (
+			hit               SearchHit
+			chatID, messageID string
+		)
+		if err := rows.Scan(&chatID, &messageID, &hit.SenderID, &hit.CreatedAt); err != nil {
+			return nil, err
+		}
+		if hit.ChatID, err = gocql.ParseUUID(chatID); err != nil {
+			return nil, err
+		}
+		if hit.MessageID, err = gocql.ParseUUID(messageID); err != nil {
+			return nil, err
+		}
+		hits = append(hits, hit)
+	}
+	return hits, rows.Err()
+}
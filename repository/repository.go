@@ -0,0 +1,332 @@
+package repository
+
+import (
+	"Bmessage_backend/models"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gocql/gocql"
+)
+
+// ErrNotFound возвращается, если запрошенная запись не существует
+var ErrNotFound = errors.New("not found")
+
+// ErrDuplicate возвращается, если запись нарушает ограничение уникальности
+var ErrDuplicate = errors.New("duplicate")
+
+// Chat — чат с точки зрения одного участника (UserID)
+type Chat struct {
+	UserID      uint
+	ChatID      gocql.UUID
+	CompanionID uint
+	ChatType    string
+	Secured     bool
+	Muted       bool
+	// MutedUntil — конец временного отключения уведомлений; при Muted и nil — навсегда
+	MutedUntil *time.Time
+	// PinOrder — позиция в списке закреплённых чатов, начиная с 1; 0 — чат не закреплён
+	PinOrder int
+	// Archived — чат скрыт из основного списка
+	Archived bool
+	// MessageTTL — срок жизни новых сообщений в секундах, одинаковый у обоих участников; 0 — сообщения не исчезают
+	MessageTTL int
+	// CompanionDeleted — собеседник удалил аккаунт; чат доступен только для чтения
+	CompanionDeleted bool
+	LastMsgTime      *time.Time
+	LastUpdated      *time.Time
+	PrivateKey       string
+	// Черновик пользователя: текст зашифрован ключом чата. DraftUpdatedAt = nil — черновика нет
+	DraftText             string
+	DraftReplyToMessageID *gocql.UUID
+	DraftUpdatedAt        *time.Time
+	// UnreadExpiring — непрочитанные входящие сообщения со сроком жизни и время их исчезновения.
+	// Исчезнувшие по сроку сообщения вычитаются из счётчика непрочитанных при чтении списка чатов.
+	UnreadExpiring map[gocql.UUID]time.Time
+}
+
+// MutedAt сообщает, отключены ли уведомления чата в момент t
+func (c Chat) MutedAt(t time.Time) bool {
+	return c.Muted && (c.MutedUntil == nil || t.Before(*c.MutedUntil))
+}
+
+// Message — копия сообщения, принадлежащая участнику OwnerID.
+// MessageText зашифрован ключом чата владельца.
+type Message struct {
+	OwnerID                uint
+	ChatID                 gocql.UUID
+	MessageID              gocql.UUID
+	SenderID               uint
+	MessageText            string
+	CreatedAt              time.Time
+	ReplyToMessageID       *gocql.UUID
+	ForwardedFromChatID    *gocql.UUID
+	ForwardedFromMessageID *gocql.UUID
+	// ForwardedFromSenderID и ForwardedAt — автор и время исходного сообщения для пересланных
+	ForwardedFromSenderID *uint
+	ForwardedAt           *time.Time
+	Read                  bool
+	// EditedAt — время последнего редактирования; nil, если сообщение не редактировалось
+	EditedAt *time.Time
+	// ExpiresAt — момент, когда хранилище удалит копию; nil — сообщение не исчезает
+	ExpiresAt *time.Time
+	// ViewOnce — сообщение удаляется у обоих участников, когда получатель его прочитает
+	ViewOnce bool
+	// Kind — вид служебного сообщения; пусто у сообщений пользователей. У служебных
+	// сообщений нет текста и отправителя, данные события лежат в Payload в виде JSON.
+	Kind    string
+	Payload string
+}
+
+// PinnedMessage — закреплённое сообщение в копии чата владельца OwnerID
+type PinnedMessage struct {
+	OwnerID   uint
+	ChatID    gocql.UUID
+	MessageID gocql.UUID
+	PinnedBy  uint
+	PinnedAt  time.Time
+	// ExpiresAt — срок жизни закреплённого сообщения: закрепление исчезает вместе с ним
+	ExpiresAt *time.Time
+}
+
+// MessageKey однозначно определяет сообщение внутри чата
+type MessageKey struct {
+	CreatedAt time.Time
+	MessageID gocql.UUID
+	// ExpiresAt — срок жизни сообщения: изменение не должно его продлевать
+	ExpiresAt *time.Time
+}
+
+// ListOptions ограничивает выборку сообщений по времени создания.
+// Before включает границу, After — нет. Limit = 0 означает без ограничения.
+type ListOptions struct {
+	Before *time.Time
+	After  *time.Time
+	Limit  int
+}
+
+// UserSearch — параметры поиска пользователей. Limit = 0 означает без ограничения.
+type UserSearch struct {
+	Term       string
+	ExcludeIDs []uint
+	Limit      int
+	Offset     int
+}
+
+// SearchEntry — сообщение в поисковом индексе владельца. Text — расшифрованный текст;
+// в индекс попадают только лексемы, сам текст не сохраняется.
+type SearchEntry struct {
+	OwnerID   uint
+	ChatID    gocql.UUID
+	MessageID gocql.UUID
+	SenderID  uint
+	CreatedAt time.Time
+	Text      string
+}
+
+// MessageSearch — параметры поиска по сообщениям владельца. ChatID = nil — по всем чатам.
+type MessageSearch struct {
+	OwnerID uint
+	ChatID  *gocql.UUID
+	Query   string
+	Limit   int
+	Offset  int
+}
+
+// SearchHit — найденное сообщение. Текст берётся из хранилища сообщений.
+type SearchHit struct {
+	ChatID    gocql.UUID
+	MessageID gocql.UUID
+	SenderID  uint
+	CreatedAt time.Time
+}
+
+// UserRepository хранит учётные записи пользователей
+type UserRepository interface {
+	GetUser(id uint) (models.User, error)
+	GetUsers(ids []uint) ([]models.User, error)
+	GetUserByLogin(login string) (models.User, error)
+	CreateUser(user *models.User) error
+	// UpdateProfile сохраняет имя, фамилию, ник, аватар и почту пользователя
+	UpdateProfile(user *models.User) error
+	// UpdatePassword заменяет bcrypt-хеш пароля пользователя
+	UpdatePassword(userID uint, passwordHash string) error
+	NikExists(nik string) (bool, error)
+	LoginExists(login string) (bool, error)
+	// SearchUsers ищет пользователей по подстроке имени, фамилии или ника без учёта регистра.
+	// Первыми идут точное совпадение ника, затем ники с этим началом, затем остальные по похожести.
+	SearchUsers(opts UserSearch) ([]models.User, error)
+	// SetDeletionRequestedAt планирует удаление аккаунта (at != nil) или отменяет его (at == nil)
+	SetDeletionRequestedAt(userID uint, at *time.Time) error
+	// ListDeletionDue возвращает пользователей, запросивших удаление не позже before
+	ListDeletionDue(before time.Time) ([]models.User, error)
+	// PurgeUser обезличивает учётную запись и помечает её удалённой. Ник и логин
+	// заменяются на deleted_<id>, чтобы их можно было занять заново.
+	PurgeUser(userID uint) error
+	// SetTOTP сохраняет секрет двухфакторной аутентификации и признак её включения
+	SetTOTP(userID uint, secret string, enabled bool) error
+	// ReplaceRecoveryCodes заменяет все резервные коды пользователя новыми (sha256 кодов)
+	ReplaceRecoveryCodes(userID uint, codeHashes []string) error
+	// UseRecoveryCode отмечает неиспользованный код использованным. false — кода нет или он уже использован.
+	UseRecoveryCode(userID uint, codeHash string) (bool, error)
+	// BlockUser добавляет blockedID в чёрный список blockerID; повторная блокировка не ошибка
+	BlockUser(blockerID, blockedID uint) error
+	UnblockUser(blockerID, blockedID uint) error
+	// IsBlocked сообщает, заблокировал ли blockerID пользователя blockedID
+	IsBlocked(blockerID, blockedID uint) (bool, error)
+	// ListBlocked возвращает пользователей из чёрного списка blockerID
+	ListBlocked(blockerID uint) ([]uint, error)
+	// ListBlockers возвращает пользователей, заблокировавших blockedID
+	ListBlockers(blockedID uint) ([]uint, error)
+	// GetUsersByNiks возвращает существующих пользователей с указанными никами
+	GetUsersByNiks(niks []string) ([]models.User, error)
+	SetContactsOnly(userID uint, contactsOnly bool) error
+}
+
+// ContactRepository хранит списки контактов пользователей
+type ContactRepository interface {
+	// AddContact добавляет контакт или заменяет его CustomName
+	AddContact(contact models.Contact) error
+	RemoveContact(ownerID, contactID uint) error
+	GetContact(ownerID, contactID uint) (models.Contact, error)
+	// ListContacts возвращает контакты владельца в порядке добавления
+	ListContacts(ownerID uint) ([]models.Contact, error)
+}
+
+// ScheduleRepository хранит отложенные сообщения. Отправленные сообщения остаются
+// в таблице для истории, но не видны через ListScheduled, GetScheduled и CancelScheduled.
+type ScheduleRepository interface {
+	CreateScheduled(message *models.ScheduledMessage) error
+	GetScheduled(senderID, id uint) (models.ScheduledMessage, error)
+	// ListScheduled возвращает ожидающие и неотправленные сообщения отправителя
+	// по возрастанию SendAt; chatID ограничивает выборку одним чатом
+	ListScheduled(senderID uint, chatID *gocql.UUID) ([]models.ScheduledMessage, error)
+	// UpdateScheduled меняет текст и время ожидающего сообщения; для остальных — ErrNotFound
+	UpdateScheduled(senderID, id uint, text string, sendAt time.Time) error
+	// CancelScheduled удаляет ожидающее или неотправленное сообщение
+	CancelScheduled(senderID, id uint) error
+	// ListDueScheduled возвращает до limit ожидающих сообщений с SendAt не позже before
+	// и сообщений, застрявших в sending с UpdatedAt раньше staleBefore
+	ListDueScheduled(before, staleBefore time.Time, limit int) ([]models.ScheduledMessage, error)
+	// ClaimScheduled переводит ожидающее или застрявшее (sending с UpdatedAt раньше staleBefore)
+	// сообщение в sending и возвращает его. При первом захвате сообщению присваивается messageID,
+	// повторные захваты сохраняют уже присвоенный. false — сообщение забрано другим
+	// диспетчером, изменено или отменено.
+	ClaimScheduled(id uint, messageID gocql.UUID, staleBefore time.Time) (models.ScheduledMessage, bool, error)
+	// FinishScheduled отмечает сообщение отправленным (failure пустая) или неотправленным
+	FinishScheduled(id uint, messageID *gocql.UUID, failure string) error
+}
+
+// ReportRepository хранит жалобы пользователей для модераторов
+type ReportRepository interface {
+	CreateReport(report *models.Report) error
+}
+
+// ChatRepository хранит список чатов пользователей и счётчики непрочитанных
+type ChatRepository interface {
+	GetChat(userID uint, chatID gocql.UUID) (Chat, error)
+	FindChatByCompanion(userID, companionID uint) (Chat, error)
+	CreateChat(chat Chat) error
+	// TouchChat поднимает чат в списке пользователя. last_updated никогда не откатывается назад.
+	TouchChat(userID uint, chatID gocql.UUID, lastUpdated time.Time, lastMsgTime *time.Time) error
+	// ListChats возвращает до pageSize чатов, отсортированных по last_updated, для которых match
+	// истинно (match = nil — все чаты), и состояние следующей страницы. Индекс читается, пока
+	// не наберётся pageSize чатов; состояние указывает на последний просмотренный чат, а не
+	// на конец прочитанной страницы индекса. nil — чатов больше нет.
+	ListChats(userID uint, pageState []byte, pageSize int, match func(Chat) bool) ([]Chat, []byte, error)
+	GetUnreadCount(userID uint, chatID gocql.UUID) (int, error)
+	AddUnreadCount(userID uint, chatID gocql.UUID, delta int) error
+	SetUnreadCount(userID uint, chatID gocql.UUID, count int) error
+	// AddUnreadExpiring запоминает непрочитанное сообщение, которое исчезнет в expiresAt
+	AddUnreadExpiring(userID uint, chatID gocql.UUID, messageID gocql.UUID, expiresAt time.Time) error
+	// RemoveUnreadExpiring забывает сообщения, прочитанные, удалённые или исчезнувшие
+	RemoveUnreadExpiring(userID uint, chatID gocql.UUID, messageIDs []gocql.UUID) error
+	// MarkCompanionDeleted отмечает в чате пользователя, что собеседник удалил аккаунт
+	MarkCompanionDeleted(userID uint, chatID gocql.UUID) error
+	// SetMuted отключает (muted = true) или включает уведомления чата; until = nil — навсегда
+	SetMuted(userID uint, chatID gocql.UUID, muted bool, until *time.Time) error
+	// SetPinOrder задаёт позицию чата среди закреплённых; 0 открепляет чат
+	SetPinOrder(userID uint, chatID gocql.UUID, order int) error
+	SetArchived(userID uint, chatID gocql.UUID, archived bool) error
+	// SetMessageTTL задаёт срок жизни новых сообщений чата в секундах; 0 отключает исчезновение
+	SetMessageTTL(userID uint, chatID gocql.UUID, ttl int) error
+	// SetDraft сохраняет черновик пользователя в чате; updatedAt = nil удаляет черновик
+	SetDraft(userID uint, chatID gocql.UUID, text string, replyTo *gocql.UUID, updatedAt *time.Time) error
+	// ListPinnedChats возвращает закреплённые чаты пользователя по возрастанию PinOrder
+	ListPinnedChats(userID uint) ([]Chat, error)
+	// DeleteChat удаляет чат из списка пользователя вместе со счётчиком непрочитанных
+	DeleteChat(userID uint, chatID gocql.UUID) error
+	// DropLegacyData удаляет keyspace user_N, оставшийся от схемы с keyspace на пользователя
+	DropLegacyData(userID uint) error
+}
+
+// MessageRepository хранит копии сообщений участников чатов
+type MessageRepository interface {
+	// AddMessage сохраняет копию; копия с ExpiresAt удаляется хранилищем в этот момент
+	AddMessage(message Message) error
+	GetMessage(ownerID uint, chatID, messageID gocql.UUID) (Message, error)
+	// ListMessages возвращает сообщения владельца в чате от новых к старым
+	ListMessages(ownerID uint, chatID gocql.UUID, opts ListOptions) ([]Message, error)
+	// MarkRead отмечает копии владельца прочитанными; удалённые и исчезнувшие копии пропускаются
+	MarkRead(ownerID uint, chatID gocql.UUID, keys []MessageKey) error
+	// DeleteMessages удаляет все копии сообщений владельца в чате вместе с закреплениями
+	DeleteMessages(ownerID uint, chatID gocql.UUID) error
+	// UpdateMessageText заменяет зашифрованный текст копии владельца и отмечает время редактирования;
+	// ErrNotFound — копии уже нет
+	UpdateMessageText(ownerID uint, chatID, messageID gocql.UUID, text string, editedAt time.Time) error
+	// DeleteMessage удаляет копию сообщения владельца и её закрепление
+	DeleteMessage(ownerID uint, chatID, messageID gocql.UUID) error
+	// PinMessage закрепляет сообщение; повторное закрепление обновляет PinnedBy и PinnedAt
+	PinMessage(pin PinnedMessage) error
+	UnpinMessage(ownerID uint, chatID, messageID gocql.UUID) error
+	// ListPinnedMessages возвращает закрепления владельца в чате, последние закреплённые первыми
+	ListPinnedMessages(ownerID uint, chatID gocql.UUID) ([]PinnedMessage, error)
+}
+
+// SearchRepository — полнотекстовый индекс сообщений, отдельный для каждого владельца
+type SearchRepository interface {
+	// IndexMessage добавляет сообщение в индекс или заменяет его текст
+	IndexMessage(entry SearchEntry) error
+	RemoveMessage(ownerID uint, messageID gocql.UUID) error
+	// RemoveChat удаляет из индекса владельца все сообщения чата
+	RemoveChat(ownerID uint, chatID gocql.UUID) error
+	// SearchMessages возвращает сообщения, содержащие все слова запроса, от более релевантных к менее
+	SearchMessages(opts MessageSearch) ([]SearchHit, error)
+}
+
+// Repositories объединяет все репозитории, доступные обработчику запроса
+type Repositories struct {
+	Users    UserRepository
+	Chats    ChatRepository
+	Messages MessageRepository
+	Search   SearchRepository
+	Reports  ReportRepository
+	Contacts ContactRepository
+	Schedule ScheduleRepository
+}
+
+// Open открывает репозитории на время обработки запроса. Возвращаемая функция
+// освобождает соединения. В тестах подменяется на UseMemory.
+var Open = OpenDefault
+
+type HandlerFunc func(repos *Repositories, c *gin.Context)
+
+func WithRepositories(handler HandlerFunc) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		repos, closeRepos, err := Open()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Не удалось подключиться к базе данных"})
+			return
+		}
+		defer closeRepos()
+
+		handler(repos, c)
+	}
+}
+
+// BucketFor возвращает номер бакета (год и месяц) партиции сообщений для момента t
+func BucketFor(t time.Time) int {
+	t = t.UTC()
+	return t.Year()*100 + int(t.Month())
+}
@@ -0,0 +1,680 @@
+package repository
+
+import (
+	"Bmessage_backend/database"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+// Общая схема в keyspace chat:
+//   user_chats              — состояние чата участника, партиция user_id
+//   user_chats_by_time      — индекс для сортировки списка чатов по last_updated
+//   user_chats_by_companion — поиск чата по собеседнику
+//   chat_unread             — counter-таблица непрочитанных сообщений
+//   messages                — копии сообщений, партиция (chat_id, bucket)
+//   message_buckets         — список бакетов чата
+//   message_ids             — поиск сообщения по message_id
+
+// Максимальное число попыток compare-and-set при обновлении last_updated
+const touchChatAttempts = 10
+
+// Размер условного батча при массовом обновлении сообщений одной партиции
+const markReadBatchSize = 100
+
+const ks = database.SharedKeyspace
+
+// OpenDefault открывает соединения с Postgres и Scylla и репозитории поверх них
+func OpenDefault() (*Repositories, func(), error) {
+	db, err := database.GetDb()
+	if err != nil {
+		return nil, nil, err
+	}
+	sqlDB, err := db.DB()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	session, err := database.GetSession()
+	if err != nil {
+		sqlDB.Close()
+		return nil, nil, err
+	}
+
+	repos := &Repositories{
+		Users:    NewPostgresUserRepository(db),
+		Chats:    NewScyllaChatRepository(session),
+		Messages: NewScyllaMessageRepository(session),
+		Search:   NewPostgresSearchRepository(db),
+		Reports:  NewPostgresReportRepository(db),
+		Contacts: NewPostgresContactRepository(db),
+		Schedule: NewPostgresScheduleRepository(db),
+	}
+	closeRepos := func() {
+		session.Close()
+		sqlDB.Close()
+	}
+	return repos, closeRepos, nil
+}
+
+type scyllaChatRepository struct {
+	session *gocql.Session
+}
+
+func NewScyllaChatRepository(session *gocql.Session) ChatRepository {
+	return &scyllaChatRepository{session: session}
+}
+
+func notFound(err error) error {
+	if err == gocql.ErrNotFound {
+		return ErrNotFound
+	}
+	return err
+}
+
+const chatColumns = `chat_id, companion_id, chat_type, secured, muted, muted_until, pin_order, archived, message_ttl, companion_deleted, last_msg_time, last_updated, private_key, draft_text, draft_reply_to, draft_updated_at, unread_expiring`
+
+func chatFields(chat *Chat) []interface{} {
+	return []interface{}{
+		&chat.ChatID, &chat.CompanionID, &chat.ChatType, &chat.Secured, &chat.Muted, &chat.MutedUntil, &chat.PinOrder, &chat.Archived,
+		&chat.MessageTTL, &chat.CompanionDeleted, &chat.LastMsgTime, &chat.LastUpdated, &chat.PrivateKey,
+		&chat.DraftText, &chat.DraftReplyToMessageID, &chat.DraftUpdatedAt, &chat.UnreadExpiring,
+	}
+}
+
+func (r *scyllaChatRepository) GetChat(userID uint, chatID gocql.UUID) (Chat, error) {
+	chat := Chat{UserID: userID}
+	query := `SELECT ` + chatColumns + ` FROM ` + ks + `.user_chats WHERE user_id = ? AND chat_id = ?`
+	err := r.session.Query(query, userID, chatID).Scan(chatFields(&chat)...)
+	return chat, notFound(err)
+}
+
+func (r *scyllaChatRepository) FindChatByCompanion(userID, companionID uint) (Chat, error) {
+	query := `SELECT chat_id FROM ` + ks + `.user_chats_by_companion WHERE user_id = ? AND companion_id = ?`
+
+	var chatID gocql.UUID
+	if err := r.session.Query(query, userID, companionID).Scan(&chatID); err != nil {
+		return Chat{}, notFound(err)
+	}
+	return r.GetChat(userID, chatID)
+}
+
+func (r *scyllaChatRepository) CreateChat(chat Chat) error {
+	insertChatQuery := `INSERT INTO ` + ks + `.user_chats (user_id, ` + chatColumns + `) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	if err := r.session.Query(insertChatQuery, chat.UserID, chat.ChatID, chat.CompanionID, chat.ChatType, chat.Secured, chat.Muted, chat.MutedUntil, chat.PinOrder, chat.Archived, chat.MessageTTL, chat.CompanionDeleted, chat.LastMsgTime, chat.LastUpdated, chat.PrivateKey, chat.DraftText, chat.DraftReplyToMessageID, chat.DraftUpdatedAt, chat.UnreadExpiring).Exec(); err != nil {
+		return err
+	}
+
+	insertCompanionQuery := `INSERT INTO ` + ks + `.user_chats_by_companion (user_id, companion_id, chat_id) VALUES (?, ?, ?)`
+	if err := r.session.Query(insertCompanionQuery, chat.UserID, chat.CompanionID, chat.ChatID).Exec(); err != nil {
+		return err
+	}
+
+	insertIndexQuery := `INSERT INTO ` + ks + `.user_chats_by_time (user_id, last_updated, chat_id) VALUES (?, ?, ?)`
+	return r.session.Query(insertIndexQuery, chat.UserID, chat.LastUpdated, chat.ChatID).Exec()
+}
+
+// TouchChat обновляет last_updated через compare-and-set и переносит строку индекса
+// user_chats_by_time, поэтому конкурентные вызовы не оставляют дублей в индексе.
+func (r *scyllaChatRepository) TouchChat(userID uint, chatID gocql.UUID, lastUpdated time.Time, lastMsgTime *time.Time) error {
+	chat, err := r.GetChat(userID, chatID)
+	if err != nil {
+		return err
+	}
+
+	var casQuery string
+	if lastMsgTime != nil {
+		casQuery = `UPDATE ` + ks + `.user_chats SET last_updated = ?, last_msg_time = ? WHERE user_id = ? AND chat_id = ? IF last_updated = ?`
+	} else {
+		casQuery = `UPDATE ` + ks + `.user_chats SET last_updated = ? WHERE user_id = ? AND chat_id = ? IF last_updated = ?`
+	}
+
+	previous := chat.LastUpdated
+	for attempt := 0; attempt < touchChatAttempts; attempt++ {
+		if previous != nil && !lastUpdated.After(*previous) {
+			return nil
+		}
+
+		var args []interface{}
+		if lastMsgTime != nil {
+			args = []interface{}{lastUpdated, *lastMsgTime, userID, chatID, previous}
+		} else {
+			args = []interface{}{lastUpdated, userID, chatID, previous}
+		}
+
+		var current *time.Time
+		applied, err := r.session.Query(casQuery, args...).ScanCAS(&current)
+		if err != nil {
+			return err
+		}
+		if !applied {
+			previous = current
+			continue
+		}
+
+		if previous != nil {
+			deleteIndexQuery := `DELETE FROM ` + ks + `.user_chats_by_time WHERE user_id = ? AND last_updated = ? AND chat_id = ?`
+			if err := r.session.Query(deleteIndexQuery, userID, *previous, chatID).Exec(); err != nil {
+				return err
+			}
+		}
+
+		insertIndexQuery := `INSERT INTO ` + ks + `.user_chats_by_time (user_id, last_updated, chat_id) VALUES (?, ?, ?)`
+		return r.session.Query(insertIndexQuery, userID, lastUpdated, chatID).Exec()
+	}
+
+	return fmt.Errorf("failed to update last_updated for chat %s: too much contention", chatID)
+}
+
+// chatCursor — позиция в индексе user_chats_by_time; закодированная, служит состоянием страницы ListChats
+type chatCursor struct {
+	lastUpdated time.Time
+	chatID      gocql.UUID
+}
+
+func (c chatCursor) encode() []byte {
+	state := binary.BigEndian.AppendUint64(nil, uint64(c.lastUpdated.UnixMilli()))
+	return append(state, c.chatID.Bytes()...)
+}
+
+func decodeChatCursor(state []byte) (chatCursor, error) {
+	if len(state) != 24 {
+		return chatCursor{}, fmt.Errorf("invalid chats page state")
+	}
+	chatID, err := gocql.UUIDFromBytes(state[8:])
+	if err != nil {
+		return chatCursor{}, err
+	}
+	lastUpdated := time.UnixMilli(int64(binary.BigEndian.Uint64(state[:8])))
+	return chatCursor{lastUpdated: lastUpdated, chatID: chatID}, nil
+}
+
+// chatIndexAfter читает до limit строк индекса после cursor (nil — с начала). Строки с тем же
+// last_updated идут по возрастанию chat_id, поэтому они дочитываются отдельным запросом.
+func (r *scyllaChatRepository) chatIndexAfter(userID uint, cursor *chatCursor, limit int) ([]chatCursor, error) {
+	var rows []chatCursor
+	scan := func(query string, values ...interface{}) error {
+		iter := r.session.Query(query, values...).Iter()
+		var row chatCursor
+		for iter.Scan(&row.lastUpdated, &row.chatID) {
+			rows = append(rows, row)
+		}
+		return iter.Close()
+	}
+
+	if cursor == nil {
+		query := `SELECT last_updated, chat_id FROM ` + ks + `.user_chats_by_time WHERE user_id = ? LIMIT ?`
+		return rows, scan(query, userID, limit)
+	}
+
+	sameTimeQuery := `SELECT last_updated, chat_id FROM ` + ks + `.user_chats_by_time WHERE user_id = ? AND last_updated = ? AND chat_id > ? LIMIT ?`
+	if err := scan(sameTimeQuery, userID, cursor.lastUpdated, cursor.chatID, limit); err != nil {
+		return nil, err
+	}
+	if len(rows) == limit {
+		return rows, nil
+	}
+	olderQuery := `SELECT last_updated, chat_id FROM ` + ks + `.user_chats_by_time WHERE user_id = ? AND last_updated < ? LIMIT ?`
+	return rows, scan(olderQuery, userID, cursor.lastUpdated, limit-len(rows))
+}
+
+// ListChats читает индекс страницами по pageSize строк, пока не наберёт pageSize подходящих
+// чатов, поэтому отфильтрованные строки не дают коротких и пустых страниц
+func (r *scyllaChatRepository) ListChats(userID uint, pageState []byte, pageSize int, match func(Chat) bool) ([]Chat, []byte, error) {
+	var cursor *chatCursor
+	if len(pageState) > 0 {
+		decoded, err := decodeChatCursor(pageState)
+		if err != nil {
+			return nil, nil, err
+		}
+		cursor = &decoded
+	}
+
+	var chats []Chat
+	for {
+		rows, err := r.chatIndexAfter(userID, cursor, pageSize)
+		if err != nil {
+			return nil, nil, err
+		}
+		for i := range rows {
+			cursor = &rows[i]
+			chat, err := r.GetChat(userID, rows[i].chatID)
+			if err == ErrNotFound {
+				continue
+			}
+			if err != nil {
+				return nil, nil, err
+			}
+			if match != nil && !match(chat) {
+				continue
+			}
+			chats = append(chats, chat)
+			if len(chats) == pageSize {
+				return chats, cursor.encode(), nil
+			}
+		}
+		if len(rows) < pageSize {
+			return chats, nil, nil
+		}
+	}
+}
+
+func (r *scyllaChatRepository) GetUnreadCount(userID uint, chatID gocql.UUID) (int, error) {
+	query := `SELECT new_msg_count FROM ` + ks + `.chat_unread WHERE user_id = ? AND chat_id = ?`
+
+	var count int64
+	if err := r.session.Query(query, userID, chatID).Scan(&count); err != nil {
+		if err == gocql.ErrNotFound {
+			return 0, nil
+		}
+		return 0, err
+	}
+	return int(max(count, 0)), nil
+}
+
+func (r *scyllaChatRepository) AddUnreadCount(userID uint, chatID gocql.UUID, delta int) error {
+	if delta == 0 {
+		return nil
+	}
+	query := `UPDATE ` + ks + `.chat_unread SET new_msg_count = new_msg_count + ? WHERE user_id = ? AND chat_id = ?`
+	return r.session.Query(query, int64(delta), userID, chatID).Exec()
+}
+
+func (r *scyllaChatRepository) SetUnreadCount(userID uint, chatID gocql.UUID, count int) error {
+	query := `SELECT new_msg_count FROM ` + ks + `.chat_unread WHERE user_id = ? AND chat_id = ?`
+
+	var current int64
+	if err := r.session.Query(query, userID, chatID).Scan(&current); err != nil && err != gocql.ErrNotFound {
+		return err
+	}
+	return r.AddUnreadCount(userID, chatID, count-int(current))
+}
+
+func (r *scyllaChatRepository) AddUnreadExpiring(userID uint, chatID gocql.UUID, messageID gocql.UUID, expiresAt time.Time) error {
+	return r.updateChat(`unread_expiring[?] = ?`, userID, chatID, messageID, expiresAt)
+}
+
+func (r *scyllaChatRepository) RemoveUnreadExpiring(userID uint, chatID gocql.UUID, messageIDs []gocql.UUID) error {
+	if len(messageIDs) == 0 {
+		return nil
+	}
+	return r.updateChat(`unread_expiring = unread_expiring - ?`, userID, chatID, messageIDs)
+}
+
+// updateChat обновляет только существующую строку user_chats: UPDATE без IF EXISTS
+// создал бы в Scylla пустой чат, если пользователь уже удалил его у себя.
+func (r *scyllaChatRepository) updateChat(set string, userID uint, chatID gocql.UUID, values ...interface{}) error {
+	query := `UPDATE ` + ks + `.user_chats SET ` + set + ` WHERE user_id = ? AND chat_id = ? IF EXISTS`
+	args := append(values, userID, chatID)
+	applied, err := r.session.Query(query, args...).MapScanCAS(map[string]interface{}{})
+	if err != nil {
+		return err
+	}
+	if !applied {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *scyllaChatRepository) MarkCompanionDeleted(userID uint, chatID gocql.UUID) error {
+	return r.updateChat(`companion_deleted = true`, userID, chatID)
+}
+
+func (r *scyllaChatRepository) SetMuted(userID uint, chatID gocql.UUID, muted bool, until *time.Time) error {
+	return r.updateChat(`muted = ?, muted_until = ?`, userID, chatID, muted, until)
+}
+
+func (r *scyllaChatRepository) SetPinOrder(userID uint, chatID gocql.UUID, order int) error {
+	return r.updateChat(`pin_order = ?`, userID, chatID, order)
+}
+
+func (r *scyllaChatRepository) SetArchived(userID uint, chatID gocql.UUID, archived bool) error {
+	return r.updateChat(`archived = ?`, userID, chatID, archived)
+}
+
+func (r *scyllaChatRepository) SetMessageTTL(userID uint, chatID gocql.UUID, ttl int) error {
+	return r.updateChat(`message_ttl = ?`, userID, chatID, ttl)
+}
+
+func (r *scyllaChatRepository) SetDraft(userID uint, chatID gocql.UUID, text string, replyTo *gocql.UUID, updatedAt *time.Time) error {
+	return r.updateChat(`draft_text = ?, draft_reply_to = ?, draft_updated_at = ?`, userID, chatID, text, replyTo, updatedAt)
+}
+
+// ListPinnedChats читает партицию пользователя целиком: закреплённых чатов немного,
+// а отдельная таблица потребовала бы согласованного обновления при каждом изменении порядка
+func (r *scyllaChatRepository) ListPinnedChats(userID uint) ([]Chat, error) {
+	query := `SELECT ` + chatColumns + ` FROM ` + ks + `.user_chats WHERE user_id = ?`
+	iter := r.session.Query(query, userID).Iter()
+
+	var pinned []Chat
+	chat := Chat{UserID: userID}
+	for iter.Scan(chatFields(&chat)...) {
+		if chat.PinOrder > 0 {
+			pinned = append(pinned, chat)
+		}
+		chat = Chat{UserID: userID}
+	}
+	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+	sort.Slice(pinned, func(i, j int) bool { return pinned[i].PinOrder < pinned[j].PinOrder })
+	return pinned, nil
+}
+
+func (r *scyllaChatRepository) DeleteChat(userID uint, chatID gocql.UUID) error {
+	chat, err := r.GetChat(userID, chatID)
+	if err != nil {
+		return err
+	}
+
+	if chat.LastUpdated != nil {
+		deleteIndexQuery := `DELETE FROM ` + ks + `.user_chats_by_time WHERE user_id = ? AND last_updated = ? AND chat_id = ?`
+		if err := r.session.Query(deleteIndexQuery, userID, *chat.LastUpdated, chatID).Exec(); err != nil {
+			return err
+		}
+	}
+
+	deleteCompanionQuery := `DELETE FROM ` + ks + `.user_chats_by_companion WHERE user_id = ? AND companion_id = ?`
+	if err := r.session.Query(deleteCompanionQuery, userID, chat.CompanionID).Exec(); err != nil {
+		return err
+	}
+
+	deleteUnreadQuery := `DELETE FROM ` + ks + `.chat_unread WHERE user_id = ? AND chat_id = ?`
+	if err := r.session.Query(deleteUnreadQuery, userID, chatID).Exec(); err != nil {
+		return err
+	}
+
+	deleteChatQuery := `DELETE FROM ` + ks + `.user_chats WHERE user_id = ? AND chat_id = ?`
+	return r.session.Query(deleteChatQuery, userID, chatID).Exec()
+}
+
+func (r *scyllaChatRepository) DropLegacyData(userID uint) error {
+	return r.session.Query(fmt.Sprintf(`DROP KEYSPACE IF EXISTS user_%d`, userID)).Exec()
+}
+
+type scyllaMessageRepository struct {
+	session *gocql.Session
+}
+
+func NewScyllaMessageRepository(session *gocql.Session) MessageRepository {
+	return &scyllaMessageRepository{session: session}
+}
+
+const messageColumns = `chat_id, message_id, sender_id, message_text, created_at, reply_to_message_id, forwarded_from_chat_id, forwarded_from_message_id, forwarded_from_sender_id, forwarded_at, read, edited_at, expires_at, view_once, kind, payload`
+
+func messageFields(message *Message) []interface{} {
+	return []interface{}{
+		&message.ChatID, &message.MessageID, &message.SenderID, &message.MessageText, &message.CreatedAt,
+		&message.ReplyToMessageID, &message.ForwardedFromChatID, &message.ForwardedFromMessageID,
+		&message.ForwardedFromSenderID, &message.ForwardedAt, &message.Read, &message.EditedAt,
+		&message.ExpiresAt, &message.ViewOnce, &message.Kind, &message.Payload,
+	}
+}
+
+// ttlUntil возвращает USING TTL для записи, которая должна исчезнуть в expiresAt: 0 — без срока,
+// отрицательное значение — срок уже истёк и писать не нужно. UPDATE без TTL оставил бы
+// изменённые колонки жить после истечения остальных, и строка появилась бы снова.
+func ttlUntil(expiresAt *time.Time) int {
+	if expiresAt == nil {
+		return 0
+	}
+	remaining := time.Until(*expiresAt)
+	if remaining <= 0 {
+		return -1
+	}
+	return int((remaining + time.Second - 1) / time.Second)
+}
+
+func (r *scyllaMessageRepository) AddMessage(message Message) error {
+	bucket := BucketFor(message.CreatedAt)
+	ttl := ttlUntil(message.ExpiresAt)
+	if ttl < 0 {
+		return nil
+	}
+
+	insertMessageQuery := `INSERT INTO ` + ks + `.messages (bucket, owner_id, ` + messageColumns + `) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?) USING TTL ?`
+	if err := r.session.Query(insertMessageQuery, bucket, message.OwnerID,
+		message.ChatID, message.MessageID, message.SenderID, message.MessageText, message.CreatedAt,
+		message.ReplyToMessageID, message.ForwardedFromChatID, message.ForwardedFromMessageID,
+		message.ForwardedFromSenderID, message.ForwardedAt, message.Read, message.EditedAt,
+		message.ExpiresAt, message.ViewOnce, message.Kind, message.Payload, ttl,
+	).Exec(); err != nil {
+		return err
+	}
+
+	insertBucketQuery := `INSERT INTO ` + ks + `.message_buckets (chat_id, bucket) VALUES (?, ?)`
+	if err := r.session.Query(insertBucketQuery, message.ChatID, bucket).Exec(); err != nil {
+		return err
+	}
+
+	insertLookupQuery := `INSERT INTO ` + ks + `.message_ids (owner_id, chat_id, message_id, created_at) VALUES (?, ?, ?, ?) USING TTL ?`
+	return r.session.Query(insertLookupQuery, message.OwnerID, message.ChatID, message.MessageID, message.CreatedAt, ttl).Exec()
+}
+
+func (r *scyllaMessageRepository) GetMessage(ownerID uint, chatID, messageID gocql.UUID) (Message, error) {
+	lookupQuery := `SELECT created_at FROM ` + ks + `.message_ids WHERE owner_id = ? AND chat_id = ? AND message_id = ?`
+
+	var createdAt time.Time
+	if err := r.session.Query(lookupQuery, ownerID, chatID, messageID).Scan(&createdAt); err != nil {
+		return Message{}, notFound(err)
+	}
+
+	message := Message{OwnerID: ownerID}
+	query := `SELECT ` + messageColumns + ` FROM ` + ks + `.messages WHERE chat_id = ? AND bucket = ? AND owner_id = ? AND created_at = ? AND message_id = ?`
+	err := r.session.Query(query, chatID, BucketFor(createdAt), ownerID, createdAt, messageID).Scan(messageFields(&message)...)
+	return message, notFound(err)
+}
+
+func (r *scyllaMessageRepository) buckets(chatID gocql.UUID) ([]int, error) {
+	iter := r.session.Query(`SELECT bucket FROM `+ks+`.message_buckets WHERE chat_id = ?`, chatID).Iter()
+
+	var buckets []int
+	var bucket int
+	for iter.Scan(&bucket) {
+		buckets = append(buckets, bucket)
+	}
+	return buckets, iter.Close()
+}
+
+func (r *scyllaMessageRepository) ListMessages(ownerID uint, chatID gocql.UUID, opts ListOptions) ([]Message, error) {
+	buckets, err := r.buckets(chatID)
+	if err != nil {
+		return nil, err
+	}
+
+	query := `SELECT ` + messageColumns + ` FROM ` + ks + `.messages WHERE chat_id = ? AND bucket = ? AND owner_id = ?`
+	args := []interface{}{chatID, 0, ownerID}
+	if opts.Before != nil {
+		query += ` AND created_at <= ?`
+		args = append(args, *opts.Before)
+	}
+	if opts.After != nil {
+		query += ` AND created_at > ?`
+		args = append(args, *opts.After)
+	}
+
+	var messages []Message
+	for _, bucket := range buckets {
+		if opts.Before != nil && bucket > BucketFor(*opts.Before) {
+			continue
+		}
+		if opts.After != nil && bucket < BucketFor(*opts.After) {
+			break
+		}
+
+		args[1] = bucket
+		iter := r.session.Query(query, args...).Iter()
+		message := Message{OwnerID: ownerID}
+		for iter.Scan(messageFields(&message)...) {
+			messages = append(messages, message)
+			if opts.Limit > 0 && len(messages) >= opts.Limit {
+				break
+			}
+		}
+		if err := iter.Close(); err != nil {
+			return nil, err
+		}
+		if opts.Limit > 0 && len(messages) >= opts.Limit {
+			break
+		}
+	}
+	return messages, nil
+}
+
+// MarkRead пишет только в существующие копии: UPDATE без IF EXISTS воскресил бы
+// удалённую или исчезнувшую копию как строку из одних ключей с read = true
+func (r *scyllaMessageRepository) MarkRead(ownerID uint, chatID gocql.UUID, keys []MessageKey) error {
+	query := `UPDATE ` + ks + `.messages USING TTL ? SET read = true WHERE chat_id = ? AND bucket = ? AND owner_id = ? AND created_at = ? AND message_id = ? IF EXISTS`
+
+	// Строки одного бакета лежат в одной партиции, поэтому условные батчи допустимы
+	byBucket := make(map[int][]MessageKey)
+	for _, key := range keys {
+		bucket := BucketFor(key.CreatedAt)
+		byBucket[bucket] = append(byBucket[bucket], key)
+	}
+
+	for bucket, bucketKeys := range byBucket {
+		for start := 0; start < len(bucketKeys); start += markReadBatchSize {
+			end := min(start+markReadBatchSize, len(bucketKeys))
+			var chunk []MessageKey
+			batch := r.session.NewBatch(gocql.UnloggedBatch)
+			for _, key := range bucketKeys[start:end] {
+				if ttl := ttlUntil(key.ExpiresAt); ttl >= 0 {
+					batch.Query(query, ttl, chatID, bucket, ownerID, key.CreatedAt, key.MessageID)
+					chunk = append(chunk, key)
+				}
+			}
+			if batch.Size() == 0 {
+				continue
+			}
+			applied, iter, err := r.session.MapExecuteBatchCAS(batch, map[string]interface{}{})
+			if err != nil {
+				return err
+			}
+			if err := iter.Close(); err != nil {
+				return err
+			}
+			if applied {
+				continue
+			}
+
+			// Условный батч не применяется целиком, если хотя бы одной копии уже нет:
+			// тогда отмечаем копии по одной и пропускаем отсутствующие
+			for _, key := range chunk {
+				ttl := ttlUntil(key.ExpiresAt)
+				if ttl < 0 {
+					continue
+				}
+				if _, err := r.session.Query(query, ttl, chatID, bucket, ownerID, key.CreatedAt, key.MessageID).MapScanCAS(map[string]interface{}{}); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	return nil
+}
+
+func (r *scyllaMessageRepository) DeleteMessages(ownerID uint, chatID gocql.UUID) error {
+	buckets, err := r.buckets(chatID)
+	if err != nil {
+		return err
+	}
+
+	// Копии владельца — префикс кластерного ключа, поэтому в каждом бакете достаточно одного range-удаления
+	deleteMessagesQuery := `DELETE FROM ` + ks + `.messages WHERE chat_id = ? AND bucket = ? AND owner_id = ?`
+	for _, bucket := range buckets {
+		if err := r.session.Query(deleteMessagesQuery, chatID, bucket, ownerID).Exec(); err != nil {
+			return err
+		}
+	}
+
+	deletePinsQuery := `DELETE FROM ` + ks + `.pinned_messages WHERE owner_id = ? AND chat_id = ?`
+	if err := r.session.Query(deletePinsQuery, ownerID, chatID).Exec(); err != nil {
+		return err
+	}
+
+	deleteLookupQuery := `DELETE FROM ` + ks + `.message_ids WHERE owner_id = ? AND chat_id = ?`
+	return r.session.Query(deleteLookupQuery, ownerID, chatID).Exec()
+}
+
+func (r *scyllaMessageRepository) UpdateMessageText(ownerID uint, chatID, messageID gocql.UUID, text string, editedAt time.Time) error {
+	message, err := r.GetMessage(ownerID, chatID, messageID)
+	if err != nil {
+		return err
+	}
+
+	ttl := ttlUntil(message.ExpiresAt)
+	if ttl < 0 {
+		return ErrNotFound
+	}
+
+	// IF EXISTS: копия могла быть удалена между чтением и записью, UPDATE создал бы её заново
+	query := `UPDATE ` + ks + `.messages USING TTL ? SET message_text = ?, edited_at = ? WHERE chat_id = ? AND bucket = ? AND owner_id = ? AND created_at = ? AND message_id = ? IF EXISTS`
+	applied, err := r.session.Query(query, ttl, text, editedAt, chatID, BucketFor(message.CreatedAt), ownerID, message.CreatedAt, messageID).MapScanCAS(map[string]interface{}{})
+	if err != nil {
+		return err
+	}
+	if !applied {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func (r *scyllaMessageRepository) DeleteMessage(ownerID uint, chatID, messageID gocql.UUID) error {
+	message, err := r.GetMessage(ownerID, chatID, messageID)
+	if err != nil {
+		return err
+	}
+
+	deleteMessageQuery := `DELETE FROM ` + ks + `.messages WHERE chat_id = ? AND bucket = ? AND owner_id = ? AND created_at = ? AND message_id = ?`
+	if err := r.session.Query(deleteMessageQuery, chatID, BucketFor(message.CreatedAt), ownerID, message.CreatedAt, messageID).Exec(); err != nil {
+		return err
+	}
+
+	if err := r.UnpinMessage(ownerID, chatID, messageID); err != nil {
+		return err
+	}
+
+	deleteLookupQuery := `DELETE FROM ` + ks + `.message_ids WHERE owner_id = ? AND chat_id = ? AND message_id = ?`
+	return r.session.Query(deleteLookupQuery, ownerID, chatID, messageID).Exec()
+}
+
+func (r *scyllaMessageRepository) PinMessage(pin PinnedMessage) error {
+	ttl := ttlUntil(pin.ExpiresAt)
+	if ttl < 0 {
+		return nil
+	}
+	query := `INSERT INTO ` + ks + `.pinned_messages (owner_id, chat_id, message_id, pinned_by, pinned_at, expires_at) VALUES (?, ?, ?, ?, ?, ?) USING TTL ?`
+	return r.session.Query(query, pin.OwnerID, pin.ChatID, pin.MessageID, pin.PinnedBy, pin.PinnedAt, pin.ExpiresAt, ttl).Exec()
+}
+
+func (r *scyllaMessageRepository) UnpinMessage(ownerID uint, chatID, messageID gocql.UUID) error {
+	query := `DELETE FROM ` + ks + `.pinned_messages WHERE owner_id = ? AND chat_id = ? AND message_id = ?`
+	return r.session.Query(query, ownerID, chatID, messageID).Exec()
+}
+
+// ListPinnedMessages сортирует закрепления в памяти: их немного, а кластеризация
+// по message_id позволяет закреплять и откреплять сообщение без чтения строки
+func (r *scyllaMessageRepository) ListPinnedMessages(ownerID uint, chatID gocql.UUID) ([]PinnedMessage, error) {
+	query := `SELECT message_id, pinned_by, pinned_at, expires_at FROM ` + ks + `.pinned_messages WHERE owner_id = ? AND chat_id = ?`
+	iter := r.session.Query(query, ownerID, chatID).Iter()
+
+	var pins []PinnedMessage
+	pin := PinnedMessage{OwnerID: ownerID, ChatID: chatID}
+	for iter.Scan(&pin.MessageID, &pin.PinnedBy, &pin.PinnedAt, &pin.ExpiresAt) {
+		pins = append(pins, pin)
+		pin = PinnedMessage{OwnerID: ownerID, ChatID: chatID}
+	}
+	if err := iter.Close(); err != nil {
+		return nil, err
+	}
+	sortPins(pins)
+	return pins, nil
+}
+
+func sortPins(pins []PinnedMessage) {
+	sort.SliceStable(pins, func(i, j int) bool { return pins[i].PinnedAt.After(pins[j].PinnedAt) })
+}
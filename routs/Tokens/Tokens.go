@@ -1,7 +1,8 @@
 package tokens
 
 import (
-	"context"
+	"Bmessage_backend/database"
+	"Bmessage_backend/handshake"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
@@ -11,13 +12,13 @@ import (
 	"strings"
 
 	"github.com/gin-gonic/gin"
-	"github.com/go-redis/redis/v8"
 	"github.com/google/uuid"
 )
 
 func TokensRouter(router *gin.Engine) {
 	roustBase := "token/"
 	router.GET(roustBase+"generateToken", generateToken)
+	router.POST(roustBase+"key-exchange", keyExchange)
 	// router.GET(roustBase+"get-public-key", getPublicKey)
 }
 
@@ -56,12 +57,10 @@ func generateToken(c *gin.Context) {
 	publicKeyBase64 := stripPEMHeaders(string(publicKeyPEM))
 	privateKeyBase64 := stripPEMHeaders(string(privateKeyPEM))
 
-	client := redis.NewClient(&redis.Options{
-		Addr: "localhost:6379",
-		DB:   0,
-	})
-	ctx := context.Background()
-	if err := client.Set(ctx, uuid+"_private_key", privateKeyBase64, 0).Err(); err != nil {
+	client := database.GetRedis()
+	defer client.Close()
+
+	if err := client.Set(c.Request.Context(), uuid+"_private_key", privateKeyBase64, 0).Err(); err != nil {
 		log.Println("Error setting private key in Redis:", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
 		return
@@ -73,6 +72,60 @@ func generateToken(c *gin.Context) {
 	})
 }
 
+// KeyExchangeStruct — публичный ключ клиента для обмена X25519
+// @Description Публичный ключ X25519 клиента, 32 байта в base64
+type KeyExchangeStruct struct {
+	ClientPublicKey string `json:"client_public_key" binding:"required"`
+}
+
+// KeyExchangeResponse — результат обмена ключами
+// @Description uuid обмена и публичный ключ X25519 сервера. Поля запросов шифруются AES-256-GCM
+// @Description ключом клиент→сервер, выведенным HKDF-SHA256 из общего секрета (соль — uuid,
+// @Description info — "bmessage handshake v1"); формат поля — base64(nonce || ciphertext), связанные данные — uuid.
+type KeyExchangeResponse struct {
+	Uuid            string `json:"uuid"`
+	ServerPublicKey string `json:"server_public_key"`
+	Scheme          string `json:"scheme"`
+	ExpiresIn       int    `json:"expires_in"`
+}
+
+// @Tags Tokens
+// keyExchange godoc
+// @Summary Обмен ключами X25519
+// @Description Альтернатива generateToken без RSA: стороны выводят общие ключи AES-256-GCM.
+// @Description Полученный uuid передаётся в запросах так же, как uuid из generateToken; ключи живут 10 минут.
+// @Accept json
+// @Produce json
+// @Param data body KeyExchangeStruct true "Публичный ключ клиента"
+// @Success 200 {object} KeyExchangeResponse
+// @Failure 400 {object} map[string]interface{} "Неверный публичный ключ"
+// @Router /token/key-exchange [post]
+func keyExchange(c *gin.Context) {
+	var exchangeData KeyExchangeStruct
+	if err := c.ShouldBindJSON(&exchangeData); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверные входные данные"})
+		return
+	}
+
+	exchange, err := handshake.KeyExchange(c.Request.Context(), exchangeData.ClientPublicKey)
+	if err == handshake.ErrInvalidKey {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный публичный ключ"})
+		return
+	}
+	if err != nil {
+		log.Println("Error exchanging keys:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Internal server error"})
+		return
+	}
+
+	c.JSON(http.StatusOK, KeyExchangeResponse{
+		Uuid:            exchange.Uuid,
+		ServerPublicKey: exchange.ServerPublicKey,
+		Scheme:          handshake.SchemeX25519,
+		ExpiresIn:       int(exchange.ExpiresIn.Seconds()),
+	})
+}
+
 // // @Tags Tokens
 // // getPublicKey godoc
 // // @Summary Получение токена и публичного ключа по UUID
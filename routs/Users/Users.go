@@ -1,25 +1,55 @@
 package users
 
 import (
+	"Bmessage_backend/audit"
 	database "Bmessage_backend/database"
+	"Bmessage_backend/handshake"
 	helpers "Bmessage_backend/helpers"
+	"Bmessage_backend/middleware"
 	models "Bmessage_backend/models"
-	"Bmessage_backend/routs/chats"
+	"Bmessage_backend/repository"
 	tokens "Bmessage_backend/routs/tokens"
+	"Bmessage_backend/sessions"
+	"log"
 	"net/http"
 
 	"github.com/gin-gonic/gin"
-	"github.com/go-redis/redis"
+	"github.com/go-redis/redis/v8"
 	"golang.org/x/crypto/bcrypt"
-	"gorm.io/gorm"
 )
 
 func UsersRouter(router *gin.Engine) {
 	roustBase := "user/"
-	router.POST(roustBase+"log-in-with-credentials", database.WithDatabase(loginWithCredentials))
-	router.POST(roustBase+"registration", database.WithDatabase(registerUser))
-	router.POST(roustBase+"chek-token", database.WithDatabase(chekTokenUser))
-	router.POST(roustBase+"check-uniqueness-registration-data", database.WithDatabase(check_uniqueness_registration_data))
+	router.POST(roustBase+"log-in-with-credentials", repository.WithRepositories(loginWithCredentials))
+	router.POST(roustBase+"registration", repository.WithRepositories(registerUser))
+	router.POST(roustBase+"chek-token", repository.WithRepositories(chekTokenUser))
+	router.POST(roustBase+"check-uniqueness-registration-data", repository.WithRepositories(check_uniqueness_registration_data))
+
+	router.GET(roustBase+"profile", middleware.RequireUser(), repository.WithRepositories(getOwnProfile))
+	router.GET(roustBase+"profile/:user_id", middleware.RequireUser(), repository.WithRepositories(getProfile))
+	router.POST(roustBase+"update-profile", middleware.RequireUser(), repository.WithRepositories(updateProfile))
+	router.POST(roustBase+"upload-avatar", middleware.RequireUser(), repository.WithRepositories(uploadAvatar))
+	router.POST(roustBase+"change-password", middleware.RequireUser(), repository.WithRepositories(changePassword))
+	router.POST(roustBase+"request-password-reset", repository.WithRepositories(requestPasswordReset))
+	router.POST(roustBase+"reset-password", repository.WithRepositories(resetPassword))
+	router.POST(roustBase+"delete-account", middleware.RequireUser(), repository.WithRepositories(deleteAccount))
+	router.POST(roustBase+"cancel-account-deletion", middleware.RequireUser(), repository.WithRepositories(cancelAccountDeletion))
+	router.GET(roustBase+"export-data", middleware.RequireUser(), repository.WithRepositories(exportData))
+	router.POST(roustBase+"log-in-2fa", repository.WithRepositories(loginSecondFactor))
+	router.POST(roustBase+"2fa/enroll", middleware.RequireUser(), repository.WithRepositories(enrollTwoFactor))
+	router.POST(roustBase+"2fa/verify", middleware.RequireUser(), repository.WithRepositories(verifyTwoFactor))
+	router.POST(roustBase+"2fa/recovery-codes", middleware.RequireUser(), repository.WithRepositories(regenerateRecoveryCodes))
+	router.POST(roustBase+"2fa/disable", middleware.RequireUser(), repository.WithRepositories(disableTwoFactor))
+	router.POST(roustBase+"block", middleware.RequireUser(), repository.WithRepositories(blockUser))
+	router.POST(roustBase+"unblock", middleware.RequireUser(), repository.WithRepositories(unblockUser))
+	router.GET(roustBase+"blocked", middleware.RequireUser(), repository.WithRepositories(getBlockedUsers))
+	router.POST(roustBase+"report", middleware.RequireUser(), repository.WithRepositories(reportUser))
+	router.GET(roustBase+"contacts", middleware.RequireUser(), repository.WithRepositories(getContacts))
+	router.POST(roustBase+"contacts/add", middleware.RequireUser(), repository.WithRepositories(addContact))
+	router.POST(roustBase+"contacts/remove", middleware.RequireUser(), repository.WithRepositories(removeContact))
+	router.POST(roustBase+"contacts/import", middleware.RequireUser(), repository.WithRepositories(importContacts))
+	router.POST(roustBase+"privacy", middleware.RequireUser(), repository.WithRepositories(updatePrivacy))
+	router.Static(avatarURLPrefix, avatarDir())
 }
 
 // ResponseMessage defines a standard response message structure.
@@ -47,13 +77,18 @@ type UserLogin struct {
 // @Tags Users
 // loginWithCredentials godoc
 // @Summary Аутентификация пользователя по логину и паролю
+// @Description Неизвестный логин и неверный пароль дают одинаковый ответ 401. После 5 неудач подряд для логина или 20 для IP вход временно блокируется (429, заголовок Retry-After), срок блокировки растёт вдвое с каждой следующей неудачей.
+// @Description Если включена двухфакторная аутентификация, вместо токена возвращается two_factor_required и challenge для user/log-in-2fa.
+// @Description Если пользователь запросил удаление аккаунта, в ответе есть deletion_scheduled_at.
 // @Accept json
 // @Produce  json
 // @Param data body UserLogin true "Данные пользователя"
 // @Success 200 {object} map[string]interface{} "successful response"
 // @Failure 400 {object} map[string]interface{} "bad request"
+// @Failure 401 {object} map[string]interface{} "invalid credentials"
+// @Failure 429 {object} map[string]interface{} "too many attempts"
 // @Router /user/log-in-with-credentials [post]
-func loginWithCredentials(db *gorm.DB, c *gin.Context) {
+func loginWithCredentials(repos *repository.Repositories, c *gin.Context) {
 	var userData UserLogin
 
 	if err := c.BindJSON(&userData); err != nil {
@@ -61,58 +96,100 @@ func loginWithCredentials(db *gorm.DB, c *gin.Context) {
 		return
 	}
 
-	client := redis.NewClient(&redis.Options{
-		Addr: "localhost:6379",
-		DB:   0,
-	})
-
-	privateKeyPEM, err := client.Get(userData.Uuid + "_private_key").Result()
+	ctx := c.Request.Context()
+	session, err := openHandshake(c, userData.Uuid, &userData.Login, &userData.Password)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": ""})
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Ошибка дешифрования данных"})
 		return
 	}
 
-	dLogin, err := helpers.DecryptWithPrivateKey(userData.Login, privateKeyPEM)
+	client := database.GetRedis()
+	defer client.Close()
+
+	ip := c.ClientIP()
+	lockedFor, err := loginLockedFor(ctx, client, userData.Login, ip)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		log.Println("Failed to check login lockout:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Внутренняя ошибка сервера"})
 		return
-
 	}
-
-	dPassword, err := helpers.DecryptWithPrivateKey(userData.Password, privateKeyPEM)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка дешифрования пароля"})
+	if lockedFor > 0 {
+		audit.Record(ctx, audit.Event{Type: audit.LoginLocked, Login: userData.Login, IP: ip})
+		respondTooManyAttempts(c, lockedFor)
 		return
 	}
 
-	var user models.User
-
-	if err := db.Where("login = ?", dLogin).First(&user).Error; err != nil {
-		c.JSON(http.StatusNotFound, gin.H{"error": "Пользователь не найден"})
+	user, err := repos.Users.GetUserByLogin(userData.Login)
+	reason := ""
+	switch {
+	case err == repository.ErrNotFound:
+		bcrypt.CompareHashAndPassword(dummyPasswordHash(), []byte(userData.Password))
+		reason = "unknown_login"
+	case err != nil:
+		log.Println("Failed to fetch user:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Внутренняя ошибка сервера"})
+		return
+	case bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(userData.Password)) != nil:
+		reason = "wrong_password"
+	}
+
+	if reason != "" {
+		audit.Record(ctx, audit.Event{Type: audit.LoginFailed, UserID: user.ID, Login: userData.Login, IP: ip, Reason: reason})
+
+		lockout, err := registerLoginFailure(ctx, client, userData.Login, ip)
+		if err != nil {
+			log.Println("Failed to register login failure:", err)
+		}
+		if lockout > 0 {
+			respondTooManyAttempts(c, lockout)
+			return
+		}
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Неверный логин или пароль"})
 		return
 	}
-	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(dPassword)); err != nil {
-		c.JSON(http.StatusUnauthorized, gin.H{"error": "Неверный пароль"})
+
+	if user.TOTPEnabled {
+		// Счётчик неудач не сбрасывается до второго шага, иначе верный пароль
+		// позволял бы перебирать коды без ограничений
+		challenge, err := createLoginChallenge(ctx, client, loginChallenge{UserID: user.ID, Login: userData.Login})
+		if err != nil {
+			log.Println("Failed to create login challenge:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Внутренняя ошибка сервера"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"two_factor_required": true, "challenge": challenge, "challenge_ttl": int(loginChallengeTTL.Seconds())})
 		return
 	}
 
-	TokenUserData := helpers.UserData{
-		User_id: user.ID,
+	completeLogin(c, client, session, user, userData.Login, userData.PKey)
+}
+
+// completeLogin сбрасывает счётчик неудачных попыток и выдаёт токен сессии,
+// защищённый по схеме обмена ключами
+func completeLogin(c *gin.Context, client *redis.Client, session *handshake.Session, user models.User, login, pKey string) {
+	ctx := c.Request.Context()
+	if err := resetLoginFailures(ctx, client, login); err != nil {
+		log.Println("Failed to reset login failures:", err)
 	}
 
-	token, err := helpers.EncryptAES(TokenUserData)
+	token, err := sessions.Issue(ctx, user.ID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Внутренняя ошибка сервера"})
 		return
 	}
 
-	сToken, err := helpers.EncryptWithPublicKey(token, userData.PKey)
+	сToken, err := session.Seal(token, pKey)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Внутренняя ошибка сервера"})
 		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"message": "Пользователь успешно аутентифицирован", "token": сToken})
+	response := gin.H{"message": "Пользователь успешно аутентифицирован", "token": сToken}
+	if user.DeletionRequestedAt != nil {
+		// Клиент предлагает отменить удаление через user/cancel-account-deletion
+		response["deletion_scheduled_at"] = user.DeletionRequestedAt.Add(deletionGrace())
+	}
+	c.JSON(http.StatusOK, response)
 }
 
 // UserRegistration represents the JSON structure for a user registration request
@@ -138,7 +215,7 @@ type UserRegistration struct {
 // @Failure 400 {object} ErrorResponse "Invalid request data"
 // @Failure 500 {object} ErrorResponse "Failed to connect to database"
 // @Router /user/registration [post]
-func registerUser(db *gorm.DB, c *gin.Context) {
+func registerUser(repos *repository.Repositories, c *gin.Context) {
 	var userData UserRegistration
 
 	if err := c.BindJSON(&userData); err != nil {
@@ -146,41 +223,30 @@ func registerUser(db *gorm.DB, c *gin.Context) {
 		return
 	}
 
-	client := redis.NewClient(&redis.Options{
-		Addr: "localhost:6379",
-		DB:   0,
-	})
-
-	privateKeyPEM, err := client.Get(userData.Uuid + "_private_key").Result()
+	session, err := handshake.Start(c.Request.Context(), c.Request, userData.Uuid)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Внутренняя ошибка сервера"})
 		return
 	}
 
-	dName, err := helpers.DecryptWithPrivateKey(userData.Name, privateKeyPEM)
-	if err != nil {
+	dName, dSoName, dNik, dLogin, dPassword := userData.Name, userData.SoName, userData.Nik, userData.Login, userData.Password
+	if err := session.Decrypt(&dName); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка дешифрования имени"})
 		return
 	}
-	dSoName, err := helpers.DecryptWithPrivateKey(userData.SoName, privateKeyPEM)
-	if err != nil {
+	if err := session.Decrypt(&dSoName); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка дешифрования фамилии"})
 		return
 	}
-
-	dNik, err := helpers.DecryptWithPrivateKey(userData.Nik, privateKeyPEM)
-	if err != nil {
+	if err := session.Decrypt(&dNik); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка дешифрования ника"})
 		return
 	}
-
-	dLogin, err := helpers.DecryptWithPrivateKey(userData.Login, privateKeyPEM)
-	if err != nil {
+	if err := session.Decrypt(&dLogin); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка дешифрования логина"})
 		return
 	}
-	dPassword, err := helpers.DecryptWithPrivateKey(userData.Password, privateKeyPEM)
-	if err != nil {
+	if err := session.Decrypt(&dPassword); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка дешифрования пароля"})
 		return
 	}
@@ -191,13 +257,19 @@ func registerUser(db *gorm.DB, c *gin.Context) {
 		return
 	}
 
-	var count int64
-	if err := db.Model(&models.User{}).Where("nik = ? OR login = ?", dNik, dLogin).Count(&count).Error; err != nil {
+	nikExists, err := repos.Users.NikExists(dNik)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка проверки уникальности пользователя"})
+		return
+	}
+
+	loginExists, err := repos.Users.LoginExists(dLogin)
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка проверки уникальности пользователя"})
 		return
 	}
 
-	if count > 0 {
+	if nikExists || loginExists {
 		c.JSON(http.StatusConflict, gin.H{"error": "Пользователь с таким ником или логином уже существует"})
 		return
 	}
@@ -211,28 +283,27 @@ func registerUser(db *gorm.DB, c *gin.Context) {
 		PrivateKey: tokens.GeneratePrivateKey(),
 	}
 
-	if err := db.Create(newUser).Error; err != nil {
+	if err := repos.Users.CreateUser(newUser); err != nil {
+		if err == repository.ErrDuplicate {
+			c.JSON(http.StatusConflict, gin.H{"error": "Пользователь с таким ником или логином уже существует"})
+			return
+		}
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка регистрации пользователя"})
 		return
 	}
 
-	TokenUserData := helpers.UserData{
-		User_id: newUser.ID,
-	}
-
-	token, err := helpers.EncryptAES(TokenUserData)
+	token, err := sessions.Issue(c.Request.Context(), newUser.ID)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Внутренняя ошибка сервера"})
 		return
 	}
 
-	сToken, err := helpers.EncryptWithPublicKey(token, userData.PKey)
+	сToken, err := session.Seal(token, userData.PKey)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Внутренняя ошибка сервера"})
 		return
 	}
 
-	chats.RegisterUserTemplate(newUser.ID)
 	c.JSON(http.StatusOK, gin.H{"message": "Пользователь успешно зарегистрирован", "token": сToken})
 }
 
@@ -255,7 +326,7 @@ type UserCT struct {
 // @Failure 400 {object} ErrorResponse "Invalid request data"
 // @Failure 500 {object} ErrorResponse "Failed to connect to database"
 // @Router /user/chek-token [post]
-func chekTokenUser(db *gorm.DB, c *gin.Context) {
+func chekTokenUser(repos *repository.Repositories, c *gin.Context) {
 	var userData UserCT
 
 	if err := c.BindJSON(&userData); err != nil {
@@ -263,29 +334,22 @@ func chekTokenUser(db *gorm.DB, c *gin.Context) {
 		return
 	}
 
-	client := redis.NewClient(&redis.Options{
-		Addr: "localhost:6379",
-		DB:   0,
-	})
-
-	privateKeyPEM, err := client.Get(userData.Uuid + "_private_key").Result()
-	if err != nil {
+	dToken := userData.Token
+	if _, err := openHandshake(c, userData.Uuid, &dToken); err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Сессия была завершена"})
 		return
 	}
 
-	dToken, err := helpers.DecryptWithPrivateKey(userData.Token, privateKeyPEM)
+	userDataToToken, err := helpers.DecryptAES(dToken)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Сессия была завершена"})
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
 		return
 
 	}
 
-	userDataToToken, err := helpers.DecryptAES(dToken)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	if valid, err := sessions.Valid(c.Request.Context(), userDataToToken); err != nil || !valid {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Сессия была завершена"})
 		return
-
 	}
 
 	c.JSON(http.StatusOK, gin.H{"message": "Пользователь успешно зарегистрирован", "token": userDataToToken})
@@ -308,7 +372,7 @@ type UserCUD struct {
 // @Param data body UserCUD true "Данные о пользователе"
 // @Success 200 {object} ResponseMessage "User registered successfully"
 // @Router /user/check-uniqueness-registration-data [post]
-func check_uniqueness_registration_data(db *gorm.DB, c *gin.Context) {
+func check_uniqueness_registration_data(repos *repository.Repositories, c *gin.Context) {
 
 	var userData UserCUD
 
@@ -317,42 +381,23 @@ func check_uniqueness_registration_data(db *gorm.DB, c *gin.Context) {
 		return
 	}
 
-	client := redis.NewClient(&redis.Options{
-		Addr: "localhost:6379",
-		DB:   0,
-	})
-
-	privateKeyPEM, err := client.Get(userData.Uuid + "_private_key").Result()
-	if err != nil {
+	dNik, dLogin := userData.Nik, userData.Login
+	if _, err := openHandshake(c, userData.Uuid, &dNik, &dLogin); err != nil {
 		c.JSON(http.StatusOK, gin.H{"uniqueNik": false, "uniqueLogin": false})
 		return
 	}
 
-	dNik, err := helpers.DecryptWithPrivateKey(userData.Nik, privateKeyPEM)
+	nikExists, err := repos.Users.NikExists(dNik)
 	if err != nil {
 		c.JSON(http.StatusOK, gin.H{"uniqueNik": false, "uniqueLogin": false})
 		return
-
 	}
 
-	dLogin, err := helpers.DecryptWithPrivateKey(userData.Login, privateKeyPEM)
+	loginExists, err := repos.Users.LoginExists(dLogin)
 	if err != nil {
 		c.JSON(http.StatusOK, gin.H{"uniqueNik": false, "uniqueLogin": false})
 		return
-
-	}
-
-	var countNik int64
-	if err := db.Model(&models.User{}).Where("nik = ?", dNik).Count(&countNik).Error; err != nil {
-		c.JSON(http.StatusOK, gin.H{"uniqueNik": false, "uniqueLogin": false})
-		return
-	}
-
-	var countLogin int64
-	if err := db.Model(&models.User{}).Where("login = ?", dLogin).Count(&countLogin).Error; err != nil {
-		c.JSON(http.StatusOK, gin.H{"uniqueNik": false, "uniqueLogin": false})
-		return
 	}
 
-	c.JSON(http.StatusOK, gin.H{"uniqueNik": countNik == 0, "uniqueLogin": countLogin == 0})
+	c.JSON(http.StatusOK, gin.H{"uniqueNik": !nikExists, "uniqueLogin": !loginExists})
 }
@@ -0,0 +1,195 @@
+package users
+
+import (
+	"Bmessage_backend/helpers"
+	"Bmessage_backend/routs/tokens"
+	"Bmessage_backend/testutil"
+	"bytes"
+	"crypto/tls"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func registration(t *testing.T, client *testutil.Client, nik, login, password string) UserRegistration {
+	return UserRegistration{
+		Uuid:     client.Uuid,
+		Name:     client.Encrypt(t, "Иван"),
+		SoName:   client.Encrypt(t, "Иванов"),
+		Nik:      client.Encrypt(t, nik),
+		Login:    client.Encrypt(t, login),
+		Password: client.Encrypt(t, password),
+		PKey:     client.PKey(t),
+	}
+}
+
+func TestRegistrationAndLogin(t *testing.T) {
+	repos, router := testutil.Setup(t)
+	tokens.TokensRouter(router)
+	UsersRouter(router)
+
+	client := testutil.NewClient(t, router)
+
+	var registered struct {
+		Token string `json:"token"`
+	}
+	rec := testutil.Do(t, router, http.MethodPost, "/user/registration", registration(t, client, "ivan", "ivan_login", "secret"))
+	testutil.Decode(t, rec, http.StatusOK, &registered)
+
+	user, err := repos.Users.GetUserByLogin("ivan_login")
+	if err != nil {
+		t.Fatalf("registered user not stored: %v", err)
+	}
+	if user.Nik != "ivan" || user.Name != "Иван" || user.PrivateKey == "" {
+		t.Fatalf("unexpected stored user: %+v", user)
+	}
+	if user.Password == "secret" {
+		t.Fatal("password stored without hashing")
+	}
+
+	tokenData, err := helpers.DecryptAES(client.Decrypt(t, registered.Token))
+	if err != nil {
+		t.Fatalf("registration token: %v", err)
+	}
+	if tokenData.User_id != user.ID {
+		t.Fatalf("registration token user = %d, want %d", tokenData.User_id, user.ID)
+	}
+
+	var loggedIn struct {
+		Token string `json:"token"`
+	}
+	rec = testutil.Do(t, router, http.MethodPost, "/user/log-in-with-credentials", UserLogin{
+		Uuid:     client.Uuid,
+		PKey:     client.PKey(t),
+		Login:    client.Encrypt(t, "ivan_login"),
+		Password: client.Encrypt(t, "secret"),
+	})
+	testutil.Decode(t, rec, http.StatusOK, &loggedIn)
+
+	tokenData, err = helpers.DecryptAES(client.Decrypt(t, loggedIn.Token))
+	if err != nil {
+		t.Fatalf("login token: %v", err)
+	}
+	if tokenData.User_id != user.ID {
+		t.Fatalf("login token user = %d, want %d", tokenData.User_id, user.ID)
+	}
+
+	rec = testutil.Do(t, router, http.MethodPost, "/user/log-in-with-credentials", UserLogin{
+		Uuid:     client.Uuid,
+		PKey:     client.PKey(t),
+		Login:    client.Encrypt(t, "ivan_login"),
+		Password: client.Encrypt(t, "wrong"),
+	})
+	testutil.Decode(t, rec, http.StatusUnauthorized, nil)
+}
+
+func TestRegistrationRejectsDuplicates(t *testing.T) {
+	_, router := testutil.Setup(t)
+	tokens.TokensRouter(router)
+	UsersRouter(router)
+
+	client := testutil.NewClient(t, router)
+
+	rec := testutil.Do(t, router, http.MethodPost, "/user/registration", registration(t, client, "ivan", "ivan_login", "secret"))
+	testutil.Decode(t, rec, http.StatusOK, nil)
+
+	rec = testutil.Do(t, router, http.MethodPost, "/user/registration", registration(t, client, "ivan", "other_login", "secret"))
+	testutil.Decode(t, rec, http.StatusConflict, nil)
+
+	var unique struct {
+		UniqueNik   bool `json:"uniqueNik"`
+		UniqueLogin bool `json:"uniqueLogin"`
+	}
+	rec = testutil.Do(t, router, http.MethodPost, "/user/check-uniqueness-registration-data", UserCUD{
+		Uuid:  client.Uuid,
+		Nik:   client.Encrypt(t, "ivan"),
+		Login: client.Encrypt(t, "free_login"),
+	})
+	testutil.Decode(t, rec, http.StatusOK, &unique)
+	if unique.UniqueNik || !unique.UniqueLogin {
+		t.Fatalf("uniqueness = %+v, want nik taken and login free", unique)
+	}
+}
+
+func TestX25519Handshake(t *testing.T) {
+	repos, router := testutil.Setup(t)
+	tokens.TokensRouter(router)
+	UsersRouter(router)
+
+	client := testutil.NewX25519Client(t, router)
+
+	var registered struct {
+		Token string `json:"token"`
+	}
+	rec := testutil.Do(t, router, http.MethodPost, "/user/registration", registration(t, client, "ivan", "ivan_login", "secret"))
+	testutil.Decode(t, rec, http.StatusOK, &registered)
+
+	user, err := repos.Users.GetUserByLogin("ivan_login")
+	if err != nil || user.Name != "Иван" {
+		t.Fatalf("registered user = %+v, %v", user, err)
+	}
+	tokenData, err := helpers.DecryptAES(client.Decrypt(t, registered.Token))
+	if err != nil || tokenData.User_id != user.ID {
+		t.Fatalf("registration token = %+v, %v", tokenData, err)
+	}
+
+	// Поле, зашифрованное для другого обмена, не расшифровывается
+	other := testutil.NewX25519Client(t, router)
+	rec = testutil.Do(t, router, http.MethodPost, "/user/log-in-with-credentials", UserLogin{
+		Uuid:     client.Uuid,
+		Login:    client.Encrypt(t, "ivan_login"),
+		Password: other.Encrypt(t, "secret"),
+	})
+	testutil.Decode(t, rec, http.StatusBadRequest, nil)
+
+	var loggedIn struct {
+		Token string `json:"token"`
+	}
+	rec = testutil.Do(t, router, http.MethodPost, "/user/log-in-with-credentials", UserLogin{
+		Uuid:     client.Uuid,
+		Login:    client.Encrypt(t, "ivan_login"),
+		Password: client.Encrypt(t, "secret"),
+	})
+	testutil.Decode(t, rec, http.StatusOK, &loggedIn)
+	if tokenData, err := helpers.DecryptAES(client.Decrypt(t, loggedIn.Token)); err != nil || tokenData.User_id != user.ID {
+		t.Fatalf("login token = %+v, %v", tokenData, err)
+	}
+
+	testutil.Decode(t, testutil.Do(t, router, http.MethodPost, "/token/key-exchange", map[string]string{"client_public_key": "AAAA"}), http.StatusBadRequest, nil)
+}
+
+func TestLoginOverTLSWithoutHandshake(t *testing.T) {
+	_, router := testutil.Setup(t)
+	tokens.TokensRouter(router)
+	UsersRouter(router)
+
+	client := testutil.NewClient(t, router)
+	rec := testutil.Do(t, router, http.MethodPost, "/user/registration", registration(t, client, "ivan", "ivan_login", "secret"))
+	testutil.Decode(t, rec, http.StatusOK, nil)
+
+	credentials := UserLogin{Login: "ivan_login", Password: "secret"}
+	loginRequest := func(secure bool) *http.Request {
+		body, err := json.Marshal(credentials)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req := httptest.NewRequest(http.MethodPost, "/user/log-in-with-credentials", bytes.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		if secure {
+			req.TLS = &tls.ConnectionState{}
+		}
+		return req
+	}
+
+	// По открытому HTTP учётные данные без шифрования не принимаются
+	testutil.Decode(t, serve(router, loginRequest(false)), http.StatusBadRequest, nil)
+
+	var loggedIn struct {
+		Token string `json:"token"`
+	}
+	testutil.Decode(t, serve(router, loginRequest(true)), http.StatusOK, &loggedIn)
+	if _, err := helpers.DecryptAES(loggedIn.Token); err != nil {
+		t.Fatalf("token over TLS: %v", err)
+	}
+}
@@ -0,0 +1,423 @@
+package users
+
+import (
+	helpers "Bmessage_backend/helpers"
+	"Bmessage_backend/middleware"
+	"Bmessage_backend/models"
+	"Bmessage_backend/repository"
+	"Bmessage_backend/routs/chats"
+	"Bmessage_backend/sessions"
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gocql/gocql"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Срок, в течение которого удаление аккаунта можно отменить, если ACCOUNT_DELETION_GRACE не задан
+const defaultDeletionGrace = 30 * 24 * time.Hour
+
+// deletionGrace возвращает срок отмены удаления из ACCOUNT_DELETION_GRACE (например, 720h)
+func deletionGrace() time.Duration {
+	if value := os.Getenv("ACCOUNT_DELETION_GRACE"); value != "" {
+		grace, err := time.ParseDuration(value)
+		if err == nil && grace >= 0 {
+			return grace
+		}
+		log.Printf("Invalid ACCOUNT_DELETION_GRACE %q, using %s", value, defaultDeletionGrace)
+	}
+	return defaultDeletionGrace
+}
+
+// DeleteAccountStruct represents the JSON
+// @Description Запрос на удаление аккаунта. Пароль зашифрован ключом из token/generateToken.
+type DeleteAccountStruct struct {
+	Uuid     string `json:"uuid"`
+	Password string `json:"password"`
+}
+
+// @Tags Users
+// deleteAccount godoc
+// @Summary Запрос на удаление аккаунта
+// @Description Планирует удаление аккаунта по истечении срока отмены (ACCOUNT_DELETION_GRACE, по умолчанию 30 дней) и завершает все сессии. До окончания срока можно войти и отменить удаление.
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param data body DeleteAccountStruct true "Пароль"
+// @Success 200 {object} map[string]interface{} "successful response"
+// @Failure 400 {object} map[string]interface{} "bad request"
+// @Failure 401 {object} map[string]interface{} "wrong password"
+// @Router /user/delete-account [post]
+func deleteAccount(repos *repository.Repositories, c *gin.Context) {
+	var deleteData DeleteAccountStruct
+	if err := c.BindJSON(&deleteData); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверные входные данные"})
+		return
+	}
+
+	if _, err := openHandshake(c, deleteData.Uuid, &deleteData.Password); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Ошибка дешифрования пароля"})
+		return
+	}
+
+	user, err := repos.Users.GetUser(middleware.UserID(c))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Пользователь не найден"})
+		return
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(deleteData.Password)); err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Неверный пароль"})
+		return
+	}
+
+	requestedAt := time.Now().UTC()
+	if user.DeletionRequestedAt != nil {
+		requestedAt = *user.DeletionRequestedAt
+	} else if err := repos.Users.SetDeletionRequestedAt(user.ID, &requestedAt); err != nil {
+		log.Println("Failed to schedule account deletion:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Внутренняя ошибка сервера"})
+		return
+	}
+
+	if _, err := sessions.RevokeAll(c.Request.Context(), user.ID); err != nil {
+		log.Println("Failed to revoke sessions:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Внутренняя ошибка сервера"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"message":               "Аккаунт будет удалён. До этого срока удаление можно отменить, войдя в аккаунт",
+		"deletion_scheduled_at": requestedAt.Add(deletionGrace()),
+	})
+}
+
+// @Tags Users
+// cancelAccountDeletion godoc
+// @Summary Отмена удаления аккаунта
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} map[string]interface{} "successful response"
+// @Failure 401 {object} map[string]interface{} "unauthorized"
+// @Router /user/cancel-account-deletion [post]
+func cancelAccountDeletion(repos *repository.Repositories, c *gin.Context) {
+	if err := repos.Users.SetDeletionRequestedAt(middleware.UserID(c), nil); err != nil {
+		if err == repository.ErrNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Пользователь не найден"})
+			return
+		}
+		log.Println("Failed to cancel account deletion:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Внутренняя ошибка сервера"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Удаление аккаунта отменено"})
+}
+
+// exportChat — запись chats.json в архиве выгрузки
+type exportChat struct {
+	ChatID           gocql.UUID `json:"chat_id"`
+	CompanionID      uint       `json:"companion_id"`
+	CompanionNik     string     `json:"companion_nik,omitempty"`
+	CompanionName    string     `json:"companion_name,omitempty"`
+	CompanionSoName  string     `json:"companion_so_name,omitempty"`
+	CompanionDeleted bool       `json:"companion_deleted,omitempty"`
+	ChatType         string     `json:"chat_type"`
+	Secured          bool       `json:"secured"`
+	LastMsgTime      *time.Time `json:"last_msg_time,omitempty"`
+}
+
+// exportContact — запись файла contacts.json
+type exportContact struct {
+	ContactID  uint      `json:"contact_id"`
+	CustomName string    `json:"custom_name,omitempty"`
+	CreatedAt  time.Time `json:"created_at"`
+}
+
+// exportMessage — сообщение в файле messages/<chat_id>.json, текст расшифрован
+type exportMessage struct {
+	MessageID              gocql.UUID  `json:"message_id"`
+	SenderID               uint        `json:"sender_id"`
+	IsMyMessage            bool        `json:"is_my_message"`
+	Text                   string      `json:"text"`
+	CreatedAt              time.Time   `json:"created_at"`
+	Read                   bool        `json:"read"`
+	ReplyToMessageID       *gocql.UUID `json:"reply_to_message_id,omitempty"`
+	ForwardedFromChatID    *gocql.UUID `json:"forwarded_from_chat_id,omitempty"`
+	ForwardedFromMessageID *gocql.UUID `json:"forwarded_from_message_id,omitempty"`
+	ForwardedFromSenderID  *uint       `json:"forwarded_from_sender_id,omitempty"`
+	ForwardedAt            *time.Time  `json:"forwarded_at,omitempty"`
+	ExpiresAt              *time.Time  `json:"expires_at,omitempty"`
+	// Kind и Payload заполнены у служебных сообщений, текста у них нет
+	Kind    string          `json:"kind,omitempty"`
+	Payload json.RawMessage `json:"payload,omitempty"`
+}
+
+// @Tags Users
+// exportData godoc
+// @Summary Выгрузка данных пользователя
+// @Description ZIP-архив: profile.json, chats.json, contacts.json и messages/<chat_id>.json с расшифрованными сообщениями в хронологическом порядке; служебные сообщения выгружаются с kind и payload.
+// @Produce application/zip
+// @Security BearerAuth
+// @Success 200 {file} file "ZIP-архив"
+// @Failure 401 {object} map[string]interface{} "unauthorized"
+// @Router /user/export-data [get]
+func exportData(repos *repository.Repositories, c *gin.Context) {
+	user, err := repos.Users.GetUser(middleware.UserID(c))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Пользователь не найден"})
+		return
+	}
+
+	// Архив собирается целиком до отправки, чтобы при ошибке вернуть 500, а не оборванный файл
+	var archive bytes.Buffer
+	if err := writeExport(repos, user, &archive); err != nil {
+		log.Println("Failed to export user data:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Не удалось выгрузить данные"})
+		return
+	}
+
+	fileName := fmt.Sprintf("bmessage-export-%d.zip", user.ID)
+	c.Header("Content-Disposition", `attachment; filename="`+fileName+`"`)
+	c.Data(http.StatusOK, "application/zip", archive.Bytes())
+}
+
+func writeExport(repos *repository.Repositories, user models.User, w *bytes.Buffer) error {
+	archive := zip.NewWriter(w)
+
+	writeJSON := func(name string, v interface{}) error {
+		file, err := archive.Create(name)
+		if err != nil {
+			return err
+		}
+		encoder := json.NewEncoder(file)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(v)
+	}
+
+	profile := ownProfile(user)
+	if err := writeJSON("profile.json", profile); err != nil {
+		return err
+	}
+
+	chatRows, err := listAllChats(repos, user.ID)
+	if err != nil {
+		return err
+	}
+
+	var companionIDs []uint
+	for _, chatRow := range chatRows {
+		companionIDs = append(companionIDs, chatRow.CompanionID)
+	}
+	companions, err := repos.Users.GetUsers(companionIDs)
+	if err != nil {
+		return err
+	}
+	companionByID := make(map[uint]models.User)
+	for _, companion := range companions {
+		companionByID[companion.ID] = companion
+	}
+
+	exportChats := make([]exportChat, 0, len(chatRows))
+	for _, chatRow := range chatRows {
+		companion := companionByID[chatRow.CompanionID]
+		exportChats = append(exportChats, exportChat{
+			ChatID:           chatRow.ChatID,
+			CompanionID:      chatRow.CompanionID,
+			CompanionNik:     companion.Nik,
+			CompanionName:    companion.Name,
+			CompanionSoName:  companion.SoName,
+			CompanionDeleted: chatRow.CompanionDeleted,
+			ChatType:         chatRow.ChatType,
+			Secured:          chatRow.Secured,
+			LastMsgTime:      chatRow.LastMsgTime,
+		})
+	}
+	if err := writeJSON("chats.json", exportChats); err != nil {
+		return err
+	}
+
+	contacts, err := repos.Contacts.ListContacts(user.ID)
+	if err != nil {
+		return err
+	}
+	exportContacts := make([]exportContact, 0, len(contacts))
+	for _, contact := range contacts {
+		exportContacts = append(exportContacts, exportContact{ContactID: contact.ContactID, CustomName: contact.CustomName, CreatedAt: contact.CreatedAt})
+	}
+	if err := writeJSON("contacts.json", exportContacts); err != nil {
+		return err
+	}
+
+	for _, chatRow := range chatRows {
+		rows, err := repos.Messages.ListMessages(user.ID, chatRow.ChatID, repository.ListOptions{})
+		if err != nil {
+			return err
+		}
+
+		messages := make([]exportMessage, 0, len(rows))
+		for i := len(rows) - 1; i >= 0; i-- {
+			row := rows[i]
+			if row.Kind != "" {
+				messages = append(messages, exportMessage{
+					MessageID: row.MessageID,
+					CreatedAt: row.CreatedAt,
+					Read:      row.Read,
+					Kind:      row.Kind,
+					Payload:   json.RawMessage(row.Payload),
+				})
+				continue
+			}
+			text, err := helpers.DecryptWithPrivateKey(row.MessageText, chatRow.PrivateKey)
+			if err != nil {
+				return fmt.Errorf("decrypt message %s in chat %s: %w", row.MessageID, chatRow.ChatID, err)
+			}
+			messages = append(messages, exportMessage{
+				MessageID:              row.MessageID,
+				SenderID:               row.SenderID,
+				IsMyMessage:            row.SenderID == user.ID,
+				Text:                   text,
+				CreatedAt:              row.CreatedAt,
+				Read:                   row.Read,
+				ReplyToMessageID:       row.ReplyToMessageID,
+				ForwardedFromChatID:    row.ForwardedFromChatID,
+				ForwardedFromMessageID: row.ForwardedFromMessageID,
+				ForwardedFromSenderID:  row.ForwardedFromSenderID,
+				ForwardedAt:            row.ForwardedAt,
+				ExpiresAt:              row.ExpiresAt,
+			})
+		}
+		if err := writeJSON("messages/"+chatRow.ChatID.String()+".json", messages); err != nil {
+			return err
+		}
+	}
+
+	return archive.Close()
+}
+
+// listAllChats возвращает все чаты пользователя, проходя по страницам ListChats
+func listAllChats(repos *repository.Repositories, userID uint) ([]repository.Chat, error) {
+	var all []repository.Chat
+	var pageState []byte
+	for {
+		chatRows, nextPageState, err := repos.Chats.ListChats(userID, pageState, 100, nil)
+		if err != nil {
+			return nil, err
+		}
+		all = append(all, chatRows...)
+		if len(nextPageState) == 0 {
+			return all, nil
+		}
+		pageState = nextPageState
+	}
+}
+
+// purgeAccount окончательно удаляет аккаунт: у собеседников чаты становятся доступны
+// только для чтения, чаты и копии сообщений пользователя стираются, учётная запись
+// обезличивается. Повторный вызов после сбоя продолжает с того места, где он прервался.
+func purgeAccount(ctx context.Context, repos *repository.Repositories, user models.User) error {
+	chatRows, err := listAllChats(repos, user.ID)
+	if err != nil {
+		return err
+	}
+
+	for _, chatRow := range chatRows {
+		if !chatRow.CompanionDeleted {
+			err := repos.Chats.MarkCompanionDeleted(chatRow.CompanionID, chatRow.ChatID)
+			if err != nil && err != repository.ErrNotFound {
+				return err
+			}
+			if err == nil {
+				chats.UpdeteDataChat(chatRow.CompanionID, chatRow.ChatID)
+			}
+		}
+
+		if err := repos.Messages.DeleteMessages(user.ID, chatRow.ChatID); err != nil {
+			return err
+		}
+		if err := repos.Search.RemoveChat(user.ID, chatRow.ChatID); err != nil {
+			return err
+		}
+		if err := repos.Chats.DeleteChat(user.ID, chatRow.ChatID); err != nil && err != repository.ErrNotFound {
+			return err
+		}
+	}
+
+	if err := repos.Chats.DropLegacyData(user.ID); err != nil {
+		return err
+	}
+
+	if err := repos.Users.PurgeUser(user.ID); err != nil {
+		return err
+	}
+
+	if strings.HasPrefix(user.Avatar, avatarURLPrefix+"/") {
+		if err := os.Remove(filepath.Join(avatarDir(), filepath.Base(user.Avatar))); err != nil && !os.IsNotExist(err) {
+			log.Println("Failed to remove avatar of deleted account:", err)
+		}
+	}
+
+	if _, err := sessions.RevokeAll(ctx, user.ID); err != nil {
+		log.Println("Failed to revoke sessions of deleted account:", err)
+	}
+	return nil
+}
+
+// purgeDueAccounts удаляет аккаунты, срок отмены удаления которых истёк к моменту now
+func purgeDueAccounts(ctx context.Context, repos *repository.Repositories, now time.Time) (int, error) {
+	users, err := repos.Users.ListDeletionDue(now.Add(-deletionGrace()))
+	if err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	for _, user := range users {
+		if err := purgeAccount(ctx, repos, user); err != nil {
+			log.Printf("Failed to purge account %d: %v", user.ID, err)
+			continue
+		}
+		purged++
+	}
+	return purged, nil
+}
+
+// RunAccountPurge раз в interval удаляет аккаунты с истёкшим сроком отмены удаления.
+// Блокирует вызывающего до отмены ctx. Удаление идемпотентно, поэтому задача может
+// одновременно работать на нескольких инстансах.
+func RunAccountPurge(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		repos, closeRepos, err := repository.Open()
+		if err != nil {
+			log.Println("Account purge: failed to open repositories:", err)
+			continue
+		}
+		purged, err := purgeDueAccounts(ctx, repos, time.Now())
+		closeRepos()
+		if err != nil {
+			log.Println("Account purge failed:", err)
+			continue
+		}
+		if purged > 0 {
+			log.Printf("Account purge: removed %d accounts\n", purged)
+		}
+	}
+}
@@ -0,0 +1,239 @@
+package users
+
+import (
+	"Bmessage_backend/repository"
+	"Bmessage_backend/routs/chats"
+	"Bmessage_backend/routs/messages"
+	"Bmessage_backend/testutil"
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gocql/gocql"
+)
+
+func TestDeleteAccountAndCancel(t *testing.T) {
+	router := newPasswordRouter(t)
+	client := testutil.NewClient(t, router)
+
+	rec := testutil.Do(t, router, http.MethodPost, "/user/registration", registration(t, client, "ivan", "ivan_login", "password-1"))
+	testutil.Decode(t, rec, http.StatusOK, nil)
+	session := login(t, router, client, "ivan_login", "password-1", http.StatusOK)
+
+	rec = testutil.DoAs(t, router, session, http.MethodPost, "/user/delete-account", DeleteAccountStruct{
+		Uuid:     client.Uuid,
+		Password: client.Encrypt(t, "wrong-password"),
+	})
+	testutil.Decode(t, rec, http.StatusUnauthorized, nil)
+
+	var scheduled struct {
+		DeletionScheduledAt time.Time `json:"deletion_scheduled_at"`
+	}
+	rec = testutil.DoAs(t, router, session, http.MethodPost, "/user/delete-account", DeleteAccountStruct{
+		Uuid:     client.Uuid,
+		Password: client.Encrypt(t, "password-1"),
+	})
+	testutil.Decode(t, rec, http.StatusOK, &scheduled)
+	if until := time.Until(scheduled.DeletionScheduledAt); until < defaultDeletionGrace-time.Minute || until > defaultDeletionGrace {
+		t.Fatalf("deletion scheduled in %s, want about %s", until, defaultDeletionGrace)
+	}
+
+	// Все сессии завершены, но войти и отменить удаление можно
+	testutil.Decode(t, testutil.DoAs(t, router, session, http.MethodGet, "/user/profile", nil), http.StatusUnauthorized, nil)
+
+	var loggedIn map[string]interface{}
+	rec = testutil.Do(t, router, http.MethodPost, "/user/log-in-with-credentials", UserLogin{
+		Uuid:     client.Uuid,
+		PKey:     client.PKey(t),
+		Login:    client.Encrypt(t, "ivan_login"),
+		Password: client.Encrypt(t, "password-1"),
+	})
+	testutil.Decode(t, rec, http.StatusOK, &loggedIn)
+	if _, ok := loggedIn["deletion_scheduled_at"]; !ok {
+		t.Fatalf("login response does not mention scheduled deletion: %v", loggedIn)
+	}
+	session = client.Decrypt(t, loggedIn["token"].(string))
+
+	testutil.Decode(t, testutil.DoAs(t, router, session, http.MethodPost, "/user/cancel-account-deletion", nil), http.StatusOK, nil)
+
+	loggedIn = nil
+	rec = testutil.Do(t, router, http.MethodPost, "/user/log-in-with-credentials", UserLogin{
+		Uuid:     client.Uuid,
+		PKey:     client.PKey(t),
+		Login:    client.Encrypt(t, "ivan_login"),
+		Password: client.Encrypt(t, "password-1"),
+	})
+	testutil.Decode(t, rec, http.StatusOK, &loggedIn)
+	if _, ok := loggedIn["deletion_scheduled_at"]; ok {
+		t.Fatalf("deletion still scheduled after cancel: %v", loggedIn)
+	}
+}
+
+type accountFixture struct {
+	repos     *repository.Repositories
+	router    *gin.Engine
+	chatID    gocql.UUID
+	ivanID    uint
+	ivanToken string
+	petrID    uint
+	petrToken string
+}
+
+func newAccountFixture(t *testing.T) accountFixture {
+	t.Helper()
+	repos, router := testutil.Setup(t)
+	UsersRouter(router)
+	chats.ChatRouter(router)
+	messages.MessageRouter(router)
+
+	ivan, ivanToken := testutil.CreateUser(t, repos, "ivan")
+	petr, petrToken := testutil.CreateUser(t, repos, "petr")
+
+	var created struct {
+		ChatID gocql.UUID `json:"chat_id"`
+	}
+	rec := testutil.DoAs(t, router, ivanToken, http.MethodPost, "/chats/create-chat", chats.CreateChatStruct{Companion_id: petr.ID})
+	testutil.Decode(t, rec, http.StatusOK, &created)
+
+	return accountFixture{repos, router, created.ChatID, ivan.ID, ivanToken, petr.ID, petrToken}
+}
+
+func (f accountFixture) send(t *testing.T, token, text string, status int) {
+	t.Helper()
+	rec := testutil.DoAs(t, f.router, token, http.MethodPost, "/messages/add-message", messages.AddMessageStruct{
+		ChatID:      f.chatID.String(),
+		MessageText: text,
+	})
+	testutil.Decode(t, rec, status, nil)
+}
+
+func TestPurgeDueAccounts(t *testing.T) {
+	f := newAccountFixture(t)
+	sidor, _ := testutil.CreateUser(t, f.repos, "sidor")
+
+	f.send(t, f.ivanToken, "привет", http.StatusOK)
+	f.send(t, f.petrToken, "и тебе привет", http.StatusOK)
+
+	now := time.Now()
+	expired := now.Add(-defaultDeletionGrace - time.Hour)
+	recent := now.Add(-time.Hour)
+	if err := f.repos.Users.SetDeletionRequestedAt(f.ivanID, &expired); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.repos.Users.SetDeletionRequestedAt(sidor.ID, &recent); err != nil {
+		t.Fatal(err)
+	}
+
+	purged, err := purgeDueAccounts(context.Background(), f.repos, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if purged != 1 {
+		t.Fatalf("purged %d accounts, want 1", purged)
+	}
+
+	if _, err := f.repos.Users.GetUser(sidor.ID); err != nil {
+		t.Fatalf("account within grace period was purged: %v", err)
+	}
+	if _, err := f.repos.Users.GetUser(f.ivanID); err != repository.ErrNotFound {
+		t.Fatalf("GetUser after purge = %v, want ErrNotFound", err)
+	}
+	if exists, _ := f.repos.Users.NikExists("ivan"); exists {
+		t.Fatal("nik of purged account is still taken")
+	}
+	if _, err := f.repos.Chats.GetChat(f.ivanID, f.chatID); err != repository.ErrNotFound {
+		t.Fatalf("chat of purged account = %v, want ErrNotFound", err)
+	}
+	if rows, _ := f.repos.Messages.ListMessages(f.ivanID, f.chatID, repository.ListOptions{}); len(rows) != 0 {
+		t.Fatalf("messages of purged account remain: %d", len(rows))
+	}
+	testutil.Decode(t, testutil.DoAs(t, f.router, f.ivanToken, http.MethodGet, "/chats/get-chats", nil), http.StatusUnauthorized, nil)
+
+	// У собеседника история остаётся, но написать в чат уже нельзя
+	var list struct {
+		Chats []chats.Chat `json:"chats"`
+	}
+	testutil.Decode(t, testutil.DoAs(t, f.router, f.petrToken, http.MethodGet, "/chats/get-chats", nil), http.StatusOK, &list)
+	if len(list.Chats) != 1 || !list.Chats[0].CompanionDeleted || list.Chats[0].CompanionName == "" {
+		t.Fatalf("unexpected chats of companion: %+v", list.Chats)
+	}
+
+	var history []messages.Message
+	rec := testutil.DoAs(t, f.router, f.petrToken, http.MethodGet, "/messages/get-messages?chat_id="+f.chatID.String(), nil)
+	testutil.Decode(t, rec, http.StatusOK, &history)
+	// Служебное chat_created и два сообщения
+	if len(history) != 3 {
+		t.Fatalf("companion history has %d messages, want 3", len(history))
+	}
+
+	rec = testutil.DoAs(t, f.router, f.petrToken, http.MethodPost, "/messages/read-message", messages.ReadMessageStruct{
+		ChatID:    f.chatID.String(),
+		MessageID: history[1].MessageID.String(),
+	})
+	testutil.Decode(t, rec, http.StatusOK, nil)
+	if rows, _ := f.repos.Messages.ListMessages(f.ivanID, f.chatID, repository.ListOptions{}); len(rows) != 0 {
+		t.Fatal("read receipt recreated messages of purged account")
+	}
+
+	f.send(t, f.petrToken, "ты тут?", http.StatusForbidden)
+}
+
+func TestExportData(t *testing.T) {
+	f := newAccountFixture(t)
+
+	f.send(t, f.ivanToken, "первое", http.StatusOK)
+	f.send(t, f.petrToken, "второе", http.StatusOK)
+
+	rec := testutil.DoAs(t, f.router, f.ivanToken, http.MethodGet, "/user/export-data", nil)
+	if rec.Code != http.StatusOK || rec.Header().Get("Content-Type") != "application/zip" {
+		t.Fatalf("export: status %d, content type %q: %s", rec.Code, rec.Header().Get("Content-Type"), rec.Body.String())
+	}
+
+	archive, err := zip.NewReader(bytes.NewReader(rec.Body.Bytes()), int64(rec.Body.Len()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	readJSON := func(name string, v interface{}) {
+		t.Helper()
+		file, err := archive.Open(name)
+		if err != nil {
+			t.Fatalf("open %s: %v", name, err)
+		}
+		defer file.Close()
+		data, err := io.ReadAll(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := json.Unmarshal(data, v); err != nil {
+			t.Fatalf("decode %s: %v", name, err)
+		}
+	}
+
+	var profile Profile
+	readJSON("profile.json", &profile)
+	if profile.UserID != f.ivanID || profile.Nik != "ivan" || profile.Login != "ivan" {
+		t.Fatalf("unexpected profile: %+v", profile)
+	}
+
+	var exportedChats []exportChat
+	readJSON("chats.json", &exportedChats)
+	if len(exportedChats) != 1 || exportedChats[0].ChatID != f.chatID || exportedChats[0].CompanionNik != "petr" {
+		t.Fatalf("unexpected chats: %+v", exportedChats)
+	}
+
+	var exportedMessages []exportMessage
+	readJSON("messages/"+f.chatID.String()+".json", &exportedMessages)
+	if len(exportedMessages) != 3 || exportedMessages[0].Kind != chats.SystemChatCreated ||
+		exportedMessages[1].Text != "первое" || !exportedMessages[1].IsMyMessage ||
+		exportedMessages[2].Text != "второе" || exportedMessages[2].SenderID != f.petrID {
+		t.Fatalf("unexpected messages: %+v", exportedMessages)
+	}
+
+	testutil.Decode(t, testutil.Do(t, f.router, http.MethodGet, "/user/export-data", nil), http.StatusUnauthorized, nil)
+}
@@ -0,0 +1,265 @@
+package users
+
+import (
+	"Bmessage_backend/authz"
+	"Bmessage_backend/helpers"
+	"Bmessage_backend/middleware"
+	"Bmessage_backend/models"
+	"Bmessage_backend/repository"
+	"log"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gocql/gocql"
+)
+
+const maxReportReasonLength = 1000
+
+// BlockUserStruct represents the JSON
+// @Description Пользователь, которого нужно добавить в чёрный список или убрать из него
+type BlockUserStruct struct {
+	UserID uint `json:"user_id"`
+}
+
+// bindBlockTarget разбирает тело запроса блокировки и проверяет, что пользователь существует и это не сам запрашивающий
+func bindBlockTarget(repos *repository.Repositories, c *gin.Context) (uint, bool) {
+	var blockData BlockUserStruct
+	if err := c.BindJSON(&blockData); err != nil || blockData.UserID == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверные входные данные"})
+		return 0, false
+	}
+	if blockData.UserID == middleware.UserID(c) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Нельзя заблокировать самого себя"})
+		return 0, false
+	}
+	if _, err := repos.Users.GetUser(blockData.UserID); err != nil {
+		if err == repository.ErrNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Пользователь не найден"})
+			return 0, false
+		}
+		log.Println("Failed to fetch user:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Внутренняя ошибка сервера"})
+		return 0, false
+	}
+	return blockData.UserID, true
+}
+
+// @Tags Users
+// blockUser godoc
+// @Summary Блокировка пользователя
+// @Description Заблокированный не может создать чат и писать пользователю, а пользователь пропадает из его поиска.
+// @Description Статуса «в сети» в API нет, поэтому скрывать присутствие от заблокированного не требуется.
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param data body BlockUserStruct true "Пользователь"
+// @Success 200 {object} map[string]interface{} "successful response"
+// @Failure 400 {object} map[string]interface{} "bad request"
+// @Failure 401 {object} map[string]interface{} "unauthorized"
+// @Failure 404 {object} map[string]interface{} "not found"
+// @Router /user/block [post]
+func blockUser(repos *repository.Repositories, c *gin.Context) {
+	blockedID, ok := bindBlockTarget(repos, c)
+	if !ok {
+		return
+	}
+
+	if err := repos.Users.BlockUser(middleware.UserID(c), blockedID); err != nil {
+		log.Println("Failed to block user:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Внутренняя ошибка сервера"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Пользователь заблокирован"})
+}
+
+// @Tags Users
+// unblockUser godoc
+// @Summary Разблокировка пользователя
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param data body BlockUserStruct true "Пользователь"
+// @Success 200 {object} map[string]interface{} "successful response"
+// @Failure 400 {object} map[string]interface{} "bad request"
+// @Failure 401 {object} map[string]interface{} "unauthorized"
+// @Failure 404 {object} map[string]interface{} "not found"
+// @Router /user/unblock [post]
+func unblockUser(repos *repository.Repositories, c *gin.Context) {
+	blockedID, ok := bindBlockTarget(repos, c)
+	if !ok {
+		return
+	}
+
+	if err := repos.Users.UnblockUser(middleware.UserID(c), blockedID); err != nil {
+		log.Println("Failed to unblock user:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Внутренняя ошибка сервера"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Пользователь разблокирован"})
+}
+
+// @Tags Users
+// getBlockedUsers godoc
+// @Summary Чёрный список
+// @Description Заблокированные пользователи, недавно добавленные первыми.
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} map[string]interface{} "successful response"
+// @Failure 401 {object} map[string]interface{} "unauthorized"
+// @Router /user/blocked [get]
+func getBlockedUsers(repos *repository.Repositories, c *gin.Context) {
+	ids, err := repos.Users.ListBlocked(middleware.UserID(c))
+	if err != nil {
+		log.Println("Failed to fetch block list:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Внутренняя ошибка сервера"})
+		return
+	}
+
+	users, err := repos.Users.GetUsers(ids)
+	if err != nil {
+		log.Println("Failed to fetch users:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Внутренняя ошибка сервера"})
+		return
+	}
+	byID := make(map[uint]models.User, len(users))
+	for _, user := range users {
+		byID[user.ID] = user
+	}
+
+	profiles := make([]Profile, 0, len(ids))
+	for _, id := range ids {
+		if user, ok := byID[id]; ok {
+			profiles = append(profiles, profileFromUser(user))
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": profiles})
+}
+
+// ReportStruct represents the JSON
+// @Description Жалоба на пользователя (user_id) или на сообщение (chat_id и message_id).
+type ReportStruct struct {
+	UserID    uint   `json:"user_id,omitempty"`
+	ChatID    string `json:"chat_id,omitempty"`
+	MessageID string `json:"message_id,omitempty"`
+	Reason    string `json:"reason"`
+}
+
+// @Tags Users
+// reportUser godoc
+// @Summary Жалоба на пользователя или сообщение
+// @Description Для жалобы на сообщение модераторам сохраняется его текст и время отправки, автором считается отправитель.
+// @Description Пожаловаться можно только на сообщение из своего чата; на служебные сообщения жаловаться нельзя.
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param data body ReportStruct true "Жалоба"
+// @Success 200 {object} map[string]interface{} "successful response"
+// @Failure 400 {object} map[string]interface{} "bad request"
+// @Failure 401 {object} map[string]interface{} "unauthorized"
+// @Failure 403 {object} map[string]interface{} "forbidden"
+// @Failure 404 {object} map[string]interface{} "not found"
+// @Router /user/report [post]
+func reportUser(repos *repository.Repositories, c *gin.Context) {
+	var reportData ReportStruct
+	if err := c.BindJSON(&reportData); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверные входные данные"})
+		return
+	}
+
+	reason := strings.TrimSpace(reportData.Reason)
+	if reason == "" || utf8.RuneCountInString(reason) > maxReportReasonLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Укажите причину жалобы"})
+		return
+	}
+
+	userID := middleware.UserID(c)
+	report := models.Report{ReporterID: userID, ReportedUserID: reportData.UserID, Reason: reason}
+
+	if reportData.MessageID != "" {
+		if !snapshotReportedMessage(repos, c, userID, reportData, &report) {
+			return
+		}
+	}
+
+	if report.ReportedUserID == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Укажите пользователя или сообщение"})
+		return
+	}
+	if report.ReportedUserID == userID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Нельзя пожаловаться на самого себя"})
+		return
+	}
+	if report.MessageID == nil {
+		if _, err := repos.Users.GetUser(report.ReportedUserID); err != nil {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Пользователь не найден"})
+			return
+		}
+	}
+
+	if err := repos.Reports.CreateReport(&report); err != nil {
+		log.Println("Failed to store report:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Внутренняя ошибка сервера"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Жалоба отправлена", "report_id": report.ID})
+}
+
+// snapshotReportedMessage находит сообщение в копии жалующегося и сохраняет в жалобе
+// его расшифрованный текст. Автор сообщения становится пользователем, на которого жалуются.
+func snapshotReportedMessage(repos *repository.Repositories, c *gin.Context, userID uint, reportData ReportStruct, report *models.Report) bool {
+	chatID, err := gocql.ParseUUID(reportData.ChatID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid chat_id"})
+		return false
+	}
+	messageID, err := gocql.ParseUUID(reportData.MessageID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid message_id"})
+		return false
+	}
+
+	access, ok := authz.Require(c, repos, userID, chatID, authz.Read)
+	if !ok {
+		return false
+	}
+
+	message, err := repos.Messages.GetMessage(userID, chatID, messageID)
+	if err == repository.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Сообщение не найдено"})
+		return false
+	}
+	if err != nil {
+		log.Println("Failed to fetch reported message:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Внутренняя ошибка сервера"})
+		return false
+	}
+	if message.Kind != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "На служебное сообщение нельзя пожаловаться"})
+		return false
+	}
+	if reportData.UserID != 0 && reportData.UserID != message.SenderID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Сообщение отправлено другим пользователем"})
+		return false
+	}
+
+	text, err := helpers.DecryptWithPrivateKey(message.MessageText, access.Chat.PrivateKey)
+	if err != nil {
+		log.Println("Failed to decrypt reported message:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Внутренняя ошибка сервера"})
+		return false
+	}
+
+	chatValue, messageValue := chatID.String(), messageID.String()
+	report.ReportedUserID = message.SenderID
+	report.ChatID = &chatValue
+	report.MessageID = &messageValue
+	report.MessageText = text
+	report.MessageSentAt = &message.CreatedAt
+	return true
+}
@@ -0,0 +1,110 @@
+package users
+
+import (
+	"Bmessage_backend/routs/chats"
+	"Bmessage_backend/routs/messages"
+	"Bmessage_backend/testutil"
+	"net/http"
+	"testing"
+
+	"github.com/gocql/gocql"
+)
+
+func TestBlockUser(t *testing.T) {
+	repos, router := testutil.Setup(t)
+	UsersRouter(router)
+	chats.ChatRouter(router)
+	messages.MessageRouter(router)
+
+	ivan, ivanToken := testutil.CreateUser(t, repos, "ivan")
+	petr, petrToken := testutil.CreateUser(t, repos, "petr")
+
+	var created struct {
+		ChatID gocql.UUID `json:"chat_id"`
+	}
+	rec := testutil.DoAs(t, router, petrToken, http.MethodPost, "/chats/create-chat", chats.CreateChatStruct{Companion_id: ivan.ID})
+	testutil.Decode(t, rec, http.StatusOK, &created)
+	send := func(token string, status int) {
+		t.Helper()
+		rec := testutil.DoAs(t, router, token, http.MethodPost, "/messages/add-message", messages.AddMessageStruct{ChatID: created.ChatID.String(), MessageText: "купи слона"})
+		testutil.Decode(t, rec, status, nil)
+	}
+	send(petrToken, http.StatusOK)
+
+	testutil.Decode(t, testutil.DoAs(t, router, ivanToken, http.MethodPost, "/user/block", BlockUserStruct{UserID: ivan.ID}), http.StatusBadRequest, nil)
+	testutil.Decode(t, testutil.DoAs(t, router, ivanToken, http.MethodPost, "/user/block", BlockUserStruct{UserID: petr.ID}), http.StatusOK, nil)
+
+	var blocked struct {
+		Data []Profile `json:"data"`
+	}
+	testutil.Decode(t, testutil.DoAs(t, router, ivanToken, http.MethodGet, "/user/blocked", nil), http.StatusOK, &blocked)
+	if len(blocked.Data) != 1 || blocked.Data[0].UserID != petr.ID {
+		t.Fatalf("unexpected block list: %+v", blocked.Data)
+	}
+
+	// Заблокированный не может писать и создавать чат, а заблокировавший — может
+	send(petrToken, http.StatusForbidden)
+	rec = testutil.DoAs(t, router, petrToken, http.MethodPost, "/chats/create-chat", chats.CreateChatStruct{Companion_id: ivan.ID})
+	testutil.Decode(t, rec, http.StatusForbidden, nil)
+	send(ivanToken, http.StatusOK)
+
+	type found struct {
+		Data []chats.FoundUser `json:"data"`
+	}
+	var petrSearch, ivanSearch found
+	testutil.Decode(t, testutil.DoAs(t, router, petrToken, http.MethodGet, "/chats/find-chats?search_term=ivan", nil), http.StatusOK, &petrSearch)
+	if len(petrSearch.Data) != 0 {
+		t.Fatalf("blocker is visible in search: %+v", petrSearch.Data)
+	}
+	testutil.Decode(t, testutil.DoAs(t, router, ivanToken, http.MethodGet, "/chats/find-chats?search_term=petr", nil), http.StatusOK, &ivanSearch)
+	if len(ivanSearch.Data) != 1 {
+		t.Fatalf("blocked user is missing from blocker's search: %+v", ivanSearch.Data)
+	}
+
+	testutil.Decode(t, testutil.DoAs(t, router, ivanToken, http.MethodPost, "/user/unblock", BlockUserStruct{UserID: petr.ID}), http.StatusOK, nil)
+	send(petrToken, http.StatusOK)
+}
+
+func TestReportMessage(t *testing.T) {
+	repos, router := testutil.Setup(t)
+	UsersRouter(router)
+	chats.ChatRouter(router)
+	messages.MessageRouter(router)
+
+	ivan, ivanToken := testutil.CreateUser(t, repos, "ivan")
+	petr, petrToken := testutil.CreateUser(t, repos, "petr")
+	_, sidorToken := testutil.CreateUser(t, repos, "sidor")
+
+	var created struct {
+		ChatID gocql.UUID `json:"chat_id"`
+	}
+	rec := testutil.DoAs(t, router, petrToken, http.MethodPost, "/chats/create-chat", chats.CreateChatStruct{Companion_id: ivan.ID})
+	testutil.Decode(t, rec, http.StatusOK, &created)
+	rec = testutil.DoAs(t, router, petrToken, http.MethodPost, "/messages/add-message", messages.AddMessageStruct{ChatID: created.ChatID.String(), MessageText: "купи слона"})
+	testutil.Decode(t, rec, http.StatusOK, nil)
+
+	var history []messages.Message
+	rec = testutil.DoAs(t, router, ivanToken, http.MethodGet, "/messages/get-messages?chat_id="+created.ChatID.String(), nil)
+	testutil.Decode(t, rec, http.StatusOK, &history)
+	if len(history) != 2 || history[1].Kind != chats.SystemChatCreated {
+		t.Fatalf("got %d messages, want chat_created and 1 message", len(history))
+	}
+
+	report := ReportStruct{ChatID: created.ChatID.String(), MessageID: history[0].MessageID.String(), Reason: "спам"}
+	var response struct {
+		ReportID uint `json:"report_id"`
+	}
+	testutil.Decode(t, testutil.DoAs(t, router, ivanToken, http.MethodPost, "/user/report", report), http.StatusOK, &response)
+	if response.ReportID == 0 {
+		t.Fatal("report_id is missing")
+	}
+
+	// Жаловаться на своё сообщение, служебное сообщение или сообщение из чужого чата нельзя
+	systemReport := ReportStruct{ChatID: created.ChatID.String(), MessageID: history[1].MessageID.String(), Reason: "спам"}
+	testutil.Decode(t, testutil.DoAs(t, router, ivanToken, http.MethodPost, "/user/report", systemReport), http.StatusBadRequest, nil)
+	testutil.Decode(t, testutil.DoAs(t, router, petrToken, http.MethodPost, "/user/report", report), http.StatusBadRequest, nil)
+	testutil.Decode(t, testutil.DoAs(t, router, sidorToken, http.MethodPost, "/user/report", report), http.StatusForbidden, nil)
+
+	testutil.Decode(t, testutil.DoAs(t, router, ivanToken, http.MethodPost, "/user/report", ReportStruct{UserID: petr.ID, Reason: " "}), http.StatusBadRequest, nil)
+	testutil.Decode(t, testutil.DoAs(t, router, ivanToken, http.MethodPost, "/user/report", ReportStruct{UserID: petr.ID, Reason: "оскорбления"}), http.StatusOK, nil)
+}
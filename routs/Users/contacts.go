@@ -0,0 +1,286 @@
+package users
+
+import (
+	"Bmessage_backend/middleware"
+	"Bmessage_backend/models"
+	"Bmessage_backend/repository"
+	"Bmessage_backend/routs/chats"
+	"log"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	maxCustomNameLength = 64
+	maxImportNiks       = 500
+)
+
+// Contact — контакт пользователя с его публичными данными
+type Contact struct {
+	Profile
+	CustomName string `json:"custom_name,omitempty"`
+}
+
+// pushContactChat отправляет владельцу обновлённый чат с контактом, если чат есть:
+// имя собеседника в списке чатов зависит от CustomName
+func pushContactChat(repos *repository.Repositories, ownerID, contactID uint) {
+	chat, err := repos.Chats.FindChatByCompanion(ownerID, contactID)
+	if err != nil {
+		if err != repository.ErrNotFound {
+			log.Println("Failed to find chat with contact:", err)
+		}
+		return
+	}
+	chats.UpdeteDataChat(ownerID, chat.ChatID)
+}
+
+// @Tags Users
+// getContacts godoc
+// @Summary Список контактов
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} map[string]interface{} "successful response"
+// @Failure 401 {object} map[string]interface{} "unauthorized"
+// @Router /user/contacts [get]
+func getContacts(repos *repository.Repositories, c *gin.Context) {
+	contacts, err := repos.Contacts.ListContacts(middleware.UserID(c))
+	if err != nil {
+		log.Println("Failed to fetch contacts:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Внутренняя ошибка сервера"})
+		return
+	}
+
+	ids := make([]uint, 0, len(contacts))
+	for _, contact := range contacts {
+		ids = append(ids, contact.ContactID)
+	}
+	users, err := repos.Users.GetUsers(ids)
+	if err != nil {
+		log.Println("Failed to fetch users:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Внутренняя ошибка сервера"})
+		return
+	}
+	byID := make(map[uint]models.User, len(users))
+	for _, user := range users {
+		byID[user.ID] = user
+	}
+
+	result := make([]Contact, 0, len(contacts))
+	for _, contact := range contacts {
+		if user, ok := byID[contact.ContactID]; ok {
+			result = append(result, Contact{Profile: profileFromUser(user), CustomName: contact.CustomName})
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": result})
+}
+
+// AddContactStruct represents the JSON
+// @Description Добавление контакта. custom_name заменяет имя контакта в списке чатов; пустое — убирает замену.
+type AddContactStruct struct {
+	UserID     uint   `json:"user_id"`
+	CustomName string `json:"custom_name,omitempty"`
+}
+
+// @Tags Users
+// addContact godoc
+// @Summary Добавление контакта или изменение его имени
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param data body AddContactStruct true "Контакт"
+// @Success 200 {object} map[string]interface{} "successful response"
+// @Failure 400 {object} map[string]interface{} "bad request"
+// @Failure 401 {object} map[string]interface{} "unauthorized"
+// @Failure 404 {object} map[string]interface{} "not found"
+// @Router /user/contacts/add [post]
+func addContact(repos *repository.Repositories, c *gin.Context) {
+	var contactData AddContactStruct
+	if err := c.BindJSON(&contactData); err != nil || contactData.UserID == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверные входные данные"})
+		return
+	}
+
+	customName := strings.TrimSpace(contactData.CustomName)
+	if utf8.RuneCountInString(customName) > maxCustomNameLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Слишком длинное имя контакта"})
+		return
+	}
+
+	userID := middleware.UserID(c)
+	if contactData.UserID == userID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Нельзя добавить себя в контакты"})
+		return
+	}
+	if _, err := repos.Users.GetUser(contactData.UserID); err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Пользователь не найден"})
+		return
+	}
+
+	contact := models.Contact{OwnerID: userID, ContactID: contactData.UserID, CustomName: customName}
+	if err := repos.Contacts.AddContact(contact); err != nil {
+		log.Println("Failed to add contact:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Внутренняя ошибка сервера"})
+		return
+	}
+
+	pushContactChat(repos, userID, contactData.UserID)
+	c.JSON(http.StatusOK, gin.H{"message": "Контакт сохранён"})
+}
+
+// RemoveContactStruct represents the JSON
+// @Description Удаление контакта
+type RemoveContactStruct struct {
+	UserID uint `json:"user_id"`
+}
+
+// @Tags Users
+// removeContact godoc
+// @Summary Удаление контакта
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param data body RemoveContactStruct true "Контакт"
+// @Success 200 {object} map[string]interface{} "successful response"
+// @Failure 400 {object} map[string]interface{} "bad request"
+// @Failure 401 {object} map[string]interface{} "unauthorized"
+// @Router /user/contacts/remove [post]
+func removeContact(repos *repository.Repositories, c *gin.Context) {
+	var contactData RemoveContactStruct
+	if err := c.BindJSON(&contactData); err != nil || contactData.UserID == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверные входные данные"})
+		return
+	}
+
+	userID := middleware.UserID(c)
+	if err := repos.Contacts.RemoveContact(userID, contactData.UserID); err != nil {
+		log.Println("Failed to remove contact:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Внутренняя ошибка сервера"})
+		return
+	}
+
+	pushContactChat(repos, userID, contactData.UserID)
+	c.JSON(http.StatusOK, gin.H{"message": "Контакт удалён"})
+}
+
+// ImportContactsStruct represents the JSON
+// @Description Импорт контактов по списку ников, не больше 500
+type ImportContactsStruct struct {
+	Niks []string `json:"niks"`
+}
+
+// @Tags Users
+// importContacts godoc
+// @Summary Импорт контактов по никам
+// @Description Найденные пользователи добавляются в контакты; имена уже существующих контактов не меняются.
+// @Description В ответе — добавленные контакты и ники, которые не найдены.
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param data body ImportContactsStruct true "Ники"
+// @Success 200 {object} map[string]interface{} "successful response"
+// @Failure 400 {object} map[string]interface{} "bad request"
+// @Failure 401 {object} map[string]interface{} "unauthorized"
+// @Router /user/contacts/import [post]
+func importContacts(repos *repository.Repositories, c *gin.Context) {
+	var importData ImportContactsStruct
+	if err := c.BindJSON(&importData); err != nil || len(importData.Niks) == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверные входные данные"})
+		return
+	}
+	if len(importData.Niks) > maxImportNiks {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Слишком много ников"})
+		return
+	}
+
+	userID := middleware.UserID(c)
+
+	var niks []string
+	requested := make(map[string]bool)
+	for _, nik := range importData.Niks {
+		nik = strings.TrimSpace(nik)
+		if nik != "" && !requested[nik] {
+			requested[nik] = true
+			niks = append(niks, nik)
+		}
+	}
+
+	users, err := repos.Users.GetUsersByNiks(niks)
+	if err != nil {
+		log.Println("Failed to find users by nik:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Внутренняя ошибка сервера"})
+		return
+	}
+
+	added := make([]Contact, 0, len(users))
+	found := make(map[string]bool)
+	for _, user := range users {
+		found[user.Nik] = true
+		if user.ID == userID {
+			continue
+		}
+
+		contact, err := repos.Contacts.GetContact(userID, user.ID)
+		if err == nil {
+			added = append(added, Contact{Profile: profileFromUser(user), CustomName: contact.CustomName})
+			continue
+		}
+		if err != repository.ErrNotFound {
+			log.Println("Failed to fetch contact:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Внутренняя ошибка сервера"})
+			return
+		}
+		if err := repos.Contacts.AddContact(models.Contact{OwnerID: userID, ContactID: user.ID}); err != nil {
+			log.Println("Failed to add contact:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Внутренняя ошибка сервера"})
+			return
+		}
+		added = append(added, Contact{Profile: profileFromUser(user)})
+	}
+
+	notFound := make([]string, 0)
+	for _, nik := range niks {
+		if !found[nik] {
+			notFound = append(notFound, nik)
+		}
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": added, "not_found": notFound})
+}
+
+// PrivacyStruct represents the JSON
+// @Description Настройки приватности
+type PrivacyStruct struct {
+	// Начинать чат могут только контакты. Уже существующие чаты продолжают работать.
+	ContactsOnly bool `json:"contacts_only"`
+}
+
+// @Tags Users
+// updatePrivacy godoc
+// @Summary Настройки приватности
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param data body PrivacyStruct true "Настройки"
+// @Success 200 {object} map[string]interface{} "successful response"
+// @Failure 400 {object} map[string]interface{} "bad request"
+// @Failure 401 {object} map[string]interface{} "unauthorized"
+// @Router /user/privacy [post]
+func updatePrivacy(repos *repository.Repositories, c *gin.Context) {
+	var privacyData PrivacyStruct
+	if err := c.BindJSON(&privacyData); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверные входные данные"})
+		return
+	}
+
+	if err := repos.Users.SetContactsOnly(middleware.UserID(c), privacyData.ContactsOnly); err != nil {
+		log.Println("Failed to update privacy settings:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Внутренняя ошибка сервера"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Настройки сохранены"})
+}
@@ -0,0 +1,81 @@
+package users
+
+import (
+	"Bmessage_backend/routs/chats"
+	"Bmessage_backend/testutil"
+	"net/http"
+	"testing"
+)
+
+func TestContacts(t *testing.T) {
+	repos, router := testutil.Setup(t)
+	UsersRouter(router)
+	chats.ChatRouter(router)
+
+	ivan, ivanToken := testutil.CreateUser(t, repos, "ivan")
+	petr, _ := testutil.CreateUser(t, repos, "petr")
+	testutil.CreateUser(t, repos, "sidor")
+
+	var imported struct {
+		Data     []Contact `json:"data"`
+		NotFound []string  `json:"not_found"`
+	}
+	rec := testutil.DoAs(t, router, ivanToken, http.MethodPost, "/user/contacts/import", ImportContactsStruct{Niks: []string{"petr", "sidor", "petr", "nobody", "ivan"}})
+	testutil.Decode(t, rec, http.StatusOK, &imported)
+	if len(imported.Data) != 2 || len(imported.NotFound) != 1 || imported.NotFound[0] != "nobody" {
+		t.Fatalf("unexpected import result: %+v", imported)
+	}
+
+	rec = testutil.DoAs(t, router, ivanToken, http.MethodPost, "/user/contacts/add", AddContactStruct{UserID: petr.ID, CustomName: "Петя"})
+	testutil.Decode(t, rec, http.StatusOK, nil)
+	testutil.Decode(t, testutil.DoAs(t, router, ivanToken, http.MethodPost, "/user/contacts/add", AddContactStruct{UserID: ivan.ID}), http.StatusBadRequest, nil)
+
+	var contacts struct {
+		Data []Contact `json:"data"`
+	}
+	testutil.Decode(t, testutil.DoAs(t, router, ivanToken, http.MethodGet, "/user/contacts", nil), http.StatusOK, &contacts)
+	if len(contacts.Data) != 2 || contacts.Data[0].UserID != petr.ID || contacts.Data[0].CustomName != "Петя" {
+		t.Fatalf("unexpected contacts: %+v", contacts.Data)
+	}
+
+	// Имя контакта заменяет имя собеседника в списке чатов
+	rec = testutil.DoAs(t, router, ivanToken, http.MethodPost, "/chats/create-chat", chats.CreateChatStruct{Companion_id: petr.ID})
+	testutil.Decode(t, rec, http.StatusOK, nil)
+	var list struct {
+		Chats []chats.Chat `json:"chats"`
+	}
+	testutil.Decode(t, testutil.DoAs(t, router, ivanToken, http.MethodGet, "/chats/get-chats", nil), http.StatusOK, &list)
+	if len(list.Chats) != 1 || list.Chats[0].CompanionName != "Петя" {
+		t.Fatalf("custom name is not applied: %+v", list.Chats)
+	}
+
+	testutil.Decode(t, testutil.DoAs(t, router, ivanToken, http.MethodPost, "/user/contacts/remove", RemoveContactStruct{UserID: petr.ID}), http.StatusOK, nil)
+	testutil.Decode(t, testutil.DoAs(t, router, ivanToken, http.MethodGet, "/user/contacts", nil), http.StatusOK, &contacts)
+	if len(contacts.Data) != 1 {
+		t.Fatalf("contact was not removed: %+v", contacts.Data)
+	}
+}
+
+func TestContactsOnlyPrivacy(t *testing.T) {
+	repos, router := testutil.Setup(t)
+	UsersRouter(router)
+	chats.ChatRouter(router)
+
+	ivan, ivanToken := testutil.CreateUser(t, repos, "ivan")
+	petr, petrToken := testutil.CreateUser(t, repos, "petr")
+
+	testutil.Decode(t, testutil.DoAs(t, router, ivanToken, http.MethodPost, "/user/privacy", PrivacyStruct{ContactsOnly: true}), http.StatusOK, nil)
+
+	var own Profile
+	testutil.Decode(t, testutil.DoAs(t, router, ivanToken, http.MethodGet, "/user/profile", nil), http.StatusOK, &own)
+	if !own.ContactsOnly {
+		t.Fatal("contacts_only is not shown in own profile")
+	}
+
+	rec := testutil.DoAs(t, router, petrToken, http.MethodPost, "/chats/create-chat", chats.CreateChatStruct{Companion_id: ivan.ID})
+	testutil.Decode(t, rec, http.StatusForbidden, nil)
+
+	testutil.Decode(t, testutil.DoAs(t, router, ivanToken, http.MethodPost, "/user/contacts/add", AddContactStruct{UserID: petr.ID}), http.StatusOK, nil)
+	rec = testutil.DoAs(t, router, petrToken, http.MethodPost, "/chats/create-chat", chats.CreateChatStruct{Companion_id: ivan.ID})
+	testutil.Decode(t, rec, http.StatusOK, nil)
+}
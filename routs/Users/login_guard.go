@@ -0,0 +1,104 @@
+package users
+
+import (
+	"context"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Защита входа от перебора. Неудачные попытки считаются отдельно по логину и по IP
+// в окне loginFailureWindow. После порога каждая следующая неудача блокирует вход
+// на вдвое больший срок, от lockoutBase до lockoutMax.
+const (
+	loginFailureWindow = time.Hour
+	maxLoginFailures   = 5
+	maxIPFailures      = 20
+	lockoutBase        = 30 * time.Second
+	lockoutMax         = time.Hour
+)
+
+func loginFailuresKey(login string) string { return "login_failures_login_" + login }
+func ipFailuresKey(ip string) string       { return "login_failures_ip_" + ip }
+func loginLockKey(login string) string     { return "login_lock_login_" + login }
+func ipLockKey(ip string) string           { return "login_lock_ip_" + ip }
+
+// lockoutDuration возвращает срок блокировки после excess неудач сверх порога
+func lockoutDuration(excess int64) time.Duration {
+	if excess >= 12 {
+		return lockoutMax
+	}
+	return min(lockoutBase<<excess, lockoutMax)
+}
+
+// loginLockedFor возвращает, сколько ещё заблокирован вход для логина или IP; 0 — не заблокирован
+func loginLockedFor(ctx context.Context, client *redis.Client, login, ip string) (time.Duration, error) {
+	pipe := client.Pipeline()
+	loginTTL := pipe.PTTL(ctx, loginLockKey(login))
+	ipTTL := pipe.PTTL(ctx, ipLockKey(ip))
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, err
+	}
+	return max(loginTTL.Val(), ipTTL.Val(), 0), nil
+}
+
+// registerLoginFailure учитывает неудачную попытку и возвращает срок блокировки,
+// если порог превышен
+func registerLoginFailure(ctx context.Context, client *redis.Client, login, ip string) (time.Duration, error) {
+	pipe := client.TxPipeline()
+	loginFailures := pipe.Incr(ctx, loginFailuresKey(login))
+	pipe.Expire(ctx, loginFailuresKey(login), loginFailureWindow)
+	ipFailures := pipe.Incr(ctx, ipFailuresKey(ip))
+	pipe.Expire(ctx, ipFailuresKey(ip), loginFailureWindow)
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, err
+	}
+
+	var lockout time.Duration
+	lock := func(key string, failures, threshold int64) error {
+		if failures < threshold {
+			return nil
+		}
+		duration := lockoutDuration(failures - threshold)
+		lockout = max(lockout, duration)
+		return client.Set(ctx, key, strconv.FormatInt(failures, 10), duration).Err()
+	}
+	if err := lock(loginLockKey(login), loginFailures.Val(), maxLoginFailures); err != nil {
+		return 0, err
+	}
+	if err := lock(ipLockKey(ip), ipFailures.Val(), maxIPFailures); err != nil {
+		return 0, err
+	}
+	return lockout, nil
+}
+
+// resetLoginFailures сбрасывает счётчик логина после успешного входа. Счётчик IP
+// не сбрасывается, иначе вход в свой аккаунт обнулял бы перебор чужих.
+func resetLoginFailures(ctx context.Context, client *redis.Client, login string) error {
+	return client.Del(ctx, loginFailuresKey(login)).Err()
+}
+
+// respondTooManyAttempts отвечает 429 с заголовком Retry-After
+func respondTooManyAttempts(c *gin.Context, retryAfter time.Duration) {
+	respondTooManyRequests(c, retryAfter, "Слишком много попыток входа, повторите позже")
+}
+
+// respondTooManyRequests отвечает 429 с сообщением message и заголовком Retry-After
+func respondTooManyRequests(c *gin.Context, retryAfter time.Duration, message string) {
+	seconds := int(math.Ceil(retryAfter.Seconds()))
+	c.Header("Retry-After", strconv.Itoa(seconds))
+	c.JSON(http.StatusTooManyRequests, gin.H{"error": message, "retry_after": seconds})
+}
+
+// dummyPasswordHash сравнивается с паролем, когда логин не найден, чтобы время
+// ответа не выдавало существование пользователя
+var dummyPasswordHash = sync.OnceValue(func() []byte {
+	hash, _ := bcrypt.GenerateFromPassword([]byte("dummy-password"), bcrypt.DefaultCost)
+	return hash
+})
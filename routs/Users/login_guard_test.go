@@ -0,0 +1,124 @@
+package users
+
+import (
+	"Bmessage_backend/audit"
+	"Bmessage_backend/testutil"
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+type recordingAudit struct {
+	mu     sync.Mutex
+	events []audit.Event
+}
+
+func (a *recordingAudit) Record(ctx context.Context, event audit.Event) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.events = append(a.events, event)
+	return nil
+}
+
+func loginAttempt(t *testing.T, router *gin.Engine, client *testutil.Client, ip, loginName, password string) (int, map[string]interface{}, http.Header) {
+	t.Helper()
+	rec := testutil.DoFrom(t, router, ip, http.MethodPost, "/user/log-in-with-credentials", UserLogin{
+		Uuid:     client.Uuid,
+		PKey:     client.PKey(t),
+		Login:    client.Encrypt(t, loginName),
+		Password: client.Encrypt(t, password),
+	})
+	var body map[string]interface{}
+	testutil.Decode(t, rec, rec.Code, &body)
+	return rec.Code, body, rec.Header()
+}
+
+func TestLoginErrorsAreUniform(t *testing.T) {
+	router := newPasswordRouter(t)
+	records := &recordingAudit{}
+	t.Cleanup(audit.Use(records))
+
+	client := testutil.NewClient(t, router)
+	testutil.Decode(t, testutil.Do(t, router, http.MethodPost, "/user/registration", registration(t, client, "ivan", "ivan_login", "password-1")), http.StatusOK, nil)
+
+	unknownStatus, unknownBody, _ := loginAttempt(t, router, client, "10.0.0.1", "nobody", "password-1")
+	wrongStatus, wrongBody, _ := loginAttempt(t, router, client, "10.0.0.1", "ivan_login", "wrong-password")
+	if unknownStatus != http.StatusUnauthorized || wrongStatus != http.StatusUnauthorized || unknownBody["error"] != wrongBody["error"] {
+		t.Fatalf("responses differ: %d %v / %d %v", unknownStatus, unknownBody, wrongStatus, wrongBody)
+	}
+
+	if len(records.events) != 2 ||
+		records.events[0].Type != audit.LoginFailed || records.events[0].Reason != "unknown_login" ||
+		records.events[1].Reason != "wrong_password" || records.events[1].IP != "10.0.0.1" || records.events[1].UserID == 0 {
+		t.Fatalf("unexpected audit events: %+v", records.events)
+	}
+}
+
+func TestLoginLockout(t *testing.T) {
+	router := newPasswordRouter(t)
+	t.Cleanup(audit.Use(&recordingAudit{}))
+
+	client := testutil.NewClient(t, router)
+	testutil.Decode(t, testutil.Do(t, router, http.MethodPost, "/user/registration", registration(t, client, "ivan", "ivan_login", "password-1")), http.StatusOK, nil)
+
+	// Успешный вход сбрасывает счётчик логина
+	for i := 0; i < maxLoginFailures-1; i++ {
+		if status, _, _ := loginAttempt(t, router, client, "10.0.0.1", "ivan_login", "wrong-password"); status != http.StatusUnauthorized {
+			t.Fatalf("attempt %d: status %d", i, status)
+		}
+	}
+	if status, _, _ := loginAttempt(t, router, client, "10.0.0.1", "ivan_login", "password-1"); status != http.StatusOK {
+		t.Fatalf("correct password rejected: %d", status)
+	}
+
+	for i := 0; i < maxLoginFailures-1; i++ {
+		if status, _, _ := loginAttempt(t, router, client, "10.0.0.2", "ivan_login", "wrong-password"); status != http.StatusUnauthorized {
+			t.Fatalf("attempt %d after reset: status %d", i, status)
+		}
+	}
+	status, _, header := loginAttempt(t, router, client, "10.0.0.2", "ivan_login", "wrong-password")
+	if status != http.StatusTooManyRequests || header.Get("Retry-After") != strconv.Itoa(int(lockoutBase.Seconds())) {
+		t.Fatalf("threshold attempt: status %d, Retry-After %q", status, header.Get("Retry-After"))
+	}
+
+	// Во время блокировки не принимается даже верный пароль, в том числе с другого IP
+	if status, _, _ := loginAttempt(t, router, client, "10.0.0.3", "ivan_login", "password-1"); status != http.StatusTooManyRequests {
+		t.Fatalf("login accepted during lockout: %d", status)
+	}
+}
+
+func TestLoginLockoutByIP(t *testing.T) {
+	router := newPasswordRouter(t)
+	t.Cleanup(audit.Use(&recordingAudit{}))
+
+	client := testutil.NewClient(t, router)
+	testutil.Decode(t, testutil.Do(t, router, http.MethodPost, "/user/registration", registration(t, client, "ivan", "ivan_login", "password-1")), http.StatusOK, nil)
+
+	// Перебор разных логинов с одного адреса
+	for i := 0; i < maxIPFailures-1; i++ {
+		if status, _, _ := loginAttempt(t, router, client, "10.0.0.1", "user_"+strconv.Itoa(i), "password"); status != http.StatusUnauthorized {
+			t.Fatalf("attempt %d: status %d", i, status)
+		}
+	}
+	if status, _, _ := loginAttempt(t, router, client, "10.0.0.1", "another", "password"); status != http.StatusTooManyRequests {
+		t.Fatalf("IP not locked after %d failures: %d", maxIPFailures, status)
+	}
+	if status, _, _ := loginAttempt(t, router, client, "10.0.0.1", "ivan_login", "password-1"); status != http.StatusTooManyRequests {
+		t.Fatalf("locked IP logged in: %d", status)
+	}
+	if status, _, _ := loginAttempt(t, router, client, "10.0.0.2", "ivan_login", "password-1"); status != http.StatusOK {
+		t.Fatalf("other IP rejected: %d", status)
+	}
+}
+
+func TestLockoutDuration(t *testing.T) {
+	for excess, want := range map[int64]string{0: "30s", 1: "1m0s", 3: "4m0s", 7: "1h0m0s", 40: "1h0m0s"} {
+		if got := lockoutDuration(excess).String(); got != want {
+			t.Fatalf("lockoutDuration(%d) = %s, want %s", excess, got, want)
+		}
+	}
+}
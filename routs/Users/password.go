@@ -0,0 +1,358 @@
+package users
+
+import (
+	"Bmessage_backend/database"
+	"Bmessage_backend/handshake"
+	"Bmessage_backend/middleware"
+	"Bmessage_backend/notify"
+	"Bmessage_backend/repository"
+	"Bmessage_backend/sessions"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Неверные коды сброса считаются по пользователю в окне resetAttemptsWindow, а не по коду:
+// запрос нового кода не возвращает попытки. Запросы кода ограничены по логину и по IP.
+const (
+	minPasswordLength     = 8
+	resetCodeTTL          = 15 * time.Minute
+	maxResetCodeAttempts  = 5
+	resetAttemptsWindow   = time.Hour
+	resetRequestCooldown  = time.Minute
+	resetRequestWindow    = time.Hour
+	maxResetRequestsPerIP = 10
+)
+
+func resetCodeKey(userID uint) string {
+	return "password_reset_" + strconv.FormatUint(uint64(userID), 10)
+}
+
+func resetAttemptsKey(userID uint) string {
+	return "password_reset_attempts_" + strconv.FormatUint(uint64(userID), 10)
+}
+
+func resetRequestLoginKey(login string) string { return "password_reset_request_login_" + login }
+func resetRequestIPKey(ip string) string       { return "password_reset_request_ip_" + ip }
+
+// resetRequestAllowed учитывает запрос кода и возвращает, через сколько можно
+// повторить запрос; 0 — запрос разрешён. Ограничение действует и для несуществующих
+// логинов, чтобы ответ не выдавал существование пользователя.
+func resetRequestAllowed(ctx context.Context, client *redis.Client, login, ip string) (time.Duration, error) {
+	pipe := client.TxPipeline()
+	ipRequests := pipe.Incr(ctx, resetRequestIPKey(ip))
+	pipe.Expire(ctx, resetRequestIPKey(ip), resetRequestWindow)
+	ipTTL := pipe.PTTL(ctx, resetRequestIPKey(ip))
+	if _, err := pipe.Exec(ctx); err != nil {
+		return 0, err
+	}
+	if ipRequests.Val() > maxResetRequestsPerIP {
+		return max(ipTTL.Val(), time.Second), nil
+	}
+
+	allowed, err := client.SetNX(ctx, resetRequestLoginKey(login), 1, resetRequestCooldown).Result()
+	if err != nil || allowed {
+		return 0, err
+	}
+	retryAfter, err := client.PTTL(ctx, resetRequestLoginKey(login)).Result()
+	if err != nil {
+		return 0, err
+	}
+	return max(retryAfter, time.Second), nil
+}
+
+// hashCode возвращает sha256 одноразового кода: в хранилище коды не попадают в открытом виде
+func hashCode(code string) string {
+	sum := sha256.Sum256([]byte(code))
+	return hex.EncodeToString(sum[:])
+}
+
+// openHandshake расшифровывает поля запроса по схеме, определённой uuid: ключом
+// token/key-exchange, ключом token/generateToken или без шифрования по TLS
+func openHandshake(c *gin.Context, uuid string, fields ...*string) (*handshake.Session, error) {
+	return handshake.DecryptFields(c.Request.Context(), c.Request, uuid, fields...)
+}
+
+// ChangePasswordStruct represents the JSON
+// @Description Смена пароля. Пароли зашифрованы ключом из token/generateToken.
+type ChangePasswordStruct struct {
+	Uuid            string `json:"uuid"`
+	PKey            string `json:"pKey"`
+	CurrentPassword string `json:"current_password"`
+	NewPassword     string `json:"new_password"`
+}
+
+// @Tags Users
+// changePassword godoc
+// @Summary Смена пароля
+// @Description Требует текущий пароль. Все остальные сессии пользователя завершаются, в ответе — новый токен текущей сессии.
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param data body ChangePasswordStruct true "Текущий и новый пароль"
+// @Success 200 {object} map[string]interface{} "successful response"
+// @Failure 400 {object} map[string]interface{} "bad request"
+// @Failure 401 {object} map[string]interface{} "wrong password"
+// @Router /user/change-password [post]
+func changePassword(repos *repository.Repositories, c *gin.Context) {
+	var passwordData ChangePasswordStruct
+	if err := c.BindJSON(&passwordData); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверные входные данные"})
+		return
+	}
+
+	session, err := openHandshake(c, passwordData.Uuid, &passwordData.CurrentPassword, &passwordData.NewPassword)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Ошибка дешифрования пароля"})
+		return
+	}
+
+	if len(passwordData.NewPassword) < minPasswordLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Пароль должен быть не короче %d символов", minPasswordLength)})
+		return
+	}
+
+	user, err := repos.Users.GetUser(middleware.UserID(c))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Пользователь не найден"})
+		return
+	}
+
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(passwordData.CurrentPassword)); err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Неверный пароль"})
+		return
+	}
+
+	if err := setPassword(repos, user.ID, passwordData.NewPassword); err != nil {
+		log.Println("Failed to change password:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка смены пароля"})
+		return
+	}
+
+	token, err := sessions.RevokeAll(c.Request.Context(), user.ID)
+	if err != nil {
+		log.Println("Failed to revoke sessions:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Внутренняя ошибка сервера"})
+		return
+	}
+
+	сToken, err := session.Seal(token, passwordData.PKey)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Внутренняя ошибка сервера"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Пароль изменён", "token": сToken})
+}
+
+// RequestPasswordResetStruct represents the JSON
+// @Description Запрос кода сброса пароля. Логин зашифрован ключом из token/generateToken.
+type RequestPasswordResetStruct struct {
+	Uuid  string `json:"uuid"`
+	Login string `json:"login"`
+}
+
+// @Tags Users
+// requestPasswordReset godoc
+// @Summary Запрос кода сброса пароля
+// @Description Отправляет одноразовый код на почту пользователя. Ответ не зависит от того, существует ли логин.
+// @Description Код для одного логина можно запросить не чаще раза в минуту, с одного IP — не больше 10 раз в час.
+// @Accept json
+// @Produce json
+// @Param data body RequestPasswordResetStruct true "Логин"
+// @Success 200 {object} map[string]interface{} "successful response"
+// @Failure 400 {object} map[string]interface{} "bad request"
+// @Failure 429 {object} map[string]interface{} "too many requests"
+// @Router /user/request-password-reset [post]
+func requestPasswordReset(repos *repository.Repositories, c *gin.Context) {
+	var resetData RequestPasswordResetStruct
+	if err := c.BindJSON(&resetData); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверные входные данные"})
+		return
+	}
+
+	if _, err := openHandshake(c, resetData.Uuid, &resetData.Login); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Ошибка дешифрования логина"})
+		return
+	}
+
+	client := database.GetRedis()
+	defer client.Close()
+
+	ctx := c.Request.Context()
+	retryAfter, err := resetRequestAllowed(ctx, client, resetData.Login, c.ClientIP())
+	if err != nil {
+		log.Println("Failed to check reset request limit:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Внутренняя ошибка сервера"})
+		return
+	}
+	if retryAfter > 0 {
+		respondTooManyRequests(c, retryAfter, "Слишком много запросов кода, повторите позже")
+		return
+	}
+
+	response := gin.H{"message": "Если аккаунт существует и к нему привязана почта, код отправлен"}
+
+	user, err := repos.Users.GetUserByLogin(resetData.Login)
+	if err != nil || user.Email == "" {
+		c.JSON(http.StatusOK, response)
+		return
+	}
+
+	code, err := rand.Int(rand.Reader, big.NewInt(1_000_000))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Внутренняя ошибка сервера"})
+		return
+	}
+	resetCode := fmt.Sprintf("%06d", code.Int64())
+
+	// Счётчик неверных попыток не сбрасывается: иначе новый код возвращал бы перебор
+	if err := client.Set(ctx, resetCodeKey(user.ID), hashCode(resetCode), resetCodeTTL).Err(); err != nil {
+		log.Println("Failed to store reset code:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Внутренняя ошибка сервера"})
+		return
+	}
+
+	err = notify.Current().Send(ctx, notify.Message{
+		To:      user.Email,
+		Subject: "Код сброса пароля",
+		Body:    fmt.Sprintf("Ваш код сброса пароля: %s\nКод действует %d минут.", resetCode, int(resetCodeTTL.Minutes())),
+	})
+	if err != nil {
+		log.Println("Failed to send reset code:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Не удалось отправить код"})
+		return
+	}
+
+	c.JSON(http.StatusOK, response)
+}
+
+// ResetPasswordStruct represents the JSON
+// @Description Сброс пароля по коду. Все поля зашифрованы ключом из token/generateToken.
+type ResetPasswordStruct struct {
+	Uuid        string `json:"uuid"`
+	Login       string `json:"login"`
+	Code        string `json:"code"`
+	NewPassword string `json:"new_password"`
+}
+
+// @Tags Users
+// resetPassword godoc
+// @Summary Сброс пароля по коду
+// @Description Код одноразовый и действует 15 минут. После 5 неверных кодов за час текущий код аннулируется,
+// @Description а новые коды не принимаются до конца часа. Все сессии пользователя завершаются.
+// @Accept json
+// @Produce json
+// @Param data body ResetPasswordStruct true "Логин, код и новый пароль"
+// @Success 200 {object} map[string]interface{} "successful response"
+// @Failure 400 {object} map[string]interface{} "invalid or expired code"
+// @Router /user/reset-password [post]
+func resetPassword(repos *repository.Repositories, c *gin.Context) {
+	var resetData ResetPasswordStruct
+	if err := c.BindJSON(&resetData); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверные входные данные"})
+		return
+	}
+
+	if _, err := openHandshake(c, resetData.Uuid, &resetData.Login, &resetData.Code, &resetData.NewPassword); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Ошибка дешифрования данных"})
+		return
+	}
+
+	if len(resetData.NewPassword) < minPasswordLength {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("Пароль должен быть не короче %d символов", minPasswordLength)})
+		return
+	}
+
+	invalidCode := gin.H{"error": "Неверный или просроченный код"}
+
+	user, err := repos.Users.GetUserByLogin(resetData.Login)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, invalidCode)
+		return
+	}
+
+	ok, err := consumeResetCode(c.Request.Context(), user.ID, resetData.Code)
+	if err != nil {
+		log.Println("Failed to check reset code:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Внутренняя ошибка сервера"})
+		return
+	}
+	if !ok {
+		c.JSON(http.StatusBadRequest, invalidCode)
+		return
+	}
+
+	if err := setPassword(repos, user.ID, resetData.NewPassword); err != nil {
+		log.Println("Failed to reset password:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка смены пароля"})
+		return
+	}
+
+	if _, err := sessions.RevokeAll(c.Request.Context(), user.ID); err != nil {
+		log.Println("Failed to revoke sessions:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Внутренняя ошибка сервера"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Пароль изменён, войдите с новым паролем"})
+}
+
+// consumeResetScript проверяет код и учитывает попытку одним шагом, поэтому параллельные
+// неверные коды не проскакивают мимо лимита. KEYS: код, счётчик попыток.
+// ARGV: хеш кода, лимит попыток, окно счётчика в мс. Возвращает 1, если код принят и удалён.
+var consumeResetScript = redis.NewScript(`
+local attempts = tonumber(redis.call("GET", KEYS[2]) or "0")
+if attempts >= tonumber(ARGV[2]) then
+	return 0
+end
+local stored = redis.call("GET", KEYS[1])
+if not stored then
+	return 0
+end
+if stored == ARGV[1] then
+	redis.call("DEL", KEYS[1], KEYS[2])
+	return 1
+end
+attempts = redis.call("INCR", KEYS[2])
+if attempts == 1 then
+	redis.call("PEXPIRE", KEYS[2], ARGV[3])
+end
+if attempts >= tonumber(ARGV[2]) then
+	redis.call("DEL", KEYS[1])
+end
+return 0
+`)
+
+// consumeResetCode проверяет код и удаляет его. Код принимается только один раз,
+// а после maxResetCodeAttempts неверных кодов за resetAttemptsWindow не принимается никакой.
+func consumeResetCode(ctx context.Context, userID uint, code string) (bool, error) {
+	client := database.GetRedis()
+	defer client.Close()
+
+	keys := []string{resetCodeKey(userID), resetAttemptsKey(userID)}
+	accepted, err := consumeResetScript.Run(ctx, client, keys, hashCode(code), maxResetCodeAttempts, resetAttemptsWindow.Milliseconds()).Int()
+	if err != nil {
+		return false, err
+	}
+	return accepted == 1, nil
+}
+
+func setPassword(repos *repository.Repositories, userID uint, password string) error {
+	bytesPass, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return err
+	}
+	return repos.Users.UpdatePassword(userID, string(bytesPass))
+}
@@ -0,0 +1,315 @@
+package users
+
+import (
+	"Bmessage_backend/database"
+	"Bmessage_backend/notify"
+	"Bmessage_backend/routs/tokens"
+	"Bmessage_backend/testutil"
+	"context"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sync"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+type recordingNotifier struct {
+	mu       sync.Mutex
+	messages []notify.Message
+}
+
+func (n *recordingNotifier) Send(ctx context.Context, message notify.Message) error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.messages = append(n.messages, message)
+	return nil
+}
+
+func (n *recordingNotifier) lastCode(t *testing.T) string {
+	t.Helper()
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if len(n.messages) == 0 {
+		t.Fatal("no notification was sent")
+	}
+	code := regexp.MustCompile(`\d{6}`).FindString(n.messages[len(n.messages)-1].Body)
+	if code == "" {
+		t.Fatalf("no code in notification %q", n.messages[len(n.messages)-1].Body)
+	}
+	return code
+}
+
+// skipResetCooldown снимает ограничение частоты запросов кода для логина
+func skipResetCooldown(t *testing.T, loginName string) {
+	t.Helper()
+	client := database.GetRedis()
+	defer client.Close()
+	if err := client.Del(context.Background(), resetRequestLoginKey(loginName)).Err(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newPasswordRouter(t *testing.T) *gin.Engine {
+	t.Helper()
+	_, router := testutil.Setup(t)
+	tokens.TokensRouter(router)
+	UsersRouter(router)
+	return router
+}
+
+// login выполняет вход и возвращает расшифрованный токен сессии
+func login(t *testing.T, router *gin.Engine, client *testutil.Client, loginName, password string, status int) string {
+	t.Helper()
+
+	var resp struct {
+		Token string `json:"token"`
+	}
+	rec := testutil.Do(t, router, http.MethodPost, "/user/log-in-with-credentials", UserLogin{
+		Uuid:     client.Uuid,
+		PKey:     client.PKey(t),
+		Login:    client.Encrypt(t, loginName),
+		Password: client.Encrypt(t, password),
+	})
+	testutil.Decode(t, rec, status, &resp)
+	if status != http.StatusOK {
+		return ""
+	}
+	return client.Decrypt(t, resp.Token)
+}
+
+func TestChangePasswordRevokesOtherSessions(t *testing.T) {
+	router := newPasswordRouter(t)
+	client := testutil.NewClient(t, router)
+
+	rec := testutil.Do(t, router, http.MethodPost, "/user/registration", registration(t, client, "ivan", "ivan_login", "old-password"))
+	testutil.Decode(t, rec, http.StatusOK, nil)
+
+	current := login(t, router, client, "ivan_login", "old-password", http.StatusOK)
+	other := login(t, router, client, "ivan_login", "old-password", http.StatusOK)
+
+	rec = testutil.DoAs(t, router, current, http.MethodPost, "/user/change-password", ChangePasswordStruct{
+		Uuid:            client.Uuid,
+		PKey:            client.PKey(t),
+		CurrentPassword: client.Encrypt(t, "wrong-password"),
+		NewPassword:     client.Encrypt(t, "new-password"),
+	})
+	testutil.Decode(t, rec, http.StatusUnauthorized, nil)
+
+	rec = testutil.DoAs(t, router, current, http.MethodPost, "/user/change-password", ChangePasswordStruct{
+		Uuid:            client.Uuid,
+		PKey:            client.PKey(t),
+		CurrentPassword: client.Encrypt(t, "old-password"),
+		NewPassword:     client.Encrypt(t, "short"),
+	})
+	testutil.Decode(t, rec, http.StatusBadRequest, nil)
+
+	var changed struct {
+		Token string `json:"token"`
+	}
+	rec = testutil.DoAs(t, router, current, http.MethodPost, "/user/change-password", ChangePasswordStruct{
+		Uuid:            client.Uuid,
+		PKey:            client.PKey(t),
+		CurrentPassword: client.Encrypt(t, "old-password"),
+		NewPassword:     client.Encrypt(t, "new-password"),
+	})
+	testutil.Decode(t, rec, http.StatusOK, &changed)
+	renewed := client.Decrypt(t, changed.Token)
+
+	testutil.Decode(t, testutil.DoAs(t, router, other, http.MethodGet, "/user/profile", nil), http.StatusUnauthorized, nil)
+	testutil.Decode(t, testutil.DoAs(t, router, current, http.MethodGet, "/user/profile", nil), http.StatusUnauthorized, nil)
+	testutil.Decode(t, testutil.DoAs(t, router, renewed, http.MethodGet, "/user/profile", nil), http.StatusOK, nil)
+
+	login(t, router, client, "ivan_login", "old-password", http.StatusUnauthorized)
+	fresh := login(t, router, client, "ivan_login", "new-password", http.StatusOK)
+	testutil.Decode(t, testutil.DoAs(t, router, fresh, http.MethodGet, "/user/profile", nil), http.StatusOK, nil)
+}
+
+func TestPasswordReset(t *testing.T) {
+	router := newPasswordRouter(t)
+	notifier := &recordingNotifier{}
+	t.Cleanup(notify.Use(notifier))
+
+	client := testutil.NewClient(t, router)
+	rec := testutil.Do(t, router, http.MethodPost, "/user/registration", registration(t, client, "ivan", "ivan_login", "old-password"))
+	testutil.Decode(t, rec, http.StatusOK, nil)
+	session := login(t, router, client, "ivan_login", "old-password", http.StatusOK)
+
+	requestReset := func(loginName string) {
+		t.Helper()
+		skipResetCooldown(t, loginName)
+		rec := testutil.Do(t, router, http.MethodPost, "/user/request-password-reset", RequestPasswordResetStruct{
+			Uuid:  client.Uuid,
+			Login: client.Encrypt(t, loginName),
+		})
+		testutil.Decode(t, rec, http.StatusOK, nil)
+	}
+	reset := func(code, password string, status int) {
+		t.Helper()
+		rec := testutil.Do(t, router, http.MethodPost, "/user/reset-password", ResetPasswordStruct{
+			Uuid:        client.Uuid,
+			Login:       client.Encrypt(t, "ivan_login"),
+			Code:        client.Encrypt(t, code),
+			NewPassword: client.Encrypt(t, password),
+		})
+		testutil.Decode(t, rec, status, nil)
+	}
+
+	// Без почты и для неизвестного логина ответ тот же, но код не отправляется
+	requestReset("ivan_login")
+	requestReset("unknown")
+	if len(notifier.messages) != 0 {
+		t.Fatalf("code sent without email: %+v", notifier.messages)
+	}
+
+	email := "ivan@example.com"
+	rec = testutil.DoAs(t, router, session, http.MethodPost, "/user/update-profile", UpdateProfileStruct{Email: &email})
+	testutil.Decode(t, rec, http.StatusOK, nil)
+
+	requestReset("ivan_login")
+	code := notifier.lastCode(t)
+	if notifier.messages[0].To != email {
+		t.Fatalf("code sent to %q, want %q", notifier.messages[0].To, email)
+	}
+
+	wrong := "000000"
+	if code == wrong {
+		wrong = "111111"
+	}
+	reset(wrong, "new-password", http.StatusBadRequest)
+	reset(code, "new-password", http.StatusOK)
+	reset(code, "another-password", http.StatusBadRequest)
+
+	testutil.Decode(t, testutil.DoAs(t, router, session, http.MethodGet, "/user/profile", nil), http.StatusUnauthorized, nil)
+	login(t, router, client, "ivan_login", "old-password", http.StatusUnauthorized)
+	login(t, router, client, "ivan_login", "new-password", http.StatusOK)
+}
+
+func TestPasswordResetCodeExpiresAfterFailedAttempts(t *testing.T) {
+	router := newPasswordRouter(t)
+	notifier := &recordingNotifier{}
+	t.Cleanup(notify.Use(notifier))
+
+	client := testutil.NewClient(t, router)
+	rec := testutil.Do(t, router, http.MethodPost, "/user/registration", registration(t, client, "ivan", "ivan_login", "old-password"))
+	testutil.Decode(t, rec, http.StatusOK, nil)
+	session := login(t, router, client, "ivan_login", "old-password", http.StatusOK)
+
+	email := "ivan@example.com"
+	testutil.Decode(t, testutil.DoAs(t, router, session, http.MethodPost, "/user/update-profile", UpdateProfileStruct{Email: &email}), http.StatusOK, nil)
+
+	rec = testutil.Do(t, router, http.MethodPost, "/user/request-password-reset", RequestPasswordResetStruct{
+		Uuid:  client.Uuid,
+		Login: client.Encrypt(t, "ivan_login"),
+	})
+	testutil.Decode(t, rec, http.StatusOK, nil)
+	code := notifier.lastCode(t)
+
+	attempt := func(code string) int {
+		return testutil.Do(t, router, http.MethodPost, "/user/reset-password", ResetPasswordStruct{
+			Uuid:        client.Uuid,
+			Login:       client.Encrypt(t, "ivan_login"),
+			Code:        client.Encrypt(t, code),
+			NewPassword: client.Encrypt(t, "new-password"),
+		}).Code
+	}
+
+	wrong := "000000"
+	if code == wrong {
+		wrong = "111111"
+	}
+	for i := 0; i < maxResetCodeAttempts; i++ {
+		if status := attempt(wrong); status != http.StatusBadRequest {
+			t.Fatalf("wrong code attempt %d: status %d", i, status)
+		}
+	}
+	if status := attempt(code); status != http.StatusBadRequest {
+		t.Fatalf("code accepted after %d failed attempts", maxResetCodeAttempts)
+	}
+}
+
+func TestPasswordResetNewCodeKeepsFailedAttempts(t *testing.T) {
+	router := newPasswordRouter(t)
+	notifier := &recordingNotifier{}
+	t.Cleanup(notify.Use(notifier))
+
+	client := testutil.NewClient(t, router)
+	rec := testutil.Do(t, router, http.MethodPost, "/user/registration", registration(t, client, "ivan", "ivan_login", "old-password"))
+	testutil.Decode(t, rec, http.StatusOK, nil)
+	session := login(t, router, client, "ivan_login", "old-password", http.StatusOK)
+
+	email := "ivan@example.com"
+	testutil.Decode(t, testutil.DoAs(t, router, session, http.MethodPost, "/user/update-profile", UpdateProfileStruct{Email: &email}), http.StatusOK, nil)
+
+	requestReset := func(status int) string {
+		t.Helper()
+		rec := testutil.Do(t, router, http.MethodPost, "/user/request-password-reset", RequestPasswordResetStruct{
+			Uuid:  client.Uuid,
+			Login: client.Encrypt(t, "ivan_login"),
+		})
+		testutil.Decode(t, rec, status, nil)
+		if status != http.StatusOK {
+			return ""
+		}
+		return notifier.lastCode(t)
+	}
+	attempt := func(code string) int {
+		return testutil.Do(t, router, http.MethodPost, "/user/reset-password", ResetPasswordStruct{
+			Uuid:        client.Uuid,
+			Login:       client.Encrypt(t, "ivan_login"),
+			Code:        client.Encrypt(t, code),
+			NewPassword: client.Encrypt(t, "new-password"),
+		}).Code
+	}
+	wrongFor := func(code string) string {
+		if code == "000000" {
+			return "111111"
+		}
+		return "000000"
+	}
+
+	code := requestReset(http.StatusOK)
+	// Повторный запрос сразу же отклоняется
+	requestReset(http.StatusTooManyRequests)
+
+	for i := 0; i < maxResetCodeAttempts-1; i++ {
+		if status := attempt(wrongFor(code)); status != http.StatusBadRequest {
+			t.Fatalf("wrong code attempt %d: status %d", i, status)
+		}
+	}
+
+	// Новый код не возвращает израсходованные попытки
+	skipResetCooldown(t, "ivan_login")
+	code = requestReset(http.StatusOK)
+	if status := attempt(wrongFor(code)); status != http.StatusBadRequest {
+		t.Fatalf("last wrong code attempt: status %d", status)
+	}
+	skipResetCooldown(t, "ivan_login")
+	code = requestReset(http.StatusOK)
+	if status := attempt(code); status != http.StatusBadRequest {
+		t.Fatalf("code accepted after %d failed attempts across codes", maxResetCodeAttempts)
+	}
+	login(t, router, client, "ivan_login", "old-password", http.StatusOK)
+}
+
+func TestPasswordResetRequestsAreLimitedPerIP(t *testing.T) {
+	router := newPasswordRouter(t)
+	client := testutil.NewClient(t, router)
+
+	request := func(loginName string) int {
+		return testutil.DoFrom(t, router, "192.0.2.7", http.MethodPost, "/user/request-password-reset", RequestPasswordResetStruct{
+			Uuid:  client.Uuid,
+			Login: client.Encrypt(t, loginName),
+		}).Code
+	}
+	for i := 0; i < maxResetRequestsPerIP; i++ {
+		if status := request(fmt.Sprintf("login%d", i)); status != http.StatusOK {
+			t.Fatalf("request %d: status %d", i, status)
+		}
+	}
+	if status := request("another"); status != http.StatusTooManyRequests {
+		t.Fatalf("request over IP limit: status %d", status)
+	}
+}
@@ -0,0 +1,327 @@
+package users
+
+import (
+	"Bmessage_backend/middleware"
+	"Bmessage_backend/models"
+	"Bmessage_backend/repository"
+	"Bmessage_backend/routs/chats"
+	"io"
+	"log"
+	"net/http"
+	"net/mail"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	avatarURLPrefix = "/avatars"
+	maxAvatarSize   = 5 << 20
+)
+
+// Допустимые форматы аватара и расширения сохраняемых файлов
+var avatarExtensions = map[string]string{
+	"image/jpeg": ".jpg",
+	"image/png":  ".png",
+	"image/gif":  ".gif",
+	"image/webp": ".webp",
+}
+
+func avatarDir() string {
+	if dir := os.Getenv("AVATAR_DIR"); dir != "" {
+		return dir
+	}
+	return filepath.Join("uploads", "avatars")
+}
+
+// Profile — публичные данные пользователя. Login, Email, TwoFactorEnabled и ContactsOnly заполняются только в собственном профиле.
+type Profile struct {
+	UserID           uint   `json:"user_id"`
+	Name             string `json:"name"`
+	SoName           string `json:"soName"`
+	Nik              string `json:"nik"`
+	Avatar           string `json:"avatar,omitempty"`
+	Login            string `json:"login,omitempty"`
+	Email            string `json:"email,omitempty"`
+	TwoFactorEnabled bool   `json:"two_factor_enabled,omitempty"`
+	ContactsOnly     bool   `json:"contacts_only,omitempty"`
+}
+
+// ownProfile — профиль, который пользователь видит о себе
+func ownProfile(user models.User) Profile {
+	profile := profileFromUser(user)
+	profile.Login = user.Login
+	profile.Email = user.Email
+	profile.TwoFactorEnabled = user.TOTPEnabled
+	profile.ContactsOnly = user.ContactsOnly
+	return profile
+}
+
+func profileFromUser(user models.User) Profile {
+	return Profile{
+		UserID: user.ID,
+		Name:   user.Name,
+		SoName: user.SoName,
+		Nik:    user.Nik,
+		Avatar: user.Avatar,
+	}
+}
+
+// @Tags Users
+// getOwnProfile godoc
+// @Summary Профиль текущего пользователя
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} Profile "successful response"
+// @Failure 401 {object} map[string]interface{} "unauthorized"
+// @Router /user/profile [get]
+func getOwnProfile(repos *repository.Repositories, c *gin.Context) {
+	user, err := repos.Users.GetUser(middleware.UserID(c))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Пользователь не найден"})
+		return
+	}
+
+	profile := ownProfile(user)
+	c.JSON(http.StatusOK, profile)
+}
+
+// @Tags Users
+// getProfile godoc
+// @Summary Профиль пользователя по id
+// @Produce json
+// @Security BearerAuth
+// @Param user_id path int true "ID пользователя"
+// @Success 200 {object} Profile "successful response"
+// @Failure 401 {object} map[string]interface{} "unauthorized"
+// @Failure 404 {object} map[string]interface{} "not found"
+// @Router /user/profile/{user_id} [get]
+func getProfile(repos *repository.Repositories, c *gin.Context) {
+	userID, err := strconv.ParseUint(c.Param("user_id"), 10, 64)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid user_id"})
+		return
+	}
+
+	user, err := repos.Users.GetUser(uint(userID))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Пользователь не найден"})
+		return
+	}
+
+	c.JSON(http.StatusOK, profileFromUser(user))
+}
+
+// UpdateProfileStruct represents the JSON
+// @Description Новые данные профиля. Незаданные поля не меняются.
+type UpdateProfileStruct struct {
+	Name   *string `json:"name"`
+	SoName *string `json:"soName"`
+	Nik    *string `json:"nik"`
+	// Почта для кодов сброса пароля. Пустая строка удаляет почту.
+	Email *string `json:"email"`
+}
+
+// @Tags Users
+// updateProfile godoc
+// @Summary Изменение имени, фамилии, ника и почты
+// @Description Ник повторно проверяется на уникальность. Собеседники получают chatUpdate с новыми данными.
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param data body UpdateProfileStruct true "Данные профиля"
+// @Success 200 {object} Profile "successful response"
+// @Failure 400 {object} map[string]interface{} "bad request"
+// @Failure 401 {object} map[string]interface{} "unauthorized"
+// @Failure 409 {object} map[string]interface{} "nik is taken"
+// @Router /user/update-profile [post]
+func updateProfile(repos *repository.Repositories, c *gin.Context) {
+	var profileData UpdateProfileStruct
+	if err := c.BindJSON(&profileData); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверные входные данные"})
+		return
+	}
+
+	user, err := repos.Users.GetUser(middleware.UserID(c))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Пользователь не найден"})
+		return
+	}
+
+	currentNik := user.Nik
+	fields := []struct {
+		value  *string
+		target *string
+	}{
+		{profileData.Name, &user.Name},
+		{profileData.SoName, &user.SoName},
+		{profileData.Nik, &user.Nik},
+	}
+	for _, field := range fields {
+		if field.value == nil {
+			continue
+		}
+		value := strings.TrimSpace(*field.value)
+		if value == "" {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Имя, фамилия и ник не могут быть пустыми"})
+			return
+		}
+		*field.target = value
+	}
+
+	if profileData.Email != nil {
+		email := strings.TrimSpace(*profileData.Email)
+		if email != "" {
+			address, err := mail.ParseAddress(email)
+			if err != nil || address.Address != email {
+				c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный адрес почты"})
+				return
+			}
+		}
+		user.Email = email
+	}
+
+	if user.Nik != currentNik {
+		nikExists, err := repos.Users.NikExists(user.Nik)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка проверки уникальности пользователя"})
+			return
+		}
+		if nikExists {
+			c.JSON(http.StatusConflict, gin.H{"error": "Пользователь с таким ником уже существует"})
+			return
+		}
+	}
+
+	if err := repos.Users.UpdateProfile(&user); err != nil {
+		if err == repository.ErrDuplicate {
+			c.JSON(http.StatusConflict, gin.H{"error": "Пользователь с таким ником уже существует"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка обновления профиля"})
+		return
+	}
+
+	notifyCompanions(repos, user.ID)
+
+	profile := ownProfile(user)
+	c.JSON(http.StatusOK, profile)
+}
+
+// @Tags Users
+// uploadAvatar godoc
+// @Summary Загрузка аватара
+// @Description Принимает JPEG, PNG, GIF или WebP до 5 МБ. Собеседники получают chatUpdate с новым аватаром.
+// @Accept multipart/form-data
+// @Produce json
+// @Security BearerAuth
+// @Param avatar formData file true "Изображение"
+// @Success 200 {object} Profile "successful response"
+// @Failure 400 {object} map[string]interface{} "bad request"
+// @Failure 401 {object} map[string]interface{} "unauthorized"
+// @Failure 413 {object} map[string]interface{} "file too large"
+// @Router /user/upload-avatar [post]
+func uploadAvatar(repos *repository.Repositories, c *gin.Context) {
+	c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, maxAvatarSize+(1<<20))
+
+	fileHeader, err := c.FormFile("avatar")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Файл avatar не передан"})
+		return
+	}
+	if fileHeader.Size > maxAvatarSize {
+		c.JSON(http.StatusRequestEntityTooLarge, gin.H{"error": "Размер аватара не должен превышать 5 МБ"})
+		return
+	}
+
+	file, err := fileHeader.Open()
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Не удалось прочитать файл"})
+		return
+	}
+	defer file.Close()
+
+	head := make([]byte, 512)
+	n, _ := io.ReadFull(file, head)
+	ext, ok := avatarExtensions[http.DetectContentType(head[:n])]
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Поддерживаются только JPEG, PNG, GIF и WebP"})
+		return
+	}
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Не удалось прочитать файл"})
+		return
+	}
+
+	user, err := repos.Users.GetUser(middleware.UserID(c))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Пользователь не найден"})
+		return
+	}
+
+	fileName := strconv.FormatUint(uint64(user.ID), 10) + "_" + uuid.New().String() + ext
+	if err := saveAvatar(fileName, file); err != nil {
+		log.Println("Failed to save avatar:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Не удалось сохранить аватар"})
+		return
+	}
+
+	previous := user.Avatar
+	user.Avatar = avatarURLPrefix + "/" + fileName
+	if err := repos.Users.UpdateProfile(&user); err != nil {
+		os.Remove(filepath.Join(avatarDir(), fileName))
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Ошибка обновления профиля"})
+		return
+	}
+
+	if strings.HasPrefix(previous, avatarURLPrefix+"/") {
+		if err := os.Remove(filepath.Join(avatarDir(), filepath.Base(previous))); err != nil && !os.IsNotExist(err) {
+			log.Println("Failed to remove previous avatar:", err)
+		}
+	}
+
+	notifyCompanions(repos, user.ID)
+
+	profile := ownProfile(user)
+	c.JSON(http.StatusOK, profile)
+}
+
+func saveAvatar(fileName string, src io.Reader) error {
+	if err := os.MkdirAll(avatarDir(), 0o755); err != nil {
+		return err
+	}
+
+	dst, err := os.Create(filepath.Join(avatarDir(), fileName))
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+	return dst.Close()
+}
+
+// notifyCompanions рассылает chatUpdate всем собеседникам пользователя,
+// чтобы их списки чатов подхватили новые имя, ник и аватар
+func notifyCompanions(repos *repository.Repositories, userID uint) {
+	var pageState []byte
+	for {
+		chatRows, nextPageState, err := repos.Chats.ListChats(userID, pageState, 100, nil)
+		if err != nil {
+			log.Println("Failed to list chats for profile update:", err)
+			return
+		}
+		for _, chat := range chatRows {
+			chats.UpdeteDataChat(chat.CompanionID, chat.ChatID)
+		}
+		if len(nextPageState) == 0 {
+			return
+		}
+		pageState = nextPageState
+	}
+}
@@ -0,0 +1,145 @@
+package users
+
+import (
+	"Bmessage_backend/routs/chats"
+	"Bmessage_backend/testutil"
+	"bytes"
+	"image"
+	"image/png"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestGetProfile(t *testing.T) {
+	repos, router := testutil.Setup(t)
+	UsersRouter(router)
+
+	ivan, ivanToken := testutil.CreateUser(t, repos, "ivan")
+	petr, _ := testutil.CreateUser(t, repos, "petr")
+
+	var own Profile
+	testutil.Decode(t, testutil.DoAs(t, router, ivanToken, http.MethodGet, "/user/profile", nil), http.StatusOK, &own)
+	if own.UserID != ivan.ID || own.Nik != "ivan" || own.Login != "ivan" {
+		t.Fatalf("unexpected own profile: %+v", own)
+	}
+
+	var other Profile
+	path := "/user/profile/" + strconv.FormatUint(uint64(petr.ID), 10)
+	testutil.Decode(t, testutil.DoAs(t, router, ivanToken, http.MethodGet, path, nil), http.StatusOK, &other)
+	if other.UserID != petr.ID || other.Nik != "petr" || other.Login != "" {
+		t.Fatalf("unexpected profile of another user: %+v", other)
+	}
+
+	testutil.Decode(t, testutil.DoAs(t, router, ivanToken, http.MethodGet, "/user/profile/999", nil), http.StatusNotFound, nil)
+	testutil.Decode(t, testutil.Do(t, router, http.MethodGet, "/user/profile", nil), http.StatusUnauthorized, nil)
+}
+
+func TestUpdateProfile(t *testing.T) {
+	repos, router := testutil.Setup(t)
+	UsersRouter(router)
+	chats.ChatRouter(router)
+
+	ivan, ivanToken := testutil.CreateUser(t, repos, "ivan")
+	_, petrToken := testutil.CreateUser(t, repos, "petr")
+	testutil.CreateUser(t, repos, "taken")
+
+	rec := testutil.DoAs(t, router, petrToken, http.MethodPost, "/chats/create-chat", chats.CreateChatStruct{Companion_id: ivan.ID})
+	testutil.Decode(t, rec, http.StatusOK, nil)
+
+	taken := "taken"
+	rec = testutil.DoAs(t, router, ivanToken, http.MethodPost, "/user/update-profile", UpdateProfileStruct{Nik: &taken})
+	testutil.Decode(t, rec, http.StatusConflict, nil)
+
+	empty := " "
+	rec = testutil.DoAs(t, router, ivanToken, http.MethodPost, "/user/update-profile", UpdateProfileStruct{Name: &empty})
+	testutil.Decode(t, rec, http.StatusBadRequest, nil)
+
+	// Собственный ник не считается занятым
+	name, nik := "Иван", "ivan"
+	rec = testutil.DoAs(t, router, ivanToken, http.MethodPost, "/user/update-profile", UpdateProfileStruct{Name: &name, Nik: &nik})
+	testutil.Decode(t, rec, http.StatusOK, nil)
+
+	nik = "ivan_new"
+	var updated Profile
+	rec = testutil.DoAs(t, router, ivanToken, http.MethodPost, "/user/update-profile", UpdateProfileStruct{Nik: &nik})
+	testutil.Decode(t, rec, http.StatusOK, &updated)
+	if updated.Name != "Иван" || updated.Nik != "ivan_new" || updated.SoName != "ivan" {
+		t.Fatalf("unexpected updated profile: %+v", updated)
+	}
+
+	var list struct {
+		Chats []chats.Chat `json:"chats"`
+	}
+	testutil.Decode(t, testutil.DoAs(t, router, petrToken, http.MethodGet, "/chats/get-chats", nil), http.StatusOK, &list)
+	if len(list.Chats) != 1 || list.Chats[0].CompanionName != "Иван" || list.Chats[0].CompanionNik != "ivan_new" {
+		t.Fatalf("companion chat list is stale: %+v", list.Chats)
+	}
+}
+
+func avatarRequest(t *testing.T, token string, data []byte) *http.Request {
+	t.Helper()
+
+	var body bytes.Buffer
+	writer := multipart.NewWriter(&body)
+	part, err := writer.CreateFormFile("avatar", "avatar.png")
+	if err != nil {
+		t.Fatal(err)
+	}
+	part.Write(data)
+	writer.Close()
+
+	req := httptest.NewRequest(http.MethodPost, "/user/upload-avatar", &body)
+	req.Header.Set("Content-Type", writer.FormDataContentType())
+	req.Header.Set("Authorization", "Bearer "+token)
+	return req
+}
+
+func serve(router *gin.Engine, req *http.Request) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+func TestUploadAvatar(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("AVATAR_DIR", dir)
+
+	repos, router := testutil.Setup(t)
+	UsersRouter(router)
+
+	_, ivanToken := testutil.CreateUser(t, repos, "ivan")
+
+	var img bytes.Buffer
+	if err := png.Encode(&img, image.NewRGBA(image.Rect(0, 0, 4, 4))); err != nil {
+		t.Fatal(err)
+	}
+
+	testutil.Decode(t, serve(router, avatarRequest(t, ivanToken, []byte("not an image"))), http.StatusBadRequest, nil)
+
+	var first Profile
+	testutil.Decode(t, serve(router, avatarRequest(t, ivanToken, img.Bytes())), http.StatusOK, &first)
+	if filepath.Ext(first.Avatar) != ".png" {
+		t.Fatalf("unexpected avatar url %q", first.Avatar)
+	}
+
+	rec := testutil.Do(t, router, http.MethodGet, first.Avatar, nil)
+	if rec.Code != http.StatusOK || !bytes.Equal(rec.Body.Bytes(), img.Bytes()) {
+		t.Fatalf("avatar is not served: status %d", rec.Code)
+	}
+
+	var second Profile
+	testutil.Decode(t, serve(router, avatarRequest(t, ivanToken, img.Bytes())), http.StatusOK, &second)
+	if second.Avatar == first.Avatar {
+		t.Fatal("avatar url did not change")
+	}
+	if _, err := os.Stat(filepath.Join(dir, filepath.Base(first.Avatar))); !os.IsNotExist(err) {
+		t.Fatalf("previous avatar was not removed: %v", err)
+	}
+}
@@ -0,0 +1,470 @@
+package users
+
+import (
+	"Bmessage_backend/audit"
+	"Bmessage_backend/database"
+	"Bmessage_backend/handshake"
+	"Bmessage_backend/middleware"
+	"Bmessage_backend/models"
+	"Bmessage_backend/repository"
+	"Bmessage_backend/totp"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"log"
+	"math/big"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-redis/redis/v8"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	totpIssuer           = "Bmessage"
+	loginChallengeTTL    = 5 * time.Minute
+	maxChallengeAttempts = 5
+	recoveryCodeCount    = 10
+)
+
+// Алфавит резервных кодов без похожих друг на друга символов (0/o, 1/l/i)
+const recoveryCodeAlphabet = "abcdefghjkmnpqrstuvwxyz23456789"
+
+// loginChallenge — состояние входа между проверкой пароля и второго фактора
+type loginChallenge struct {
+	UserID uint   `json:"user_id"`
+	Login  string `json:"login"`
+}
+
+func loginChallengeKey(challenge string) string {
+	return "login_challenge_" + challenge
+}
+
+func loginChallengeAttemptsKey(challenge string) string {
+	return "login_challenge_attempts_" + challenge
+}
+
+func totpUsedKey(userID uint, step int64) string {
+	return "totp_used_" + strconv.FormatUint(uint64(userID), 10) + "_" + strconv.FormatInt(step, 10)
+}
+
+// createLoginChallenge сохраняет состояние входа и возвращает одноразовый идентификатор
+func createLoginChallenge(ctx context.Context, client *redis.Client, challenge loginChallenge) (string, error) {
+	random := make([]byte, 32)
+	if _, err := rand.Read(random); err != nil {
+		return "", err
+	}
+	id := hex.EncodeToString(random)
+
+	data, err := json.Marshal(challenge)
+	if err != nil {
+		return "", err
+	}
+	if err := client.Set(ctx, loginChallengeKey(id), data, loginChallengeTTL).Err(); err != nil {
+		return "", err
+	}
+	return id, nil
+}
+
+// generateRecoveryCodes возвращает резервные коды вида xxxxx-xxxxx и их хеши для хранения
+func generateRecoveryCodes() (codes, hashes []string, err error) {
+	alphabetSize := big.NewInt(int64(len(recoveryCodeAlphabet)))
+	for i := 0; i < recoveryCodeCount; i++ {
+		var code strings.Builder
+		for j := 0; j < 10; j++ {
+			if j == 5 {
+				code.WriteByte('-')
+			}
+			n, err := rand.Int(rand.Reader, alphabetSize)
+			if err != nil {
+				return nil, nil, err
+			}
+			code.WriteByte(recoveryCodeAlphabet[n.Int64()])
+		}
+		codes = append(codes, code.String())
+		hashes = append(hashes, hashRecoveryCode(code.String()))
+	}
+	return codes, hashes, nil
+}
+
+// hashRecoveryCode приводит код к каноническому виду (регистр, дефисы, пробелы) и хеширует его
+func hashRecoveryCode(code string) string {
+	code = strings.ToLower(strings.NewReplacer("-", "", " ", "").Replace(code))
+	return hashCode(code)
+}
+
+// checkTOTP проверяет код приложения-аутентификатора. Каждый код принимается только
+// один раз, чтобы подсмотренный код нельзя было использовать повторно.
+func checkTOTP(ctx context.Context, userID uint, secret, code string) (bool, error) {
+	step, ok := totp.Validate(secret, code, time.Now())
+	if !ok {
+		return false, nil
+	}
+
+	client := database.GetRedis()
+	defer client.Close()
+
+	return client.SetNX(ctx, totpUsedKey(userID, step), 1, (2*totp.Skew+1)*totp.Period).Result()
+}
+
+// checkSecondFactor принимает либо код приложения, либо неиспользованный резервный код
+func checkSecondFactor(ctx context.Context, repos *repository.Repositories, user models.User, code, recoveryCode string) (bool, error) {
+	if !user.TOTPEnabled {
+		return false, nil
+	}
+	if code != "" {
+		return checkTOTP(ctx, user.ID, user.TOTPSecret, code)
+	}
+	if recoveryCode != "" {
+		return repos.Users.UseRecoveryCode(user.ID, hashRecoveryCode(recoveryCode))
+	}
+	return false, nil
+}
+
+// LoginSecondFactorStruct represents the JSON
+// @Description Второй шаг входа: challenge из user/log-in-with-credentials и код приложения или резервный код
+type LoginSecondFactorStruct struct {
+	// Uuid — обмен ключами, которым защищён первый шаг; для token/key-exchange токен шифруется его ключом
+	Uuid         string `json:"uuid,omitempty"`
+	Challenge    string `json:"challenge"`
+	Code         string `json:"code,omitempty"`
+	RecoveryCode string `json:"recovery_code,omitempty"`
+	PKey         string `json:"pKey"`
+}
+
+// @Tags Users
+// loginSecondFactor godoc
+// @Summary Второй шаг входа с двухфакторной аутентификацией
+// @Description Challenge действует 5 минут и допускает 5 попыток. Неверные коды учитываются в блокировке входа так же, как неверные пароли.
+// @Accept json
+// @Produce json
+// @Param data body LoginSecondFactorStruct true "Challenge и код"
+// @Success 200 {object} map[string]interface{} "successful response"
+// @Failure 400 {object} map[string]interface{} "bad request"
+// @Failure 401 {object} map[string]interface{} "invalid code or expired challenge"
+// @Failure 429 {object} map[string]interface{} "too many attempts"
+// @Router /user/log-in-2fa [post]
+func loginSecondFactor(repos *repository.Repositories, c *gin.Context) {
+	var factorData LoginSecondFactorStruct
+	if err := c.BindJSON(&factorData); err != nil || factorData.Challenge == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверные входные данные"})
+		return
+	}
+
+	ctx := c.Request.Context()
+	session, err := handshake.Start(ctx, c.Request, factorData.Uuid)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Ошибка дешифрования данных"})
+		return
+	}
+
+	client := database.GetRedis()
+	defer client.Close()
+
+	expired := gin.H{"error": "Время подтверждения входа истекло, войдите заново"}
+
+	data, err := client.Get(ctx, loginChallengeKey(factorData.Challenge)).Bytes()
+	if err == redis.Nil {
+		c.JSON(http.StatusUnauthorized, expired)
+		return
+	}
+	if err != nil {
+		log.Println("Failed to read login challenge:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Внутренняя ошибка сервера"})
+		return
+	}
+	var challenge loginChallenge
+	if err := json.Unmarshal(data, &challenge); err != nil {
+		c.JSON(http.StatusUnauthorized, expired)
+		return
+	}
+
+	ip := c.ClientIP()
+	lockedFor, err := loginLockedFor(ctx, client, challenge.Login, ip)
+	if err != nil {
+		log.Println("Failed to check login lockout:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Внутренняя ошибка сервера"})
+		return
+	}
+	if lockedFor > 0 {
+		audit.Record(ctx, audit.Event{Type: audit.LoginLocked, UserID: challenge.UserID, Login: challenge.Login, IP: ip})
+		respondTooManyAttempts(c, lockedFor)
+		return
+	}
+
+	user, err := repos.Users.GetUser(challenge.UserID)
+	if err != nil {
+		c.JSON(http.StatusUnauthorized, expired)
+		return
+	}
+
+	ok, err := checkSecondFactor(ctx, repos, user, factorData.Code, factorData.RecoveryCode)
+	if err != nil {
+		log.Println("Failed to check second factor:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Внутренняя ошибка сервера"})
+		return
+	}
+	if !ok {
+		audit.Record(ctx, audit.Event{Type: audit.LoginFailed, UserID: user.ID, Login: challenge.Login, IP: ip, Reason: "wrong_second_factor"})
+
+		attempts, err := client.Incr(ctx, loginChallengeAttemptsKey(factorData.Challenge)).Result()
+		if err == nil {
+			client.Expire(ctx, loginChallengeAttemptsKey(factorData.Challenge), loginChallengeTTL)
+			if attempts >= maxChallengeAttempts {
+				client.Del(ctx, loginChallengeKey(factorData.Challenge), loginChallengeAttemptsKey(factorData.Challenge))
+			}
+		}
+
+		lockout, err := registerLoginFailure(ctx, client, challenge.Login, ip)
+		if err != nil {
+			log.Println("Failed to register login failure:", err)
+		}
+		if lockout > 0 {
+			respondTooManyAttempts(c, lockout)
+			return
+		}
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Неверный код"})
+		return
+	}
+
+	// Challenge одноразовый: при одновременных запросах вход получает тот, кто его удалил
+	deleted, err := client.Del(ctx, loginChallengeKey(factorData.Challenge)).Result()
+	if err != nil {
+		log.Println("Failed to delete login challenge:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Внутренняя ошибка сервера"})
+		return
+	}
+	if deleted != 1 {
+		c.JSON(http.StatusUnauthorized, expired)
+		return
+	}
+	client.Del(ctx, loginChallengeAttemptsKey(factorData.Challenge))
+
+	completeLogin(c, client, session, user, challenge.Login, factorData.PKey)
+}
+
+// @Tags Users
+// enrollTwoFactor godoc
+// @Summary Начало подключения двухфакторной аутентификации
+// @Description Создаёт секрет и возвращает otpauth://-ссылку для приложения-аутентификатора. Двухфакторная аутентификация включится после user/2fa/verify.
+// @Produce json
+// @Security BearerAuth
+// @Success 200 {object} map[string]interface{} "successful response"
+// @Failure 409 {object} map[string]interface{} "already enabled"
+// @Router /user/2fa/enroll [post]
+func enrollTwoFactor(repos *repository.Repositories, c *gin.Context) {
+	user, err := repos.Users.GetUser(middleware.UserID(c))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Пользователь не найден"})
+		return
+	}
+	if user.TOTPEnabled {
+		c.JSON(http.StatusConflict, gin.H{"error": "Двухфакторная аутентификация уже включена"})
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Внутренняя ошибка сервера"})
+		return
+	}
+	if err := repos.Users.SetTOTP(user.ID, secret, false); err != nil {
+		log.Println("Failed to store TOTP secret:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Внутренняя ошибка сервера"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{
+		"secret":           secret,
+		"provisioning_uri": totp.ProvisioningURI(totpIssuer, user.Login, secret),
+	})
+}
+
+// TwoFactorCodeStruct represents the JSON
+// @Description Код приложения-аутентификатора или резервный код
+type TwoFactorCodeStruct struct {
+	Code         string `json:"code,omitempty"`
+	RecoveryCode string `json:"recovery_code,omitempty"`
+}
+
+// @Tags Users
+// verifyTwoFactor godoc
+// @Summary Подтверждение подключения двухфакторной аутентификации
+// @Description Проверяет код из приложения, включает двухфакторную аутентификацию и возвращает резервные коды. Коды показываются один раз.
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param data body TwoFactorCodeStruct true "Код приложения"
+// @Success 200 {object} map[string]interface{} "successful response"
+// @Failure 400 {object} map[string]interface{} "invalid code"
+// @Failure 409 {object} map[string]interface{} "already enabled"
+// @Router /user/2fa/verify [post]
+func verifyTwoFactor(repos *repository.Repositories, c *gin.Context) {
+	var codeData TwoFactorCodeStruct
+	if err := c.BindJSON(&codeData); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверные входные данные"})
+		return
+	}
+
+	user, err := repos.Users.GetUser(middleware.UserID(c))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Пользователь не найден"})
+		return
+	}
+	if user.TOTPEnabled {
+		c.JSON(http.StatusConflict, gin.H{"error": "Двухфакторная аутентификация уже включена"})
+		return
+	}
+	if user.TOTPSecret == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Сначала начните подключение через user/2fa/enroll"})
+		return
+	}
+
+	ok, err := checkTOTP(c.Request.Context(), user.ID, user.TOTPSecret, codeData.Code)
+	if err != nil {
+		log.Println("Failed to check TOTP code:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Внутренняя ошибка сервера"})
+		return
+	}
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный код"})
+		return
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Внутренняя ошибка сервера"})
+		return
+	}
+	if err := repos.Users.ReplaceRecoveryCodes(user.ID, hashes); err != nil {
+		log.Println("Failed to store recovery codes:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Внутренняя ошибка сервера"})
+		return
+	}
+	if err := repos.Users.SetTOTP(user.ID, user.TOTPSecret, true); err != nil {
+		log.Println("Failed to enable TOTP:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Внутренняя ошибка сервера"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Двухфакторная аутентификация включена", "recovery_codes": codes})
+}
+
+// @Tags Users
+// regenerateRecoveryCodes godoc
+// @Summary Новые резервные коды
+// @Description Требует код приложения или резервный код. Прежние резервные коды перестают действовать.
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param data body TwoFactorCodeStruct true "Код"
+// @Success 200 {object} map[string]interface{} "successful response"
+// @Failure 400 {object} map[string]interface{} "invalid code"
+// @Router /user/2fa/recovery-codes [post]
+func regenerateRecoveryCodes(repos *repository.Repositories, c *gin.Context) {
+	var codeData TwoFactorCodeStruct
+	if err := c.BindJSON(&codeData); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверные входные данные"})
+		return
+	}
+
+	user, err := repos.Users.GetUser(middleware.UserID(c))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Пользователь не найден"})
+		return
+	}
+
+	ok, err := checkSecondFactor(c.Request.Context(), repos, user, codeData.Code, codeData.RecoveryCode)
+	if err != nil {
+		log.Println("Failed to check second factor:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Внутренняя ошибка сервера"})
+		return
+	}
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный код"})
+		return
+	}
+
+	codes, hashes, err := generateRecoveryCodes()
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Внутренняя ошибка сервера"})
+		return
+	}
+	if err := repos.Users.ReplaceRecoveryCodes(user.ID, hashes); err != nil {
+		log.Println("Failed to store recovery codes:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Внутренняя ошибка сервера"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+}
+
+// DisableTwoFactorStruct represents the JSON
+// @Description Отключение двухфакторной аутентификации. Пароль зашифрован ключом из token/generateToken.
+type DisableTwoFactorStruct struct {
+	Uuid         string `json:"uuid"`
+	Password     string `json:"password"`
+	Code         string `json:"code,omitempty"`
+	RecoveryCode string `json:"recovery_code,omitempty"`
+}
+
+// @Tags Users
+// disableTwoFactor godoc
+// @Summary Отключение двухфакторной аутентификации
+// @Description Требует пароль и код приложения или резервный код.
+// @Accept json
+// @Produce json
+// @Security BearerAuth
+// @Param data body DisableTwoFactorStruct true "Пароль и код"
+// @Success 200 {object} map[string]interface{} "successful response"
+// @Failure 400 {object} map[string]interface{} "invalid code"
+// @Failure 401 {object} map[string]interface{} "wrong password"
+// @Router /user/2fa/disable [post]
+func disableTwoFactor(repos *repository.Repositories, c *gin.Context) {
+	var disableData DisableTwoFactorStruct
+	if err := c.BindJSON(&disableData); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверные входные данные"})
+		return
+	}
+
+	if _, err := openHandshake(c, disableData.Uuid, &disableData.Password); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Ошибка дешифрования пароля"})
+		return
+	}
+
+	user, err := repos.Users.GetUser(middleware.UserID(c))
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Пользователь не найден"})
+		return
+	}
+	if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(disableData.Password)); err != nil {
+		c.JSON(http.StatusUnauthorized, gin.H{"error": "Неверный пароль"})
+		return
+	}
+
+	ok, err := checkSecondFactor(c.Request.Context(), repos, user, disableData.Code, disableData.RecoveryCode)
+	if err != nil {
+		log.Println("Failed to check second factor:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Внутренняя ошибка сервера"})
+		return
+	}
+	if !ok {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Неверный код"})
+		return
+	}
+
+	if err := repos.Users.SetTOTP(user.ID, "", false); err != nil {
+		log.Println("Failed to disable TOTP:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Внутренняя ошибка сервера"})
+		return
+	}
+	if err := repos.Users.ReplaceRecoveryCodes(user.ID, nil); err != nil {
+		log.Println("Failed to remove recovery codes:", err)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Двухфакторная аутентификация отключена"})
+}
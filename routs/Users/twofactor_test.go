@@ -0,0 +1,157 @@
+package users
+
+import (
+	"Bmessage_backend/audit"
+	"Bmessage_backend/testutil"
+	"Bmessage_backend/totp"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// loginChallengeFor выполняет первый шаг входа и возвращает challenge второго шага
+func loginChallengeFor(t *testing.T, router *gin.Engine, client *testutil.Client, loginName, password string) string {
+	t.Helper()
+
+	var resp struct {
+		Token             string `json:"token"`
+		TwoFactorRequired bool   `json:"two_factor_required"`
+		Challenge         string `json:"challenge"`
+	}
+	rec := testutil.Do(t, router, http.MethodPost, "/user/log-in-with-credentials", UserLogin{
+		Uuid:     client.Uuid,
+		PKey:     client.PKey(t),
+		Login:    client.Encrypt(t, loginName),
+		Password: client.Encrypt(t, password),
+	})
+	testutil.Decode(t, rec, http.StatusOK, &resp)
+	if !resp.TwoFactorRequired || resp.Challenge == "" || resp.Token != "" {
+		t.Fatalf("expected two-factor challenge, got %+v", resp)
+	}
+	return resp.Challenge
+}
+
+func secondFactor(t *testing.T, router *gin.Engine, client *testutil.Client, data LoginSecondFactorStruct, status int) string {
+	t.Helper()
+
+	data.PKey = client.PKey(t)
+	var resp struct {
+		Token string `json:"token"`
+	}
+	testutil.Decode(t, testutil.Do(t, router, http.MethodPost, "/user/log-in-2fa", data), status, &resp)
+	if status != http.StatusOK {
+		return ""
+	}
+	return client.Decrypt(t, resp.Token)
+}
+
+func codeAt(t *testing.T, secret string, step int64) string {
+	t.Helper()
+	code, err := totp.CodeAt(secret, step)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code
+}
+
+func TestTwoFactorLogin(t *testing.T) {
+	router := newPasswordRouter(t)
+	t.Cleanup(audit.Use(&recordingAudit{}))
+	client := testutil.NewClient(t, router)
+
+	testutil.Decode(t, testutil.Do(t, router, http.MethodPost, "/user/registration", registration(t, client, "ivan", "ivan_login", "password-1")), http.StatusOK, nil)
+	session := login(t, router, client, "ivan_login", "password-1", http.StatusOK)
+
+	var enrolled struct {
+		Secret          string `json:"secret"`
+		ProvisioningURI string `json:"provisioning_uri"`
+	}
+	testutil.Decode(t, testutil.DoAs(t, router, session, http.MethodPost, "/user/2fa/enroll", nil), http.StatusOK, &enrolled)
+	if enrolled.Secret == "" || enrolled.ProvisioningURI == "" {
+		t.Fatalf("unexpected enroll response: %+v", enrolled)
+	}
+
+	// Пока подключение не подтверждено, вход остаётся однофакторным
+	login(t, router, client, "ivan_login", "password-1", http.StatusOK)
+
+	step := totp.Step(time.Now())
+	wrong := "000000"
+	if _, ok := totp.Validate(enrolled.Secret, wrong, time.Now()); ok {
+		wrong = "111111"
+	}
+	rec := testutil.DoAs(t, router, session, http.MethodPost, "/user/2fa/verify", TwoFactorCodeStruct{Code: wrong})
+	testutil.Decode(t, rec, http.StatusBadRequest, nil)
+
+	var verified struct {
+		RecoveryCodes []string `json:"recovery_codes"`
+	}
+	rec = testutil.DoAs(t, router, session, http.MethodPost, "/user/2fa/verify", TwoFactorCodeStruct{Code: codeAt(t, enrolled.Secret, step)})
+	testutil.Decode(t, rec, http.StatusOK, &verified)
+	if len(verified.RecoveryCodes) != recoveryCodeCount {
+		t.Fatalf("got %d recovery codes, want %d", len(verified.RecoveryCodes), recoveryCodeCount)
+	}
+
+	var profile Profile
+	testutil.Decode(t, testutil.DoAs(t, router, session, http.MethodGet, "/user/profile", nil), http.StatusOK, &profile)
+	if !profile.TwoFactorEnabled {
+		t.Fatal("profile does not report two-factor authentication")
+	}
+
+	// Код, уже использованный при подтверждении, повторно не принимается
+	challenge := loginChallengeFor(t, router, client, "ivan_login", "password-1")
+	secondFactor(t, router, client, LoginSecondFactorStruct{Challenge: challenge, Code: codeAt(t, enrolled.Secret, step)}, http.StatusUnauthorized)
+	token := secondFactor(t, router, client, LoginSecondFactorStruct{Challenge: challenge, Code: codeAt(t, enrolled.Secret, step+1)}, http.StatusOK)
+	testutil.Decode(t, testutil.DoAs(t, router, token, http.MethodGet, "/user/profile", nil), http.StatusOK, nil)
+
+	// Challenge одноразовый
+	secondFactor(t, router, client, LoginSecondFactorStruct{Challenge: challenge, Code: codeAt(t, enrolled.Secret, step-1)}, http.StatusUnauthorized)
+
+	// Резервный код принимается один раз, без учёта регистра
+	recovery := verified.RecoveryCodes[0]
+	challenge = loginChallengeFor(t, router, client, "ivan_login", "password-1")
+	secondFactor(t, router, client, LoginSecondFactorStruct{Challenge: challenge, RecoveryCode: recovery}, http.StatusOK)
+	challenge = loginChallengeFor(t, router, client, "ivan_login", "password-1")
+	secondFactor(t, router, client, LoginSecondFactorStruct{Challenge: challenge, RecoveryCode: recovery}, http.StatusUnauthorized)
+
+	rec = testutil.DoAs(t, router, session, http.MethodPost, "/user/2fa/disable", DisableTwoFactorStruct{
+		Uuid:         client.Uuid,
+		Password:     client.Encrypt(t, "wrong-password"),
+		RecoveryCode: verified.RecoveryCodes[1],
+	})
+	testutil.Decode(t, rec, http.StatusUnauthorized, nil)
+
+	rec = testutil.DoAs(t, router, session, http.MethodPost, "/user/2fa/disable", DisableTwoFactorStruct{
+		Uuid:         client.Uuid,
+		Password:     client.Encrypt(t, "password-1"),
+		RecoveryCode: "  " + verified.RecoveryCodes[1] + " ",
+	})
+	testutil.Decode(t, rec, http.StatusOK, nil)
+
+	login(t, router, client, "ivan_login", "password-1", http.StatusOK)
+}
+
+func TestTwoFactorChallengeAttempts(t *testing.T) {
+	router := newPasswordRouter(t)
+	t.Cleanup(audit.Use(&recordingAudit{}))
+	client := testutil.NewClient(t, router)
+
+	testutil.Decode(t, testutil.Do(t, router, http.MethodPost, "/user/registration", registration(t, client, "ivan", "ivan_login", "password-1")), http.StatusOK, nil)
+	session := login(t, router, client, "ivan_login", "password-1", http.StatusOK)
+
+	var enrolled struct {
+		Secret string `json:"secret"`
+	}
+	testutil.Decode(t, testutil.DoAs(t, router, session, http.MethodPost, "/user/2fa/enroll", nil), http.StatusOK, &enrolled)
+	step := totp.Step(time.Now())
+	testutil.Decode(t, testutil.DoAs(t, router, session, http.MethodPost, "/user/2fa/verify", TwoFactorCodeStruct{Code: codeAt(t, enrolled.Secret, step)}), http.StatusOK, nil)
+
+	challenge := loginChallengeFor(t, router, client, "ivan_login", "password-1")
+	for i := 0; i < maxChallengeAttempts-1; i++ {
+		secondFactor(t, router, client, LoginSecondFactorStruct{Challenge: challenge, RecoveryCode: "wrong-code"}, http.StatusUnauthorized)
+	}
+	// Последняя попытка исчерпывает challenge и вместе с неудачами выше включает блокировку логина
+	secondFactor(t, router, client, LoginSecondFactorStruct{Challenge: challenge, RecoveryCode: "wrong-code"}, http.StatusTooManyRequests)
+	secondFactor(t, router, client, LoginSecondFactorStruct{Challenge: challenge, Code: codeAt(t, enrolled.Secret, step+1)}, http.StatusUnauthorized)
+}
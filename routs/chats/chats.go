@@ -1,132 +1,199 @@
 package chats
 
 import (
-	"Bmessage_backend/database"
 	"Bmessage_backend/helpers"
+	"Bmessage_backend/middleware"
 	"Bmessage_backend/models"
+	"Bmessage_backend/repository"
 	"Bmessage_backend/routs/tokens"
 	"encoding/base64"
 	"fmt"
 	"log"
 	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"github.com/gocql/gocql"
 	"github.com/google/uuid"
-	"gorm.io/gorm"
 )
 
-func RegisterUserTemplate(userID uint) bool {
-	session, err := database.GetSession()
-
-	if err != nil {
-		return false
-	}
-
-	keyspace := fmt.Sprintf("user_%d", userID)
-
-	database.CreateKeyspaceScylla(session, keyspace)
-
-	createTableQuery := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s.chats (
-		user_id bigint,
-		chat_id uuid,
-		companion_id bigint,
-		chat_type text,
-		secured boolean,
-		muted boolean,
-		new_msg_count int,
-		last_msg_time timestamp,
-		last_updated timestamp,
-		private_key text,
-		PRIMARY KEY (user_id,last_updated, companion_id, chat_id)
-	) WITH CLUSTERING ORDER BY (last_updated DESC);
-	`, keyspace)
-
-	if err := session.Query(createTableQuery).Exec(); err != nil {
-		log.Println("Failed to create table:", err)
-		return false
-	}
-
-	createMessageTableQuery := fmt.Sprintf(`CREATE TABLE IF NOT EXISTS %s.messages (
-		chat_id uuid,
-		message_id uuid,
-		sender_id bigint,
-		message_text TEXT,
-		created_at TIMESTAMP,
-		attachments LIST<TEXT>,
-		reactions MAP<TEXT, INT>,
-		reply_to_message_id UUID,
-		forwarded_from_chat_id UUID,
-		forwarded_from_message_id UUID,
-		read boolean,
-		PRIMARY KEY (chat_id, created_at, message_id)
-	) WITH CLUSTERING ORDER BY (created_at DESC);
-	`, keyspace)
-
-	if err := session.Query(createMessageTableQuery).Exec(); err != nil {
-		log.Println("Failed to create table:", err)
-		return false
-	}
-
-	return true
-
-}
-
 func ChatRouter(router *gin.Engine) {
 	routeBase := "chats/"
-	router.GET(routeBase+"get-chats", database.WithDatabaseScylla(GetChats))
+	router.GET(routeBase+"get-chats", middleware.RequireUser(), repository.WithRepositories(GetChats))
 	// router.GET(routeBase+"get-chats-secured", database.WithDatabaseScylla(GetChatsSecured))
-	router.POST(routeBase+"create-chat", database.WithDatabaseScylla(CreateChat))
-	router.GET(routeBase+"find-chats", database.WithDatabase(FindChats))
+	router.POST(routeBase+"create-chat", middleware.RequireUser(), repository.WithRepositories(CreateChat))
+	router.GET(routeBase+"find-chats", middleware.RequireUser(), repository.WithRepositories(FindChats))
+	router.POST(routeBase+"mute", middleware.RequireUser(), repository.WithRepositories(MuteChat))
+	router.POST(routeBase+"pin", middleware.RequireUser(), repository.WithRepositories(PinChat))
+	router.POST(routeBase+"reorder-pinned", middleware.RequireUser(), repository.WithRepositories(ReorderPinnedChats))
+	router.POST(routeBase+"archive", middleware.RequireUser(), repository.WithRepositories(ArchiveChat))
+	router.POST(routeBase+"disappearing", middleware.RequireUser(), repository.WithRepositories(SetDisappearingMessages))
+	router.POST(routeBase+"draft", middleware.RequireUser(), repository.WithRepositories(SaveDraft))
 }
 
+// Имя, под которым в списке чатов показывается собеседник, удаливший аккаунт
+const deletedCompanionName = "Удалённый аккаунт"
+
 type Chat struct {
-	ChatID          gocql.UUID  `json:"chat_id"`
-	CompanionID     string      `json:"companion_id"`
-	CompanionName   string      `json:"companion_name,omitempty"`
-	CompanionSoName string      `json:"companion_so_name,omitempty"`
-	CompanionNik    string      `json:"companion_nik,omitempty"`
-	ChatType        string      `json:"chat_type"`
-	Secured         bool        `json:"secured"`
-	LastMsgTime     interface{} `json:"last_msg_time"`
-	NewMsgCount     int         `json:"new_msg_count"`
-	LastMsg         *string     `json:"last_msg,omitempty"`
-	LastUpdated     interface{} `json:"last_updated,omitempty"`
-	IsMyMessage     bool        `json:"is_my_message"`
-	PrivateKey      string      `json:"-"`
+	ChatID           gocql.UUID  `json:"chat_id"`
+	CompanionID      string      `json:"companion_id"`
+	CompanionName    string      `json:"companion_name,omitempty"`
+	CompanionSoName  string      `json:"companion_so_name,omitempty"`
+	CompanionNik     string      `json:"companion_nik,omitempty"`
+	CompanionAvatar  string      `json:"companion_avatar,omitempty"`
+	CompanionDeleted bool        `json:"companion_deleted,omitempty"`
+	ChatType         string      `json:"chat_type"`
+	Secured          bool        `json:"secured"`
+	LastMsgTime      interface{} `json:"last_msg_time"`
+	NewMsgCount      int         `json:"new_msg_count"`
+	LastMsg          *string     `json:"last_msg,omitempty"`
+	// LastMsgKind — вид служебного сообщения, если последнее сообщение служебное; last_msg тогда пуст
+	LastMsgKind string      `json:"last_msg_kind,omitempty"`
+	LastUpdated interface{} `json:"last_updated,omitempty"`
+	IsMyMessage bool        `json:"is_my_message"`
+	Muted       bool        `json:"muted"`
+	MutedUntil  *time.Time  `json:"muted_until,omitempty"`
+	Pinned      bool        `json:"pinned"`
+	PinOrder    int         `json:"pin_order,omitempty"`
+	Archived    bool        `json:"archived"`
+	// MessageTTL — через сколько секунд исчезают новые сообщения; 0 — не исчезают
+	MessageTTL int `json:"message_ttl"`
+	// PinnedMessageIDs — закреплённые сообщения, последние закреплённые первыми; только в событиях чата
+	PinnedMessageIDs []gocql.UUID `json:"pinned_message_ids,omitempty"`
+	// Draft — неотправленный текст пользователя; клиент показывает его вместо last_msg
+	Draft      *Draft `json:"draft,omitempty"`
+	PrivateKey string `json:"-"`
+	// unreadExpiring — непрочитанные сообщения со сроком жизни, см. expireUnread
+	unreadExpiring map[gocql.UUID]time.Time
+}
+
+func chatFromRepository(chat repository.Chat, newMsgCount int) Chat {
+	var lastMsgTimeValue, lastUpdateTimeValue interface{}
+	if chat.LastMsgTime != nil {
+		lastMsgTimeValue = *chat.LastMsgTime
+	}
+	if chat.LastUpdated != nil {
+		lastUpdateTimeValue = *chat.LastUpdated
+	}
+
+	result := Chat{
+		ChatID:           chat.ChatID,
+		CompanionID:      fmt.Sprintf("%d", chat.CompanionID),
+		CompanionDeleted: chat.CompanionDeleted,
+		ChatType:         chat.ChatType,
+		Secured:          chat.Secured,
+		LastMsgTime:      lastMsgTimeValue,
+		NewMsgCount:      newMsgCount,
+		LastUpdated:      lastUpdateTimeValue,
+		LastMsg:          nil,
+		IsMyMessage:      false,
+		Muted:            chat.MutedAt(time.Now()),
+		Pinned:           chat.PinOrder > 0,
+		PinOrder:         chat.PinOrder,
+		Archived:         chat.Archived,
+		MessageTTL:       chat.MessageTTL,
+		PrivateKey:       chat.PrivateKey,
+		unreadExpiring:   chat.UnreadExpiring,
+	}
+	if result.Muted {
+		result.MutedUntil = chat.MutedUntil
+	}
+	if chat.CompanionDeleted {
+		result.CompanionName = deletedCompanionName
+	}
+	return result
+}
+
+// fillLastMessage расшифровывает последнее сообщение чата ключом пользователя.
+// Если последнее сообщение исчезло по сроку жизни или было удалено, показывается предыдущее.
+func fillLastMessage(repos *repository.Repositories, userID uint, chat *Chat) error {
+	if err := expireUnread(repos, userID, chat, time.Now()); err != nil {
+		return err
+	}
+
+	messages, err := repos.Messages.ListMessages(userID, chat.ChatID, repository.ListOptions{Limit: 1})
+	if err != nil {
+		return err
+	}
+
+	lastMsgTime, ok := chat.LastMsgTime.(time.Time)
+	if ok && (len(messages) == 0 || messages[0].CreatedAt.Before(lastMsgTime)) {
+		if len(messages) == 0 {
+			chat.LastMsgTime = nil
+		} else {
+			chat.LastMsgTime = messages[0].CreatedAt
+		}
+	}
+
+	if len(messages) == 0 {
+		chat.LastMsg = nil
+		chat.IsMyMessage = false
+		return nil
+	}
+	if messages[0].Kind != "" {
+		chat.LastMsg = nil
+		chat.LastMsgKind = messages[0].Kind
+		chat.IsMyMessage = false
+		return nil
+	}
+
+	decryptedText, err := helpers.DecryptWithPrivateKey(messages[0].MessageText, chat.PrivateKey)
+	if err != nil {
+		return err
+	}
+	chat.LastMsg = &decryptedText
+	chat.IsMyMessage = messages[0].SenderID == userID
+	return nil
+}
+
+// expireUnread вычитает из счётчика непрочитанных сообщения, исчезнувшие по сроку жизни
+// до прочтения: хранилище удаляет их само и счётчик иначе не уменьшился бы
+func expireUnread(repos *repository.Repositories, userID uint, chat *Chat, now time.Time) error {
+	var expired []gocql.UUID
+	for messageID, expiresAt := range chat.unreadExpiring {
+		if !expiresAt.After(now) {
+			expired = append(expired, messageID)
+		}
+	}
+	if len(expired) == 0 {
+		return nil
+	}
+
+	if err := repos.Chats.RemoveUnreadExpiring(userID, chat.ChatID, expired); err != nil {
+		return err
+	}
+	if err := repos.Chats.AddUnreadCount(userID, chat.ChatID, -len(expired)); err != nil {
+		return err
+	}
+	chat.NewMsgCount = max(chat.NewMsgCount-len(expired), 0)
+	for _, messageID := range expired {
+		delete(chat.unreadExpiring, messageID)
+	}
+	return nil
 }
 
 // GetChats retrieves chats for a user.
 // @Tags Chats
 // @Summary Получение чатов пользователя
-// @Description Получает список чатов для указанного пользователя.
+// @Description Получает список чатов для указанного пользователя. Первая страница основного списка начинается
+// @Description с закреплённых чатов в их порядке, остальные идут по времени обновления. Архивные чаты в основной
+// @Description список не попадают и запрашиваются отдельно с archived=true. Для контактов с заданным именем
+// @Description companion_name содержит это имя, а companion_so_name пуст. Если у пользователя есть черновик,
+// @Description он возвращается в draft.
 // @Accept json
 // @Produce json
-// @Param user_token query string true "Токен пользователя"
+// @Security BearerAuth
 // @Param uuid query string true "UUID пользователя"
 // @Param page_state query string false "Стейт следющей страницы"
+// @Param archived query bool false "Вернуть архивные чаты вместо основного списка"
 // @Success 200 {object} map[string]interface{} "successful response"
 // @Failure 400 {object} map[string]interface{} "bad request"
+// @Failure 401 {object} map[string]interface{} "unauthorized"
 // @Router /chats/get-chats [get]
-func GetChats(session *gocql.Session, c *gin.Context) {
-	userTokenQuery := c.Query("user_token")
-
-	// RegisterUserTemplate(21)
-	// RegisterUserTemplate(22)
-	// RegisterUserTemplate(23)
-
-	if userTokenQuery == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Missing user_id parameter"})
-		return
-	}
-
-	userDataToToken, err := helpers.DecryptAES(userTokenQuery)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
-	}
-	userID := userDataToToken.User_id
+func GetChats(repos *repository.Repositories, c *gin.Context) {
+	userID := middleware.UserID(c)
 
 	pageStateQuery := c.Query("page_state")
 	var pageState []byte
@@ -140,85 +207,47 @@ func GetChats(session *gocql.Session, c *gin.Context) {
 		}
 	}
 
-	keyspaceChats := fmt.Sprintf("user_%d.chats", userID)
-	keyspaceMessages := fmt.Sprintf("user_%d.messages", userID)
-
-	query := fmt.Sprintf(`SELECT
-		chat_id,
-		companion_id,
-		chat_type,
-		secured,
-		last_msg_time,
-		last_updated,
-		new_msg_count,
-		private_key
-	FROM
-		%s
-	WHERE
-		user_id = ?
-	ORDER BY
-		last_updated DESC ALLOW FILTERING;
-	`, keyspaceChats)
-	q := session.Query(query, userID).PageSize(10).PageState(pageState)
-
-	iter := q.Iter()
-	defer iter.Close()
+	archived := c.Query("archived") == "true"
 
-	var chats []Chat
-	var chatID gocql.UUID
-	var companionID, chatType string
-	var secured bool
-	var lastMsgTime, lastUpdated *time.Time
-	var newMsgCount int
-	var privateKey string
-
-	for iter.Scan(&chatID, &companionID, &chatType, &secured, &lastMsgTime, &lastUpdated, &newMsgCount, &privateKey) {
-		var lastMsgTimeValue, lastUpdateTimeValue interface{}
-		if lastMsgTime != nil {
-			lastMsgTimeValue = *lastMsgTime
-		} else {
-			lastMsgTimeValue = nil
-		}
-
-		if lastUpdated != nil {
-			lastUpdateTimeValue = *lastUpdated
-		} else {
-			lastUpdateTimeValue = nil
-		}
-
-		chats = append(chats, Chat{
-			ChatID:      chatID,
-			CompanionID: companionID,
-			ChatType:    chatType,
-			Secured:     secured,
-			LastMsgTime: lastMsgTimeValue,
-			NewMsgCount: newMsgCount,
-			LastUpdated: lastUpdateTimeValue,
-			LastMsg:     nil,
-			IsMyMessage: false,
-			PrivateKey:  privateKey,
-		})
-	}
-
-	if err := iter.Close(); err != nil {
+	// Закреплённые чаты уже в начале первой страницы, а архивные показываются только в архиве
+	inList := func(chat repository.Chat) bool {
+		return chat.Archived == archived && (archived || chat.PinOrder == 0)
+	}
+	pageRows, nextPageState, err := repos.Chats.ListChats(userID, pageState, 10, inList)
+	if err != nil {
 		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch chats"})
 		return
 	}
-	nextPageState := iter.PageState()
 
-	var companionIDs []string
-	for _, chat := range chats {
-		companionIDs = append(companionIDs, chat.CompanionID)
+	var chatRows []repository.Chat
+	if !archived && pageState == nil {
+		pinned, err := repos.Chats.ListPinnedChats(userID)
+		if err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch chats"})
+			return
+		}
+		chatRows = append(chatRows, pinned...)
+	}
+	chatRows = append(chatRows, pageRows...)
+
+	var chats []Chat
+	for _, chatRow := range chatRows {
+		newMsgCount, err := repos.Chats.GetUnreadCount(userID, chatRow.ChatID)
+		if err != nil {
+			log.Println(err)
+		}
+		chats = append(chats, chatFromRepository(chatRow, newMsgCount))
 	}
 
-	db, err := database.GetDb()
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Не удалось подключиться к базе данных"})
-		return
+	var companionIDs []uint
+	for _, chatRow := range chatRows {
+		companionIDs = append(companionIDs, chatRow.CompanionID)
 	}
 
-	var users []models.User
-	if err := db.Where("id IN ?", companionIDs).Find(&users).Error; err != nil {
+	users, err := repos.Users.GetUsers(companionIDs)
+	if err != nil {
 		log.Println("Failed to fetch users from PostgreSQL:", err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch users"})
 		return
@@ -230,33 +259,43 @@ func GetChats(session *gocql.Session, c *gin.Context) {
 		userMap[strID] = user
 	}
 
+	contacts, err := repos.Contacts.ListContacts(userID)
+	if err != nil {
+		log.Println("Failed to fetch contacts:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch users"})
+		return
+	}
+	customNames := make(map[string]string)
+	for _, contact := range contacts {
+		if contact.CustomName != "" {
+			customNames[fmt.Sprintf("%d", contact.ContactID)] = contact.CustomName
+		}
+	}
+
 	for i := range chats {
 		if user, ok := userMap[chats[i].CompanionID]; ok {
 			chats[i].CompanionName = user.Name
 			chats[i].CompanionSoName = user.SoName
 			chats[i].CompanionNik = user.Nik
+			chats[i].CompanionAvatar = user.Avatar
+			if name, ok := customNames[chats[i].CompanionID]; ok {
+				chats[i].CompanionName = name
+				chats[i].CompanionSoName = ""
+			}
 		}
 
-		queryLastMessage := fmt.Sprintf(`SELECT sender_id, message_text FROM %s WHERE chat_id = ? ORDER BY created_at DESC LIMIT 1`, keyspaceMessages)
-		var lastMessageSenderID *uint
-		var lastMessageText *string
-
-		if err := session.Query(queryLastMessage, chats[i].ChatID).Scan(&lastMessageSenderID, &lastMessageText); err != nil {
-			lastMessageSenderID = nil
-			lastMessageText = nil
-		} else {
-			chats[i].IsMyMessage = lastMessageSenderID != nil && *lastMessageSenderID == userID
-			if lastMessageText != nil {
-				decryptedText, err := helpers.DecryptWithPrivateKey(*lastMessageText, chats[i].PrivateKey)
-				if err != nil {
-					log.Println(err)
-					c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to decrypt message"})
-					return
-				}
-				chats[i].LastMsg = &decryptedText
-				chats[i].PrivateKey = ""
-			}
+		if err := fillLastMessage(repos, userID, &chats[i]); err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to decrypt message"})
+			return
 		}
+		draft, err := draftFromRepository(chatRows[i])
+		if err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to decrypt message"})
+			return
+		}
+		chats[i].Draft = draft
 	}
 
 	c.JSON(http.StatusOK, gin.H{"chats": chats, "nextPageState": nextPageState})
@@ -266,241 +305,320 @@ func GetChats(session *gocql.Session, c *gin.Context) {
 // CreateChatStruct represents the JSON structure for a user registration request
 // @Description Данные для создания чатов
 type CreateChatStruct struct {
-	Uuid         string `json:"uuid"`
-	User_token   string `json:"user_token"`
+	Uuid string `json:"uuid"`
+	// Устарело: токен передаётся в заголовке Authorization
+	User_token   string `json:"user_token,omitempty"`
 	Companion_id uint   `json:"companion_id"`
 }
 
-func createChatInKeyspace(session *gocql.Session, chatID gocql.UUID, userID, companionID uint, _last_updated time.Time) (gocql.UUID, error) {
-	keyspace := fmt.Sprintf("user_%d", userID)
-	tableName := keyspace + ".chats"
-
-	var existingChatID gocql.UUID
-	var chatType string
-	var secured bool
-	var last_msg_time *time.Time
-	var muted bool
-	var newMsgCount int
-	var last_updated *time.Time
-	var privateKey string
-
-	checkQuery := fmt.Sprintf(`SELECT
-		chat_id,
-		chat_type,
-		secured,
-		muted,
-		last_msg_time,
-		new_msg_count,
-		last_updated,
-		private_key
-	FROM
-		%s.chats
-	WHERE
-		companion_id = ?
-	LIMIT 1
-	ALLOW FILTERING;
-	`, keyspace)
-
-	if err := session.Query(checkQuery, companionID).Scan(&existingChatID, &chatType, &secured, &muted, &last_msg_time, &newMsgCount, &last_updated, &privateKey); err != nil {
-		if err != gocql.ErrNotFound {
-			return gocql.UUID{}, err
-		}
-		chatType = "chat"
-		secured = false
-		muted = false
-		newMsgCount = 0
-		last_msg_time = nil
-		privateKey = tokens.GeneratePrivateKey()
-		existingChatID = chatID
-	} else {
-		deleteQuery := `DELETE FROM ` + tableName + ` WHERE user_id = ? AND last_updated = ? AND companion_id = ? AND chat_id = ?`
-		if err := session.Query(deleteQuery, userID, last_updated, companionID, existingChatID).Exec(); err != nil {
+func createChatForUser(repos *repository.Repositories, chatID gocql.UUID, userID, companionID uint, _last_updated time.Time) (gocql.UUID, error) {
+	chat, err := repos.Chats.FindChatByCompanion(userID, companionID)
+	if err == nil {
+		if err := repos.Chats.TouchChat(userID, chat.ChatID, _last_updated, nil); err != nil {
 			return gocql.UUID{}, err
 		}
+		return chat.ChatID, nil
+	}
+	if err != repository.ErrNotFound {
+		return gocql.UUID{}, err
 	}
 
-	insertQuery := `INSERT INTO ` + tableName + ` (chat_id, user_id, companion_id, chat_type, secured, muted, last_msg_time, new_msg_count, last_updated, private_key) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	chat = repository.Chat{
+		UserID:      userID,
+		ChatID:      chatID,
+		CompanionID: companionID,
+		ChatType:    "chat",
+		Secured:     false,
+		Muted:       false,
+		LastMsgTime: nil,
+		LastUpdated: &_last_updated,
+		PrivateKey:  tokens.GeneratePrivateKey(),
+	}
 
-	if err := session.Query(insertQuery, existingChatID, userID, companionID, chatType, secured, muted, last_msg_time, newMsgCount, _last_updated, privateKey).Exec(); err != nil {
+	if err := repos.Chats.CreateChat(chat); err != nil {
 		return gocql.UUID{}, err
 	}
 
-	return existingChatID, nil
+	return chatID, nil
+}
+
+// canStartChat сообщает, может ли userID начать чат с companionID, который принимает
+// сообщения только от контактов. Уже существующий чат можно продолжать.
+func canStartChat(repos *repository.Repositories, companionID, userID uint) (bool, error) {
+	_, err := repos.Contacts.GetContact(companionID, userID)
+	if err == nil {
+		return true, nil
+	}
+	if err != repository.ErrNotFound {
+		return false, err
+	}
+	_, err = repos.Chats.FindChatByCompanion(companionID, userID)
+	if err == repository.ErrNotFound {
+		return false, nil
+	}
+	return err == nil, err
 }
 
 // @Tags Chats
 // CreateChat godoc
 // @Summary Создание чата
+// @Description Если собеседник разрешил начинать чат только контактам, остальные получают 403.
+// @Description В новый чат записывается служебное сообщение chat_created.
 // @Accept json
 // @Produce  json
 // @Param data body CreateChatStruct true "Данные для создания чата"
+// @Security BearerAuth
 // @Success 200 {object} map[string]interface{} "successful response"
 // @Failure 400 {object} map[string]interface{} "bad request"
+// @Failure 401 {object} map[string]interface{} "unauthorized"
+// @Failure 403 {object} map[string]interface{} "blocked by companion or companion accepts chats only from contacts"
+// @Failure 404 {object} map[string]interface{} "companion not found"
 // @Router /chats/create-chat [post]
-func CreateChat(session *gocql.Session, c *gin.Context) {
+func CreateChat(repos *repository.Repositories, c *gin.Context) {
 	var chatData CreateChatStruct
 	if err := c.BindJSON(&chatData); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
 		return
 	}
 
-	userDataToToken, err := helpers.DecryptAES(chatData.User_token)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
-	}
 	newUUID := uuid.New()
 	newChatID, _ := gocql.UUIDFromBytes(newUUID[:])
 	last_updated := time.Now()
 
-	userID := userDataToToken.User_id
+	userID := middleware.UserID(c)
+
+	if chatData.Companion_id == userID {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Нельзя создать чат с самим собой"})
+		return
+	}
+	companion, err := repos.Users.GetUser(chatData.Companion_id)
+	if err != nil {
+		if err == repository.ErrNotFound {
+			c.JSON(http.StatusNotFound, gin.H{"error": "Пользователь не найден"})
+			return
+		}
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch users"})
+		return
+	}
+	blocked, err := repos.Users.IsBlocked(chatData.Companion_id, userID)
+	if err != nil {
+		log.Println("Failed to check block list:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create chat"})
+		return
+	}
+	if blocked {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Пользователь ограничил вам отправку сообщений"})
+		return
+	}
+	if companion.ContactsOnly {
+		allowed, err := canStartChat(repos, companion.ID, userID)
+		if err != nil {
+			log.Println("Failed to check contacts:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create chat"})
+			return
+		}
+		if !allowed {
+			c.JSON(http.StatusForbidden, gin.H{"error": "Пользователь принимает сообщения только от контактов"})
+			return
+		}
+	}
 
-	chatID, err := createChatInKeyspace(session, newChatID, userID, chatData.Companion_id, last_updated)
+	chatID, err := createChatForUser(repos, newChatID, userID, chatData.Companion_id, last_updated)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to create chat: %v", err)})
 		return
 	}
 
-	_, err = createChatInKeyspace(session, newChatID, chatData.Companion_id, userID, last_updated)
+	_, err = createChatForUser(repos, chatID, chatData.Companion_id, userID, last_updated)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("Failed to create chat for companion: %v", err)})
 		return
 	}
 
+	if chatID == newChatID {
+		chat := repository.Chat{UserID: userID, ChatID: chatID, CompanionID: chatData.Companion_id}
+		if _, err := PostSystemMessage(repos, chat, SystemChatCreated, SystemPayload{ActorID: userID}); err != nil {
+			log.Println("Failed to post chat created message:", err)
+		}
+	}
+
 	UpdeteDataChat(userID, chatID)
 	// UpdeteDataChat(chatData.Companion_id, chatID)
 
 	c.JSON(http.StatusOK, gin.H{"message": "Chat setup successfully for both users", "chat_id": chatID})
 }
 
+// Размер страницы поиска пользователей
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 50
+)
+
+// FoundUser — пользователь в результатах поиска
+// @Description Найденный пользователь. chat_id указан, если чат с ним уже есть.
+type FoundUser struct {
+	UserID  uint        `json:"user_id"`
+	Name    string      `json:"name"`
+	SoName  string      `json:"soName"`
+	Nik     string      `json:"nik"`
+	Avatar  string      `json:"avatar"`
+	HasChat bool        `json:"has_chat"`
+	ChatID  *gocql.UUID `json:"chat_id,omitempty"`
+}
+
 // FindChats retrieves chats for a user.
 // @Tags Chats
 // @Summary Поиск пользователей
-// @Description Получает список пользователей
+// @Description Ищет пользователей по подстроке ника, имени или фамилии. Первыми идут точное совпадение ника,
+// @Description затем ники, начинающиеся с запроса, затем остальные по похожести. Сам пользователь, удалённые аккаунты
+// @Description и пользователи, заблокировавшие его, не возвращаются.
+// @Description next_offset есть в ответе, если результатов больше.
 // @Accept json
 // @Produce json
 // @Param search_term query string true "search_term"
-// @Param uuid query string true "UUID пользователя"
+// @Param limit query int false "Размер страницы (по умолчанию 20, не больше 50)"
+// @Param offset query int false "Смещение"
+// @Security BearerAuth
 // @Success 200 {object} map[string]interface{} "successful response"
 // @Failure 400 {object} map[string]interface{} "bad request"
+// @Failure 401 {object} map[string]interface{} "unauthorized"
 // @Router /chats/find-chats [get]
-func FindChats(db *gorm.DB, c *gin.Context) {
-	searchTerm := c.Query("search_term")
-	searchTerm = "%" + searchTerm + "%"
-	var users []models.User
-
-	if err := db.Model(&models.User{}).Select("ID", "Name", "SoName", "Nik").Where(
-		"LOWER(name) LIKE LOWER(?) OR LOWER(so_name) LIKE LOWER(?) OR LOWER(nik) LIKE LOWER(?)",
-		searchTerm, searchTerm, searchTerm,
-	).Find(&users).Error; err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "Failed to find users", "details": err.Error()})
+func FindChats(repos *repository.Repositories, c *gin.Context) {
+	userID := middleware.UserID(c)
+
+	searchTerm := strings.TrimSpace(c.Query("search_term"))
+	if searchTerm == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "search_term is required"})
 		return
 	}
 
-	var results []map[string]interface{}
+	limit := defaultSearchLimit
+	if value := c.Query("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+			return
+		}
+		limit = min(parsed, maxSearchLimit)
+	}
+	offset := 0
+	if value := c.Query("offset"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid offset"})
+			return
+		}
+		offset = parsed
+	}
+
+	// Заблокировавшие пользователя не находятся в поиске
+	blockers, err := repos.Users.ListBlockers(userID)
+	if err != nil {
+		log.Println("Failed to fetch block list:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to find users"})
+		return
+	}
+
+	// Лишняя запись показывает, есть ли следующая страница
+	users, err := repos.Users.SearchUsers(repository.UserSearch{
+		Term:       searchTerm,
+		ExcludeIDs: append([]uint{userID}, blockers...),
+		Limit:      limit + 1,
+		Offset:     offset,
+	})
+	if err != nil {
+		log.Println("Failed to search users:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to find users"})
+		return
+	}
+
+	response := gin.H{}
+	if len(users) > limit {
+		users = users[:limit]
+		response["next_offset"] = offset + limit
+	}
+
+	results := make([]FoundUser, 0, len(users))
 	for _, user := range users {
-		result := map[string]interface{}{
-			"user_id": user.ID,
-			"name":    user.Name,
-			"soName":  user.SoName,
-			"nik":     user.Nik,
+		result := FoundUser{
+			UserID: user.ID,
+			Name:   user.Name,
+			SoName: user.SoName,
+			Nik:    user.Nik,
+			Avatar: user.Avatar,
+		}
+		chat, err := repos.Chats.FindChatByCompanion(userID, user.ID)
+		switch {
+		case err == nil:
+			result.HasChat = true
+			result.ChatID = &chat.ChatID
+		case err != repository.ErrNotFound:
+			log.Println("Failed to find chat with companion:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to find users"})
+			return
 		}
 		results = append(results, result)
 	}
 
-	c.JSON(http.StatusOK, gin.H{"data": results})
+	response["data"] = results
+	c.JSON(http.StatusOK, response)
 }
 
 func GetChatDetails(userID uint, chatID gocql.UUID) (Chat, error) {
 	var chat Chat
 
-	session, err := database.GetSession()
+	repos, closeRepos, err := repository.Open()
 	if err != nil {
 		return chat, err
 	}
-	defer session.Close()
-
-	keyspaceChats := fmt.Sprintf("user_%d.chats", userID)
-	keyspaceMessages := fmt.Sprintf("user_%d.messages", userID)
-
-	query := fmt.Sprintf(`SELECT
-		chat_id,
-		companion_id,
-		chat_type,
-		secured,
-		last_msg_time,
-		last_updated,
-		new_msg_count,
-		private_key
-	FROM
-		%s
-	WHERE
-		chat_id = ?
-	ALLOW FILTERING;
-	`, keyspaceChats)
-
-	var lastMsgTime *time.Time
-	var lastUpdateTime *time.Time
-	var privateKey string
-
-	if err := session.Query(query, chatID).Scan(
-		&chat.ChatID, &chat.CompanionID, &chat.ChatType, &chat.Secured, &lastMsgTime, &lastUpdateTime, &chat.NewMsgCount, &privateKey,
-	); err != nil {
-		return chat, fmt.Errorf("failed to fetch chat details: %v", err)
-	}
+	defer closeRepos()
 
-	if lastMsgTime != nil {
-		chat.LastMsgTime = *lastMsgTime
-	} else {
-		chat.LastMsgTime = nil
-	}
-
-	if lastUpdateTime != nil {
-		chat.LastUpdated = *lastUpdateTime
-	} else {
-		chat.LastUpdated = nil
+	chatRow, err := repos.Chats.GetChat(userID, chatID)
+	if err != nil {
+		return chat, fmt.Errorf("failed to fetch chat details: %v", err)
 	}
 
-	db, err := database.GetDb()
+	newMsgCount, err := repos.Chats.GetUnreadCount(userID, chatID)
 	if err != nil {
-		return chat, fmt.Errorf("failed to connect to the database: %v", err)
+		return chat, fmt.Errorf("failed to fetch chat details: %v", err)
 	}
 
-	var users []models.User
-	if err := db.Where("id IN ?", []string{fmt.Sprintf("%d", userID), chat.CompanionID}).Find(&users).Error; err != nil {
+	chat = chatFromRepository(chatRow, newMsgCount)
+
+	companion, err := repos.Users.GetUser(chatRow.CompanionID)
+	if err != nil && err != repository.ErrNotFound {
 		log.Println("Failed to fetch users from PostgreSQL:", err)
 		return chat, fmt.Errorf("failed to fetch users: %v", err)
 	}
-
-	for _, user := range users {
-		if fmt.Sprintf("%d", user.ID) == fmt.Sprintf("%d", userID) {
-		} else if fmt.Sprintf("%d", user.ID) == chat.CompanionID {
-			chat.CompanionName = user.Name
-			chat.CompanionSoName = user.SoName
-			chat.CompanionNik = user.Nik
+	if err == nil {
+		chat.CompanionName = companion.Name
+		chat.CompanionSoName = companion.SoName
+		chat.CompanionNik = companion.Nik
+		chat.CompanionAvatar = companion.Avatar
+
+		contact, err := repos.Contacts.GetContact(userID, companion.ID)
+		if err != nil && err != repository.ErrNotFound {
+			return chat, fmt.Errorf("failed to fetch contact: %v", err)
+		}
+		if err == nil && contact.CustomName != "" {
+			chat.CompanionName = contact.CustomName
+			chat.CompanionSoName = ""
 		}
 	}
 
-	queryLastMessage := fmt.Sprintf(`SELECT sender_id, message_text FROM %s WHERE chat_id = ? ORDER BY created_at DESC LIMIT 1`, keyspaceMessages)
-	var lastMessageSenderID *uint
-	var lastMessageText *string
+	if err := fillLastMessage(repos, userID, &chat); err != nil {
+		return chat, fmt.Errorf("failed to fetch chat details: %v", err)
+	}
+	if chat.Draft, err = draftFromRepository(chatRow); err != nil {
+		return chat, fmt.Errorf("failed to decrypt draft: %v", err)
+	}
 
-	if err := session.Query(queryLastMessage, chat.ChatID).Scan(&lastMessageSenderID, &lastMessageText); err != nil {
-		lastMessageSenderID = nil
-		lastMessageText = nil
-		chat.LastMsg = nil
-		chat.IsMyMessage = false
-	} else {
-		chat.LastMsg = lastMessageText
-		chat.IsMyMessage = lastMessageSenderID != nil && *lastMessageSenderID == userID
-
-		if lastMessageText != nil {
-			decryptedText, err := helpers.DecryptWithPrivateKey(*lastMessageText, privateKey)
-			if err != nil {
-				return chat, fmt.Errorf("failed to fetch chat details: %v", err)
-			}
-			chat.LastMsg = &decryptedText
-		}
+	pins, err := repos.Messages.ListPinnedMessages(userID, chatID)
+	if err != nil {
+		return chat, fmt.Errorf("failed to fetch pinned messages: %v", err)
+	}
+	for _, pin := range pins {
+		chat.PinnedMessageIDs = append(chat.PinnedMessageIDs, pin.MessageID)
 	}
 
 	return chat, nil
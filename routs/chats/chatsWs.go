@@ -1,8 +1,8 @@
 package chats
 
 import (
-	"Bmessage_backend/database"
-	"Bmessage_backend/helpers"
+	"Bmessage_backend/middleware"
+	"encoding/json"
 	"log"
 	"net/http"
 	"sync"
@@ -13,8 +13,7 @@ import (
 )
 
 type ConnectionData struct {
-	WS        *websocket.Conn
-	UserToken string
+	WS *websocket.Conn
 }
 
 var (
@@ -32,10 +31,17 @@ var upgrader = websocket.Upgrader{
 
 func ChatsRouterWs(router *gin.Engine) {
 	routeBase := "chatsWS/"
-	router.GET(routeBase+"events-chats", database.WithDatabaseScylla(cahtsConnectorWs))
+	router.GET(routeBase+"events-chats", middleware.RequireUser(), cahtsConnectorWs)
 }
 
-func cahtsConnectorWs(session *gocql.Session, c *gin.Context) {
+// wsRequest — сообщение клиента в хабе чатов. type = "draft" сохраняет черновик,
+// остальные сообщения возвращаются отправителю как есть.
+type wsRequest struct {
+	Type string `json:"type"`
+	DraftStruct
+}
+
+func cahtsConnectorWs(c *gin.Context) {
 	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
 	if err != nil {
 		log.Println("Failed to set websocket upgrade:", err)
@@ -43,14 +49,7 @@ func cahtsConnectorWs(session *gocql.Session, c *gin.Context) {
 	}
 	defer conn.Close()
 
-	userToken := c.Query("userToken")
-	userDataToToken, err := helpers.DecryptAES(userToken)
-	if err != nil {
-		log.Println("Error writing json to connection:", err)
-		return
-	}
-
-	userID := userDataToToken.User_id
+	userID := middleware.UserID(c)
 
 	wsData := ConnectionData{
 		WS: conn,
@@ -60,18 +59,31 @@ func cahtsConnectorWs(session *gocql.Session, c *gin.Context) {
 	defer removeConnection(userID, conn)
 
 	for {
-		var msg interface{}
+		var msg json.RawMessage
 		err := conn.ReadJSON(&msg)
 		if err != nil {
 			break
 		}
 
-		if err = conn.WriteJSON(msg); err != nil {
+		var request wsRequest
+		if json.Unmarshal(msg, &request) == nil && request.Type == "draft" {
+			saveDraftFromWs(userID, conn, request.DraftStruct)
+			continue
+		}
+
+		if err = writeToConnection(conn, msg); err != nil {
 			break
 		}
 	}
 }
 
+// writeToConnection пишет в подключение под connectionsMu, чтобы не пересечься с рассылками
+func writeToConnection(conn *websocket.Conn, v interface{}) error {
+	connectionsMu.Lock()
+	defer connectionsMu.Unlock()
+	return conn.WriteJSON(v)
+}
+
 func addConnection(userID uint, data ConnectionData) {
 	connectionsMu.Lock()
 	defer connectionsMu.Unlock()
@@ -106,3 +118,18 @@ func UpdeteDataChat(userID uint, chatID gocql.UUID) {
 		}
 	}
 }
+
+// SendDraftUpdate рассылает изменение черновика подключениям пользователя, кроме except
+func SendDraftUpdate(userID uint, update DraftUpdate, except *websocket.Conn) {
+	connectionsMu.Lock()
+	defer connectionsMu.Unlock()
+
+	for _, conn := range connections[userID] {
+		if conn.WS == except {
+			continue
+		}
+		if err := conn.WS.WriteJSON(gin.H{"draftUpdate": update}); err != nil {
+			log.Println("Error writing json to connection:", err)
+		}
+	}
+}
@@ -0,0 +1,136 @@
+package chats
+
+import (
+	"Bmessage_backend/models"
+	"Bmessage_backend/testutil"
+	"net/http"
+	"testing"
+
+	"github.com/gocql/gocql"
+)
+
+func TestCreateChat(t *testing.T) {
+	repos, router := testutil.Setup(t)
+	ChatRouter(router)
+
+	ivan, ivanToken := testutil.CreateUser(t, repos, "ivan")
+	petr, petrToken := testutil.CreateUser(t, repos, "petr")
+
+	var created struct {
+		ChatID gocql.UUID `json:"chat_id"`
+	}
+	rec := testutil.DoAs(t, router, ivanToken, http.MethodPost, "/chats/create-chat", CreateChatStruct{Companion_id: petr.ID})
+	testutil.Decode(t, rec, http.StatusOK, &created)
+
+	for _, userID := range []uint{ivan.ID, petr.ID} {
+		if _, err := repos.Chats.GetChat(userID, created.ChatID); err != nil {
+			t.Fatalf("chat for user %d: %v", userID, err)
+		}
+	}
+
+	var again struct {
+		ChatID gocql.UUID `json:"chat_id"`
+	}
+	rec = testutil.DoAs(t, router, petrToken, http.MethodPost, "/chats/create-chat", CreateChatStruct{Companion_id: ivan.ID})
+	testutil.Decode(t, rec, http.StatusOK, &again)
+	if again.ChatID != created.ChatID {
+		t.Fatalf("second create-chat returned %s, want existing %s", again.ChatID, created.ChatID)
+	}
+
+	var list struct {
+		Chats []Chat `json:"chats"`
+	}
+	rec = testutil.DoAs(t, router, ivanToken, http.MethodGet, "/chats/get-chats", nil)
+	testutil.Decode(t, rec, http.StatusOK, &list)
+	if len(list.Chats) != 1 {
+		t.Fatalf("get-chats returned %d chats, want 1", len(list.Chats))
+	}
+	chat := list.Chats[0]
+	if chat.ChatID != created.ChatID || chat.CompanionNik != "petr" || chat.NewMsgCount != 0 || chat.LastMsg != nil {
+		t.Fatalf("unexpected chat: %+v", chat)
+	}
+}
+
+func TestCreateChatValidatesCompanion(t *testing.T) {
+	repos, router := testutil.Setup(t)
+	ChatRouter(router)
+
+	ivan, ivanToken := testutil.CreateUser(t, repos, "ivan")
+
+	rec := testutil.DoAs(t, router, ivanToken, http.MethodPost, "/chats/create-chat", CreateChatStruct{Companion_id: ivan.ID})
+	testutil.Decode(t, rec, http.StatusBadRequest, nil)
+
+	rec = testutil.DoAs(t, router, ivanToken, http.MethodPost, "/chats/create-chat", CreateChatStruct{Companion_id: ivan.ID + 100})
+	testutil.Decode(t, rec, http.StatusNotFound, nil)
+}
+
+func TestFindChatsRequiresToken(t *testing.T) {
+	repos, router := testutil.Setup(t)
+	ChatRouter(router)
+
+	_, ivanToken := testutil.CreateUser(t, repos, "ivan")
+	testutil.CreateUser(t, repos, "petr")
+
+	rec := testutil.Do(t, router, http.MethodGet, "/chats/find-chats?search_term=petr", nil)
+	testutil.Decode(t, rec, http.StatusUnauthorized, nil)
+
+	var found struct {
+		Data []map[string]interface{} `json:"data"`
+	}
+	rec = testutil.DoAs(t, router, ivanToken, http.MethodGet, "/chats/find-chats?search_term=petr", nil)
+	testutil.Decode(t, rec, http.StatusOK, &found)
+	if len(found.Data) != 1 || found.Data[0]["nik"] != "petr" {
+		t.Fatalf("unexpected search result: %+v", found.Data)
+	}
+}
+
+func TestFindChatsRankingAndPagination(t *testing.T) {
+	repos, router := testutil.Setup(t)
+	ChatRouter(router)
+
+	_, ivanToken := testutil.CreateUser(t, repos, "ivan")
+	anna, _ := testutil.CreateUser(t, repos, "anna")
+	ivanova, _ := testutil.CreateUser(t, repos, "ivanova")
+	ivan2, _ := testutil.CreateUser(t, repos, "ivan2")
+	deleted, _ := testutil.CreateUser(t, repos, "ivan_old")
+	if err := repos.Users.PurgeUser(deleted.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := repos.Users.UpdateProfile(&models.User{Model: anna.Model, Name: "Ivan", SoName: "Anin", Nik: "anna"}); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := testutil.DoAs(t, router, ivanToken, http.MethodPost, "/chats/create-chat", CreateChatStruct{Companion_id: ivanova.ID})
+	testutil.Decode(t, rec, http.StatusOK, nil)
+
+	type page struct {
+		Data       []FoundUser `json:"data"`
+		NextOffset *int        `json:"next_offset"`
+	}
+
+	// Сам пользователь и удалённый аккаунт не находятся, ники с началом «ivan» идут раньше совпадения по имени
+	var first page
+	testutil.Decode(t, testutil.DoAs(t, router, ivanToken, http.MethodGet, "/chats/find-chats?search_term=IVAN&limit=2", nil), http.StatusOK, &first)
+	if len(first.Data) != 2 || first.Data[0].UserID != ivanova.ID || first.Data[1].UserID != ivan2.ID || first.NextOffset == nil || *first.NextOffset != 2 {
+		t.Fatalf("unexpected first page: %+v", first)
+	}
+	if !first.Data[0].HasChat || first.Data[0].ChatID == nil || first.Data[1].HasChat {
+		t.Fatalf("chat flags are wrong: %+v", first.Data)
+	}
+
+	var second page
+	testutil.Decode(t, testutil.DoAs(t, router, ivanToken, http.MethodGet, "/chats/find-chats?search_term=ivan&limit=2&offset=2", nil), http.StatusOK, &second)
+	if len(second.Data) != 1 || second.Data[0].UserID != anna.ID || second.NextOffset != nil {
+		t.Fatalf("unexpected second page: %+v", second)
+	}
+
+	// Точное совпадение ника — первым
+	var exact page
+	testutil.Decode(t, testutil.DoAs(t, router, ivanToken, http.MethodGet, "/chats/find-chats?search_term=ivan2", nil), http.StatusOK, &exact)
+	if len(exact.Data) != 1 || exact.Data[0].Nik != "ivan2" {
+		t.Fatalf("unexpected exact match: %+v", exact)
+	}
+
+	testutil.Decode(t, testutil.DoAs(t, router, ivanToken, http.MethodGet, "/chats/find-chats?search_term=%20", nil), http.StatusBadRequest, nil)
+	testutil.Decode(t, testutil.DoAs(t, router, ivanToken, http.MethodGet, "/chats/find-chats?search_term=ivan&limit=0", nil), http.StatusBadRequest, nil)
+}
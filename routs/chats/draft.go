@@ -0,0 +1,195 @@
+package chats
+
+import (
+	"Bmessage_backend/authz"
+	"Bmessage_backend/helpers"
+	"Bmessage_backend/middleware"
+	"Bmessage_backend/repository"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gocql/gocql"
+	"github.com/gorilla/websocket"
+)
+
+// DraftStruct represents the JSON
+// @Description Черновик чата. Пустой text без reply_to_message_id удаляет черновик.
+type DraftStruct struct {
+	ChatID           string  `json:"chat_id"`
+	Text             string  `json:"text"`
+	ReplyToMessageID *string `json:"reply_to_message_id,omitempty"`
+}
+
+// Draft — неотправленный текст пользователя в чате
+type Draft struct {
+	Text             string      `json:"text"`
+	ReplyToMessageID *gocql.UUID `json:"reply_to_message_id,omitempty"`
+	UpdatedAt        time.Time   `json:"updated_at"`
+}
+
+// DraftUpdate — событие draftUpdate в хабе чатов; draft = null — черновик удалён
+type DraftUpdate struct {
+	ChatID gocql.UUID `json:"chat_id"`
+	Draft  *Draft     `json:"draft"`
+}
+
+// draftError — ошибка сохранения черновика, которую можно показать клиенту
+type draftError struct {
+	status  int
+	message string
+}
+
+func (e *draftError) Error() string {
+	return e.message
+}
+
+// draftFromRepository расшифровывает черновик пользователя; nil — черновика нет
+func draftFromRepository(chat repository.Chat) (*Draft, error) {
+	if chat.DraftUpdatedAt == nil {
+		return nil, nil
+	}
+	draft := &Draft{ReplyToMessageID: chat.DraftReplyToMessageID, UpdatedAt: *chat.DraftUpdatedAt}
+	if chat.DraftText != "" {
+		text, err := helpers.DecryptWithPrivateKey(chat.DraftText, chat.PrivateKey)
+		if err != nil {
+			return nil, err
+		}
+		draft.Text = text
+	}
+	return draft, nil
+}
+
+// saveDraft проверяет и сохраняет черновик пользователя. Черновик личный,
+// поэтому достаточно права на чтение чата.
+func saveDraft(repos *repository.Repositories, userID uint, draftData DraftStruct) (DraftUpdate, error) {
+	chatID, err := gocql.ParseUUID(draftData.ChatID)
+	if err != nil {
+		return DraftUpdate{}, &draftError{http.StatusBadRequest, "Invalid chat_id"}
+	}
+	access, err := authz.Authorize(repos, userID, chatID, authz.Read)
+	if err == authz.ErrForbidden {
+		return DraftUpdate{}, &draftError{http.StatusForbidden, "Нет доступа к чату"}
+	}
+	if err != nil {
+		return DraftUpdate{}, err
+	}
+
+	update := DraftUpdate{ChatID: chatID}
+	if draftData.Text == "" && draftData.ReplyToMessageID == nil {
+		return update, repos.Chats.SetDraft(userID, chatID, "", nil, nil)
+	}
+
+	draft := &Draft{Text: draftData.Text, UpdatedAt: time.Now()}
+	if draftData.ReplyToMessageID != nil {
+		replyID, err := gocql.ParseUUID(*draftData.ReplyToMessageID)
+		if err != nil {
+			return DraftUpdate{}, &draftError{http.StatusBadRequest, "Invalid reply_to_message_id"}
+		}
+		target, err := repos.Messages.GetMessage(userID, chatID, replyID)
+		if err == repository.ErrNotFound || (err == nil && target.Kind != "") {
+			return DraftUpdate{}, &draftError{http.StatusBadRequest, "Replied message not found in this chat"}
+		}
+		if err != nil {
+			return DraftUpdate{}, err
+		}
+		draft.ReplyToMessageID = &replyID
+	}
+
+	var encryptedText string
+	if draft.Text != "" {
+		publicKey, err := helpers.ExtractPublicKey(access.Chat.PrivateKey)
+		if err != nil {
+			return DraftUpdate{}, err
+		}
+		if encryptedText, err = helpers.EncryptWithPublicKey(draft.Text, publicKey); err != nil {
+			return DraftUpdate{}, err
+		}
+	}
+	if err := repos.Chats.SetDraft(userID, chatID, encryptedText, draft.ReplyToMessageID, &draft.UpdatedAt); err != nil {
+		return DraftUpdate{}, err
+	}
+	update.Draft = draft
+	return update, nil
+}
+
+// ClearDraft удаляет черновик пользователя после отправки сообщения и сообщает
+// об этом его устройствам. Чат без черновика не трогается.
+func ClearDraft(repos *repository.Repositories, chat repository.Chat) error {
+	if chat.DraftUpdatedAt == nil {
+		return nil
+	}
+	if err := repos.Chats.SetDraft(chat.UserID, chat.ChatID, "", nil, nil); err != nil {
+		return err
+	}
+	SendDraftUpdate(chat.UserID, DraftUpdate{ChatID: chat.ChatID}, nil)
+	return nil
+}
+
+// @Tags Chats
+// SaveDraft godoc
+// @Summary Сохранение черновика
+// @Description Сохраняет неотправленный текст и сообщение, на которое готовится ответ. Черновик возвращается в get-chats
+// @Description и рассылается остальным устройствам пользователя через chatsWS событием draftUpdate. Пустой text
+// @Description без reply_to_message_id удаляет черновик; отправка сообщения в чат тоже его удаляет.
+// @Description Черновик можно сохранить и через chatsWS: {"type": "draft", "chat_id": ..., "text": ..., "reply_to_message_id": ...}.
+// @Accept json
+// @Produce  json
+// @Param data body DraftStruct true "Чат и черновик"
+// @Security BearerAuth
+// @Success 200 {object} DraftUpdate "сохранённый черновик"
+// @Failure 400 {object} map[string]interface{} "bad request"
+// @Failure 401 {object} map[string]interface{} "unauthorized"
+// @Failure 403 {object} map[string]interface{} "forbidden"
+// @Router /chats/draft [post]
+func SaveDraft(repos *repository.Repositories, c *gin.Context) {
+	var draftData DraftStruct
+	if err := c.BindJSON(&draftData); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+		return
+	}
+
+	userID := middleware.UserID(c)
+	update, err := saveDraft(repos, userID, draftData)
+	var invalid *draftError
+	if errors.As(err, &invalid) {
+		c.JSON(invalid.status, gin.H{"error": invalid.message})
+		return
+	}
+	if err != nil {
+		log.Println("Failed to save draft:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to save draft"})
+		return
+	}
+
+	SendDraftUpdate(userID, update, nil)
+	c.JSON(http.StatusOK, update)
+}
+
+// saveDraftFromWs сохраняет черновик, пришедший через хаб чатов, и рассылает его
+// остальным подключениям пользователя; отправителю приходит только ошибка.
+// Подключение к базе открывается на каждый черновик, а не на всё время жизни сокета.
+func saveDraftFromWs(userID uint, conn *websocket.Conn, draftData DraftStruct) {
+	repos, closeRepos, err := repository.Open()
+	if err != nil {
+		log.Println("Failed to open repositories:", err)
+		writeToConnection(conn, gin.H{"error": "Failed to save draft", "chat_id": draftData.ChatID})
+		return
+	}
+	defer closeRepos()
+
+	update, err := saveDraft(repos, userID, draftData)
+	var invalid *draftError
+	if errors.As(err, &invalid) {
+		writeToConnection(conn, gin.H{"error": invalid.message, "chat_id": draftData.ChatID})
+		return
+	}
+	if err != nil {
+		log.Println("Failed to save draft:", err)
+		writeToConnection(conn, gin.H{"error": "Failed to save draft", "chat_id": draftData.ChatID})
+		return
+	}
+	SendDraftUpdate(userID, update, conn)
+}
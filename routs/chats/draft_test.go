@@ -0,0 +1,136 @@
+package chats
+
+import (
+	"Bmessage_backend/helpers"
+	"Bmessage_backend/repository"
+	"Bmessage_backend/testutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gocql/gocql"
+	"github.com/gorilla/websocket"
+)
+
+// dialChats подключает устройство пользователя к хабу чатов
+func dialChats(t *testing.T, server *httptest.Server, token string) *websocket.Conn {
+	t.Helper()
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/chatsWS/events-chats"
+	conn, _, err := websocket.DefaultDialer.Dial(url, http.Header{"Authorization": {"Bearer " + token}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// readDraftUpdate ждёт событие draftUpdate, пропуская остальные события хаба
+func readDraftUpdate(t *testing.T, conn *websocket.Conn) DraftUpdate {
+	t.Helper()
+	conn.SetReadDeadline(time.Now().Add(2 * time.Second))
+	for {
+		var event struct {
+			DraftUpdate *DraftUpdate `json:"draftUpdate"`
+		}
+		if err := conn.ReadJSON(&event); err != nil {
+			t.Fatalf("waiting for draftUpdate: %v", err)
+		}
+		if event.DraftUpdate != nil {
+			return *event.DraftUpdate
+		}
+	}
+}
+
+func TestDrafts(t *testing.T) {
+	repos, router := testutil.Setup(t)
+	ChatRouter(router)
+	ChatsRouterWs(router)
+	server := httptest.NewServer(router)
+	defer server.Close()
+
+	ivan, ivanToken := testutil.CreateUser(t, repos, "ivan")
+	petr, petrToken := testutil.CreateUser(t, repos, "petr")
+	var created struct {
+		ChatID gocql.UUID `json:"chat_id"`
+	}
+	rec := testutil.DoAs(t, router, ivanToken, http.MethodPost, "/chats/create-chat", CreateChatStruct{Companion_id: petr.ID})
+	testutil.Decode(t, rec, http.StatusOK, &created)
+	chatID := created.ChatID
+
+	list := func(token string) []Chat {
+		t.Helper()
+		var response struct {
+			Chats []Chat `json:"chats"`
+		}
+		testutil.Decode(t, testutil.DoAs(t, router, token, http.MethodGet, "/chats/get-chats", nil), http.StatusOK, &response)
+		return response.Chats
+	}
+
+	// Сообщение, на которое готовится ответ, записывается в копию ivan напрямую
+	chat, err := repos.Chats.GetChat(ivan.ID, chatID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicKey, err := helpers.ExtractPublicKey(chat.PrivateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	text, err := helpers.EncryptWithPublicKey("во сколько встречаемся?", publicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	message := repository.Message{
+		OwnerID:     ivan.ID,
+		ChatID:      chatID,
+		MessageID:   gocql.TimeUUID(),
+		SenderID:    petr.ID,
+		MessageText: text,
+		CreatedAt:   time.Now(),
+	}
+	if err := repos.Messages.AddMessage(message); err != nil {
+		t.Fatal(err)
+	}
+	replyTo := message.MessageID.String()
+
+	phone := dialChats(t, server, ivanToken)
+	desktop := dialChats(t, server, ivanToken)
+
+	// Черновик, сохранённый с одного устройства, приходит на другое
+	if err := phone.WriteJSON(map[string]interface{}{
+		"type": "draft", "chat_id": chatID.String(), "text": "в шесть", "reply_to_message_id": replyTo,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	update := readDraftUpdate(t, desktop)
+	if update.ChatID != chatID || update.Draft == nil || update.Draft.Text != "в шесть" ||
+		update.Draft.ReplyToMessageID == nil || update.Draft.ReplyToMessageID.String() != replyTo {
+		t.Fatalf("unexpected draft update: %+v", update)
+	}
+
+	// Черновик виден в списке чатов только владельцу
+	if chats := list(ivanToken); len(chats) != 1 || chats[0].Draft == nil || chats[0].Draft.Text != "в шесть" {
+		t.Fatalf("unexpected chats: %+v", chats)
+	}
+	if chats := list(petrToken); chats[0].Draft != nil {
+		t.Fatalf("companion sees draft: %+v", chats[0].Draft)
+	}
+
+	foreign := chatID.String()
+	rec = testutil.DoAs(t, router, petrToken, http.MethodPost, "/chats/draft",
+		DraftStruct{ChatID: chatID.String(), Text: "ответ", ReplyToMessageID: &foreign})
+	testutil.Decode(t, rec, http.StatusBadRequest, nil)
+
+	// Пустой черновик удаляет сохранённый на всех остальных устройствах
+	rec = testutil.DoAs(t, router, ivanToken, http.MethodPost, "/chats/draft", DraftStruct{ChatID: chatID.String()})
+	testutil.Decode(t, rec, http.StatusOK, nil)
+	for _, conn := range []*websocket.Conn{phone, desktop} {
+		if update := readDraftUpdate(t, conn); update.Draft != nil {
+			t.Fatalf("draft not cleared: %+v", update)
+		}
+	}
+	if chats := list(ivanToken); chats[0].Draft != nil {
+		t.Fatalf("draft remains after clearing: %+v", chats[0].Draft)
+	}
+}
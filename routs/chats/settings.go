@@ -0,0 +1,383 @@
+package chats
+
+import (
+	"Bmessage_backend/authz"
+	"Bmessage_backend/middleware"
+	"Bmessage_backend/repository"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gocql/gocql"
+)
+
+// Максимальное число закреплённых чатов пользователя
+const maxPinnedChats = 5
+
+// bindChatSetting разбирает тело запроса настройки чата и проверяет доступ к чату.
+// Настройки личные, поэтому достаточно права на чтение.
+func bindChatSetting(repos *repository.Repositories, c *gin.Context, data interface{}, chatIDValue func() string) (uint, gocql.UUID, repository.Chat, bool) {
+	if err := c.BindJSON(data); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+		return 0, gocql.UUID{}, repository.Chat{}, false
+	}
+
+	chatID, err := gocql.ParseUUID(chatIDValue())
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid chat_id"})
+		return 0, gocql.UUID{}, repository.Chat{}, false
+	}
+
+	userID := middleware.UserID(c)
+	access, ok := authz.Require(c, repos, userID, chatID, authz.Read)
+	if !ok {
+		return 0, gocql.UUID{}, repository.Chat{}, false
+	}
+	return userID, chatID, access.Chat, true
+}
+
+// MuteChatStruct represents the JSON
+// @Description Отключение уведомлений чата. Без muted_until — навсегда.
+type MuteChatStruct struct {
+	ChatID     string     `json:"chat_id"`
+	Muted      bool       `json:"muted"`
+	MutedUntil *time.Time `json:"muted_until,omitempty"`
+}
+
+// @Tags Chats
+// MuteChat godoc
+// @Summary Отключение уведомлений чата
+// @Description muted = true отключает уведомления до muted_until или навсегда, muted = false включает их.
+// @Accept json
+// @Produce  json
+// @Param data body MuteChatStruct true "Чат и срок"
+// @Security BearerAuth
+// @Success 200 {object} map[string]interface{} "successful response"
+// @Failure 400 {object} map[string]interface{} "bad request"
+// @Failure 401 {object} map[string]interface{} "unauthorized"
+// @Failure 403 {object} map[string]interface{} "forbidden"
+// @Router /chats/mute [post]
+func MuteChat(repos *repository.Repositories, c *gin.Context) {
+	var muteData MuteChatStruct
+	userID, chatID, _, ok := bindChatSetting(repos, c, &muteData, func() string { return muteData.ChatID })
+	if !ok {
+		return
+	}
+
+	until := muteData.MutedUntil
+	if !muteData.Muted {
+		until = nil
+	} else if until != nil && !until.After(time.Now()) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "muted_until must be in the future"})
+		return
+	}
+
+	if err := repos.Chats.SetMuted(userID, chatID, muteData.Muted, until); err != nil {
+		log.Println("Failed to mute chat:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update chat"})
+		return
+	}
+
+	UpdeteDataChat(userID, chatID)
+	c.JSON(http.StatusOK, gin.H{"message": "Chat updated"})
+}
+
+// PinChatStruct represents the JSON
+// @Description Закрепление или открепление чата
+type PinChatStruct struct {
+	ChatID string `json:"chat_id"`
+	Pinned bool   `json:"pinned"`
+}
+
+// @Tags Chats
+// PinChat godoc
+// @Summary Закрепление чата
+// @Description Закреплённый чат добавляется в конец списка закреплённых; их не больше 5. Закрепление возвращает чат из архива.
+// @Accept json
+// @Produce  json
+// @Param data body PinChatStruct true "Чат"
+// @Security BearerAuth
+// @Success 200 {object} map[string]interface{} "successful response"
+// @Failure 400 {object} map[string]interface{} "bad request"
+// @Failure 401 {object} map[string]interface{} "unauthorized"
+// @Failure 403 {object} map[string]interface{} "forbidden"
+// @Failure 409 {object} map[string]interface{} "too many pinned chats"
+// @Router /chats/pin [post]
+func PinChat(repos *repository.Repositories, c *gin.Context) {
+	var pinData PinChatStruct
+	userID, chatID, chat, ok := bindChatSetting(repos, c, &pinData, func() string { return pinData.ChatID })
+	if !ok {
+		return
+	}
+
+	pinned, err := repos.Chats.ListPinnedChats(userID)
+	if err != nil {
+		log.Println("Failed to list pinned chats:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update chat"})
+		return
+	}
+
+	var changed []gocql.UUID
+	if pinData.Pinned {
+		if chat.PinOrder == 0 {
+			if len(pinned) >= maxPinnedChats {
+				c.JSON(http.StatusConflict, gin.H{"error": "Too many pinned chats"})
+				return
+			}
+			order := 1
+			if len(pinned) > 0 {
+				order = pinned[len(pinned)-1].PinOrder + 1
+			}
+			if err := repos.Chats.SetPinOrder(userID, chatID, order); err != nil {
+				log.Println("Failed to pin chat:", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update chat"})
+				return
+			}
+		}
+		if chat.Archived {
+			if err := repos.Chats.SetArchived(userID, chatID, false); err != nil {
+				log.Println("Failed to unarchive chat:", err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update chat"})
+				return
+			}
+		}
+		changed = append(changed, chatID)
+	} else if chat.PinOrder > 0 {
+		changed, err = unpinChat(repos, userID, chatID, pinned)
+		if err != nil {
+			log.Println("Failed to unpin chat:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update chat"})
+			return
+		}
+	}
+
+	for _, id := range changed {
+		UpdeteDataChat(userID, id)
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Chat updated"})
+}
+
+// unpinChat открепляет чат и сдвигает оставшиеся, чтобы порядок шёл с 1 без пропусков.
+// Возвращает чаты, у которых изменилась позиция.
+func unpinChat(repos *repository.Repositories, userID uint, chatID gocql.UUID, pinned []repository.Chat) ([]gocql.UUID, error) {
+	if err := repos.Chats.SetPinOrder(userID, chatID, 0); err != nil {
+		return nil, err
+	}
+	changed := []gocql.UUID{chatID}
+	order := 1
+	for _, chat := range pinned {
+		if chat.ChatID == chatID {
+			continue
+		}
+		if chat.PinOrder != order {
+			if err := repos.Chats.SetPinOrder(userID, chat.ChatID, order); err != nil {
+				return changed, err
+			}
+			changed = append(changed, chat.ChatID)
+		}
+		order++
+	}
+	return changed, nil
+}
+
+// ReorderPinnedStruct represents the JSON
+// @Description Новый порядок закреплённых чатов
+type ReorderPinnedStruct struct {
+	ChatIDs []string `json:"chat_ids"`
+}
+
+// @Tags Chats
+// ReorderPinnedChats godoc
+// @Summary Порядок закреплённых чатов
+// @Description chat_ids должен содержать ровно все закреплённые чаты пользователя в новом порядке.
+// @Accept json
+// @Produce  json
+// @Param data body ReorderPinnedStruct true "Закреплённые чаты"
+// @Security BearerAuth
+// @Success 200 {object} map[string]interface{} "successful response"
+// @Failure 400 {object} map[string]interface{} "bad request"
+// @Failure 401 {object} map[string]interface{} "unauthorized"
+// @Router /chats/reorder-pinned [post]
+func ReorderPinnedChats(repos *repository.Repositories, c *gin.Context) {
+	var reorderData ReorderPinnedStruct
+	if err := c.BindJSON(&reorderData); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+		return
+	}
+
+	userID := middleware.UserID(c)
+
+	pinned, err := repos.Chats.ListPinnedChats(userID)
+	if err != nil {
+		log.Println("Failed to list pinned chats:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update chats"})
+		return
+	}
+	current := make(map[gocql.UUID]int, len(pinned))
+	for _, chat := range pinned {
+		current[chat.ChatID] = chat.PinOrder
+	}
+
+	if len(reorderData.ChatIDs) != len(pinned) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "chat_ids must list all pinned chats"})
+		return
+	}
+	chatIDs := make([]gocql.UUID, 0, len(reorderData.ChatIDs))
+	seen := make(map[gocql.UUID]bool, len(reorderData.ChatIDs))
+	for _, value := range reorderData.ChatIDs {
+		chatID, err := gocql.ParseUUID(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid chat_id"})
+			return
+		}
+		if _, ok := current[chatID]; !ok || seen[chatID] {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "chat_ids must list all pinned chats"})
+			return
+		}
+		seen[chatID] = true
+		chatIDs = append(chatIDs, chatID)
+	}
+
+	for i, chatID := range chatIDs {
+		if current[chatID] == i+1 {
+			continue
+		}
+		if err := repos.Chats.SetPinOrder(userID, chatID, i+1); err != nil {
+			log.Println("Failed to reorder pinned chats:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update chats"})
+			return
+		}
+		UpdeteDataChat(userID, chatID)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"message": "Chats updated"})
+}
+
+// ArchiveChatStruct represents the JSON
+// @Description Перенос чата в архив или из архива
+type ArchiveChatStruct struct {
+	ChatID   string `json:"chat_id"`
+	Archived bool   `json:"archived"`
+}
+
+// @Tags Chats
+// ArchiveChat godoc
+// @Summary Архивирование чата
+// @Description Архивный чат скрыт из основного списка и открепляется. Новое сообщение возвращает его из архива, если уведомления чата не отключены.
+// @Accept json
+// @Produce  json
+// @Param data body ArchiveChatStruct true "Чат"
+// @Security BearerAuth
+// @Success 200 {object} map[string]interface{} "successful response"
+// @Failure 400 {object} map[string]interface{} "bad request"
+// @Failure 401 {object} map[string]interface{} "unauthorized"
+// @Failure 403 {object} map[string]interface{} "forbidden"
+// @Router /chats/archive [post]
+func ArchiveChat(repos *repository.Repositories, c *gin.Context) {
+	var archiveData ArchiveChatStruct
+	userID, chatID, chat, ok := bindChatSetting(repos, c, &archiveData, func() string { return archiveData.ChatID })
+	if !ok {
+		return
+	}
+
+	changed := []gocql.UUID{chatID}
+	if archiveData.Archived && chat.PinOrder > 0 {
+		pinned, err := repos.Chats.ListPinnedChats(userID)
+		if err == nil {
+			changed, err = unpinChat(repos, userID, chatID, pinned)
+		}
+		if err != nil {
+			log.Println("Failed to unpin chat:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update chat"})
+			return
+		}
+	}
+
+	if err := repos.Chats.SetArchived(userID, chatID, archiveData.Archived); err != nil {
+		log.Println("Failed to archive chat:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update chat"})
+		return
+	}
+
+	for _, id := range changed {
+		UpdeteDataChat(userID, id)
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Chat updated"})
+}
+
+// Допустимый срок жизни исчезающих сообщений
+const (
+	minMessageTTL = 5 * time.Second
+	maxMessageTTL = 365 * 24 * time.Hour
+)
+
+// DisappearingMessagesStruct represents the JSON
+// @Description Таймер исчезающих сообщений. ttl_seconds = 0 отключает таймер.
+type DisappearingMessagesStruct struct {
+	ChatID     string `json:"chat_id"`
+	TTLSeconds int    `json:"ttl_seconds"`
+}
+
+// @Tags Chats
+// SetDisappearingMessages godoc
+// @Summary Таймер исчезающих сообщений
+// @Description Таймер общий для обоих участников, и любой из них может его изменить. Он действует на сообщения,
+// @Description отправленные после изменения: каждое исчезает у обоих участников через ttl_seconds после отправки.
+// @Description Допустимо от 5 секунд до года. Изменение таймера записывается в чат служебным сообщением timer_changed.
+// @Accept json
+// @Produce  json
+// @Param data body DisappearingMessagesStruct true "Чат и срок жизни сообщений"
+// @Security BearerAuth
+// @Success 200 {object} map[string]interface{} "successful response"
+// @Failure 400 {object} map[string]interface{} "bad request"
+// @Failure 401 {object} map[string]interface{} "unauthorized"
+// @Failure 403 {object} map[string]interface{} "forbidden"
+// @Router /chats/disappearing [post]
+func SetDisappearingMessages(repos *repository.Repositories, c *gin.Context) {
+	var ttlData DisappearingMessagesStruct
+	if err := c.BindJSON(&ttlData); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+		return
+	}
+
+	chatID, err := gocql.ParseUUID(ttlData.ChatID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid chat_id"})
+		return
+	}
+	ttl := time.Duration(ttlData.TTLSeconds) * time.Second
+	if ttlData.TTLSeconds != 0 && (ttl < minMessageTTL || ttl > maxMessageTTL) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "ttl_seconds must be 0 or between 5 seconds and a year"})
+		return
+	}
+
+	// Таймер меняется в чатах обоих участников, поэтому нужно право писать собеседнику
+	userID := middleware.UserID(c)
+	access, ok := authz.Require(c, repos, userID, chatID, authz.Post)
+	if !ok {
+		return
+	}
+	companionID := access.Chat.CompanionID
+	if access.Chat.MessageTTL == ttlData.TTLSeconds {
+		c.JSON(http.StatusOK, gin.H{"message": "Chat updated"})
+		return
+	}
+
+	for _, ownerID := range []uint{userID, companionID} {
+		if err := repos.Chats.SetMessageTTL(ownerID, chatID, ttlData.TTLSeconds); err != nil {
+			log.Println("Failed to set message ttl:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update chat"})
+			return
+		}
+	}
+
+	// Служебное сообщение само отправляет обоим участникам обновлённый чат
+	payload := SystemPayload{ActorID: userID, TTLSeconds: &ttlData.TTLSeconds}
+	if _, err := PostSystemMessage(repos, access.Chat, SystemTimerChanged, payload); err != nil {
+		log.Println("Failed to post timer changed message:", err)
+		UpdeteDataChat(userID, chatID)
+		UpdeteDataChat(companionID, chatID)
+	}
+	c.JSON(http.StatusOK, gin.H{"message": "Chat updated"})
+}
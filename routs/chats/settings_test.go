@@ -0,0 +1,129 @@
+package chats
+
+import (
+	"Bmessage_backend/testutil"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+func TestChatSettings(t *testing.T) {
+	repos, router := testutil.Setup(t)
+	ChatRouter(router)
+
+	_, ivanToken := testutil.CreateUser(t, repos, "ivan")
+	var chatIDs []gocql.UUID
+	for i := 0; i < 3; i++ {
+		companion, _ := testutil.CreateUser(t, repos, fmt.Sprintf("user%d", i))
+		var created struct {
+			ChatID gocql.UUID `json:"chat_id"`
+		}
+		rec := testutil.DoAs(t, router, ivanToken, http.MethodPost, "/chats/create-chat", CreateChatStruct{Companion_id: companion.ID})
+		testutil.Decode(t, rec, http.StatusOK, &created)
+		chatIDs = append(chatIDs, created.ChatID)
+	}
+
+	list := func(query string) []Chat {
+		t.Helper()
+		var response struct {
+			Chats []Chat `json:"chats"`
+		}
+		testutil.Decode(t, testutil.DoAs(t, router, ivanToken, http.MethodGet, "/chats/get-chats"+query, nil), http.StatusOK, &response)
+		return response.Chats
+	}
+	post := func(path string, body interface{}, status int) {
+		t.Helper()
+		testutil.Decode(t, testutil.DoAs(t, router, ivanToken, http.MethodPost, path, body), status, nil)
+	}
+
+	// Закреплённые чаты идут первыми в своём порядке, без повторов
+	post("/chats/pin", PinChatStruct{ChatID: chatIDs[0].String(), Pinned: true}, http.StatusOK)
+	post("/chats/pin", PinChatStruct{ChatID: chatIDs[1].String(), Pinned: true}, http.StatusOK)
+	chats := list("")
+	if len(chats) != 3 || chats[0].ChatID != chatIDs[0] || chats[1].ChatID != chatIDs[1] || !chats[1].Pinned || chats[2].Pinned {
+		t.Fatalf("unexpected pinned list: %+v", chats)
+	}
+
+	post("/chats/reorder-pinned", ReorderPinnedStruct{ChatIDs: []string{chatIDs[1].String(), chatIDs[0].String()}}, http.StatusOK)
+	if chats = list(""); chats[0].ChatID != chatIDs[1] || chats[1].ChatID != chatIDs[0] {
+		t.Fatalf("reorder ignored: %+v", chats)
+	}
+	post("/chats/reorder-pinned", ReorderPinnedStruct{ChatIDs: []string{chatIDs[1].String()}}, http.StatusBadRequest)
+
+	// Архивирование открепляет чат и убирает его из основного списка
+	post("/chats/archive", ArchiveChatStruct{ChatID: chatIDs[1].String(), Archived: true}, http.StatusOK)
+	chats = list("")
+	if len(chats) != 2 || chats[0].ChatID != chatIDs[0] || chats[0].PinOrder != 1 {
+		t.Fatalf("unexpected main list after archive: %+v", chats)
+	}
+	if archived := list("?archived=true"); len(archived) != 1 || archived[0].ChatID != chatIDs[1] || archived[0].Pinned {
+		t.Fatalf("unexpected archive: %+v", archived)
+	}
+
+	until := time.Now().Add(time.Hour)
+	post("/chats/mute", MuteChatStruct{ChatID: chatIDs[2].String(), Muted: true, MutedUntil: &until}, http.StatusOK)
+	past := time.Now().Add(-time.Hour)
+	post("/chats/mute", MuteChatStruct{ChatID: chatIDs[2].String(), Muted: true, MutedUntil: &past}, http.StatusBadRequest)
+	chats = list("")
+	if chats[1].ChatID != chatIDs[2] || !chats[1].Muted || chats[1].MutedUntil == nil || chats[0].Muted {
+		t.Fatalf("unexpected mute state: %+v", chats)
+	}
+
+	post("/chats/mute", MuteChatStruct{ChatID: chatIDs[2].String(), Muted: false}, http.StatusOK)
+	if chats = list(""); chats[1].Muted || chats[1].MutedUntil != nil {
+		t.Fatalf("chat still muted: %+v", chats[1])
+	}
+}
+
+func TestArchivedChatsDoNotShortenPages(t *testing.T) {
+	repos, router := testutil.Setup(t)
+	ChatRouter(router)
+
+	_, ivanToken := testutil.CreateUser(t, repos, "ivan")
+	createChat := func(nik string) gocql.UUID {
+		t.Helper()
+		companion, _ := testutil.CreateUser(t, repos, nik)
+		var created struct {
+			ChatID gocql.UUID `json:"chat_id"`
+		}
+		rec := testutil.DoAs(t, router, ivanToken, http.MethodPost, "/chats/create-chat", CreateChatStruct{Companion_id: companion.ID})
+		testutil.Decode(t, rec, http.StatusOK, &created)
+		return created.ChatID
+	}
+	type page struct {
+		Chats         []Chat `json:"chats"`
+		NextPageState []byte `json:"nextPageState"`
+	}
+	list := func(query string) page {
+		t.Helper()
+		var response page
+		testutil.Decode(t, testutil.DoAs(t, router, ivanToken, http.MethodGet, "/chats/get-chats"+query, nil), http.StatusOK, &response)
+		return response
+	}
+
+	// Одиннадцать архивных чатов новее единственного активного
+	active := createChat("active")
+	for i := 0; i < 11; i++ {
+		chatID := createChat(fmt.Sprintf("archived%d", i))
+		rec := testutil.DoAs(t, router, ivanToken, http.MethodPost, "/chats/archive", ArchiveChatStruct{ChatID: chatID.String(), Archived: true})
+		testutil.Decode(t, rec, http.StatusOK, nil)
+	}
+
+	if main := list(""); len(main.Chats) != 1 || main.Chats[0].ChatID != active || main.NextPageState != nil {
+		t.Fatalf("unexpected main list: %+v", main)
+	}
+
+	first := list("?archived=true")
+	if len(first.Chats) != 10 || first.NextPageState == nil {
+		t.Fatalf("unexpected first archive page: %d chats, next %v", len(first.Chats), first.NextPageState)
+	}
+	second := list("?archived=true&page_state=" + url.QueryEscape(base64.StdEncoding.EncodeToString(first.NextPageState)))
+	if len(second.Chats) != 1 || !second.Chats[0].Archived || second.NextPageState != nil {
+		t.Fatalf("unexpected second archive page: %+v", second)
+	}
+}
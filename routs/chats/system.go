@@ -0,0 +1,88 @@
+package chats
+
+import (
+	"Bmessage_backend/repository"
+	"encoding/json"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+// Виды служебных сообщений. Служебное сообщение хранится в той же таблице, что и обычные,
+// без текста и отправителя: данные события лежат в payload.
+const (
+	SystemChatCreated   = "chat_created"
+	SystemMessagePinned = "message_pinned"
+	SystemTimerChanged  = "timer_changed"
+)
+
+// SystemPayload — данные служебного сообщения; заполнены поля, относящиеся к его виду
+type SystemPayload struct {
+	// ActorID — пользователь, действие которого записано в сообщении
+	ActorID uint `json:"actor_id"`
+	// MessageID — закреплённое сообщение для message_pinned
+	MessageID *gocql.UUID `json:"message_id,omitempty"`
+	// TTLSeconds — новый таймер исчезающих сообщений для timer_changed; 0 — таймер выключен
+	TTLSeconds *int `json:"ttl_seconds,omitempty"`
+}
+
+// ParseSystemPayload разбирает payload служебного сообщения
+func ParseSystemPayload(payload string) (*SystemPayload, error) {
+	var result SystemPayload
+	if err := json.Unmarshal([]byte(payload), &result); err != nil {
+		return nil, err
+	}
+	return &result, nil
+}
+
+// systemMessageSink рассылает записанное служебное сообщение подключённым к чату.
+// Подключения к чатам принадлежат пакету messages, поэтому он задаёт sink через OnSystemMessage.
+var systemMessageSink func(message repository.Message)
+
+// OnSystemMessage задаёт получателя записанных служебных сообщений
+func OnSystemMessage(sink func(message repository.Message)) {
+	systemMessageSink = sink
+}
+
+// PostSystemMessage записывает служебное сообщение в копии обоих участников чата chat
+// и поднимает чат в их списках. Сообщение сразу отмечено прочитанным, поэтому не попадает
+// в счётчики непрочитанных, и не исчезает по таймеру чата.
+func PostSystemMessage(repos *repository.Repositories, chat repository.Chat, kind string, payload SystemPayload) (repository.Message, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return repository.Message{}, err
+	}
+
+	createdAt := time.Now()
+	message := repository.Message{
+		ChatID:    chat.ChatID,
+		MessageID: gocql.TimeUUID(),
+		CreatedAt: createdAt,
+		Read:      true,
+		Kind:      kind,
+		Payload:   string(data),
+	}
+
+	owners := []uint{chat.UserID}
+	if !chat.CompanionDeleted {
+		owners = append(owners, chat.CompanionID)
+	}
+	for _, ownerID := range owners {
+		ownerCopy := message
+		ownerCopy.OwnerID = ownerID
+		if err := repos.Messages.AddMessage(ownerCopy); err != nil {
+			return repository.Message{}, err
+		}
+		if err := repos.Chats.TouchChat(ownerID, chat.ChatID, createdAt, &createdAt); err != nil {
+			return repository.Message{}, err
+		}
+	}
+
+	for _, ownerID := range owners {
+		UpdeteDataChat(ownerID, chat.ChatID)
+	}
+	if systemMessageSink != nil {
+		systemMessageSink(message)
+	}
+	return message, nil
+}
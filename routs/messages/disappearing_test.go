@@ -0,0 +1,227 @@
+package messages
+
+import (
+	"Bmessage_backend/repository"
+	"Bmessage_backend/routs/chats"
+	"Bmessage_backend/testutil"
+	"net/http"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+func (f chatFixture) chatList(t *testing.T, token string) []chats.Chat {
+	t.Helper()
+	var response struct {
+		Chats []chats.Chat `json:"chats"`
+	}
+	testutil.Decode(t, testutil.DoAs(t, f.router, token, http.MethodGet, "/chats/get-chats", nil), http.StatusOK, &response)
+	return response.Chats
+}
+
+func TestDisappearingMessages(t *testing.T) {
+	f := newChatFixture(t)
+
+	setTTL := func(token string, ttl, status int) {
+		t.Helper()
+		rec := testutil.DoAs(t, f.router, token, http.MethodPost, "/chats/disappearing",
+			chats.DisappearingMessagesStruct{ChatID: f.chatID.String(), TTLSeconds: ttl})
+		testutil.Decode(t, rec, status, nil)
+	}
+	setTTL(f.petrToken, 1, http.StatusBadRequest)
+	setTTL(f.petrToken, 3600, http.StatusOK)
+
+	// Таймер, заданный одним участником, виден обоим
+	for _, token := range []string{f.ivanToken, f.petrToken} {
+		if list := f.chatList(t, token); len(list) != 1 || list[0].MessageTTL != 3600 {
+			t.Fatalf("unexpected chats: %+v", list)
+		}
+	}
+
+	before := time.Now()
+	f.send(t, f.ivanToken, "исчезнет через час")
+	for _, token := range []string{f.ivanToken, f.petrToken} {
+		message := f.messages(t, token)[0]
+		if message.ExpiresAt == nil || message.ExpiresAt.Before(before.Add(time.Hour)) || message.ExpiresAt.After(time.Now().Add(time.Hour)) {
+			t.Fatalf("unexpected expires_at: %+v", message)
+		}
+	}
+	if page := f.search(t, f.petrToken, "q="+url.QueryEscape("исчезнет"), http.StatusOK); len(page.Data) != 0 {
+		t.Fatalf("disappearing message is indexed: %+v", page.Data)
+	}
+
+	setTTL(f.ivanToken, 0, http.StatusOK)
+	f.send(t, f.ivanToken, "останется")
+	if message := f.messages(t, f.petrToken)[0]; message.ExpiresAt != nil {
+		t.Fatalf("message expires after timer was disabled: %+v", message)
+	}
+}
+
+// addExpiring записывает входящее для petr сообщение ivan, исчезающее в expiresAt, так же,
+// как его сохраняет отправка: минимальный таймер чата слишком долог для теста
+func (f chatFixture) addExpiring(t *testing.T, createdAt, expiresAt time.Time) gocql.UUID {
+	t.Helper()
+	message := repository.Message{
+		ChatID:    f.chatID,
+		MessageID: gocql.TimeUUID(),
+		SenderID:  f.ivanID,
+		CreatedAt: createdAt,
+		ExpiresAt: &expiresAt,
+	}
+	for _, ownerID := range []uint{f.ivanID, f.petrID} {
+		message.OwnerID = ownerID
+		if err := f.repos.Messages.AddMessage(message); err != nil {
+			t.Fatal(err)
+		}
+		if err := f.repos.Chats.TouchChat(ownerID, f.chatID, createdAt, &createdAt); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := f.repos.Chats.AddUnreadCount(f.petrID, f.chatID, 1); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.repos.Chats.AddUnreadExpiring(f.petrID, f.chatID, message.MessageID, expiresAt); err != nil {
+		t.Fatal(err)
+	}
+	return message.MessageID
+}
+
+func TestChatListSkipsExpiredLastMessage(t *testing.T) {
+	f := newChatFixture(t)
+	f.send(t, f.ivanToken, "обычное")
+
+	createdAt := time.Now()
+	f.addExpiring(t, createdAt, createdAt.Add(20*time.Millisecond))
+	time.Sleep(30 * time.Millisecond)
+
+	list := f.chatList(t, f.petrToken)
+	if len(list) != 1 || list[0].LastMsg == nil || *list[0].LastMsg != "обычное" || list[0].NewMsgCount != 1 {
+		t.Fatalf("unexpected chats after expiry: %+v", list)
+	}
+	if lastMsgTime, ok := list[0].LastMsgTime.(string); !ok || lastMsgTime == "" {
+		t.Fatalf("unexpected last_msg_time: %v", list[0].LastMsgTime)
+	}
+	if unread := f.unread(t, f.petrID); unread != 1 {
+		t.Fatalf("unread = %d, want 1", unread)
+	}
+}
+
+func TestUnreadCountDropsExpiredOlderMessage(t *testing.T) {
+	f := newChatFixture(t)
+	createdAt := time.Now()
+	f.addExpiring(t, createdAt, createdAt.Add(20*time.Millisecond))
+	f.send(t, f.ivanToken, "после исчезающего")
+	if unread := f.unread(t, f.petrID); unread != 2 {
+		t.Fatalf("unread = %d, want 2", unread)
+	}
+	time.Sleep(30 * time.Millisecond)
+
+	// Последнее сообщение на месте, но исчезнувшее непрочитанное больше не считается
+	list := f.chatList(t, f.petrToken)
+	if len(list) != 1 || list[0].LastMsg == nil || *list[0].LastMsg != "после исчезающего" || list[0].NewMsgCount != 1 {
+		t.Fatalf("unexpected chats after expiry: %+v", list)
+	}
+	if unread := f.unread(t, f.petrID); unread != 1 {
+		t.Fatalf("unread = %d, want 1", unread)
+	}
+	// Повторное чтение списка не вычитает сообщение ещё раз
+	if list := f.chatList(t, f.petrToken); list[0].NewMsgCount != 1 {
+		t.Fatalf("expired message subtracted twice: %+v", list)
+	}
+
+	// Прочитанное до исчезновения сообщение больше не отслеживается и из счётчика повторно не вычитается
+	createdAt = time.Now()
+	latest := f.addExpiring(t, createdAt, createdAt.Add(time.Hour))
+	testutil.Decode(t, testutil.DoAs(t, f.router, f.petrToken, http.MethodPost, "/messages/read-messages-up-to",
+		ReadMessagesUpToStruct{ChatID: f.chatID.String(), MessageID: latest.String()}), http.StatusOK, nil)
+	chat, err := f.repos.Chats.GetChat(f.petrID, f.chatID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chat.UnreadExpiring) != 0 {
+		t.Fatalf("read messages still tracked: %v", chat.UnreadExpiring)
+	}
+}
+
+func TestViewOnceMessage(t *testing.T) {
+	f := newChatFixture(t)
+	f.send(t, f.ivanToken, "обычное")
+	rec := testutil.DoAs(t, f.router, f.ivanToken, http.MethodPost, "/messages/add-message", AddMessageStruct{
+		ChatID:      f.chatID.String(),
+		MessageText: "только один раз",
+		ViewOnce:    true,
+	})
+	testutil.Decode(t, rec, http.StatusOK, nil)
+
+	received := f.messages(t, f.petrToken)
+	if len(received) != 2 || !received[0].ViewOnce || received[0].MessageText != "только один раз" {
+		t.Fatalf("unexpected messages: %+v", received)
+	}
+	viewOnce := received[0]
+
+	// Одноразовое сообщение нельзя переслать
+	forward := ForwardMessageStruct{ChatID: f.chatID.String(), MessageID: viewOnce.MessageID.String(), TargetChatIDs: []string{f.chatID.String()}}
+	testutil.Decode(t, testutil.DoAs(t, f.router, f.petrToken, http.MethodPost, "/messages/forward", forward), http.StatusBadRequest, nil)
+
+	// Прочтение до последнего сообщения не открывает одноразовое
+	testutil.Decode(t, testutil.DoAs(t, f.router, f.petrToken, http.MethodPost, "/messages/read-messages-up-to",
+		ReadMessagesUpToStruct{ChatID: f.chatID.String(), MessageID: viewOnce.MessageID.String()}), http.StatusOK, nil)
+	if unread := f.unread(t, f.petrID); unread != 1 {
+		t.Fatalf("unread after read-up-to = %d, want 1", unread)
+	}
+	if len(f.messages(t, f.petrToken)) != 2 {
+		t.Fatal("view-once message consumed by read-up-to")
+	}
+
+	// Отправитель, читающий своё сообщение, его не удаляет
+	read := ReadMessageStruct{ChatID: f.chatID.String(), MessageID: viewOnce.MessageID.String()}
+	testutil.Decode(t, testutil.DoAs(t, f.router, f.ivanToken, http.MethodPost, "/messages/read-message", read), http.StatusOK, nil)
+	if len(f.messages(t, f.ivanToken)) != 2 {
+		t.Fatal("view-once message consumed by its sender")
+	}
+
+	testutil.Decode(t, testutil.DoAs(t, f.router, f.petrToken, http.MethodPost, "/messages/read-message", read), http.StatusOK, nil)
+	for _, token := range []string{f.ivanToken, f.petrToken} {
+		if messages := f.messages(t, token); len(messages) != 1 || messages[0].MessageText != "обычное" {
+			t.Fatalf("view-once message not deleted: %+v", messages)
+		}
+	}
+	if unread := f.unread(t, f.petrID); unread != 0 {
+		t.Fatalf("unread after viewing = %d, want 0", unread)
+	}
+}
+
+func TestReplyPreviewHidesViewOnceText(t *testing.T) {
+	f := newChatFixture(t)
+	rec := testutil.DoAs(t, f.router, f.ivanToken, http.MethodPost, "/messages/add-message", AddMessageStruct{
+		ChatID:      f.chatID.String(),
+		MessageText: "секрет",
+		ViewOnce:    true,
+	})
+	testutil.Decode(t, rec, http.StatusOK, nil)
+	viewOnce := f.messages(t, f.ivanToken)[0].MessageID.String()
+
+	rec = testutil.DoAs(t, f.router, f.ivanToken, http.MethodPost, "/messages/add-message", AddMessageStruct{
+		ChatID:           f.chatID.String(),
+		MessageText:      "это только для тебя",
+		ReplyToMessageID: &viewOnce,
+	})
+	testutil.Decode(t, rec, http.StatusOK, nil)
+
+	// Цитата не раскрывает текст ни отправителю, ни получателю до и после просмотра
+	check := func() {
+		t.Helper()
+		for _, token := range []string{f.ivanToken, f.petrToken} {
+			preview := f.messages(t, token)[0].ReplyPreview
+			if preview == nil || preview.Text != "" || (!preview.ViewOnce && !preview.Deleted) {
+				t.Fatalf("view-once text leaked in reply preview: %+v", preview)
+			}
+		}
+	}
+	check()
+	testutil.Decode(t, testutil.DoAs(t, f.router, f.petrToken, http.MethodPost, "/messages/read-message",
+		ReadMessageStruct{ChatID: f.chatID.String(), MessageID: viewOnce}), http.StatusOK, nil)
+	check()
+}
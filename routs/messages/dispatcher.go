@@ -0,0 +1,157 @@
+package messages
+
+import (
+	"Bmessage_backend/authz"
+	"Bmessage_backend/helpers"
+	"Bmessage_backend/leader"
+	"Bmessage_backend/models"
+	"Bmessage_backend/repository"
+	"context"
+	"log"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+const (
+	// Ключ Redis, которым инстанс удерживает роль диспетчера отложенных сообщений
+	scheduledLeaderKey = "leader:scheduled_messages"
+	// Сколько сообщений диспетчер отправляет за один шаг
+	scheduledBatchSize = 100
+	// Через сколько сообщение, застрявшее в sending (диспетчер упал или потерял Redis
+	// до FinishScheduled), снова забирается на отправку
+	scheduledClaimTimeout = 2 * time.Minute
+)
+
+// sendScheduled отправляет отложенное сообщение от имени отправителя через обычный путь записи
+func sendScheduled(repos *repository.Repositories, scheduled models.ScheduledMessage) (gocql.UUID, error) {
+	chatID, err := gocql.ParseUUID(scheduled.ChatID)
+	if err != nil {
+		return gocql.UUID{}, err
+	}
+	access, err := authz.Authorize(repos, scheduled.SenderID, chatID, authz.Post)
+	if err != nil {
+		return gocql.UUID{}, err
+	}
+
+	text, err := helpers.DecryptWithPrivateKey(scheduled.MessageText, access.Chat.PrivateKey)
+	if err != nil {
+		return gocql.UUID{}, err
+	}
+	out := outgoingMessage{Text: text}
+	if scheduled.MessageID != nil {
+		messageID, err := gocql.ParseUUID(*scheduled.MessageID)
+		if err != nil {
+			return gocql.UUID{}, err
+		}
+		out.MessageID = &messageID
+	}
+	if scheduled.ReplyToMessageID != nil {
+		replyID, err := gocql.ParseUUID(*scheduled.ReplyToMessageID)
+		if err != nil {
+			return gocql.UUID{}, err
+		}
+		out.ReplyToMessageID = &replyID
+	}
+
+	message, err := deliverMessage(repos, access, scheduled.SenderID, out)
+	if err != nil {
+		return gocql.UUID{}, err
+	}
+	return message.MessageID, nil
+}
+
+// dispatchDueScheduled отправляет сообщения, время которых наступило к now, и возвращает
+// число отправленных. Каждое сообщение сначала забирается ClaimScheduled, поэтому
+// изменённое или отменённое в это время сообщение не будет отправлено дважды, а
+// застрявшее после сбоя отправляется повторно с тем же message_id.
+// Перед каждым захватом продлевается аренда lease; потеряв её, диспетчер останавливается.
+// lease = nil — без проверки лидерства.
+func dispatchDueScheduled(ctx context.Context, repos *repository.Repositories, lease *leader.Lease, now time.Time) (int, error) {
+	staleBefore := now.Add(-scheduledClaimTimeout)
+	due, err := repos.Schedule.ListDueScheduled(now, staleBefore, scheduledBatchSize)
+	if err != nil {
+		return 0, err
+	}
+
+	sent := 0
+	for _, candidate := range due {
+		if lease != nil {
+			isLeader, err := lease.Acquire(ctx)
+			if err != nil {
+				return sent, err
+			}
+			if !isLeader {
+				log.Println("Scheduled messages: leadership lost, stopping dispatch")
+				return sent, nil
+			}
+		}
+
+		scheduled, claimed, err := repos.Schedule.ClaimScheduled(candidate.ID, gocql.TimeUUID(), staleBefore)
+		if err != nil {
+			return sent, err
+		}
+		if !claimed {
+			continue
+		}
+
+		messageID, err := sendScheduled(repos, scheduled)
+		if err != nil {
+			log.Printf("Scheduled message %d failed: %v\n", scheduled.ID, err)
+			if err := repos.Schedule.FinishScheduled(scheduled.ID, nil, err.Error()); err != nil {
+				return sent, err
+			}
+			continue
+		}
+		if err := repos.Schedule.FinishScheduled(scheduled.ID, &messageID, ""); err != nil {
+			return sent, err
+		}
+		sent++
+	}
+	return sent, nil
+}
+
+// RunScheduledDispatcher раз в interval отправляет отложенные сообщения, время которых наступило.
+// Блокирует вызывающего до отмены ctx. Работает только на инстансе, удерживающем
+// аренду лидера в Redis; остальные инстансы ждут, пока она освободится. Аренда
+// продлевается перед каждым сообщением, поэтому долгий шаг её не теряет.
+func RunScheduledDispatcher(ctx context.Context, interval time.Duration) {
+	lease := leader.New(scheduledLeaderKey, 3*interval)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			if err := lease.Release(context.Background()); err != nil {
+				log.Println("Scheduled messages: failed to release leadership:", err)
+			}
+			return
+		case <-ticker.C:
+		}
+
+		isLeader, err := lease.Acquire(ctx)
+		if err != nil {
+			log.Println("Scheduled messages: leader election failed:", err)
+			continue
+		}
+		if !isLeader {
+			continue
+		}
+
+		repos, closeRepos, err := repository.Open()
+		if err != nil {
+			log.Println("Scheduled messages: failed to open repositories:", err)
+			continue
+		}
+		sent, err := dispatchDueScheduled(ctx, repos, lease, time.Now())
+		closeRepos()
+		if err != nil {
+			log.Println("Scheduled messages dispatch failed:", err)
+			continue
+		}
+		if sent > 0 {
+			log.Printf("Scheduled messages: sent %d messages\n", sent)
+		}
+	}
+}
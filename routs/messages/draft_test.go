@@ -0,0 +1,24 @@
+package messages
+
+import (
+	"Bmessage_backend/routs/chats"
+	"Bmessage_backend/testutil"
+	"net/http"
+	"testing"
+)
+
+func TestSendClearsDraft(t *testing.T) {
+	f := newChatFixture(t)
+
+	rec := testutil.DoAs(t, f.router, f.ivanToken, http.MethodPost, "/chats/draft",
+		chats.DraftStruct{ChatID: f.chatID.String(), Text: "в шесть"})
+	testutil.Decode(t, rec, http.StatusOK, nil)
+	if list := f.chatList(t, f.ivanToken); list[0].Draft == nil {
+		t.Fatal("draft not saved")
+	}
+
+	f.send(t, f.ivanToken, "в шесть")
+	if list := f.chatList(t, f.ivanToken); list[0].Draft != nil {
+		t.Fatalf("draft remains after send: %+v", list[0].Draft)
+	}
+}
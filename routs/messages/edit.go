@@ -0,0 +1,251 @@
+package messages
+
+import (
+	"Bmessage_backend/authz"
+	"Bmessage_backend/helpers"
+	"Bmessage_backend/middleware"
+	"Bmessage_backend/repository"
+	"Bmessage_backend/routs/chats"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gocql/gocql"
+)
+
+// indexMessage добавляет копию сообщения в поисковый индекс владельца. Ошибка индекса
+// не отменяет запись сообщения, поэтому только логируется. Исчезающие сообщения
+// не индексируются: индекс в Postgres хранил бы их текст дольше самих сообщений.
+func indexMessage(repos *repository.Repositories, message repository.Message, text string) {
+	if message.ExpiresAt != nil || message.ViewOnce {
+		return
+	}
+	entry := repository.SearchEntry{
+		OwnerID:   message.OwnerID,
+		ChatID:    message.ChatID,
+		MessageID: message.MessageID,
+		SenderID:  message.SenderID,
+		CreatedAt: message.CreatedAt,
+		Text:      text,
+	}
+	if err := repos.Search.IndexMessage(entry); err != nil {
+		log.Println("Failed to index message:", err)
+	}
+}
+
+// EditMessageStruct represents the JSON
+// @Description Новый текст сообщения. Редактировать можно только свои сообщения.
+type EditMessageStruct struct {
+	ChatID      string `json:"chat_id"`
+	MessageID   string `json:"message_id"`
+	MessageText string `json:"message_text"`
+}
+
+// @Tags Message
+// EditMessage godoc
+// @Summary Редактирование сообщения
+// @Description Заменяет текст в копиях обоих участников и в поисковом индексе. Участникам чата уходит событие с type = "edited".
+// @Accept json
+// @Produce  json
+// @Param data body EditMessageStruct true "Сообщение и новый текст"
+// @Security BearerAuth
+// @Success 200 {object} map[string]interface{} "successful response"
+// @Failure 400 {object} map[string]interface{} "bad request"
+// @Failure 401 {object} map[string]interface{} "unauthorized"
+// @Failure 403 {object} map[string]interface{} "forbidden"
+// @Failure 404 {object} map[string]interface{} "message not found"
+// @Router /messages/edit-message [post]
+func EditMessage(repos *repository.Repositories, c *gin.Context) {
+	var messageData EditMessageStruct
+	if err := c.BindJSON(&messageData); err != nil || messageData.MessageText == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+		return
+	}
+
+	chatID, err := gocql.ParseUUID(messageData.ChatID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid chat_id"})
+		return
+	}
+
+	messageID, err := gocql.ParseUUID(messageData.MessageID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid message_id"})
+		return
+	}
+
+	userID := middleware.UserID(c)
+
+	access, ok := authz.Require(c, repos, userID, chatID, authz.Post)
+	if !ok {
+		return
+	}
+
+	message, err := repos.Messages.GetMessage(userID, chatID, messageID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Message not found"})
+		return
+	}
+	if message.SenderID != userID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only the sender can edit a message"})
+		return
+	}
+
+	editedAt := time.Now()
+	copies := []repository.Chat{access.Chat, access.CompanionChat}
+	for _, chat := range copies {
+		publicKey, err := helpers.ExtractPublicKey(chat.PrivateKey)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to edit message"})
+			return
+		}
+		encrypted, err := helpers.EncryptWithPublicKey(messageData.MessageText, publicKey)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to edit message"})
+			return
+		}
+
+		err = repos.Messages.UpdateMessageText(chat.UserID, chatID, messageID, encrypted, editedAt)
+		if err == repository.ErrNotFound && chat.UserID != userID {
+			// Собеседник удалил сообщение у себя — редактировать нечего
+			continue
+		}
+		if err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to edit message"})
+			return
+		}
+
+		ownerCopy := message
+		ownerCopy.OwnerID = chat.UserID
+		indexMessage(repos, ownerCopy, messageData.MessageText)
+	}
+
+	edited := messageFromRepository(message)
+	edited.MessageText = messageData.MessageText
+	edited.EditedAt = &editedAt
+	edited.Type = "edited"
+
+	chats.UpdeteDataChat(userID, chatID)
+	chats.UpdeteDataChat(access.Chat.CompanionID, chatID)
+	SendWsMessageToChat(chatID.String(), edited)
+
+	c.JSON(http.StatusOK, gin.H{"status": "Message edited successfully", "edited_at": editedAt})
+}
+
+// DeleteMessageStruct represents the JSON
+// @Description Удаление сообщения. for_everyone удаляет и копию собеседника; доступно только отправителю.
+type DeleteMessageStruct struct {
+	ChatID      string `json:"chat_id"`
+	MessageID   string `json:"message_id"`
+	ForEveryone bool   `json:"for_everyone"`
+}
+
+// @Tags Message
+// DeleteMessage godoc
+// @Summary Удаление сообщения
+// @Description Удаляет копию пользователя или, с for_everyone, копии обоих участников вместе с записями поискового индекса и пересчитывает new_msg_count.
+// @Description Событие с type = "deleted" уходит всем участникам чата при for_everyone и только самому пользователю иначе.
+// @Accept json
+// @Produce  json
+// @Param data body DeleteMessageStruct true "Удаляемое сообщение"
+// @Security BearerAuth
+// @Success 200 {object} map[string]interface{} "successful response"
+// @Failure 400 {object} map[string]interface{} "bad request"
+// @Failure 401 {object} map[string]interface{} "unauthorized"
+// @Failure 403 {object} map[string]interface{} "forbidden"
+// @Failure 404 {object} map[string]interface{} "message not found"
+// @Router /messages/delete-message [post]
+func DeleteMessage(repos *repository.Repositories, c *gin.Context) {
+	var messageData DeleteMessageStruct
+	if err := c.BindJSON(&messageData); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+		return
+	}
+
+	chatID, err := gocql.ParseUUID(messageData.ChatID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid chat_id"})
+		return
+	}
+
+	messageID, err := gocql.ParseUUID(messageData.MessageID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid message_id"})
+		return
+	}
+
+	userID := middleware.UserID(c)
+
+	// Удалить у себя можно и в чате только для чтения
+	access, ok := authz.Require(c, repos, userID, chatID, authz.Read)
+	if !ok {
+		return
+	}
+	companionID := access.Chat.CompanionID
+
+	message, err := repos.Messages.GetMessage(userID, chatID, messageID)
+	if err != nil {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Message not found"})
+		return
+	}
+	if messageData.ForEveryone && message.SenderID != userID {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Only the sender can delete a message for everyone"})
+		return
+	}
+
+	owners := []uint{userID}
+	if messageData.ForEveryone && !access.Chat.CompanionDeleted {
+		owners = append(owners, companionID)
+	}
+	for _, ownerID := range owners {
+		ownerCopy, err := repos.Messages.GetMessage(ownerID, chatID, messageID)
+		if err == repository.ErrNotFound {
+			continue
+		}
+		if err == nil {
+			err = repos.Messages.DeleteMessage(ownerID, chatID, messageID)
+		}
+		if err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete message"})
+			return
+		}
+
+		if err := repos.Search.RemoveMessage(ownerID, messageID); err != nil {
+			log.Println("Failed to remove message from search index:", err)
+		}
+
+		if !ownerCopy.Read && ownerCopy.SenderID != ownerID {
+			if err := repos.Chats.AddUnreadCount(ownerID, chatID, -1); err != nil {
+				log.Println(err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update new message count"})
+				return
+			}
+			if ownerCopy.ExpiresAt != nil {
+				if err := repos.Chats.RemoveUnreadExpiring(ownerID, chatID, []gocql.UUID{messageID}); err != nil {
+					log.Println(err)
+					c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update new message count"})
+					return
+				}
+			}
+		}
+		chats.UpdeteDataChat(ownerID, chatID)
+	}
+
+	deleted := Message{
+		ChatID:    chatID,
+		MessageID: messageID,
+		SenderID:  message.SenderID,
+		CreatedAt: message.CreatedAt,
+		Type:      "deleted",
+	}
+	if messageData.ForEveryone {
+		SendWsMessageToChat(chatID.String(), deleted)
+	} else {
+		SendWsMessageToUser(chatID.String(), userID, deleted)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "Message deleted successfully"})
+}
@@ -0,0 +1,110 @@
+package messages
+
+import (
+	"Bmessage_backend/authz"
+	"Bmessage_backend/middleware"
+	"Bmessage_backend/repository"
+	"log"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gocql/gocql"
+)
+
+// Максимальное число чатов, в которые можно переслать сообщение одним запросом
+const maxForwardTargets = 10
+
+// ForwardMessageStruct represents the JSON
+// @Description Пересылка сообщения из chat_id в один или несколько чатов
+type ForwardMessageStruct struct {
+	ChatID        string   `json:"chat_id"`
+	MessageID     string   `json:"message_id"`
+	TargetChatIDs []string `json:"target_chat_ids"`
+}
+
+// ForwardedMessage — созданное при пересылке сообщение
+type ForwardedMessage struct {
+	ChatID    gocql.UUID `json:"chat_id"`
+	MessageID gocql.UUID `json:"message_id"`
+}
+
+// @Tags Message
+// ForwardMessage godoc
+// @Summary Пересылка сообщения
+// @Description Сервер сам расшифровывает исходное сообщение ключом исходного чата и шифрует копии для каждого целевого чата.
+// @Description В копиях сохраняются автор и время исходного сообщения. Доступ ко всем чатам проверяется до записи.
+// @Accept json
+// @Produce  json
+// @Param data body ForwardMessageStruct true "Исходное сообщение и целевые чаты"
+// @Security BearerAuth
+// @Success 200 {object} map[string]interface{} "successful response"
+// @Failure 400 {object} map[string]interface{} "bad request"
+// @Failure 401 {object} map[string]interface{} "unauthorized"
+// @Failure 403 {object} map[string]interface{} "forbidden"
+// @Failure 404 {object} map[string]interface{} "message not found"
+// @Router /messages/forward [post]
+func ForwardMessage(repos *repository.Repositories, c *gin.Context) {
+	var forwardData ForwardMessageStruct
+	if err := c.BindJSON(&forwardData); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+		return
+	}
+
+	chatID, err := gocql.ParseUUID(forwardData.ChatID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid chat_id"})
+		return
+	}
+	messageID, err := gocql.ParseUUID(forwardData.MessageID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid message_id"})
+		return
+	}
+	if len(forwardData.TargetChatIDs) == 0 || len(forwardData.TargetChatIDs) > maxForwardTargets {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "target_chat_ids must contain from 1 to 10 chats"})
+		return
+	}
+
+	var targets []gocql.UUID
+	seen := make(map[gocql.UUID]bool)
+	for _, value := range forwardData.TargetChatIDs {
+		targetID, err := gocql.ParseUUID(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid target_chat_ids"})
+			return
+		}
+		if !seen[targetID] {
+			seen[targetID] = true
+			targets = append(targets, targetID)
+		}
+	}
+
+	userID := middleware.UserID(c)
+
+	accesses := make([]authz.Access, 0, len(targets))
+	for _, targetID := range targets {
+		access, ok := authz.Require(c, repos, userID, targetID, authz.Post)
+		if !ok {
+			return
+		}
+		accesses = append(accesses, access)
+	}
+
+	attribution, text, ok := loadForwardSource(repos, c, userID, chatID, messageID)
+	if !ok {
+		return
+	}
+
+	forwarded := make([]ForwardedMessage, 0, len(accesses))
+	for _, access := range accesses {
+		message, err := deliverMessage(repos, access, userID, outgoingMessage{Text: text, Forward: &attribution})
+		if err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to forward message", "messages": forwarded})
+			return
+		}
+		forwarded = append(forwarded, ForwardedMessage{ChatID: message.ChatID, MessageID: message.MessageID})
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "Message forwarded successfully", "messages": forwarded})
+}
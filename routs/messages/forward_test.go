@@ -0,0 +1,69 @@
+package messages
+
+import (
+	"Bmessage_backend/routs/chats"
+	"Bmessage_backend/testutil"
+	"net/http"
+	"testing"
+
+	"github.com/gocql/gocql"
+)
+
+func TestForwardMessage(t *testing.T) {
+	f := newChatFixture(t)
+	f.send(t, f.petrToken, "встреча в восемь")
+	original := f.messages(t, f.ivanToken)[0]
+
+	sidor, sidorToken := testutil.CreateUser(t, f.repos, "sidor")
+	var created struct {
+		ChatID gocql.UUID `json:"chat_id"`
+	}
+	rec := testutil.DoAs(t, f.router, f.ivanToken, http.MethodPost, "/chats/create-chat", chats.CreateChatStruct{Companion_id: sidor.ID})
+	testutil.Decode(t, rec, http.StatusOK, &created)
+
+	forward := ForwardMessageStruct{
+		ChatID:        f.chatID.String(),
+		MessageID:     original.MessageID.String(),
+		TargetChatIDs: []string{created.ChatID.String()},
+	}
+	var response struct {
+		Messages []ForwardedMessage `json:"messages"`
+	}
+	testutil.Decode(t, testutil.DoAs(t, f.router, f.ivanToken, http.MethodPost, "/messages/forward", forward), http.StatusOK, &response)
+	if len(response.Messages) != 1 || response.Messages[0].ChatID != created.ChatID {
+		t.Fatalf("unexpected forward response: %+v", response)
+	}
+
+	// Получатель видит текст, расшифрованный своим ключом, и исходного автора
+	var received []Message
+	rec = testutil.DoAs(t, f.router, sidorToken, http.MethodGet, "/messages/get-messages?chat_id="+created.ChatID.String(), nil)
+	testutil.Decode(t, rec, http.StatusOK, &received)
+	if len(received) != 2 || received[1].Kind != chats.SystemChatCreated {
+		t.Fatalf("got %d messages, want forwarded message and chat_created", len(received))
+	}
+	message := received[0]
+	if message.MessageText != "встреча в восемь" || message.SenderID != f.ivanID ||
+		message.ForwardedFromSenderID == nil || *message.ForwardedFromSenderID != f.petrID ||
+		message.ForwardedAt == nil || !message.ForwardedAt.Equal(original.CreatedAt) {
+		t.Fatalf("unexpected forwarded message: %+v", message)
+	}
+
+	// Повторная пересылка сохраняет исходного автора
+	forward = ForwardMessageStruct{
+		ChatID:        created.ChatID.String(),
+		MessageID:     message.MessageID.String(),
+		TargetChatIDs: []string{f.chatID.String()},
+	}
+	testutil.Decode(t, testutil.DoAs(t, f.router, f.ivanToken, http.MethodPost, "/messages/forward", forward), http.StatusOK, nil)
+	again := f.messages(t, f.petrToken)[0]
+	if again.ForwardedFromSenderID == nil || *again.ForwardedFromSenderID != f.petrID ||
+		again.ForwardedFromMessageID == nil || *again.ForwardedFromMessageID != original.MessageID {
+		t.Fatalf("attribution lost on second forward: %+v", again)
+	}
+
+	forward.TargetChatIDs = nil
+	testutil.Decode(t, testutil.DoAs(t, f.router, f.ivanToken, http.MethodPost, "/messages/forward", forward), http.StatusBadRequest, nil)
+	forward.MessageID = gocql.TimeUUID().String()
+	forward.TargetChatIDs = []string{f.chatID.String()}
+	testutil.Decode(t, testutil.DoAs(t, f.router, f.ivanToken, http.MethodPost, "/messages/forward", forward), http.StatusNotFound, nil)
+}
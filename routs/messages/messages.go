@@ -1,10 +1,11 @@
 package messages
 
 import (
-	"Bmessage_backend/database"
+	"Bmessage_backend/authz"
 	"Bmessage_backend/helpers"
+	"Bmessage_backend/middleware"
+	"Bmessage_backend/repository"
 	"Bmessage_backend/routs/chats"
-	"fmt"
 	"log"
 	"net/http"
 	"time"
@@ -15,48 +16,130 @@ import (
 
 func MessageRouter(router *gin.Engine) {
 	routeBase := "messages/"
-	router.POST(routeBase+"add-message", database.WithDatabaseScylla(AddMessage))
-	router.GET(routeBase+"get-messages", database.WithDatabaseScylla(GetMessages))
-	router.POST(routeBase+"read-message", database.WithDatabaseScylla(ReadMessage))
+	router.POST(routeBase+"add-message", middleware.RequireUser(), repository.WithRepositories(AddMessage))
+	router.GET(routeBase+"get-messages", middleware.RequireUser(), repository.WithRepositories(GetMessages))
+	router.POST(routeBase+"read-message", middleware.RequireUser(), repository.WithRepositories(ReadMessage))
+	router.POST(routeBase+"read-messages-up-to", middleware.RequireUser(), repository.WithRepositories(ReadMessagesUpTo))
+	router.POST(routeBase+"edit-message", middleware.RequireUser(), repository.WithRepositories(EditMessage))
+	router.POST(routeBase+"delete-message", middleware.RequireUser(), repository.WithRepositories(DeleteMessage))
+	router.GET(routeBase+"search", middleware.RequireUser(), repository.WithRepositories(SearchMessages))
+	router.POST(routeBase+"forward", middleware.RequireUser(), repository.WithRepositories(ForwardMessage))
+	router.POST(routeBase+"pin", middleware.RequireUser(), repository.WithRepositories(PinMessage))
+	router.GET(routeBase+"pinned", middleware.RequireUser(), repository.WithRepositories(GetPinnedMessages))
+	router.POST(routeBase+"schedule", middleware.RequireUser(), repository.WithRepositories(ScheduleMessage))
+	router.GET(routeBase+"scheduled", middleware.RequireUser(), repository.WithRepositories(GetScheduledMessages))
+	router.POST(routeBase+"scheduled/edit", middleware.RequireUser(), repository.WithRepositories(EditScheduledMessage))
+	router.POST(routeBase+"scheduled/cancel", middleware.RequireUser(), repository.WithRepositories(CancelScheduledMessage))
 }
 
 // AddMessageStruct represents the JSON
 // @Description Данные для создания сообщения
 type AddMessageStruct struct {
-	TemporaryMessageId     string  `json:"temporary_message_id"`
-	ChatID                 string  `json:"chat_id"`
-	UserToken              string  `json:"user_token"`
+	TemporaryMessageId string `json:"temporary_message_id"`
+	ChatID             string `json:"chat_id"`
+	// Устарело: токен передаётся в заголовке Authorization
+	UserToken              string  `json:"user_token,omitempty"`
 	MessageText            string  `json:"message_text"`
 	ReplyToMessageID       *string `json:"reply_to_message_id"`
 	ForwardedFromChatID    *string `json:"forwarded_from_chat_id"`
 	ForwardedFromMessageID *string `json:"forwarded_from_message_id"`
+	// ViewOnce — сообщение удалится у обоих участников, когда получатель его прочитает
+	ViewOnce bool `json:"view_once,omitempty"`
 }
 
 type Message struct {
-	TemporaryMessageId     string      `json:"temporary_message_id"`
-	ChatID                 gocql.UUID  `json:"chat_id"`
-	MessageID              gocql.UUID  `json:"message_id"`
-	SenderID               uint        `json:"sender_id"`
-	MessageText            string      `json:"message_text"`
-	CreatedAt              time.Time   `json:"created_at"`
-	ReplyToMessageID       *gocql.UUID `json:"reply_to_message_id"`
-	ForwardedFromChatID    *gocql.UUID `json:"forwarded_from_chat_id"`
-	ForwardedFromMessageID *gocql.UUID `json:"forwarded_from_message_id"`
-	IsMyMessage            bool        `json:"is_my_message"`
-	Read                   bool        `json:"read"`
-	Type                   string      `json:"type"`
+	TemporaryMessageId string      `json:"temporary_message_id"`
+	ChatID             gocql.UUID  `json:"chat_id"`
+	MessageID          gocql.UUID  `json:"message_id"`
+	SenderID           uint        `json:"sender_id"`
+	MessageText        string      `json:"message_text"`
+	CreatedAt          time.Time   `json:"created_at"`
+	ReplyToMessageID   *gocql.UUID `json:"reply_to_message_id"`
+	// ReplyPreview — цитата сообщения reply_to_message_id
+	ReplyPreview           *ReplyPreview `json:"reply_preview,omitempty"`
+	ForwardedFromChatID    *gocql.UUID   `json:"forwarded_from_chat_id"`
+	ForwardedFromMessageID *gocql.UUID   `json:"forwarded_from_message_id"`
+	// Автор и время исходного сообщения, если сообщение переслано
+	ForwardedFromSenderID *uint      `json:"forwarded_from_sender_id,omitempty"`
+	ForwardedAt           *time.Time `json:"forwarded_at,omitempty"`
+	IsMyMessage           bool       `json:"is_my_message"`
+	Read                  bool       `json:"read"`
+	EditedAt              *time.Time `json:"edited_at,omitempty"`
+	// ExpiresAt — когда сообщение исчезнет по таймеру чата
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	ViewOnce  bool       `json:"view_once,omitempty"`
+	// ActorID — пользователь, закрепивший или открепивший сообщение, в событиях pinned и unpinned
+	ActorID uint `json:"actor_id,omitempty"`
+	// Kind — вид служебного сообщения (chat_created, message_pinned, timer_changed); у служебных
+	// сообщений нет текста и отправителя, данные события — в payload
+	Kind    string               `json:"kind,omitempty"`
+	Payload *chats.SystemPayload `json:"payload,omitempty"`
+	Type    string               `json:"type"`
+}
+
+func messageFromRepository(row repository.Message) Message {
+	message := Message{
+		ChatID:                 row.ChatID,
+		MessageID:              row.MessageID,
+		SenderID:               row.SenderID,
+		MessageText:            row.MessageText,
+		CreatedAt:              row.CreatedAt,
+		ReplyToMessageID:       row.ReplyToMessageID,
+		ForwardedFromChatID:    row.ForwardedFromChatID,
+		ForwardedFromMessageID: row.ForwardedFromMessageID,
+		ForwardedFromSenderID:  row.ForwardedFromSenderID,
+		ForwardedAt:            row.ForwardedAt,
+		Read:                   row.Read,
+		EditedAt:               row.EditedAt,
+		ExpiresAt:              row.ExpiresAt,
+		ViewOnce:               row.ViewOnce,
+		Kind:                   row.Kind,
+	}
+	if row.Kind != "" {
+		payload, err := chats.ParseSystemPayload(row.Payload)
+		if err != nil {
+			log.Println("Failed to parse system message payload:", err)
+		}
+		message.Payload = payload
+	}
+	return message
+}
+
+// decryptMessage переводит копию сообщения владельца в открытый вид.
+// Служебные сообщения не зашифрованы и не имеют текста.
+func decryptMessage(row repository.Message, privateKey string) (Message, error) {
+	message := messageFromRepository(row)
+	if row.Kind != "" {
+		return message, nil
+	}
+	text, err := helpers.DecryptWithPrivateKey(row.MessageText, privateKey)
+	if err != nil {
+		return Message{}, err
+	}
+	message.MessageText = text
+	return message, nil
 }
 
 // @Tags Message
 // AddMessage godoc
 // @Summary Запись сообщения
+// @Description forwarded_from_chat_id и forwarded_from_message_id устарели: используйте messages/forward.
+// @Description Если они переданы, текст и автор берутся из исходного сообщения, а message_text игнорируется.
+// @Description reply_to_message_id должен указывать на сообщение этого же чата.
+// @Description Если в чате включён таймер, сообщение исчезнет у обоих участников через message_ttl секунд.
+// @Description Сообщение с view_once удаляется у обоих участников после messages/read-message получателем.
+// @Description Отправка удаляет черновик отправителя в этом чате.
 // @Accept json
 // @Produce  json
 // @Param data body AddMessageStruct true "Данные для создания сообщения"
+// @Security BearerAuth
 // @Success 200 {object} map[string]interface{} "successful response"
 // @Failure 400 {object} map[string]interface{} "bad request"
+// @Failure 401 {object} map[string]interface{} "unauthorized"
+// @Failure 403 {object} map[string]interface{} "forbidden"
+// @Failure 404 {object} map[string]interface{} "forwarded message not found"
 // @Router /messages/add-message [post]
-func AddMessage(session *gocql.Session, c *gin.Context) {
+func AddMessage(repos *repository.Repositories, c *gin.Context) {
 	var messageData AddMessageStruct
 	if err := c.BindJSON(&messageData); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
@@ -69,178 +152,80 @@ func AddMessage(session *gocql.Session, c *gin.Context) {
 		return
 	}
 
-	userDataToToken, err := helpers.DecryptAES(messageData.UserToken)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
-	}
-
-	userID := userDataToToken.User_id
-	keyspaceUser := fmt.Sprintf("user_%d", userID)
-	queryCompanionDetID := fmt.Sprintf(`SELECT companion_id, chat_type, secured, muted, last_updated ,private_key FROM %s.chats WHERE chat_id = ? ALLOW FILTERING;`, keyspaceUser)
-
-	var companionID uint
-	var chatType string
-	var secured bool
-	var muted bool
-	var newMsgCount int
-	var lastUpdated *time.Time
-	var private_keyUser string
-	var private_keyCompanion string
+	userID := middleware.UserID(c)
 
-	if err := session.Query(queryCompanionDetID, chatID).Scan(&companionID, &chatType, &secured, &muted, &lastUpdated, &private_keyUser); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get chat details"})
+	access, ok := authz.Require(c, repos, userID, chatID, authz.Post)
+	if !ok {
 		return
 	}
 
-	keyspaceCompanion := fmt.Sprintf("user_%d", companionID)
-
-	queryCompanionNewMsgCount := fmt.Sprintf(`SELECT new_msg_count,private_key FROM %s.chats WHERE user_id = ? AND companion_id = ? AND chat_id = ? AND last_updated = ? ALLOW FILTERING;`, keyspaceCompanion)
-	if err := session.Query(queryCompanionNewMsgCount, companionID, userID, chatID, lastUpdated).Scan(&newMsgCount, &private_keyCompanion); err != nil {
-		if err == gocql.ErrNotFound {
-			newMsgCount = 0
-		} else {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get new message count for companion"})
-			return
-		}
+	out := outgoingMessage{
+		TemporaryMessageId: messageData.TemporaryMessageId,
+		Text:               messageData.MessageText,
+		ViewOnce:           messageData.ViewOnce,
 	}
 
-	messageID := gocql.TimeUUID()
-	createdAt := time.Now()
+	out.ReplyToMessageID, ok = parseReplyTarget(repos, c, userID, chatID, messageData.ReplyToMessageID)
+	if !ok {
+		return
+	}
 
-	var replyToMessageID *gocql.UUID
-	if messageData.ReplyToMessageID != nil {
-		replyID, err := gocql.ParseUUID(*messageData.ReplyToMessageID)
-		if err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid reply_to_message_id"})
+	if messageData.ForwardedFromChatID != nil || messageData.ForwardedFromMessageID != nil {
+		if messageData.ForwardedFromChatID == nil || messageData.ForwardedFromMessageID == nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "forwarded_from_chat_id and forwarded_from_message_id must be set together"})
 			return
 		}
-		replyToMessageID = &replyID
-	}
-
-	var forwardedFromChatID *gocql.UUID
-	if messageData.ForwardedFromChatID != nil {
-		chatID, err := gocql.ParseUUID(*messageData.ForwardedFromChatID)
+		sourceChatID, err := gocql.ParseUUID(*messageData.ForwardedFromChatID)
 		if err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid forwarded_from_chat_id"})
 			return
 		}
-		forwardedFromChatID = &chatID
-	}
-
-	var forwardedFromMessageID *gocql.UUID
-	if messageData.ForwardedFromMessageID != nil {
-		messageID, err := gocql.ParseUUID(*messageData.ForwardedFromMessageID)
+		sourceMessageID, err := gocql.ParseUUID(*messageData.ForwardedFromMessageID)
 		if err != nil {
 			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid forwarded_from_message_id"})
 			return
 		}
-		forwardedFromMessageID = &messageID
-	}
-
-	publicKeyUser, err := helpers.ExtractPublicKey(private_keyUser)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to insert message"})
-		return
-	}
-
-	publicKeyCompanion, err := helpers.ExtractPublicKey(private_keyCompanion)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to insert message"})
-		return
-	}
-
-	encryptedDataUser, err := helpers.EncryptWithPublicKey(messageData.MessageText, publicKeyUser)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to insert message"})
-		return
-	}
-
-	encryptedDataCompanion, err := helpers.EncryptWithPublicKey(messageData.MessageText, publicKeyCompanion)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to insert message"})
-		return
-	}
 
-	queryUserAddMessage := fmt.Sprintf(`INSERT INTO %s.messages (chat_id, message_id, sender_id, message_text, created_at, reply_to_message_id, forwarded_from_chat_id, forwarded_from_message_id,read) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`, keyspaceUser)
-	if err := session.Query(queryUserAddMessage, chatID, messageID, userID, encryptedDataUser, createdAt, replyToMessageID, forwardedFromChatID, forwardedFromMessageID, false).Exec(); err != nil {
-		log.Println(err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to insert message"})
-		return
+		// Переслать можно только сообщение из чата, который пользователь может читать
+		attribution, text, ok := loadForwardSource(repos, c, userID, sourceChatID, sourceMessageID)
+		if !ok {
+			return
+		}
+		out.Forward = &attribution
+		out.Text = text
 	}
 
-	queryCompanionAddMessage := fmt.Sprintf(`INSERT INTO %s.messages (chat_id, message_id, sender_id, message_text, created_at, reply_to_message_id, forwarded_from_chat_id, forwarded_from_message_id,read) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`, keyspaceCompanion)
-	if err := session.Query(queryCompanionAddMessage, chatID, messageID, userID, encryptedDataCompanion, createdAt, replyToMessageID, forwardedFromChatID, forwardedFromMessageID, false).Exec(); err != nil {
+	if _, err := deliverMessage(repos, access, userID, out); err != nil {
 		log.Println(err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to insert message"})
 		return
 	}
-
-	deleteChatUser := fmt.Sprintf(`DELETE FROM %s.chats WHERE user_id = ? AND companion_id = ? AND chat_id = ? AND last_updated = ?`, keyspaceUser)
-	if err := session.Query(deleteChatUser, userID, companionID, chatID, lastUpdated).Exec(); err != nil {
-		log.Println(err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete existing chat for user"})
-		return
-	}
-
-	insertChatUser := fmt.Sprintf(`INSERT INTO %s.chats (user_id, chat_id, companion_id, chat_type, secured, muted, new_msg_count, last_msg_time, last_updated,private_key) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`, keyspaceUser)
-	if err := session.Query(insertChatUser, userID, chatID, companionID, chatType, secured, muted, 0, createdAt, createdAt, private_keyUser).Exec(); err != nil {
-		log.Println(err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to insert new chat for user"})
-		return
-	}
-
-	deleteChatCompanion := fmt.Sprintf(`DELETE FROM %s.chats WHERE user_id = ? AND companion_id = ? AND chat_id = ? AND last_updated = ?`, keyspaceCompanion)
-	if err := session.Query(deleteChatCompanion, companionID, userID, chatID, lastUpdated).Exec(); err != nil {
-		log.Println(err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete existing chat for companion"})
-		return
-	}
-
-	insertChatCompanion := fmt.Sprintf(`INSERT INTO %s.chats (user_id, chat_id, companion_id, chat_type, secured, muted, new_msg_count, last_msg_time, last_updated,private_key) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`, keyspaceCompanion)
-	if err := session.Query(insertChatCompanion, companionID, chatID, userID, chatType, secured, muted, newMsgCount+1, createdAt, createdAt, private_keyCompanion).Exec(); err != nil {
-		log.Println(err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to insert new chat for companion"})
-		return
-	}
-
-	newMessage := Message{
-		TemporaryMessageId:     messageData.TemporaryMessageId,
-		ChatID:                 chatID,
-		SenderID:               userID,
-		MessageID:              messageID,
-		MessageText:            messageData.MessageText,
-		CreatedAt:              createdAt,
-		ReplyToMessageID:       replyToMessageID,
-		ForwardedFromChatID:    forwardedFromChatID,
-		ForwardedFromMessageID: forwardedFromMessageID,
-		IsMyMessage:            true,
-		Read:                   false,
-		Type:                   "new",
+	if err := chats.ClearDraft(repos, access.Chat); err != nil {
+		log.Println("Failed to clear draft:", err)
 	}
-
-	chats.UpdeteDataChat(userID, chatID)
-	chats.UpdeteDataChat(companionID, chatID)
-	SendWsMessageToChat(chatID.String(), newMessage)
 	c.JSON(http.StatusOK, gin.H{"status": "Message added successfully"})
 }
 
 // @Tags Message
 // GetMessages godoc
 // @Summary Получение сообщений
+// @Description Для ответов reply_preview содержит автора и начало текста цитируемого сообщения или признак его удаления.
+// @Description Текст одноразового сообщения в цитату не попадает, вместо него reply_preview.view_once = true.
+// @Description Служебные сообщения отличаются полем kind, их данные — в payload, sender_id равен 0.
 // @Accept json
 // @Produce json
 // @Param chat_id query string true "Chat ID"
-// @Param user_token query string true "User Token"
+// @Security BearerAuth
 // @Success 200 {object} []Message "successful response"
 // @Failure 400 {object} map[string]interface{} "bad request"
+// @Failure 401 {object} map[string]interface{} "unauthorized"
+// @Failure 403 {object} map[string]interface{} "forbidden"
 // @Router /messages/get-messages [get]
-func GetMessages(session *gocql.Session, c *gin.Context) {
+func GetMessages(repos *repository.Repositories, c *gin.Context) {
 	chatID := c.Query("chat_id")
-	userToken := c.Query("user_token")
 
-	if chatID == "" || userToken == "" {
-		c.JSON(http.StatusBadRequest, gin.H{"error": "chat_id and user_token are required"})
+	if chatID == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "chat_id is required"})
 		return
 	}
 
@@ -250,66 +235,37 @@ func GetMessages(session *gocql.Session, c *gin.Context) {
 		return
 	}
 
-	userDataToToken, err := helpers.DecryptAES(userToken)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	userID := middleware.UserID(c)
+
+	access, ok := authz.Require(c, repos, userID, chatUUID, authz.Read)
+	if !ok {
 		return
 	}
+	privateKey := access.Chat.PrivateKey
 
-	userID := userDataToToken.User_id
-	keyspaceUser := fmt.Sprintf("user_%d", userID)
-
-	var privateKey string
-
-	checkQuery := fmt.Sprintf(`SELECT
-		private_key
-	FROM
-		%s.chats
-	WHERE
-	chat_id = ?
-	LIMIT 1
-	ALLOW FILTERING;
-	`, keyspaceUser)
-
-	if err := session.Query(checkQuery, chatID).Scan(&privateKey); err != nil {
+	rows, err := repos.Messages.ListMessages(userID, chatUUID, repository.ListOptions{})
+	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch messages"})
 		return
 	}
 
-	query := fmt.Sprintf(`SELECT chat_id, message_id, sender_id, message_text, created_at, reply_to_message_id, forwarded_from_chat_id, forwarded_from_message_id , read
-	FROM %s.messages
-	WHERE chat_id = ?;`, keyspaceUser)
-
-	iter := session.Query(query, chatUUID).Iter()
-	defer iter.Close()
+	replies := newReplyResolver(repos, access.Chat)
 
 	var messages []Message
-	var msg Message
-	for iter.Scan(
-		&msg.ChatID,
-		&msg.MessageID,
-		&msg.SenderID,
-		&msg.MessageText,
-		&msg.CreatedAt,
-		&msg.ReplyToMessageID,
-		&msg.ForwardedFromChatID,
-		&msg.ForwardedFromMessageID,
-		&msg.Read) {
-
-		decryptedText, err := helpers.DecryptWithPrivateKey(msg.MessageText, privateKey)
+	for _, row := range rows {
+		msg, err := decryptMessage(row, privateKey)
 		if err != nil {
 			log.Println(err)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to decrypt message"})
 			return
 		}
-		msg.MessageText = decryptedText
 		msg.IsMyMessage = (msg.SenderID == userID)
+		replies.remember(msg)
 		messages = append(messages, msg)
 	}
-
-	if err := iter.Close(); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch messages"})
-		return
+	// Цитируемые сообщения старше ответов, поэтому превью заполняются после расшифровки всей страницы
+	for i := range messages {
+		replies.attach(&messages[i])
 	}
 
 	c.JSON(http.StatusOK, messages)
@@ -318,23 +274,28 @@ func GetMessages(session *gocql.Session, c *gin.Context) {
 // ReadMessageStruct represents the JSON
 // @Description Данные для прочтения сообщения
 type ReadMessageStruct struct {
-	ChatID    string    `json:"chat_id"`
-	MessageID string    `json:"message_id"`
-	UserToken string    `json:"user_token"`
-	CreatedAt time.Time `json:"created_at"`
+	ChatID    string `json:"chat_id"`
+	MessageID string `json:"message_id"`
+	// Устарело: токен передаётся в заголовке Authorization
+	UserToken string `json:"user_token,omitempty"`
 }
 
 // @Tags Message
 // ReadMessage godoc
 // @Tags Message
 // @Summary Сообщение было прочитано
+// @Description Входящее сообщение с view_once после прочтения удаляется у обоих участников; участникам уходит событие с type = "deleted".
+// @Description Прочтение своего сообщения ничего не меняет.
 // @Accept json
 // @Produce  json
 // @Param data body ReadMessageStruct true "Данные для прочтения сообщения"
+// @Security BearerAuth
 // @Success 200 {object} map[string]interface{} "successful response"
 // @Failure 400 {object} map[string]interface{} "bad request"
+// @Failure 401 {object} map[string]interface{} "unauthorized"
+// @Failure 403 {object} map[string]interface{} "forbidden"
 // @Router /messages/read-message [post]
-func ReadMessage(session *gocql.Session, c *gin.Context) {
+func ReadMessage(repos *repository.Repositories, c *gin.Context) {
 	var messageData ReadMessageStruct
 	if err := c.BindJSON(&messageData); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
@@ -353,51 +314,67 @@ func ReadMessage(session *gocql.Session, c *gin.Context) {
 		return
 	}
 
-	userDataToToken, err := helpers.DecryptAES(messageData.UserToken)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	userID := middleware.UserID(c)
+
+	access, ok := authz.Require(c, repos, userID, chatID, authz.Read)
+	if !ok {
 		return
 	}
 
-	userID := userDataToToken.User_id
-	keyspaceUser := fmt.Sprintf("user_%d", userID)
-	queryCompanionDetID := fmt.Sprintf(`SELECT companion_id,new_msg_count,last_updated FROM %s.chats WHERE chat_id = ? ALLOW FILTERING;`, keyspaceUser)
+	companionID := access.Chat.CompanionID
 
-	var companionID uint
-	var newMsgCount int
-	var last_updated time.Time
+	message, err := repos.Messages.GetMessage(userID, chatID, messageID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Message not found"})
+		return
+	}
 
-	if err := session.Query(queryCompanionDetID, chatID).Scan(&companionID, &newMsgCount, &last_updated); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get chat details"})
+	// Своё сообщение прочитать нельзя: отметка о прочтении общая для обеих копий,
+	// и без этой проверки она снималась бы у получателя мимо его счётчика непрочитанных
+	if message.SenderID == userID {
+		c.JSON(http.StatusOK, gin.H{"status": "Message read successfully"})
 		return
 	}
 
-	log.Println(newMsgCount)
-	keyspaceCompanion := fmt.Sprintf("user_%d", companionID)
+	if message.ViewOnce {
+		if err := consumeViewOnce(repos, access, message); err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to delete view-once message"})
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"status": "Message read successfully"})
+		return
+	}
 
-	queryUserReadMessage := fmt.Sprintf(`UPDATE %s.messages SET read = true WHERE chat_id = ? AND created_at = ? AND message_id = ?`, keyspaceUser)
-	if err := session.Query(queryUserReadMessage, chatID, messageData.CreatedAt, messageID).Exec(); err != nil {
+	keys := []repository.MessageKey{{CreatedAt: message.CreatedAt, MessageID: messageID, ExpiresAt: message.ExpiresAt}}
+	if err := repos.Messages.MarkRead(userID, chatID, keys); err != nil {
 		log.Println(err)
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update read status for user"})
 		return
 	}
 
-	queryCompanionReadMessage := fmt.Sprintf(`UPDATE %s.messages SET read = true WHERE chat_id = ? AND created_at = ? AND message_id = ?`, keyspaceCompanion)
-	if err := session.Query(queryCompanionReadMessage, chatID, messageData.CreatedAt, messageID).Exec(); err != nil {
-		log.Println(err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update read status for companion"})
-		return
+	// Копии удалённого собеседника стёрты, писать в них нельзя: UPDATE в Scylla создал бы строки заново
+	if !access.Chat.CompanionDeleted {
+		if err := repos.Messages.MarkRead(companionID, chatID, keys); err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update read status for companion"})
+			return
+		}
 	}
 
-	if newMsgCount > 0 {
-		newMsgCount--
-		updateUserChatQuery := fmt.Sprintf(`UPDATE %s.chats SET new_msg_count = ? WHERE user_id = ? and last_updated = ? and companion_id = ? and chat_id = ?`, keyspaceUser)
-		if err := session.Query(updateUserChatQuery, newMsgCount, userDataToToken.User_id, last_updated, companionID, chatID).Exec(); err != nil {
+	if !message.Read {
+		if err := repos.Chats.AddUnreadCount(userID, chatID, -1); err != nil {
 			log.Println(err)
 			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update new message count for user"})
 			return
 		}
-
+		if message.ExpiresAt != nil {
+			if err := repos.Chats.RemoveUnreadExpiring(userID, chatID, []gocql.UUID{messageID}); err != nil {
+				log.Println(err)
+				c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update new message count for user"})
+				return
+			}
+		}
 	}
 
 	newMessage := Message{
@@ -413,3 +390,169 @@ func ReadMessage(session *gocql.Session, c *gin.Context) {
 
 	c.JSON(http.StatusOK, gin.H{"status": "Message read successfully"})
 }
+
+// Размер страницы истории, которую читает read-messages-up-to в поисках непрочитанных
+const readUpToPageSize = 100
+
+// ReadMessagesUpToStruct represents the JSON
+// @Description Данные для прочтения всех сообщений до указанного включительно
+type ReadMessagesUpToStruct struct {
+	ChatID    string `json:"chat_id"`
+	MessageID string `json:"message_id"`
+	// Устарело: токен передаётся в заголовке Authorization
+	UserToken string `json:"user_token,omitempty"`
+}
+
+// @Tags Message
+// ReadMessagesUpTo godoc
+// @Summary Прочтение всех сообщений чата до указанного включительно
+// @Description Отмечает прочитанными все входящие сообщения, созданные не позже указанного, и пересчитывает new_msg_count. Время сообщения берётся из хранилища, а не от клиента.
+// @Description Сообщения с view_once не отмечаются: каждое нужно прочитать через messages/read-message.
+// @Accept json
+// @Produce  json
+// @Param data body ReadMessagesUpToStruct true "Данные о последнем прочитанном сообщении"
+// @Security BearerAuth
+// @Success 200 {object} map[string]interface{} "successful response"
+// @Failure 400 {object} map[string]interface{} "bad request"
+// @Failure 401 {object} map[string]interface{} "unauthorized"
+// @Failure 403 {object} map[string]interface{} "forbidden"
+// @Router /messages/read-messages-up-to [post]
+func ReadMessagesUpTo(repos *repository.Repositories, c *gin.Context) {
+	var messageData ReadMessagesUpToStruct
+	if err := c.BindJSON(&messageData); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+		return
+	}
+
+	chatID, err := gocql.ParseUUID(messageData.ChatID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid chat_id"})
+		return
+	}
+
+	messageID, err := gocql.ParseUUID(messageData.MessageID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid message_id"})
+		return
+	}
+
+	userID := middleware.UserID(c)
+
+	access, ok := authz.Require(c, repos, userID, chatID, authz.Read)
+	if !ok {
+		return
+	}
+
+	companionID := access.Chat.CompanionID
+
+	message, err := repos.Messages.GetMessage(userID, chatID, messageID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Message not found"})
+		return
+	}
+	watermark := message.CreatedAt
+
+	// Сообщения новее отметки остаются непрочитанными
+	remainingMessages, err := repos.Messages.ListMessages(userID, chatID, repository.ListOptions{After: &watermark})
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch messages"})
+		return
+	}
+	remaining := 0
+	for _, message := range remainingMessages {
+		if !message.Read && message.SenderID != userID {
+			remaining++
+		}
+	}
+
+	// Непрочитанных не новее отметки столько, сколько осталось в счётчике сверх remaining:
+	// история читается страницами от отметки и только до тех пор, пока все они не найдены
+	unread, err := repos.Chats.GetUnreadCount(userID, chatID)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch messages"})
+		return
+	}
+
+	// Одноразовые сообщения остаются непрочитанными, пока получатель не откроет каждое из них
+	var toRead []repository.MessageKey
+	var readExpiring []gocql.UUID
+	viewOnce := 0
+	found := 0
+	seen := make(map[gocql.UUID]bool)
+	before := watermark
+	for found < unread-remaining {
+		page, err := repos.Messages.ListMessages(userID, chatID, repository.ListOptions{Before: &before, Limit: readUpToPageSize})
+		if err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch messages"})
+			return
+		}
+
+		// Before включает границу, поэтому сообщения на границе страниц приходят повторно
+		fresh := 0
+		for _, message := range page {
+			if seen[message.MessageID] {
+				continue
+			}
+			seen[message.MessageID] = true
+			fresh++
+			if message.Read || message.SenderID == userID {
+				continue
+			}
+			found++
+			if message.ViewOnce {
+				viewOnce++
+				continue
+			}
+			toRead = append(toRead, repository.MessageKey{CreatedAt: message.CreatedAt, MessageID: message.MessageID, ExpiresAt: message.ExpiresAt})
+			if message.ExpiresAt != nil {
+				readExpiring = append(readExpiring, message.MessageID)
+			}
+		}
+		if len(page) < readUpToPageSize || fresh == 0 {
+			break
+		}
+		before = page[len(page)-1].CreatedAt
+	}
+
+	owners := []uint{userID}
+	if !access.Chat.CompanionDeleted {
+		owners = append(owners, companionID)
+	}
+	for _, ownerID := range owners {
+		if err := repos.Messages.MarkRead(ownerID, chatID, toRead); err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update read status"})
+			return
+		}
+	}
+
+	newMsgCount := remaining + viewOnce
+	if err := repos.Chats.SetUnreadCount(userID, chatID, newMsgCount); err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update new message count for user"})
+		return
+	}
+	if err := repos.Chats.RemoveUnreadExpiring(userID, chatID, readExpiring); err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update new message count for user"})
+		return
+	}
+
+	newMessage := Message{
+		ChatID:    chatID,
+		MessageID: messageID,
+		CreatedAt: watermark,
+		Read:      true,
+		Type:      "readUpTo",
+	}
+
+	chats.UpdeteDataChat(userID, chatID)
+	chats.UpdeteDataChat(companionID, chatID)
+
+	SendWsMessageToChat(chatID.String(), newMessage)
+
+	c.JSON(http.StatusOK, gin.H{"status": "Messages read successfully", "read_count": len(toRead), "new_msg_count": newMsgCount})
+}
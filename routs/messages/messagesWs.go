@@ -1,8 +1,9 @@
 package messages
 
 import (
-	"Bmessage_backend/database"
-	"Bmessage_backend/helpers"
+	"Bmessage_backend/authz"
+	"Bmessage_backend/middleware"
+	"Bmessage_backend/repository"
 	"log"
 	"net/http"
 	"sync"
@@ -13,8 +14,8 @@ import (
 )
 
 type ConnectionData struct {
-	WS        *websocket.Conn
-	UserToken string
+	WS     *websocket.Conn
+	UserID uint
 }
 
 var (
@@ -32,10 +33,19 @@ var upgrader = websocket.Upgrader{
 
 func MessageRouterWs(router *gin.Engine) {
 	routeBase := "messagesWS/"
-	router.GET(routeBase+"events-messages", database.WithDatabaseScylla(messageConnectorWs))
+	router.GET(routeBase+"events-messages", middleware.RequireUser(), repository.WithRepositories(messageConnectorWs))
 }
 
-func messageConnectorWs(session *gocql.Session, c *gin.Context) {
+func messageConnectorWs(repos *repository.Repositories, c *gin.Context) {
+	chatUUID, err := gocql.ParseUUID(c.Query("chatId"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid chatId"})
+		return
+	}
+	if _, ok := authz.Require(c, repos, middleware.UserID(c), chatUUID, authz.Read); !ok {
+		return
+	}
+
 	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
 	if err != nil {
 		log.Println("Failed to set websocket upgrade:", err)
@@ -43,16 +53,14 @@ func messageConnectorWs(session *gocql.Session, c *gin.Context) {
 	}
 	defer conn.Close()
 
-	chatID := c.Query("chatId")
-	userToken := c.Query("userToken")
+	chatID := chatUUID.String()
 
 	wsData := ConnectionData{
-		WS:        conn,
-		UserToken: userToken,
+		WS:     conn,
+		UserID: middleware.UserID(c),
 	}
 
 	addConnection(chatID, wsData)
-	log.Println(connections)
 	defer removeConnection(chatID, conn)
 
 	for {
@@ -86,6 +94,22 @@ func removeConnection(chatID string, conn *websocket.Conn) {
 	}
 }
 
+// SendWsMessageToUser отправляет событие только подключениям пользователя к чату
+func SendWsMessageToUser(chatID string, userID uint, messageData Message) {
+	connectionsMu.Lock()
+	defer connectionsMu.Unlock()
+
+	for _, conn := range connections[chatID] {
+		if conn.UserID != userID {
+			continue
+		}
+		messageData.IsMyMessage = (conn.UserID == messageData.SenderID)
+		if err := conn.WS.WriteJSON(gin.H{"newMessage": messageData}); err != nil {
+			log.Println("Error writing json to connection:", err)
+		}
+	}
+}
+
 func SendWsMessageToChat(chatID string, messageData Message) {
 	connectionsMu.Lock()
 	defer connectionsMu.Unlock()
@@ -97,15 +121,7 @@ func SendWsMessageToChat(chatID string, messageData Message) {
 	}
 
 	for _, conn := range conns {
-		userToken := conn.UserToken
-		userDataToToken, err := helpers.DecryptAES(userToken)
-		if err != nil {
-			log.Println("Error writing json to connection:", err)
-			return
-		}
-
-		userID := userDataToToken.User_id
-		messageData.IsMyMessage = (userID == messageData.SenderID)
+		messageData.IsMyMessage = (conn.UserID == messageData.SenderID)
 		if err := conn.WS.WriteJSON(gin.H{"newMessage": messageData}); err != nil {
 			log.Println("Error writing json to connection:", err)
 		}
@@ -0,0 +1,368 @@
+package messages
+
+import (
+	"Bmessage_backend/repository"
+	"Bmessage_backend/routs/chats"
+	"Bmessage_backend/testutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gocql/gocql"
+)
+
+type chatFixture struct {
+	repos     *repository.Repositories
+	router    *gin.Engine
+	chatID    gocql.UUID
+	ivanID    uint
+	ivanToken string
+	petrID    uint
+	petrToken string
+}
+
+func newChatFixture(t *testing.T) chatFixture {
+	repos, router := testutil.Setup(t)
+	chats.ChatRouter(router)
+	MessageRouter(router)
+
+	ivan, ivanToken := testutil.CreateUser(t, repos, "ivan")
+	petr, petrToken := testutil.CreateUser(t, repos, "petr")
+
+	var created struct {
+		ChatID gocql.UUID `json:"chat_id"`
+	}
+	rec := testutil.DoAs(t, router, ivanToken, http.MethodPost, "/chats/create-chat", chats.CreateChatStruct{Companion_id: petr.ID})
+	testutil.Decode(t, rec, http.StatusOK, &created)
+
+	return chatFixture{repos, router, created.ChatID, ivan.ID, ivanToken, petr.ID, petrToken}
+}
+
+func (f chatFixture) send(t *testing.T, token, text string) {
+	t.Helper()
+	rec := testutil.DoAs(t, f.router, token, http.MethodPost, "/messages/add-message", AddMessageStruct{
+		ChatID:      f.chatID.String(),
+		MessageText: text,
+	})
+	testutil.Decode(t, rec, http.StatusOK, nil)
+}
+
+// history возвращает всю ленту чата вместе со служебными сообщениями
+func (f chatFixture) history(t *testing.T, token string) []Message {
+	t.Helper()
+	var messages []Message
+	rec := testutil.DoAs(t, f.router, token, http.MethodGet, "/messages/get-messages?chat_id="+f.chatID.String(), nil)
+	testutil.Decode(t, rec, http.StatusOK, &messages)
+	return messages
+}
+
+// messages возвращает только сообщения участников, без служебных
+func (f chatFixture) messages(t *testing.T, token string) []Message {
+	t.Helper()
+	var messages []Message
+	for _, message := range f.history(t, token) {
+		if message.Kind == "" {
+			messages = append(messages, message)
+		}
+	}
+	return messages
+}
+
+func (f chatFixture) unread(t *testing.T, userID uint) int {
+	t.Helper()
+	count, err := f.repos.Chats.GetUnreadCount(userID, f.chatID)
+	if err != nil {
+		t.Fatalf("unread count: %v", err)
+	}
+	return count
+}
+
+func TestSendAndReadMessage(t *testing.T) {
+	f := newChatFixture(t)
+
+	f.send(t, f.ivanToken, "привет")
+
+	for _, token := range []string{f.ivanToken, f.petrToken} {
+		messages := f.messages(t, token)
+		if len(messages) != 1 || messages[0].MessageText != "привет" || messages[0].SenderID != f.ivanID {
+			t.Fatalf("unexpected messages: %+v", messages)
+		}
+		if messages[0].IsMyMessage != (token == f.ivanToken) {
+			t.Fatalf("is_my_message = %v for sender token %v", messages[0].IsMyMessage, token == f.ivanToken)
+		}
+	}
+
+	if got := f.unread(t, f.petrID); got != 1 {
+		t.Fatalf("companion unread = %d, want 1", got)
+	}
+	if got := f.unread(t, f.ivanID); got != 0 {
+		t.Fatalf("sender unread = %d, want 0", got)
+	}
+
+	messageID := f.messages(t, f.petrToken)[0].MessageID
+	rec := testutil.DoAs(t, f.router, f.petrToken, http.MethodPost, "/messages/read-message", ReadMessageStruct{
+		ChatID:    f.chatID.String(),
+		MessageID: messageID.String(),
+	})
+	testutil.Decode(t, rec, http.StatusOK, nil)
+
+	if got := f.unread(t, f.petrID); got != 0 {
+		t.Fatalf("companion unread after read = %d, want 0", got)
+	}
+	for _, token := range []string{f.ivanToken, f.petrToken} {
+		if messages := f.messages(t, token); !messages[0].Read {
+			t.Fatal("message is not marked read for both participants")
+		}
+	}
+
+	// Повторное прочтение не уводит счётчик в минус
+	rec = testutil.DoAs(t, f.router, f.petrToken, http.MethodPost, "/messages/read-message", ReadMessageStruct{
+		ChatID:    f.chatID.String(),
+		MessageID: messageID.String(),
+	})
+	testutil.Decode(t, rec, http.StatusOK, nil)
+	if got := f.unread(t, f.petrID); got != 0 {
+		t.Fatalf("companion unread after repeated read = %d, want 0", got)
+	}
+}
+
+func TestReadMessagesUpTo(t *testing.T) {
+	f := newChatFixture(t)
+
+	for _, text := range []string{"первое", "второе", "третье"} {
+		f.send(t, f.ivanToken, text)
+	}
+	if got := f.unread(t, f.petrID); got != 3 {
+		t.Fatalf("companion unread = %d, want 3", got)
+	}
+
+	// Сообщения отдаются от новых к старым: читаем до второго включительно
+	second := f.messages(t, f.petrToken)[1]
+
+	var resp struct {
+		ReadCount   int `json:"read_count"`
+		NewMsgCount int `json:"new_msg_count"`
+	}
+	rec := testutil.DoAs(t, f.router, f.petrToken, http.MethodPost, "/messages/read-messages-up-to", ReadMessagesUpToStruct{
+		ChatID:    f.chatID.String(),
+		MessageID: second.MessageID.String(),
+	})
+	testutil.Decode(t, rec, http.StatusOK, &resp)
+
+	if resp.ReadCount != 2 || resp.NewMsgCount != 1 {
+		t.Fatalf("read-messages-up-to = %+v, want read_count 2 and new_msg_count 1", resp)
+	}
+	if got := f.unread(t, f.petrID); got != 1 {
+		t.Fatalf("companion unread = %d, want 1", got)
+	}
+
+	messages := f.messages(t, f.ivanToken)
+	if messages[0].Read || !messages[1].Read || !messages[2].Read {
+		t.Fatalf("sender copies read flags = %v %v %v, want false true true", messages[0].Read, messages[1].Read, messages[2].Read)
+	}
+}
+
+// countingMessages считает строки, которые обработчики получают из ListMessages
+type countingMessages struct {
+	repository.MessageRepository
+	listed int
+}
+
+func (m *countingMessages) ListMessages(ownerID uint, chatID gocql.UUID, opts repository.ListOptions) ([]repository.Message, error) {
+	messages, err := m.MessageRepository.ListMessages(ownerID, chatID, opts)
+	m.listed += len(messages)
+	return messages, err
+}
+
+func TestReadUpToSkipsReadHistory(t *testing.T) {
+	f := newChatFixture(t)
+
+	// Давно прочитанная история в прошлых месяцах
+	old := time.Now().AddDate(0, -2, 0)
+	for i := 0; i < 3*readUpToPageSize; i++ {
+		message := repository.Message{
+			OwnerID:   f.petrID,
+			ChatID:    f.chatID,
+			MessageID: gocql.TimeUUID(),
+			SenderID:  f.ivanID,
+			CreatedAt: old.Add(time.Duration(i) * time.Minute),
+			Read:      true,
+		}
+		if err := f.repos.Messages.AddMessage(message); err != nil {
+			t.Fatal(err)
+		}
+	}
+	f.send(t, f.ivanToken, "первое")
+	f.send(t, f.ivanToken, "второе")
+	newest, err := f.repos.Messages.ListMessages(f.petrID, f.chatID, repository.ListOptions{Limit: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	latest := newest[0].MessageID
+
+	counting := &countingMessages{MessageRepository: f.repos.Messages}
+	f.repos.Messages = counting
+	var resp struct {
+		ReadCount   int `json:"read_count"`
+		NewMsgCount int `json:"new_msg_count"`
+	}
+	rec := testutil.DoAs(t, f.router, f.petrToken, http.MethodPost, "/messages/read-messages-up-to", ReadMessagesUpToStruct{
+		ChatID:    f.chatID.String(),
+		MessageID: latest.String(),
+	})
+	testutil.Decode(t, rec, http.StatusOK, &resp)
+
+	if resp.ReadCount != 2 || resp.NewMsgCount != 0 || f.unread(t, f.petrID) != 0 {
+		t.Fatalf("read-messages-up-to = %+v, unread %d", resp, f.unread(t, f.petrID))
+	}
+	// Одна страница истории и последние сообщения для обновления чатов, а не вся история
+	if counting.listed >= 2*readUpToPageSize {
+		t.Fatalf("read-messages-up-to listed %d messages, want under %d", counting.listed, 2*readUpToPageSize)
+	}
+}
+
+func TestCrossUserAccessIsForbidden(t *testing.T) {
+	f := newChatFixture(t)
+	f.send(t, f.ivanToken, "секрет")
+	messageID := f.messages(t, f.ivanToken)[0].MessageID
+
+	mallory, malloryToken := testutil.CreateUser(t, f.repos, "mallory")
+
+	var own struct {
+		ChatID gocql.UUID `json:"chat_id"`
+	}
+	rec := testutil.DoAs(t, f.router, malloryToken, http.MethodPost, "/chats/create-chat", chats.CreateChatStruct{Companion_id: f.petrID})
+	testutil.Decode(t, rec, http.StatusOK, &own)
+
+	foreignChat := f.chatID.String()
+	foreignMessage := messageID.String()
+
+	requests := []struct {
+		name   string
+		method string
+		path   string
+		body   interface{}
+	}{
+		{"get messages", http.MethodGet, "/messages/get-messages?chat_id=" + foreignChat, nil},
+		{"add message", http.MethodPost, "/messages/add-message", AddMessageStruct{ChatID: foreignChat, MessageText: "привет"}},
+		{"read message", http.MethodPost, "/messages/read-message", ReadMessageStruct{ChatID: foreignChat, MessageID: foreignMessage}},
+		{"read up to", http.MethodPost, "/messages/read-messages-up-to", ReadMessagesUpToStruct{ChatID: foreignChat, MessageID: foreignMessage}},
+		{"forward from foreign chat", http.MethodPost, "/messages/add-message", AddMessageStruct{
+			ChatID:                 own.ChatID.String(),
+			MessageText:            "секрет",
+			ForwardedFromChatID:    &foreignChat,
+			ForwardedFromMessageID: &foreignMessage,
+		}},
+		{"forward endpoint from foreign chat", http.MethodPost, "/messages/forward", ForwardMessageStruct{
+			ChatID:        foreignChat,
+			MessageID:     foreignMessage,
+			TargetChatIDs: []string{own.ChatID.String()},
+		}},
+		{"forward into foreign chat", http.MethodPost, "/messages/forward", ForwardMessageStruct{
+			ChatID:        own.ChatID.String(),
+			MessageID:     foreignMessage,
+			TargetChatIDs: []string{foreignChat},
+		}},
+		{"pin message", http.MethodPost, "/messages/pin", PinMessageStruct{ChatID: foreignChat, MessageID: foreignMessage, Pinned: true}},
+		{"get pinned messages", http.MethodGet, "/messages/pinned?chat_id=" + foreignChat, nil},
+		{"subscribe to chat events", http.MethodGet, "/messagesWS/events-messages?chatId=" + foreignChat, nil},
+	}
+
+	MessageRouterWs(f.router)
+	for _, r := range requests {
+		t.Run(r.name, func(t *testing.T) {
+			rec := testutil.DoAs(t, f.router, malloryToken, r.method, r.path, r.body)
+			testutil.Decode(t, rec, http.StatusForbidden, nil)
+		})
+	}
+
+	// Ни одна из попыток не изменила чужой чат
+	if got := f.unread(t, f.petrID); got != 1 {
+		t.Fatalf("companion unread = %d, want 1", got)
+	}
+	if messages := f.messages(t, f.petrToken); len(messages) != 1 || messages[0].Read {
+		t.Fatalf("foreign chat changed: %+v", messages)
+	}
+	// В собственном чате mallory только служебное chat_created
+	if messages, _ := f.repos.Messages.ListMessages(mallory.ID, own.ChatID, repository.ListOptions{}); len(messages) != 1 || messages[0].Kind != chats.SystemChatCreated {
+		t.Fatalf("forward from foreign chat was stored: %+v", messages)
+	}
+}
+
+func TestRequestsWithoutTokenAreUnauthorized(t *testing.T) {
+	f := newChatFixture(t)
+
+	rec := testutil.Do(t, f.router, http.MethodGet, "/messages/get-messages?chat_id="+f.chatID.String(), nil)
+	testutil.Decode(t, rec, http.StatusUnauthorized, nil)
+
+	rec = testutil.DoAs(t, f.router, "bm90LWEtdG9rZW4=", http.MethodPost, "/messages/add-message", AddMessageStruct{ChatID: f.chatID.String(), MessageText: "привет"})
+	testutil.Decode(t, rec, http.StatusUnauthorized, nil)
+}
+
+func TestNewMessageUnarchivesChat(t *testing.T) {
+	f := newChatFixture(t)
+
+	if err := f.repos.Chats.SetArchived(f.petrID, f.chatID, true); err != nil {
+		t.Fatal(err)
+	}
+	f.send(t, f.ivanToken, "привет")
+	if chat, _ := f.repos.Chats.GetChat(f.petrID, f.chatID); chat.Archived {
+		t.Fatal("chat stayed archived after a new message")
+	}
+
+	// Чат с отключёнными уведомлениями остаётся в архиве
+	if err := f.repos.Chats.SetArchived(f.petrID, f.chatID, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.repos.Chats.SetMuted(f.petrID, f.chatID, true, nil); err != nil {
+		t.Fatal(err)
+	}
+	f.send(t, f.ivanToken, "ещё")
+	if chat, _ := f.repos.Chats.GetChat(f.petrID, f.chatID); !chat.Archived {
+		t.Fatal("muted chat was unarchived")
+	}
+}
+
+func TestReplyPreview(t *testing.T) {
+	f := newChatFixture(t)
+	long := strings.Repeat("я", replyPreviewLength+20)
+	f.send(t, f.petrToken, long)
+	original := f.messages(t, f.ivanToken)[0]
+
+	replyTo := original.MessageID.String()
+	rec := testutil.DoAs(t, f.router, f.ivanToken, http.MethodPost, "/messages/add-message", AddMessageStruct{
+		ChatID:           f.chatID.String(),
+		MessageText:      "согласен",
+		ReplyToMessageID: &replyTo,
+	})
+	testutil.Decode(t, rec, http.StatusOK, nil)
+
+	reply := f.messages(t, f.ivanToken)[0]
+	preview := reply.ReplyPreview
+	if preview == nil || preview.MessageID != original.MessageID || preview.SenderID != f.petrID ||
+		preview.Text != strings.Repeat("я", replyPreviewLength)+"…" || preview.Deleted {
+		t.Fatalf("unexpected reply preview: %+v", preview)
+	}
+
+	// Цитата удалённого сообщения помечается, а не пропадает
+	rec = testutil.DoAs(t, f.router, f.petrToken, http.MethodPost, "/messages/delete-message", DeleteMessageStruct{
+		ChatID:      f.chatID.String(),
+		MessageID:   replyTo,
+		ForEveryone: true,
+	})
+	testutil.Decode(t, rec, http.StatusOK, nil)
+	if preview := f.messages(t, f.ivanToken)[0].ReplyPreview; preview == nil || !preview.Deleted || preview.Text != "" {
+		t.Fatalf("unexpected preview of deleted message: %+v", preview)
+	}
+
+	// Ответить на сообщение другого чата или на несуществующее нельзя
+	missing := gocql.TimeUUID().String()
+	rec = testutil.DoAs(t, f.router, f.ivanToken, http.MethodPost, "/messages/add-message", AddMessageStruct{
+		ChatID:           f.chatID.String(),
+		MessageText:      "ответ",
+		ReplyToMessageID: &missing,
+	})
+	testutil.Decode(t, rec, http.StatusBadRequest, nil)
+}
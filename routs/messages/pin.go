@@ -0,0 +1,226 @@
+package messages
+
+import (
+	"Bmessage_backend/authz"
+	"Bmessage_backend/middleware"
+	"Bmessage_backend/repository"
+	"Bmessage_backend/routs/chats"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gocql/gocql"
+)
+
+// Максимальное число закреплённых сообщений в чате
+const maxPinnedMessages = 50
+
+// PinnedMessage — закреплённое сообщение с автором и временем закрепления
+type PinnedMessage struct {
+	Message
+	PinnedBy uint      `json:"pinned_by"`
+	PinnedAt time.Time `json:"pinned_at"`
+}
+
+// PinMessageStruct represents the JSON
+// @Description Закрепление или открепление сообщения
+type PinMessageStruct struct {
+	ChatID    string `json:"chat_id"`
+	MessageID string `json:"message_id"`
+	Pinned    bool   `json:"pinned"`
+}
+
+// @Tags Message
+// PinMessage godoc
+// @Summary Закрепление сообщения в чате
+// @Description Закрепление общее для обоих участников. В чат уходит событие с type = "pinned" или "unpinned",
+// @Description где actor_id — закрепивший пользователь; закрепление также записывается в чат служебным сообщением message_pinned.
+// @Description Закреплённое исчезающее сообщение открепляется, когда исчезает.
+// @Accept json
+// @Produce  json
+// @Param data body PinMessageStruct true "Сообщение"
+// @Security BearerAuth
+// @Success 200 {object} map[string]interface{} "successful response"
+// @Failure 400 {object} map[string]interface{} "bad request"
+// @Failure 401 {object} map[string]interface{} "unauthorized"
+// @Failure 403 {object} map[string]interface{} "forbidden"
+// @Failure 404 {object} map[string]interface{} "message not found"
+// @Failure 409 {object} map[string]interface{} "too many pinned messages"
+// @Router /messages/pin [post]
+func PinMessage(repos *repository.Repositories, c *gin.Context) {
+	var pinData PinMessageStruct
+	if err := c.BindJSON(&pinData); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+		return
+	}
+
+	chatID, err := gocql.ParseUUID(pinData.ChatID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid chat_id"})
+		return
+	}
+	messageID, err := gocql.ParseUUID(pinData.MessageID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid message_id"})
+		return
+	}
+
+	userID := middleware.UserID(c)
+
+	// Закрепление меняется у обоих участников, поэтому нужно право писать собеседнику
+	access, ok := authz.Require(c, repos, userID, chatID, authz.Post)
+	if !ok {
+		return
+	}
+	companionID := access.Chat.CompanionID
+
+	message, err := repos.Messages.GetMessage(userID, chatID, messageID)
+	if err == repository.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Message not found"})
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch message"})
+		return
+	}
+	if pinData.Pinned && message.ViewOnce {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "View-once messages cannot be pinned"})
+		return
+	}
+	if pinData.Pinned && message.Kind != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "System messages cannot be pinned"})
+		return
+	}
+
+	if pinData.Pinned {
+		pins, err := repos.Messages.ListPinnedMessages(userID, chatID)
+		if err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch pinned messages"})
+			return
+		}
+		alreadyPinned := false
+		for _, pin := range pins {
+			alreadyPinned = alreadyPinned || pin.MessageID == messageID
+		}
+		if !alreadyPinned && len(pins) >= maxPinnedMessages {
+			c.JSON(http.StatusConflict, gin.H{"error": "Too many pinned messages"})
+			return
+		}
+	}
+
+	pinnedAt := time.Now()
+	for _, ownerID := range []uint{userID, companionID} {
+		if pinData.Pinned {
+			err = repos.Messages.PinMessage(repository.PinnedMessage{
+				OwnerID:   ownerID,
+				ChatID:    chatID,
+				MessageID: messageID,
+				PinnedBy:  userID,
+				PinnedAt:  pinnedAt,
+				ExpiresAt: message.ExpiresAt,
+			})
+		} else {
+			err = repos.Messages.UnpinMessage(ownerID, chatID, messageID)
+		}
+		if err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update pinned messages"})
+			return
+		}
+	}
+
+	event := Message{
+		ChatID:    chatID,
+		MessageID: messageID,
+		SenderID:  message.SenderID,
+		CreatedAt: message.CreatedAt,
+		ActorID:   userID,
+		Type:      "unpinned",
+	}
+	if pinData.Pinned {
+		event.Type = "pinned"
+	}
+
+	SendWsMessageToChat(chatID.String(), event)
+
+	// Служебное сообщение само отправляет обоим участникам обновлённый чат
+	posted := false
+	if pinData.Pinned {
+		payload := chats.SystemPayload{ActorID: userID, MessageID: &messageID}
+		if _, err := chats.PostSystemMessage(repos, access.Chat, chats.SystemMessagePinned, payload); err != nil {
+			log.Println("Failed to post message pinned message:", err)
+		} else {
+			posted = true
+		}
+	}
+	if !posted {
+		chats.UpdeteDataChat(userID, chatID)
+		chats.UpdeteDataChat(companionID, chatID)
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "Pinned messages updated"})
+}
+
+// @Tags Message
+// GetPinnedMessages godoc
+// @Summary Закреплённые сообщения чата
+// @Description Последние закреплённые первыми.
+// @Produce json
+// @Param chat_id query string true "Chat ID"
+// @Security BearerAuth
+// @Success 200 {object} []PinnedMessage "successful response"
+// @Failure 400 {object} map[string]interface{} "bad request"
+// @Failure 401 {object} map[string]interface{} "unauthorized"
+// @Failure 403 {object} map[string]interface{} "forbidden"
+// @Router /messages/pinned [get]
+func GetPinnedMessages(repos *repository.Repositories, c *gin.Context) {
+	chatID, err := gocql.ParseUUID(c.Query("chat_id"))
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid chat_id"})
+		return
+	}
+
+	userID := middleware.UserID(c)
+
+	access, ok := authz.Require(c, repos, userID, chatID, authz.Read)
+	if !ok {
+		return
+	}
+
+	pins, err := repos.Messages.ListPinnedMessages(userID, chatID)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch pinned messages"})
+		return
+	}
+
+	replies := newReplyResolver(repos, access.Chat)
+	result := make([]PinnedMessage, 0, len(pins))
+	for _, pin := range pins {
+		row, err := repos.Messages.GetMessage(userID, chatID, pin.MessageID)
+		if err == repository.ErrNotFound {
+			// Пользователь удалил сообщение только у себя
+			continue
+		}
+		if err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch message"})
+			return
+		}
+
+		message, err := decryptMessage(row, access.Chat.PrivateKey)
+		if err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to decrypt message"})
+			return
+		}
+		message.IsMyMessage = row.SenderID == userID
+		replies.attach(&message)
+		result = append(result, PinnedMessage{Message: message, PinnedBy: pin.PinnedBy, PinnedAt: pin.PinnedAt})
+	}
+
+	c.JSON(http.StatusOK, result)
+}
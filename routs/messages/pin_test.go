@@ -0,0 +1,72 @@
+package messages
+
+import (
+	"Bmessage_backend/routs/chats"
+	"Bmessage_backend/testutil"
+	"net/http"
+	"testing"
+
+	"github.com/gocql/gocql"
+)
+
+func (f chatFixture) pinned(t *testing.T, token string) []PinnedMessage {
+	t.Helper()
+	var pinned []PinnedMessage
+	rec := testutil.DoAs(t, f.router, token, http.MethodGet, "/messages/pinned?chat_id="+f.chatID.String(), nil)
+	testutil.Decode(t, rec, http.StatusOK, &pinned)
+	return pinned
+}
+
+func (f chatFixture) pin(t *testing.T, token string, messageID gocql.UUID, pinned bool, status int) {
+	t.Helper()
+	rec := testutil.DoAs(t, f.router, token, http.MethodPost, "/messages/pin",
+		PinMessageStruct{ChatID: f.chatID.String(), MessageID: messageID.String(), Pinned: pinned})
+	testutil.Decode(t, rec, status, nil)
+}
+
+func TestPinnedMessages(t *testing.T) {
+	f := newChatFixture(t)
+	f.send(t, f.ivanToken, "адрес: Ленина, 1")
+	f.send(t, f.petrToken, "встречаемся в шесть")
+	messages := f.messages(t, f.ivanToken)
+	first, second := messages[1], messages[0]
+
+	f.pin(t, f.petrToken, first.MessageID, true, http.StatusOK)
+	f.pin(t, f.ivanToken, second.MessageID, true, http.StatusOK)
+	f.pin(t, f.ivanToken, gocql.TimeUUID(), true, http.StatusNotFound)
+
+	// Закрепления видны обоим участникам, последние закреплённые первыми
+	for _, token := range []string{f.ivanToken, f.petrToken} {
+		pinned := f.pinned(t, token)
+		if len(pinned) != 2 || pinned[0].MessageID != second.MessageID || pinned[1].MessageID != first.MessageID ||
+			pinned[1].PinnedBy != f.petrID || pinned[1].MessageText != "адрес: Ленина, 1" {
+			t.Fatalf("unexpected pinned messages: %+v", pinned)
+		}
+	}
+
+	details, err := chats.GetChatDetails(f.ivanID, f.chatID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(details.PinnedMessageIDs) != 2 || details.PinnedMessageIDs[0] != second.MessageID {
+		t.Fatalf("unexpected pinned ids in chat details: %v", details.PinnedMessageIDs)
+	}
+
+	f.pin(t, f.ivanToken, first.MessageID, false, http.StatusOK)
+	if pinned := f.pinned(t, f.petrToken); len(pinned) != 1 || pinned[0].MessageID != second.MessageID {
+		t.Fatalf("unpin not mirrored: %+v", pinned)
+	}
+
+	// Удалённое для всех сообщение открепляется
+	rec := testutil.DoAs(t, f.router, f.petrToken, http.MethodPost, "/messages/delete-message", DeleteMessageStruct{
+		ChatID:      f.chatID.String(),
+		MessageID:   second.MessageID.String(),
+		ForEveryone: true,
+	})
+	testutil.Decode(t, rec, http.StatusOK, nil)
+	for _, token := range []string{f.ivanToken, f.petrToken} {
+		if pinned := f.pinned(t, token); len(pinned) != 0 {
+			t.Fatalf("deleted message still pinned: %+v", pinned)
+		}
+	}
+}
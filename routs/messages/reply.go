@@ -0,0 +1,93 @@
+package messages
+
+import (
+	"Bmessage_backend/helpers"
+	"Bmessage_backend/repository"
+	"log"
+
+	"github.com/gocql/gocql"
+)
+
+// Длина текста в превью ответа, в символах
+const replyPreviewLength = 100
+
+// ReplyPreview — цитата сообщения, на которое отвечают
+type ReplyPreview struct {
+	MessageID gocql.UUID `json:"message_id"`
+	SenderID  uint       `json:"sender_id,omitempty"`
+	// Text обрезан до 100 символов
+	Text string `json:"text,omitempty"`
+	// Deleted — сообщение удалено из копии пользователя
+	Deleted bool `json:"deleted,omitempty"`
+	// Unavailable — сообщение не удалось прочитать
+	Unavailable bool `json:"unavailable,omitempty"`
+	// ViewOnce — цитируется одноразовое сообщение; его текст в превью не попадает
+	ViewOnce bool `json:"view_once,omitempty"`
+}
+
+func truncatePreview(text string) string {
+	runes := []rune(text)
+	if len(runes) <= replyPreviewLength {
+		return text
+	}
+	return string(runes[:replyPreviewLength]) + "…"
+}
+
+// replyResolver строит превью ответов по копиям сообщений владельца чата
+// и запоминает уже найденные сообщения
+type replyResolver struct {
+	repos    *repository.Repositories
+	chat     repository.Chat
+	previews map[gocql.UUID]*ReplyPreview
+}
+
+func newReplyResolver(repos *repository.Repositories, chat repository.Chat) *replyResolver {
+	return &replyResolver{repos: repos, chat: chat, previews: make(map[gocql.UUID]*ReplyPreview)}
+}
+
+// remember добавляет уже расшифрованное сообщение, чтобы не читать его повторно
+func (r *replyResolver) remember(message Message) {
+	preview := &ReplyPreview{MessageID: message.MessageID, SenderID: message.SenderID, ViewOnce: message.ViewOnce}
+	if !message.ViewOnce {
+		preview.Text = truncatePreview(message.MessageText)
+	}
+	r.previews[message.MessageID] = preview
+}
+
+// attach заполняет ReplyPreview сообщения, если оно является ответом
+func (r *replyResolver) attach(message *Message) {
+	if message.ReplyToMessageID != nil {
+		message.ReplyPreview = r.preview(*message.ReplyToMessageID)
+	}
+}
+
+func (r *replyResolver) preview(messageID gocql.UUID) *ReplyPreview {
+	if preview, ok := r.previews[messageID]; ok {
+		return preview
+	}
+
+	preview := &ReplyPreview{MessageID: messageID}
+	row, err := r.repos.Messages.GetMessage(r.chat.UserID, r.chat.ChatID, messageID)
+	switch {
+	case err == repository.ErrNotFound:
+		preview.Deleted = true
+	case err != nil:
+		log.Println("Failed to fetch replied message:", err)
+		preview.Unavailable = true
+	case row.ViewOnce:
+		preview.SenderID = row.SenderID
+		preview.ViewOnce = true
+	default:
+		preview.SenderID = row.SenderID
+		text, err := helpers.DecryptWithPrivateKey(row.MessageText, r.chat.PrivateKey)
+		if err != nil {
+			log.Println("Failed to decrypt replied message:", err)
+			preview.Unavailable = true
+		} else {
+			preview.Text = truncatePreview(text)
+		}
+	}
+
+	r.previews[messageID] = preview
+	return preview
+}
@@ -0,0 +1,352 @@
+package messages
+
+import (
+	"Bmessage_backend/authz"
+	"Bmessage_backend/helpers"
+	"Bmessage_backend/middleware"
+	"Bmessage_backend/models"
+	"Bmessage_backend/repository"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gocql/gocql"
+)
+
+// На сколько вперёд можно запланировать сообщение
+const maxScheduleAhead = 365 * 24 * time.Hour
+
+// ScheduledMessage — отложенное сообщение в открытом виде
+type ScheduledMessage struct {
+	ID               uint        `json:"id"`
+	ChatID           gocql.UUID  `json:"chat_id"`
+	MessageText      string      `json:"message_text"`
+	ReplyToMessageID *gocql.UUID `json:"reply_to_message_id,omitempty"`
+	SendAt           time.Time   `json:"send_at"`
+	// Status — pending, пока сообщение ждёт отправки, или failed, если отправить не удалось
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// ScheduleMessageStruct represents the JSON
+// @Description Отложенное сообщение. send_at — время отправки в формате RFC 3339, не позже чем через год.
+type ScheduleMessageStruct struct {
+	ChatID           string    `json:"chat_id"`
+	MessageText      string    `json:"message_text"`
+	SendAt           time.Time `json:"send_at"`
+	ReplyToMessageID *string   `json:"reply_to_message_id"`
+}
+
+// EditScheduledMessageStruct represents the JSON
+// @Description Изменение отложенного сообщения. Непереданные поля не меняются.
+type EditScheduledMessageStruct struct {
+	ID          uint       `json:"id"`
+	MessageText *string    `json:"message_text"`
+	SendAt      *time.Time `json:"send_at"`
+}
+
+// CancelScheduledMessageStruct represents the JSON
+// @Description Отмена отложенного сообщения
+type CancelScheduledMessageStruct struct {
+	ID uint `json:"id"`
+}
+
+// validSendAt проверяет время отправки. При ошибке сам отвечает клиенту и возвращает false.
+func validSendAt(c *gin.Context, sendAt time.Time) bool {
+	now := time.Now()
+	if !sendAt.After(now) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "send_at must be in the future"})
+		return false
+	}
+	if sendAt.After(now.Add(maxScheduleAhead)) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "send_at must be within a year"})
+		return false
+	}
+	return true
+}
+
+// scheduledFromModel расшифровывает отложенное сообщение ключом чата отправителя
+func scheduledFromModel(message models.ScheduledMessage, chat repository.Chat) (ScheduledMessage, error) {
+	result := ScheduledMessage{
+		ID:     message.ID,
+		ChatID: chat.ChatID,
+		SendAt: message.SendAt,
+		Status: message.Status,
+		Error:  message.Error,
+	}
+	if message.ReplyToMessageID != nil {
+		replyID, err := gocql.ParseUUID(*message.ReplyToMessageID)
+		if err != nil {
+			return ScheduledMessage{}, err
+		}
+		result.ReplyToMessageID = &replyID
+	}
+	text, err := helpers.DecryptWithPrivateKey(message.MessageText, chat.PrivateKey)
+	if err != nil {
+		return ScheduledMessage{}, err
+	}
+	result.MessageText = text
+	return result, nil
+}
+
+// @Tags Message
+// ScheduleMessage godoc
+// @Summary Отложенная отправка сообщения
+// @Description Сообщение хранится зашифрованным ключом чата отправителя и отправляется в send_at
+// @Description так же, как через messages/add-message. Права на отправку проверяются ещё раз в момент отправки.
+// @Accept json
+// @Produce  json
+// @Param data body ScheduleMessageStruct true "Отложенное сообщение"
+// @Security BearerAuth
+// @Success 200 {object} map[string]interface{} "successful response"
+// @Failure 400 {object} map[string]interface{} "bad request"
+// @Failure 401 {object} map[string]interface{} "unauthorized"
+// @Failure 403 {object} map[string]interface{} "forbidden"
+// @Router /messages/schedule [post]
+func ScheduleMessage(repos *repository.Repositories, c *gin.Context) {
+	var scheduleData ScheduleMessageStruct
+	if err := c.BindJSON(&scheduleData); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+		return
+	}
+
+	chatID, err := gocql.ParseUUID(scheduleData.ChatID)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid chat_id"})
+		return
+	}
+	if strings.TrimSpace(scheduleData.MessageText) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "message_text is required"})
+		return
+	}
+	if !validSendAt(c, scheduleData.SendAt) {
+		return
+	}
+
+	userID := middleware.UserID(c)
+
+	access, ok := authz.Require(c, repos, userID, chatID, authz.Post)
+	if !ok {
+		return
+	}
+	replyID, ok := parseReplyTarget(repos, c, userID, chatID, scheduleData.ReplyToMessageID)
+	if !ok {
+		return
+	}
+
+	encryptedText, err := encryptFor(access.Chat, scheduleData.MessageText)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to encrypt message"})
+		return
+	}
+
+	scheduled := models.ScheduledMessage{
+		SenderID:    userID,
+		ChatID:      chatID.String(),
+		MessageText: encryptedText,
+		SendAt:      scheduleData.SendAt,
+	}
+	if replyID != nil {
+		value := replyID.String()
+		scheduled.ReplyToMessageID = &value
+	}
+	if err := repos.Schedule.CreateScheduled(&scheduled); err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to schedule message"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"data": ScheduledMessage{
+		ID:               scheduled.ID,
+		ChatID:           chatID,
+		MessageText:      scheduleData.MessageText,
+		ReplyToMessageID: replyID,
+		SendAt:           scheduled.SendAt,
+		Status:           scheduled.Status,
+	}})
+}
+
+// @Tags Message
+// GetScheduledMessages godoc
+// @Summary Список отложенных сообщений
+// @Description Ожидающие и неотправленные (status = failed) сообщения пользователя по возрастанию send_at.
+// @Produce json
+// @Param chat_id query string false "Только сообщения этого чата"
+// @Security BearerAuth
+// @Success 200 {object} []ScheduledMessage "successful response"
+// @Failure 400 {object} map[string]interface{} "bad request"
+// @Failure 401 {object} map[string]interface{} "unauthorized"
+// @Router /messages/scheduled [get]
+func GetScheduledMessages(repos *repository.Repositories, c *gin.Context) {
+	var chatFilter *gocql.UUID
+	if value := c.Query("chat_id"); value != "" {
+		chatID, err := gocql.ParseUUID(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid chat_id"})
+			return
+		}
+		chatFilter = &chatID
+	}
+
+	userID := middleware.UserID(c)
+
+	rows, err := repos.Schedule.ListScheduled(userID, chatFilter)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch scheduled messages"})
+		return
+	}
+
+	chatsByID := make(map[string]repository.Chat)
+	result := make([]ScheduledMessage, 0, len(rows))
+	for _, row := range rows {
+		chat, ok := chatsByID[row.ChatID]
+		if !ok {
+			chatID, err := gocql.ParseUUID(row.ChatID)
+			if err == nil {
+				chat, err = repos.Chats.GetChat(userID, chatID)
+			}
+			if err != nil {
+				// Чат удалён: сообщение всё равно не будет отправлено
+				log.Println("Failed to fetch chat of scheduled message:", err)
+				continue
+			}
+			chatsByID[row.ChatID] = chat
+		}
+
+		message, err := scheduledFromModel(row, chat)
+		if err != nil {
+			log.Println("Failed to decrypt scheduled message:", err)
+			continue
+		}
+		result = append(result, message)
+	}
+
+	c.JSON(http.StatusOK, result)
+}
+
+// @Tags Message
+// EditScheduledMessage godoc
+// @Summary Изменение отложенного сообщения
+// @Description Можно менять только сообщения, которые ещё не начали отправляться.
+// @Accept json
+// @Produce  json
+// @Param data body EditScheduledMessageStruct true "Новые текст и время отправки"
+// @Security BearerAuth
+// @Success 200 {object} map[string]interface{} "successful response"
+// @Failure 400 {object} map[string]interface{} "bad request"
+// @Failure 401 {object} map[string]interface{} "unauthorized"
+// @Failure 403 {object} map[string]interface{} "forbidden"
+// @Failure 404 {object} map[string]interface{} "scheduled message not found"
+// @Router /messages/scheduled/edit [post]
+func EditScheduledMessage(repos *repository.Repositories, c *gin.Context) {
+	var editData EditScheduledMessageStruct
+	if err := c.BindJSON(&editData); err != nil || editData.ID == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+		return
+	}
+	if editData.MessageText != nil && strings.TrimSpace(*editData.MessageText) == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "message_text is required"})
+		return
+	}
+	if editData.SendAt != nil && !validSendAt(c, *editData.SendAt) {
+		return
+	}
+
+	userID := middleware.UserID(c)
+
+	scheduled, err := repos.Schedule.GetScheduled(userID, editData.ID)
+	if err != nil && err != repository.ErrNotFound {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch scheduled message"})
+		return
+	}
+	if err == repository.ErrNotFound || scheduled.Status != models.ScheduledPending {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Scheduled message not found"})
+		return
+	}
+
+	chatID, err := gocql.ParseUUID(scheduled.ChatID)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch scheduled message"})
+		return
+	}
+	access, ok := authz.Require(c, repos, userID, chatID, authz.Post)
+	if !ok {
+		return
+	}
+
+	text := scheduled.MessageText
+	if editData.MessageText != nil {
+		text, err = encryptFor(access.Chat, *editData.MessageText)
+		if err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to encrypt message"})
+			return
+		}
+	}
+	sendAt := scheduled.SendAt
+	if editData.SendAt != nil {
+		sendAt = *editData.SendAt
+	}
+
+	err = repos.Schedule.UpdateScheduled(userID, scheduled.ID, text, sendAt)
+	if err == repository.ErrNotFound {
+		// Диспетчер успел забрать сообщение на отправку
+		c.JSON(http.StatusNotFound, gin.H{"error": "Scheduled message not found"})
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to update scheduled message"})
+		return
+	}
+
+	scheduled.MessageText = text
+	scheduled.SendAt = sendAt
+	message, err := scheduledFromModel(scheduled, access.Chat)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to decrypt message"})
+		return
+	}
+	c.JSON(http.StatusOK, gin.H{"data": message})
+}
+
+// @Tags Message
+// CancelScheduledMessage godoc
+// @Summary Отмена отложенного сообщения
+// @Description Удаляет ожидающее или неотправленное сообщение.
+// @Accept json
+// @Produce  json
+// @Param data body CancelScheduledMessageStruct true "Отложенное сообщение"
+// @Security BearerAuth
+// @Success 200 {object} map[string]interface{} "successful response"
+// @Failure 400 {object} map[string]interface{} "bad request"
+// @Failure 401 {object} map[string]interface{} "unauthorized"
+// @Failure 404 {object} map[string]interface{} "scheduled message not found"
+// @Router /messages/scheduled/cancel [post]
+func CancelScheduledMessage(repos *repository.Repositories, c *gin.Context) {
+	var cancelData CancelScheduledMessageStruct
+	if err := c.BindJSON(&cancelData); err != nil || cancelData.ID == 0 {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid request data"})
+		return
+	}
+
+	err := repos.Schedule.CancelScheduled(middleware.UserID(c), cancelData.ID)
+	if err == repository.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Scheduled message not found"})
+		return
+	}
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cancel scheduled message"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"status": "Scheduled message cancelled"})
+}
@@ -0,0 +1,214 @@
+package messages
+
+import (
+	"Bmessage_backend/leader"
+	"Bmessage_backend/models"
+	"Bmessage_backend/testutil"
+	"context"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gocql/gocql"
+)
+
+func (f chatFixture) scheduled(t *testing.T, token string) []ScheduledMessage {
+	t.Helper()
+	var scheduled []ScheduledMessage
+	rec := testutil.DoAs(t, f.router, token, http.MethodGet, "/messages/scheduled?chat_id="+f.chatID.String(), nil)
+	testutil.Decode(t, rec, http.StatusOK, &scheduled)
+	return scheduled
+}
+
+// makeDue переносит отложенное сообщение в прошлое в обход проверки send_at
+func (f chatFixture) makeDue(t *testing.T, senderID, id uint) {
+	t.Helper()
+	scheduled, err := f.repos.Schedule.GetScheduled(senderID, id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.repos.Schedule.UpdateScheduled(senderID, id, scheduled.MessageText, time.Now().Add(-time.Second)); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestScheduledMessages(t *testing.T) {
+	f := newChatFixture(t)
+	sendAt := time.Now().Add(time.Hour).Truncate(time.Second)
+
+	schedule := func(text string, status int) ScheduledMessage {
+		t.Helper()
+		var response struct {
+			Data ScheduledMessage `json:"data"`
+		}
+		rec := testutil.DoAs(t, f.router, f.ivanToken, http.MethodPost, "/messages/schedule", ScheduleMessageStruct{
+			ChatID:      f.chatID.String(),
+			MessageText: text,
+			SendAt:      sendAt,
+		})
+		testutil.Decode(t, rec, status, &response)
+		return response.Data
+	}
+
+	first := schedule("доброе утро", http.StatusOK)
+	second := schedule("не отправлять", http.StatusOK)
+
+	sendAt = time.Now().Add(-time.Minute)
+	schedule("в прошлое", http.StatusBadRequest)
+
+	// Собеседник не видит чужих отложенных сообщений и не может их отменить
+	if scheduled := f.scheduled(t, f.petrToken); len(scheduled) != 0 {
+		t.Fatalf("companion sees %d scheduled messages", len(scheduled))
+	}
+	testutil.Decode(t, testutil.DoAs(t, f.router, f.petrToken, http.MethodPost, "/messages/scheduled/cancel",
+		CancelScheduledMessageStruct{ID: first.ID}), http.StatusNotFound, nil)
+
+	edited := "доброе утро, Пётр"
+	testutil.Decode(t, testutil.DoAs(t, f.router, f.ivanToken, http.MethodPost, "/messages/scheduled/edit",
+		EditScheduledMessageStruct{ID: first.ID, MessageText: &edited}), http.StatusOK, nil)
+	testutil.Decode(t, testutil.DoAs(t, f.router, f.ivanToken, http.MethodPost, "/messages/scheduled/cancel",
+		CancelScheduledMessageStruct{ID: second.ID}), http.StatusOK, nil)
+
+	scheduled := f.scheduled(t, f.ivanToken)
+	if len(scheduled) != 1 || scheduled[0].MessageText != edited || scheduled[0].Status != models.ScheduledPending {
+		t.Fatalf("unexpected scheduled messages: %+v", scheduled)
+	}
+
+	// До наступления send_at диспетчер ничего не отправляет
+	if sent, err := dispatchDueScheduled(context.Background(), f.repos, nil, time.Now()); err != nil || sent != 0 {
+		t.Fatalf("dispatch before send_at: sent %d, err %v", sent, err)
+	}
+
+	f.makeDue(t, f.ivanID, first.ID)
+	if sent, err := dispatchDueScheduled(context.Background(), f.repos, nil, time.Now()); err != nil || sent != 1 {
+		t.Fatalf("dispatch: sent %d, err %v", sent, err)
+	}
+
+	for _, token := range []string{f.ivanToken, f.petrToken} {
+		messages := f.messages(t, token)
+		if len(messages) != 1 || messages[0].MessageText != edited || messages[0].SenderID != f.ivanID {
+			t.Fatalf("unexpected delivered messages: %+v", messages)
+		}
+	}
+	if unread := f.unread(t, f.petrID); unread != 1 {
+		t.Fatalf("companion unread = %d, want 1", unread)
+	}
+	if scheduled := f.scheduled(t, f.ivanToken); len(scheduled) != 0 {
+		t.Fatalf("sent message still listed: %+v", scheduled)
+	}
+
+	// Отправленное сообщение больше нельзя изменить
+	testutil.Decode(t, testutil.DoAs(t, f.router, f.ivanToken, http.MethodPost, "/messages/scheduled/edit",
+		EditScheduledMessageStruct{ID: first.ID, MessageText: &edited}), http.StatusNotFound, nil)
+}
+
+func TestScheduledMessageFailsWhenBlocked(t *testing.T) {
+	f := newChatFixture(t)
+
+	var response struct {
+		Data ScheduledMessage `json:"data"`
+	}
+	rec := testutil.DoAs(t, f.router, f.ivanToken, http.MethodPost, "/messages/schedule", ScheduleMessageStruct{
+		ChatID:      f.chatID.String(),
+		MessageText: "привет",
+		SendAt:      time.Now().Add(time.Hour),
+	})
+	testutil.Decode(t, rec, http.StatusOK, &response)
+
+	// Права на отправку проверяются в момент отправки
+	if err := f.repos.Users.BlockUser(f.petrID, f.ivanID); err != nil {
+		t.Fatal(err)
+	}
+	f.makeDue(t, f.ivanID, response.Data.ID)
+	if sent, err := dispatchDueScheduled(context.Background(), f.repos, nil, time.Now()); err != nil || sent != 0 {
+		t.Fatalf("dispatch: sent %d, err %v", sent, err)
+	}
+	if messages := f.messages(t, f.petrToken); len(messages) != 0 {
+		t.Fatalf("blocked message delivered: %+v", messages)
+	}
+
+	scheduled := f.scheduled(t, f.ivanToken)
+	if len(scheduled) != 1 || scheduled[0].Status != models.ScheduledFailed || scheduled[0].Error == "" {
+		t.Fatalf("unexpected scheduled messages: %+v", scheduled)
+	}
+	testutil.Decode(t, testutil.DoAs(t, f.router, f.ivanToken, http.MethodPost, "/messages/scheduled/cancel",
+		CancelScheduledMessageStruct{ID: response.Data.ID}), http.StatusOK, nil)
+}
+
+// scheduleDue создаёт отложенное сообщение Ивана, время которого уже наступило
+func (f chatFixture) scheduleDue(t *testing.T, text string) ScheduledMessage {
+	t.Helper()
+	var response struct {
+		Data ScheduledMessage `json:"data"`
+	}
+	rec := testutil.DoAs(t, f.router, f.ivanToken, http.MethodPost, "/messages/schedule", ScheduleMessageStruct{
+		ChatID:      f.chatID.String(),
+		MessageText: text,
+		SendAt:      time.Now().Add(time.Hour),
+	})
+	testutil.Decode(t, rec, http.StatusOK, &response)
+	f.makeDue(t, f.ivanID, response.Data.ID)
+	return response.Data
+}
+
+func TestScheduledMessageRetriedAfterCrash(t *testing.T) {
+	f := newChatFixture(t)
+	scheduled := f.scheduleDue(t, "после сбоя")
+
+	// Диспетчер забрал сообщение, доставил его и упал до FinishScheduled
+	claimed, ok, err := f.repos.Schedule.ClaimScheduled(scheduled.ID, gocql.TimeUUID(), time.Now())
+	if err != nil || !ok || claimed.MessageID == nil {
+		t.Fatalf("claim: %+v, %v, %v", claimed, ok, err)
+	}
+	if _, err := sendScheduled(f.repos, claimed); err != nil {
+		t.Fatal(err)
+	}
+
+	// Пока не истёк срок захвата, сообщение никто не трогает
+	if sent, err := dispatchDueScheduled(context.Background(), f.repos, nil, time.Now()); err != nil || sent != 0 {
+		t.Fatalf("dispatch of fresh claim: sent %d, err %v", sent, err)
+	}
+	later := time.Now().Add(scheduledClaimTimeout + time.Second)
+	if sent, err := dispatchDueScheduled(context.Background(), f.repos, nil, later); err != nil || sent != 1 {
+		t.Fatalf("dispatch of stale claim: sent %d, err %v", sent, err)
+	}
+
+	// Повторная доставка не дублирует сообщение и счётчик непрочитанных
+	for _, token := range []string{f.ivanToken, f.petrToken} {
+		messages := f.messages(t, token)
+		if len(messages) != 1 || messages[0].MessageID.String() != *claimed.MessageID {
+			t.Fatalf("unexpected messages after retry: %+v", messages)
+		}
+	}
+	if unread := f.unread(t, f.petrID); unread != 1 {
+		t.Fatalf("companion unread = %d, want 1", unread)
+	}
+	if sent, err := dispatchDueScheduled(context.Background(), f.repos, nil, later); err != nil || sent != 0 {
+		t.Fatalf("sent message dispatched again: sent %d, err %v", sent, err)
+	}
+}
+
+func TestScheduledDispatchStopsWithoutLeadership(t *testing.T) {
+	f := newChatFixture(t)
+	scheduled := f.scheduleDue(t, "не сейчас")
+
+	ctx := context.Background()
+	other := leader.New(scheduledLeaderKey, time.Minute)
+	if ok, err := other.Acquire(ctx); err != nil || !ok {
+		t.Fatalf("acquire: %v, %v", ok, err)
+	}
+	mine := leader.New(scheduledLeaderKey, time.Minute)
+	if sent, err := dispatchDueScheduled(ctx, f.repos, mine, time.Now()); err != nil || sent != 0 {
+		t.Fatalf("dispatch without leadership: sent %d, err %v", sent, err)
+	}
+	if pending := f.scheduled(t, f.ivanToken); len(pending) != 1 || pending[0].ID != scheduled.ID || pending[0].Status != models.ScheduledPending {
+		t.Fatalf("message claimed without leadership: %+v", pending)
+	}
+
+	if err := other.Release(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if sent, err := dispatchDueScheduled(ctx, f.repos, mine, time.Now()); err != nil || sent != 1 {
+		t.Fatalf("dispatch with leadership: sent %d, err %v", sent, err)
+	}
+}
@@ -0,0 +1,180 @@
+package messages
+
+import (
+	"Bmessage_backend/authz"
+	"Bmessage_backend/helpers"
+	"Bmessage_backend/middleware"
+	"Bmessage_backend/models"
+	"Bmessage_backend/repository"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gocql/gocql"
+)
+
+// Размер страницы поиска по сообщениям
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 50
+)
+
+// SearchHit — найденное сообщение вместе с чатом, в котором оно написано
+// @Description Найденное сообщение и собеседник по чату
+type SearchHit struct {
+	ChatID           gocql.UUID `json:"chat_id"`
+	CompanionID      uint       `json:"companion_id"`
+	CompanionName    string     `json:"companion_name,omitempty"`
+	CompanionSoName  string     `json:"companion_so_name,omitempty"`
+	CompanionNik     string     `json:"companion_nik,omitempty"`
+	CompanionDeleted bool       `json:"companion_deleted,omitempty"`
+	Message          Message    `json:"message"`
+}
+
+// @Tags Message
+// SearchMessages godoc
+// @Summary Поиск по сообщениям
+// @Description Ищет сообщения пользователя, содержащие все слова запроса (поддерживаются "фразы в кавычках", OR и -исключение).
+// @Description Без chat_id — по всем чатам. next_offset есть в ответе, если результатов больше.
+// @Accept json
+// @Produce json
+// @Param q query string true "Запрос"
+// @Param chat_id query string false "Искать только в этом чате"
+// @Param limit query int false "Размер страницы (по умолчанию 20, не больше 50)"
+// @Param offset query int false "Смещение"
+// @Security BearerAuth
+// @Success 200 {object} map[string]interface{} "successful response"
+// @Failure 400 {object} map[string]interface{} "bad request"
+// @Failure 401 {object} map[string]interface{} "unauthorized"
+// @Failure 403 {object} map[string]interface{} "forbidden"
+// @Router /messages/search [get]
+func SearchMessages(repos *repository.Repositories, c *gin.Context) {
+	userID := middleware.UserID(c)
+
+	query := strings.TrimSpace(c.Query("q"))
+	if query == "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "q is required"})
+		return
+	}
+
+	search := repository.MessageSearch{OwnerID: userID, Query: query, Limit: defaultSearchLimit}
+	if value := c.Query("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit <= 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid limit"})
+			return
+		}
+		search.Limit = min(limit, maxSearchLimit)
+	}
+	if value := c.Query("offset"); value != "" {
+		offset, err := strconv.Atoi(value)
+		if err != nil || offset < 0 {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid offset"})
+			return
+		}
+		search.Offset = offset
+	}
+
+	chatsByID := make(map[gocql.UUID]repository.Chat)
+	if value := c.Query("chat_id"); value != "" {
+		chatID, err := gocql.ParseUUID(value)
+		if err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid chat_id"})
+			return
+		}
+		access, ok := authz.Require(c, repos, userID, chatID, authz.Read)
+		if !ok {
+			return
+		}
+		search.ChatID = &chatID
+		chatsByID[chatID] = access.Chat
+	}
+
+	// Лишняя запись показывает, есть ли следующая страница
+	limit := search.Limit
+	search.Limit++
+	hits, err := repos.Search.SearchMessages(search)
+	if err != nil {
+		log.Println("Failed to search messages:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to search messages"})
+		return
+	}
+
+	response := gin.H{}
+	if len(hits) > limit {
+		hits = hits[:limit]
+		response["next_offset"] = search.Offset + limit
+	}
+
+	var companionIDs []uint
+	for _, hit := range hits {
+		if _, ok := chatsByID[hit.ChatID]; ok {
+			continue
+		}
+		chat, err := repos.Chats.GetChat(userID, hit.ChatID)
+		if err != nil {
+			log.Println("Failed to fetch chat of search hit:", err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to search messages"})
+			return
+		}
+		chatsByID[hit.ChatID] = chat
+		companionIDs = append(companionIDs, chat.CompanionID)
+	}
+	if search.ChatID != nil {
+		companionIDs = append(companionIDs, chatsByID[*search.ChatID].CompanionID)
+	}
+
+	companions, err := repos.Users.GetUsers(companionIDs)
+	if err != nil {
+		log.Println("Failed to fetch users from PostgreSQL:", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to search messages"})
+		return
+	}
+	companionsByID := make(map[uint]models.User, len(companions))
+	for _, companion := range companions {
+		companionsByID[companion.ID] = companion
+	}
+
+	results := make([]SearchHit, 0, len(hits))
+	for _, hit := range hits {
+		chat := chatsByID[hit.ChatID]
+		row, err := repos.Messages.GetMessage(userID, hit.ChatID, hit.MessageID)
+		if err == repository.ErrNotFound {
+			// Запись индекса пережила сообщение — пропускаем
+			continue
+		}
+		if err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch messages"})
+			return
+		}
+		decryptedText, err := helpers.DecryptWithPrivateKey(row.MessageText, chat.PrivateKey)
+		if err != nil {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to decrypt message"})
+			return
+		}
+
+		msg := messageFromRepository(row)
+		msg.MessageText = decryptedText
+		msg.IsMyMessage = msg.SenderID == userID
+
+		result := SearchHit{
+			ChatID:           hit.ChatID,
+			CompanionID:      chat.CompanionID,
+			CompanionDeleted: chat.CompanionDeleted,
+			Message:          msg,
+		}
+		if companion, ok := companionsByID[chat.CompanionID]; ok {
+			result.CompanionName = companion.Name
+			result.CompanionSoName = companion.SoName
+			result.CompanionNik = companion.Nik
+		}
+		results = append(results, result)
+	}
+
+	response["data"] = results
+	c.JSON(http.StatusOK, response)
+}
@@ -0,0 +1,129 @@
+package messages
+
+import (
+	"Bmessage_backend/routs/chats"
+	"Bmessage_backend/testutil"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/gocql/gocql"
+)
+
+type searchPage struct {
+	Data       []SearchHit `json:"data"`
+	NextOffset *int        `json:"next_offset"`
+}
+
+func (f chatFixture) search(t *testing.T, token, query string, status int) searchPage {
+	t.Helper()
+	var page searchPage
+	rec := testutil.DoAs(t, f.router, token, http.MethodGet, "/messages/search?"+query, nil)
+	testutil.Decode(t, rec, status, &page)
+	return page
+}
+
+func TestSearchMessages(t *testing.T) {
+	f := newChatFixture(t)
+	sidor, sidorToken := testutil.CreateUser(t, f.repos, "sidor")
+
+	var other struct {
+		ChatID gocql.UUID `json:"chat_id"`
+	}
+	rec := testutil.DoAs(t, f.router, f.ivanToken, http.MethodPost, "/chats/create-chat", chats.CreateChatStruct{Companion_id: sidor.ID})
+	testutil.Decode(t, rec, http.StatusOK, &other)
+
+	f.send(t, f.ivanToken, "Встречаемся у метро в семь")
+	f.send(t, f.petrToken, "Хорошо, у метро")
+	f.send(t, f.ivanToken, "купи хлеба")
+	rec = testutil.DoAs(t, f.router, sidorToken, http.MethodPost, "/messages/add-message", AddMessageStruct{ChatID: other.ChatID.String(), MessageText: "метро закрыто"})
+	testutil.Decode(t, rec, http.StatusOK, nil)
+
+	// Глобальный поиск: новые совпадения раньше, с контекстом чата и постраничностью
+	first := f.search(t, f.ivanToken, "q="+url.QueryEscape("МЕТРО")+"&limit=2", http.StatusOK)
+	if len(first.Data) != 2 || first.NextOffset == nil || *first.NextOffset != 2 {
+		t.Fatalf("unexpected first page: %+v", first)
+	}
+	if hit := first.Data[0]; hit.ChatID != other.ChatID || hit.CompanionNik != "sidor" || hit.Message.MessageText != "метро закрыто" || hit.Message.IsMyMessage {
+		t.Fatalf("unexpected hit: %+v", hit)
+	}
+	second := f.search(t, f.ivanToken, "q="+url.QueryEscape("метро")+"&limit=2&offset=2", http.StatusOK)
+	if len(second.Data) != 1 || second.NextOffset != nil || !second.Data[0].Message.IsMyMessage {
+		t.Fatalf("unexpected second page: %+v", second)
+	}
+
+	// Поиск в одном чате и по нескольким словам
+	inChat := f.search(t, f.ivanToken, "chat_id="+f.chatID.String()+"&q="+url.QueryEscape("метро семь"), http.StatusOK)
+	if len(inChat.Data) != 1 || inChat.Data[0].Message.MessageText != "Встречаемся у метро в семь" || inChat.Data[0].CompanionNik != "petr" {
+		t.Fatalf("unexpected chat search: %+v", inChat)
+	}
+
+	// Индекс у каждого свой: Сидор не видит переписку Ивана и Петра
+	if page := f.search(t, sidorToken, "q=хлеба", http.StatusOK); len(page.Data) != 0 {
+		t.Fatalf("search leaked foreign messages: %+v", page)
+	}
+	f.search(t, sidorToken, "chat_id="+f.chatID.String()+"&q=хлеба", http.StatusForbidden)
+	f.search(t, f.ivanToken, "q=", http.StatusBadRequest)
+}
+
+func TestEditAndDeleteUpdateSearchIndex(t *testing.T) {
+	f := newChatFixture(t)
+
+	f.send(t, f.ivanToken, "встреча в пятницу")
+	f.send(t, f.petrToken, "пятницу не могу")
+	history := f.messages(t, f.ivanToken)
+	ivanMessage, petrMessage := history[1], history[0]
+
+	rec := testutil.DoAs(t, f.router, f.petrToken, http.MethodPost, "/messages/edit-message", EditMessageStruct{
+		ChatID: f.chatID.String(), MessageID: ivanMessage.MessageID.String(), MessageText: "чужое",
+	})
+	testutil.Decode(t, rec, http.StatusForbidden, nil)
+
+	rec = testutil.DoAs(t, f.router, f.ivanToken, http.MethodPost, "/messages/edit-message", EditMessageStruct{
+		ChatID: f.chatID.String(), MessageID: ivanMessage.MessageID.String(), MessageText: "встреча в субботу",
+	})
+	testutil.Decode(t, rec, http.StatusOK, nil)
+
+	for _, token := range []string{f.ivanToken, f.petrToken} {
+		if page := f.search(t, token, "q=субботу", http.StatusOK); len(page.Data) != 1 || page.Data[0].Message.EditedAt == nil {
+			t.Fatalf("edited message not found by new text: %+v", page)
+		}
+		if page := f.search(t, token, "q=пятницу", http.StatusOK); len(page.Data) != 1 || page.Data[0].Message.MessageID != petrMessage.MessageID {
+			t.Fatalf("edited message still found by old text: %+v", page)
+		}
+	}
+	if edited := f.messages(t, f.petrToken)[1]; edited.MessageText != "встреча в субботу" || edited.EditedAt == nil {
+		t.Fatalf("companion copy not edited: %+v", edited)
+	}
+
+	// Удаление у себя не трогает копию собеседника, но пересчитывает непрочитанные
+	if f.unread(t, f.ivanID) != 1 {
+		t.Fatalf("ivan unread = %d, want 1", f.unread(t, f.ivanID))
+	}
+	rec = testutil.DoAs(t, f.router, f.ivanToken, http.MethodPost, "/messages/delete-message", DeleteMessageStruct{
+		ChatID: f.chatID.String(), MessageID: petrMessage.MessageID.String(),
+	})
+	testutil.Decode(t, rec, http.StatusOK, nil)
+	if f.unread(t, f.ivanID) != 0 || len(f.messages(t, f.ivanToken)) != 1 || len(f.messages(t, f.petrToken)) != 2 {
+		t.Fatal("delete for self removed wrong copies")
+	}
+	if page := f.search(t, f.ivanToken, "q=пятницу", http.StatusOK); len(page.Data) != 0 {
+		t.Fatalf("deleted message still in index: %+v", page)
+	}
+
+	rec = testutil.DoAs(t, f.router, f.petrToken, http.MethodPost, "/messages/delete-message", DeleteMessageStruct{
+		ChatID: f.chatID.String(), MessageID: ivanMessage.MessageID.String(), ForEveryone: true,
+	})
+	testutil.Decode(t, rec, http.StatusForbidden, nil)
+
+	rec = testutil.DoAs(t, f.router, f.ivanToken, http.MethodPost, "/messages/delete-message", DeleteMessageStruct{
+		ChatID: f.chatID.String(), MessageID: ivanMessage.MessageID.String(), ForEveryone: true,
+	})
+	testutil.Decode(t, rec, http.StatusOK, nil)
+	if len(f.messages(t, f.ivanToken)) != 0 || len(f.messages(t, f.petrToken)) != 1 || f.unread(t, f.petrID) != 0 {
+		t.Fatal("delete for everyone left copies or unread count")
+	}
+	if page := f.search(t, f.petrToken, "q=субботу", http.StatusOK); len(page.Data) != 0 {
+		t.Fatalf("message deleted for everyone still in companion index: %+v", page)
+	}
+}
@@ -0,0 +1,223 @@
+package messages
+
+import (
+	"Bmessage_backend/authz"
+	"Bmessage_backend/helpers"
+	"Bmessage_backend/repository"
+	"Bmessage_backend/routs/chats"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gocql/gocql"
+)
+
+// encryptFor шифрует текст ключом чата участника
+func encryptFor(chat repository.Chat, text string) (string, error) {
+	publicKey, err := helpers.ExtractPublicKey(chat.PrivateKey)
+	if err != nil {
+		return "", err
+	}
+	return helpers.EncryptWithPublicKey(text, publicKey)
+}
+
+// forwardAttribution — исходное сообщение пересылки: его чат, автор и время отправки
+type forwardAttribution struct {
+	ChatID    gocql.UUID
+	MessageID gocql.UUID
+	SenderID  uint
+	CreatedAt time.Time
+}
+
+// outgoingMessage — новое сообщение в открытом виде
+type outgoingMessage struct {
+	TemporaryMessageId string
+	Text               string
+	ReplyToMessageID   *gocql.UUID
+	Forward            *forwardAttribution
+	ViewOnce           bool
+	// MessageID — заранее выделенный идентификатор. Повторная доставка с тем же
+	// идентификатором перезаписывает копии, а не дублирует сообщение.
+	MessageID *gocql.UUID
+}
+
+// parseReplyTarget проверяет reply_to_message_id: ответить можно только на сообщение
+// этого же чата, которое есть в копии отправителя. nil в raw — не ответ.
+// При ошибке сам отвечает клиенту и возвращает false.
+func parseReplyTarget(repos *repository.Repositories, c *gin.Context, userID uint, chatID gocql.UUID, raw *string) (*gocql.UUID, bool) {
+	if raw == nil {
+		return nil, true
+	}
+	replyID, err := gocql.ParseUUID(*raw)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid reply_to_message_id"})
+		return nil, false
+	}
+	target, err := repos.Messages.GetMessage(userID, chatID, replyID)
+	if err != nil {
+		if err != repository.ErrNotFound {
+			log.Println(err)
+			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch message"})
+			return nil, false
+		}
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Replied message not found in this chat"})
+		return nil, false
+	}
+	if target.Kind != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Cannot reply to a system message"})
+		return nil, false
+	}
+	return &replyID, true
+}
+
+// loadForwardSource находит сообщение в копии пользователя и расшифровывает его.
+// Пересылка пересланного сохраняет автора и время исходного сообщения.
+// При ошибке сам отвечает клиенту и возвращает false.
+func loadForwardSource(repos *repository.Repositories, c *gin.Context, userID uint, chatID, messageID gocql.UUID) (forwardAttribution, string, bool) {
+	access, ok := authz.Require(c, repos, userID, chatID, authz.Read)
+	if !ok {
+		return forwardAttribution{}, "", false
+	}
+
+	source, err := repos.Messages.GetMessage(userID, chatID, messageID)
+	if err == repository.ErrNotFound {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Forwarded message not found"})
+		return forwardAttribution{}, "", false
+	}
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to fetch message"})
+		return forwardAttribution{}, "", false
+	}
+
+	if source.ViewOnce {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "View-once messages cannot be forwarded"})
+		return forwardAttribution{}, "", false
+	}
+	if source.Kind != "" {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "System messages cannot be forwarded"})
+		return forwardAttribution{}, "", false
+	}
+
+	text, err := helpers.DecryptWithPrivateKey(source.MessageText, access.Chat.PrivateKey)
+	if err != nil {
+		log.Println(err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to decrypt message"})
+		return forwardAttribution{}, "", false
+	}
+
+	attribution := forwardAttribution{ChatID: chatID, MessageID: messageID, SenderID: source.SenderID, CreatedAt: source.CreatedAt}
+	if source.ForwardedFromSenderID != nil && source.ForwardedAt != nil &&
+		source.ForwardedFromChatID != nil && source.ForwardedFromMessageID != nil {
+		attribution = forwardAttribution{
+			ChatID:    *source.ForwardedFromChatID,
+			MessageID: *source.ForwardedFromMessageID,
+			SenderID:  *source.ForwardedFromSenderID,
+			CreatedAt: *source.ForwardedAt,
+		}
+	}
+	return attribution, text, true
+}
+
+// deliverMessage сохраняет копии сообщения обоих участников, зашифрованные ключами их чатов,
+// индексирует их, поднимает чат в списках, увеличивает счётчик непрочитанных собеседника
+// и рассылает событие "new". Копии исчезают по таймеру чата отправителя: при изменении
+// таймер меняется у обоих участников сразу. access — результат проверки authz.Post.
+func deliverMessage(repos *repository.Repositories, access authz.Access, userID uint, out outgoingMessage) (Message, error) {
+	chatID := access.Chat.ChatID
+	companionID := access.Chat.CompanionID
+
+	messageID, createdAt := gocql.TimeUUID(), time.Now()
+	redelivery := false
+	if out.MessageID != nil {
+		// Время берётся из идентификатора, чтобы повторная доставка дала те же копии.
+		// Если копия собеседника уже записана, счётчик непрочитанных уже увеличен.
+		messageID, createdAt = *out.MessageID, out.MessageID.Time()
+		_, err := repos.Messages.GetMessage(companionID, chatID, messageID)
+		if err != nil && err != repository.ErrNotFound {
+			return Message{}, err
+		}
+		redelivery = err == nil
+	}
+
+	encryptedDataUser, err := encryptFor(access.Chat, out.Text)
+	if err != nil {
+		return Message{}, err
+	}
+	encryptedDataCompanion, err := encryptFor(access.CompanionChat, out.Text)
+	if err != nil {
+		return Message{}, err
+	}
+
+	userMessage := repository.Message{
+		OwnerID:          userID,
+		ChatID:           chatID,
+		MessageID:        messageID,
+		SenderID:         userID,
+		MessageText:      encryptedDataUser,
+		CreatedAt:        createdAt,
+		ReplyToMessageID: out.ReplyToMessageID,
+		Read:             false,
+		ViewOnce:         out.ViewOnce,
+	}
+	if ttl := access.Chat.MessageTTL; ttl > 0 {
+		expiresAt := createdAt.Add(time.Duration(ttl) * time.Second)
+		userMessage.ExpiresAt = &expiresAt
+	}
+	if forward := out.Forward; forward != nil {
+		userMessage.ForwardedFromChatID = &forward.ChatID
+		userMessage.ForwardedFromMessageID = &forward.MessageID
+		userMessage.ForwardedFromSenderID = &forward.SenderID
+		userMessage.ForwardedAt = &forward.CreatedAt
+	}
+	if err := repos.Messages.AddMessage(userMessage); err != nil {
+		return Message{}, err
+	}
+
+	companionMessage := userMessage
+	companionMessage.OwnerID = companionID
+	companionMessage.MessageText = encryptedDataCompanion
+	if err := repos.Messages.AddMessage(companionMessage); err != nil {
+		return Message{}, err
+	}
+
+	indexMessage(repos, userMessage, out.Text)
+	indexMessage(repos, companionMessage, out.Text)
+
+	if err := repos.Chats.TouchChat(userID, chatID, createdAt, &createdAt); err != nil {
+		return Message{}, err
+	}
+	if err := repos.Chats.TouchChat(companionID, chatID, createdAt, &createdAt); err != nil {
+		return Message{}, err
+	}
+	if !redelivery {
+		if err := repos.Chats.AddUnreadCount(companionID, chatID, 1); err != nil {
+			return Message{}, err
+		}
+		if companionMessage.ExpiresAt != nil {
+			if err := repos.Chats.AddUnreadExpiring(companionID, chatID, messageID, *companionMessage.ExpiresAt); err != nil {
+				return Message{}, err
+			}
+		}
+	}
+
+	// Входящее сообщение возвращает чат из архива, если собеседник не отключил уведомления
+	if access.CompanionChat.Archived && !access.CompanionChat.MutedAt(createdAt) {
+		if err := repos.Chats.SetArchived(companionID, chatID, false); err != nil {
+			log.Println("Failed to unarchive chat for companion:", err)
+		}
+	}
+
+	newMessage := messageFromRepository(userMessage)
+	newMessage.TemporaryMessageId = out.TemporaryMessageId
+	newMessage.MessageText = out.Text
+	newMessage.IsMyMessage = true
+	newMessage.Type = "new"
+	newReplyResolver(repos, access.Chat).attach(&newMessage)
+
+	chats.UpdeteDataChat(userID, chatID)
+	chats.UpdeteDataChat(companionID, chatID)
+	SendWsMessageToChat(chatID.String(), newMessage)
+	return newMessage, nil
+}
@@ -0,0 +1,18 @@
+package messages
+
+import (
+	"Bmessage_backend/repository"
+	"Bmessage_backend/routs/chats"
+)
+
+// Служебные сообщения записывает пакет chats, а подключения к чатам принадлежат этому пакету
+func init() {
+	chats.OnSystemMessage(sendSystemMessage)
+}
+
+// sendSystemMessage рассылает записанное служебное сообщение участникам чата как событие "new"
+func sendSystemMessage(row repository.Message) {
+	message := messageFromRepository(row)
+	message.Type = "new"
+	SendWsMessageToChat(row.ChatID.String(), message)
+}
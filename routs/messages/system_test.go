@@ -0,0 +1,63 @@
+package messages
+
+import (
+	"Bmessage_backend/routs/chats"
+	"Bmessage_backend/testutil"
+	"net/http"
+	"testing"
+)
+
+func TestSystemMessages(t *testing.T) {
+	f := newChatFixture(t)
+
+	// Создание чата записывается служебным сообщением, которое не считается непрочитанным
+	for _, token := range []string{f.ivanToken, f.petrToken} {
+		history := f.history(t, token)
+		if len(history) != 1 || history[0].Kind != chats.SystemChatCreated || history[0].SenderID != 0 ||
+			history[0].Payload == nil || history[0].Payload.ActorID != f.ivanID {
+			t.Fatalf("unexpected history: %+v", history)
+		}
+		if list := f.chatList(t, token); len(list) != 1 || list[0].LastMsgKind != chats.SystemChatCreated || list[0].LastMsg != nil {
+			t.Fatalf("unexpected chats: %+v", list)
+		}
+	}
+	if f.unread(t, f.ivanID) != 0 || f.unread(t, f.petrID) != 0 {
+		t.Fatal("system message counted as unread")
+	}
+
+	// Смена таймера записывается один раз, повторная установка того же значения ничего не пишет
+	for i := 0; i < 2; i++ {
+		rec := testutil.DoAs(t, f.router, f.petrToken, http.MethodPost, "/chats/disappearing",
+			chats.DisappearingMessagesStruct{ChatID: f.chatID.String(), TTLSeconds: 3600})
+		testutil.Decode(t, rec, http.StatusOK, nil)
+	}
+	history := f.history(t, f.ivanToken)
+	if len(history) != 2 || history[0].Kind != chats.SystemTimerChanged || history[0].Payload.ActorID != f.petrID ||
+		history[0].Payload.TTLSeconds == nil || *history[0].Payload.TTLSeconds != 3600 || history[0].ExpiresAt != nil {
+		t.Fatalf("unexpected history after timer change: %+v", history)
+	}
+
+	f.send(t, f.ivanToken, "адрес: Ленина, 1")
+	message := f.messages(t, f.ivanToken)[0]
+	f.pin(t, f.petrToken, message.MessageID, true, http.StatusOK)
+	pinned := f.history(t, f.ivanToken)[0]
+	if pinned.Kind != chats.SystemMessagePinned || pinned.Payload.ActorID != f.petrID ||
+		pinned.Payload.MessageID == nil || *pinned.Payload.MessageID != message.MessageID {
+		t.Fatalf("unexpected pin message: %+v", pinned)
+	}
+	if f.unread(t, f.petrID) != 1 {
+		t.Fatalf("petr unread = %d, want 1", f.unread(t, f.petrID))
+	}
+
+	// На служебное сообщение нельзя ответить, его нельзя переслать или закрепить
+	f.pin(t, f.ivanToken, pinned.MessageID, true, http.StatusBadRequest)
+	target := pinned.MessageID.String()
+	rec := testutil.DoAs(t, f.router, f.ivanToken, http.MethodPost, "/messages/add-message", AddMessageStruct{
+		ChatID: f.chatID.String(), MessageText: "ответ", ReplyToMessageID: &target,
+	})
+	testutil.Decode(t, rec, http.StatusBadRequest, nil)
+	rec = testutil.DoAs(t, f.router, f.ivanToken, http.MethodPost, "/messages/forward", ForwardMessageStruct{
+		ChatID: f.chatID.String(), MessageID: target, TargetChatIDs: []string{f.chatID.String()},
+	})
+	testutil.Decode(t, rec, http.StatusBadRequest, nil)
+}
@@ -0,0 +1,50 @@
+package messages
+
+import (
+	"Bmessage_backend/authz"
+	"Bmessage_backend/repository"
+	"Bmessage_backend/routs/chats"
+
+	"github.com/gocql/gocql"
+)
+
+// consumeViewOnce удаляет прочитанное получателем одноразовое сообщение у обоих участников
+// и рассылает событие "deleted". access — доступ получателя к чату.
+func consumeViewOnce(repos *repository.Repositories, access authz.Access, message repository.Message) error {
+	userID := access.Chat.UserID
+	companionID := access.Chat.CompanionID
+
+	owners := []uint{userID}
+	if !access.Chat.CompanionDeleted {
+		owners = append(owners, companionID)
+	}
+	for _, ownerID := range owners {
+		err := repos.Messages.DeleteMessage(ownerID, message.ChatID, message.MessageID)
+		if err != nil && err != repository.ErrNotFound {
+			return err
+		}
+	}
+
+	if !message.Read {
+		if err := repos.Chats.AddUnreadCount(userID, message.ChatID, -1); err != nil {
+			return err
+		}
+		if message.ExpiresAt != nil {
+			if err := repos.Chats.RemoveUnreadExpiring(userID, message.ChatID, []gocql.UUID{message.MessageID}); err != nil {
+				return err
+			}
+		}
+	}
+
+	chats.UpdeteDataChat(userID, message.ChatID)
+	chats.UpdeteDataChat(companionID, message.ChatID)
+	SendWsMessageToChat(message.ChatID.String(), Message{
+		ChatID:    message.ChatID,
+		MessageID: message.MessageID,
+		SenderID:  message.SenderID,
+		CreatedAt: message.CreatedAt,
+		ViewOnce:  true,
+		Type:      "deleted",
+	})
+	return nil
+}
@@ -0,0 +1,157 @@
+// Package server запускает HTTP-сервер приложения, при необходимости с TLS.
+//
+// TLS включается одним из способов:
+//   - TLS_CERT_FILE и TLS_KEY_FILE — готовые сертификат и ключ;
+//   - TLS_AUTOCERT_DOMAINS — сертификаты по ACME (Let's Encrypt или, для проверки,
+//     локальный CA вроде Pebble через ACME_DIRECTORY_URL и ACME_CA_ROOT).
+//
+// Без этих переменных сервер работает по HTTP, как раньше (например, за TLS-прокси).
+package server
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/acme"
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// Config — параметры запуска сервера
+type Config struct {
+	Port     string
+	CertFile string
+	KeyFile  string
+
+	AutocertDomains []string
+	AutocertCache   string
+	AutocertEmail   string
+	// ACMEDirectoryURL — каталог ACME вместо Let's Encrypt
+	ACMEDirectoryURL string
+	// ACMECARoot — PEM корневого сертификата, которому доверять при обращении к ACMEDirectoryURL
+	ACMECARoot string
+	// HTTPPort — порт для проверки http-01 и перенаправления на HTTPS при autocert
+	HTTPPort string
+}
+
+// ConfigFromEnv читает параметры из окружения
+func ConfigFromEnv() Config {
+	cfg := Config{
+		Port:             os.Getenv("SERVER_PORT"),
+		CertFile:         os.Getenv("TLS_CERT_FILE"),
+		KeyFile:          os.Getenv("TLS_KEY_FILE"),
+		AutocertCache:    os.Getenv("TLS_AUTOCERT_CACHE"),
+		AutocertEmail:    os.Getenv("TLS_AUTOCERT_EMAIL"),
+		ACMEDirectoryURL: os.Getenv("ACME_DIRECTORY_URL"),
+		ACMECARoot:       os.Getenv("ACME_CA_ROOT"),
+		HTTPPort:         os.Getenv("TLS_HTTP_PORT"),
+	}
+	for _, domain := range strings.Split(os.Getenv("TLS_AUTOCERT_DOMAINS"), ",") {
+		if domain = strings.TrimSpace(domain); domain != "" {
+			cfg.AutocertDomains = append(cfg.AutocertDomains, domain)
+		}
+	}
+	if cfg.AutocertCache == "" {
+		cfg.AutocertCache = "certs"
+	}
+	if cfg.HTTPPort == "" {
+		cfg.HTTPPort = "80"
+	}
+	return cfg
+}
+
+// TLSConfig возвращает настройки TLS и обработчик HTTP-порта для autocert.
+// Если TLS не настроен, возвращает nil, nil.
+func (cfg Config) TLSConfig() (*tls.Config, http.Handler, error) {
+	switch {
+	case cfg.CertFile != "" || cfg.KeyFile != "":
+		if len(cfg.AutocertDomains) > 0 {
+			return nil, nil, errors.New("TLS_CERT_FILE/TLS_KEY_FILE и TLS_AUTOCERT_DOMAINS взаимоисключающие")
+		}
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return nil, nil, fmt.Errorf("загрузка сертификата: %w", err)
+		}
+		return &tls.Config{MinVersion: tls.VersionTLS12, Certificates: []tls.Certificate{cert}}, nil, nil
+
+	case len(cfg.AutocertDomains) > 0:
+		manager := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(cfg.AutocertDomains...),
+			Cache:      autocert.DirCache(cfg.AutocertCache),
+			Email:      cfg.AutocertEmail,
+		}
+		if cfg.ACMEDirectoryURL != "" {
+			client, err := cfg.acmeHTTPClient()
+			if err != nil {
+				return nil, nil, err
+			}
+			manager.Client = &acme.Client{DirectoryURL: cfg.ACMEDirectoryURL, HTTPClient: client}
+		}
+		tlsConfig := manager.TLSConfig()
+		tlsConfig.MinVersion = tls.VersionTLS12
+		return tlsConfig, manager.HTTPHandler(nil), nil
+	}
+	return nil, nil, nil
+}
+
+// acmeHTTPClient доверяет ACMECARoot в дополнение к системным корневым сертификатам
+func (cfg Config) acmeHTTPClient() (*http.Client, error) {
+	if cfg.ACMECARoot == "" {
+		return http.DefaultClient, nil
+	}
+	pemData, err := os.ReadFile(cfg.ACMECARoot)
+	if err != nil {
+		return nil, fmt.Errorf("чтение ACME_CA_ROOT: %w", err)
+	}
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(pemData) {
+		return nil, errors.New("ACME_CA_ROOT не содержит сертификатов")
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tls.Config{RootCAs: pool, MinVersion: tls.VersionTLS12}
+	return &http.Client{Transport: transport, Timeout: time.Minute}, nil
+}
+
+// Run запускает сервер с параметрами из окружения и блокируется до его остановки
+func Run(handler http.Handler) error {
+	cfg := ConfigFromEnv()
+	tlsConfig, challengeHandler, err := cfg.TLSConfig()
+	if err != nil {
+		return err
+	}
+
+	srv := &http.Server{
+		Addr:              ":" + cfg.Port,
+		Handler:           handler,
+		TLSConfig:         tlsConfig,
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	if tlsConfig == nil {
+		log.Println("Server will start on port:", cfg.Port)
+		return srv.ListenAndServe()
+	}
+
+	if challengeHandler != nil {
+		go func() {
+			log.Println("ACME challenge listener on port:", cfg.HTTPPort)
+			if err := http.ListenAndServe(":"+cfg.HTTPPort, challengeHandler); err != nil {
+				log.Println("ACME challenge listener stopped:", err)
+			}
+		}()
+	}
+
+	log.Println("Server will start with TLS on port:", cfg.Port)
+	// Сертификаты уже в TLSConfig
+	return srv.ListenAndServeTLS("", "")
+}
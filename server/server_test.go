@@ -0,0 +1,104 @@
+package server
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func writeSelfSigned(t *testing.T) (certFile, keyFile string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "localhost"},
+		DNSNames:     []string{"localhost"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IsCA:         true,
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	certFile, keyFile = filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func TestTLSConfigFromEnv(t *testing.T) {
+	if cfg, _, err := ConfigFromEnv().TLSConfig(); cfg != nil || err != nil {
+		t.Fatalf("TLSConfig without settings = %v, %v; want plain HTTP", cfg, err)
+	}
+
+	certFile, keyFile := writeSelfSigned(t)
+	t.Setenv("TLS_CERT_FILE", certFile)
+	t.Setenv("TLS_KEY_FILE", keyFile)
+
+	cfg, challenge, err := ConfigFromEnv().TLSConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.MinVersion != tls.VersionTLS12 || len(cfg.Certificates) != 1 || challenge != nil {
+		t.Fatalf("unexpected TLS config: min version %x, %d certificates", cfg.MinVersion, len(cfg.Certificates))
+	}
+
+	t.Setenv("TLS_AUTOCERT_DOMAINS", "chat.example.com")
+	if _, _, err := ConfigFromEnv().TLSConfig(); err == nil {
+		t.Fatal("certificate files and autocert accepted together")
+	}
+}
+
+func TestAutocertWithLocalCA(t *testing.T) {
+	caRoot, _ := writeSelfSigned(t)
+	t.Setenv("TLS_AUTOCERT_DOMAINS", "chat.local, api.chat.local")
+	t.Setenv("TLS_AUTOCERT_CACHE", t.TempDir())
+	t.Setenv("ACME_DIRECTORY_URL", "https://localhost:14000/dir")
+	t.Setenv("ACME_CA_ROOT", caRoot)
+
+	env := ConfigFromEnv()
+	if len(env.AutocertDomains) != 2 || env.AutocertDomains[1] != "api.chat.local" || env.HTTPPort != "80" {
+		t.Fatalf("unexpected config: %+v", env)
+	}
+
+	cfg, challenge, err := env.TLSConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.MinVersion != tls.VersionTLS12 || cfg.GetCertificate == nil || challenge == nil {
+		t.Fatal("autocert TLS config is incomplete")
+	}
+
+	t.Setenv("ACME_CA_ROOT", filepath.Join(t.TempDir(), "missing.pem"))
+	if _, _, err := ConfigFromEnv().TLSConfig(); err == nil {
+		t.Fatal("missing ACME_CA_ROOT accepted")
+	}
+}
@@ -0,0 +1,61 @@
+// Package sessions выдаёт токены пользователей и отзывает их.
+//
+// В токен зашифрована версия сессий пользователя. Текущая версия хранится в Redis;
+// её увеличение делает недействительными все ранее выданные токены.
+package sessions
+
+import (
+	"Bmessage_backend/database"
+	"Bmessage_backend/helpers"
+	"context"
+	"strconv"
+
+	"github.com/go-redis/redis/v8"
+)
+
+func versionKey(userID uint) string {
+	return "token_version_" + strconv.FormatUint(uint64(userID), 10)
+}
+
+// Version возвращает текущую версию сессий пользователя. Отсутствие ключа означает версию 0.
+func Version(ctx context.Context, userID uint) (int64, error) {
+	client := database.GetRedis()
+	defer client.Close()
+
+	version, err := client.Get(ctx, versionKey(userID)).Int64()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	return version, err
+}
+
+// Valid проверяет, что токен выдан после последнего отзыва сессий
+func Valid(ctx context.Context, data helpers.UserData) (bool, error) {
+	version, err := Version(ctx, data.User_id)
+	if err != nil {
+		return false, err
+	}
+	return data.Session_version >= version, nil
+}
+
+// Issue выдаёт токен текущей версии сессий
+func Issue(ctx context.Context, userID uint) (string, error) {
+	version, err := Version(ctx, userID)
+	if err != nil {
+		return "", err
+	}
+	return helpers.EncryptAES(helpers.UserData{User_id: userID, Session_version: version})
+}
+
+// RevokeAll отзывает все выданные токены пользователя и возвращает токен новой версии
+// для сессии, из которой выполнен отзыв
+func RevokeAll(ctx context.Context, userID uint) (string, error) {
+	client := database.GetRedis()
+	defer client.Close()
+
+	version, err := client.Incr(ctx, versionKey(userID)).Result()
+	if err != nil {
+		return "", err
+	}
+	return helpers.EncryptAES(helpers.UserData{User_id: userID, Session_version: version})
+}
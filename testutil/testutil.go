@@ -0,0 +1,253 @@
+// Package testutil собирает окружение для тестов обработчиков: репозитории в памяти,
+// Redis в памяти и клиентскую сторону обмена ключами.
+package testutil
+
+import (
+	"Bmessage_backend/handshake"
+	"Bmessage_backend/helpers"
+	"Bmessage_backend/models"
+	"Bmessage_backend/repository"
+	"Bmessage_backend/sessions"
+	"bytes"
+	"context"
+	"crypto/ecdh"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/gin-gonic/gin"
+)
+
+// Setup подменяет хранилища на реализации в памяти и возвращает пустой роутер.
+// Все подмены снимаются по завершении теста.
+func Setup(t *testing.T) (*repository.Repositories, *gin.Engine) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+
+	t.Setenv("TOKEN_KEY", "test-token-key")
+
+	redisServer := miniredis.RunT(t)
+	t.Setenv("REDIS_ADDR", redisServer.Addr())
+
+	repos, restore := repository.UseMemory()
+	t.Cleanup(restore)
+
+	return repos, gin.New()
+}
+
+// Do выполняет запрос к роутеру. body, если задан, кодируется в JSON.
+func Do(t *testing.T, router *gin.Engine, method, path string, body interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+	return DoAs(t, router, "", method, path, body)
+}
+
+// DoAs выполняет запрос от имени пользователя с токеном token (Authorization: Bearer)
+func DoAs(t *testing.T, router *gin.Engine, token, method, path string, body interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+	return do(t, router, token, "", method, path, body)
+}
+
+// DoFrom выполняет анонимный запрос с адреса клиента ip
+func DoFrom(t *testing.T, router *gin.Engine, ip, method, path string, body interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+	return do(t, router, "", ip, method, path, body)
+}
+
+func do(t *testing.T, router *gin.Engine, token, ip, method, path string, body interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+
+	var buf bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			t.Fatalf("marshal request body: %v", err)
+		}
+	}
+
+	req := httptest.NewRequest(method, path, &buf)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	if ip != "" {
+		req.RemoteAddr = ip + ":40000"
+	}
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, req)
+	return rec
+}
+
+// Decode разбирает JSON-ответ и проверяет код статуса
+func Decode(t *testing.T, rec *httptest.ResponseRecorder, status int, v interface{}) {
+	t.Helper()
+
+	if rec.Code != status {
+		t.Fatalf("status = %d, want %d, body: %s", rec.Code, status, rec.Body.String())
+	}
+	if v == nil {
+		return
+	}
+	if err := json.Unmarshal(rec.Body.Bytes(), v); err != nil {
+		t.Fatalf("decode response %q: %v", rec.Body.String(), err)
+	}
+}
+
+// CreateUser сохраняет пользователя напрямую в репозиторий и возвращает его токен
+func CreateUser(t *testing.T, repos *repository.Repositories, nik string) (models.User, string) {
+	t.Helper()
+
+	user := &models.User{
+		Name:   nik,
+		SoName: nik,
+		Nik:    nik,
+		Login:  nik,
+	}
+	if err := repos.Users.CreateUser(user); err != nil {
+		t.Fatalf("create user %s: %v", nik, err)
+	}
+
+	token, err := sessions.Issue(context.Background(), user.ID)
+	if err != nil {
+		t.Fatalf("encrypt token: %v", err)
+	}
+	return *user, token
+}
+
+// Client — клиентская сторона обмена ключами: uuid и публичный ключ сервера из
+// token/generateToken и собственная пара ключей для получения токена.
+// Клиент NewX25519Client вместо RSA использует ключи token/key-exchange.
+type Client struct {
+	Uuid      string
+	ServerKey string
+	key       *rsa.PrivateKey
+
+	toServer []byte
+	toClient []byte
+}
+
+// NewClient выполняет token/generateToken на роутере с подключённым TokensRouter
+func NewClient(t *testing.T, router *gin.Engine) *Client {
+	t.Helper()
+
+	var resp struct {
+		Uuid      string `json:"uuid"`
+		PublicKey string `json:"public_key"`
+	}
+	Decode(t, Do(t, router, http.MethodGet, "/token/generateToken", nil), http.StatusOK, &resp)
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate client key: %v", err)
+	}
+
+	return &Client{
+		Uuid:      resp.Uuid,
+		ServerKey: "-----BEGIN PUBLIC KEY-----" + resp.PublicKey + "-----END PUBLIC KEY-----",
+		key:       key,
+	}
+}
+
+// NewX25519Client выполняет token/key-exchange на роутере с подключённым TokensRouter
+func NewX25519Client(t *testing.T, router *gin.Engine) *Client {
+	t.Helper()
+
+	key, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generate client key: %v", err)
+	}
+
+	var resp struct {
+		Uuid            string `json:"uuid"`
+		ServerPublicKey string `json:"server_public_key"`
+	}
+	rec := Do(t, router, http.MethodPost, "/token/key-exchange", gin.H{
+		"client_public_key": base64.StdEncoding.EncodeToString(key.PublicKey().Bytes()),
+	})
+	Decode(t, rec, http.StatusOK, &resp)
+
+	raw, err := base64.StdEncoding.DecodeString(resp.ServerPublicKey)
+	if err != nil {
+		t.Fatalf("decode server key: %v", err)
+	}
+	serverKey, err := ecdh.X25519().NewPublicKey(raw)
+	if err != nil {
+		t.Fatalf("parse server key: %v", err)
+	}
+	shared, err := key.ECDH(serverKey)
+	if err != nil {
+		t.Fatalf("ecdh: %v", err)
+	}
+	toServer, toClient, err := handshake.DeriveKeys(shared, resp.Uuid)
+	if err != nil {
+		t.Fatalf("derive keys: %v", err)
+	}
+
+	return &Client{Uuid: resp.Uuid, toServer: toServer, toClient: toClient}
+}
+
+// PKey возвращает публичный ключ клиента в том виде, в каком его передаёт приложение.
+// Клиенту X25519 pKey не нужен.
+func (cl *Client) PKey(t *testing.T) string {
+	t.Helper()
+
+	if cl.key == nil {
+		return ""
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(&cl.key.PublicKey)
+	if err != nil {
+		t.Fatalf("marshal client key: %v", err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+}
+
+// Encrypt шифрует поле запроса публичным ключом сервера или ключом клиент→сервер
+func (cl *Client) Encrypt(t *testing.T, data string) string {
+	t.Helper()
+
+	if cl.toServer != nil {
+		sealed, err := handshake.Seal(cl.toServer, cl.Uuid, data)
+		if err != nil {
+			t.Fatalf("seal %q: %v", data, err)
+		}
+		return sealed
+	}
+
+	encrypted, err := helpers.EncryptWithPublicKey(data, cl.ServerKey)
+	if err != nil {
+		t.Fatalf("encrypt %q: %v", data, err)
+	}
+	return encrypted
+}
+
+// Decrypt расшифровывает ответ сервера, зашифрованный ключом клиента или ключом сервер→клиент
+func (cl *Client) Decrypt(t *testing.T, data string) string {
+	t.Helper()
+
+	if cl.toClient != nil {
+		opened, err := handshake.Open(cl.toClient, cl.Uuid, data)
+		if err != nil {
+			t.Fatalf("open: %v", err)
+		}
+		return opened
+	}
+
+	raw, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		t.Fatalf("decode %q: %v", data, err)
+	}
+	decrypted, err := rsa.DecryptPKCS1v15(rand.Reader, cl.key, raw)
+	if err != nil {
+		t.Fatalf("decrypt: %v", err)
+	}
+	return string(decrypted)
+}
@@ -0,0 +1,60 @@
+package totp
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Тестовые векторы RFC 6238 (приложение B) для SHA1, последние 6 цифр
+func TestCodeAtRFCVectors(t *testing.T) {
+	secret := base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+	for unix, want := range map[int64]string{
+		59:          "287082",
+		1111111109:  "081804",
+		1111111111:  "050471",
+		1234567890:  "005924",
+		2000000000:  "279037",
+		20000000000: "353130",
+	} {
+		got, err := CodeAt(secret, Step(time.Unix(unix, 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Fatalf("CodeAt(%d) = %s, want %s", unix, got, want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Unix(1_700_000_000, 0)
+
+	previous, _ := CodeAt(secret, Step(now)-1)
+	if step, ok := Validate(secret, previous, now); !ok || step != Step(now)-1 {
+		t.Fatalf("code of previous step: step %d, ok %v", step, ok)
+	}
+
+	stale, _ := CodeAt(secret, Step(now)-2)
+	if _, ok := Validate(secret, stale, now); ok {
+		t.Fatal("code two steps old accepted")
+	}
+	if _, ok := Validate(secret, "12345", now); ok {
+		t.Fatal("short code accepted")
+	}
+}
+
+func TestProvisioningURI(t *testing.T) {
+	uri := ProvisioningURI("Bmessage", "ivan login", "JBSWY3DPEHPK3PXP")
+	for _, want := range []string{"otpauth://totp/Bmessage:ivan%20login?", "secret=JBSWY3DPEHPK3PXP", "issuer=Bmessage", "digits=6", "period=30"} {
+		if !strings.Contains(uri, want) {
+			t.Fatalf("%s does not contain %s", uri, want)
+		}
+	}
+}